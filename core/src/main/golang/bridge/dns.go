@@ -0,0 +1,118 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/dns"
+	D "github.com/miekg/dns"
+)
+
+type DnsQuery struct {
+	Time     int64
+	Domain   string
+	Type     string
+	Upstream string
+	Latency  int64
+	Answer   string
+	Cache    bool
+	FakeIP   bool
+	Client   string
+	Error    string
+}
+
+type DnsStatistics struct {
+	Total  int64
+	Cache  int64
+	FakeIP int64
+	Failed int64
+}
+
+type DnsQueryCallback interface {
+	OnDnsQuery(query *DnsQuery)
+}
+
+// SetDnsQueryCallback send the dns queries to callback until the returned poll is stopped
+func SetDnsQueryCallback(callback DnsQueryCallback) *EventPoll {
+	sub := dns.SubscribeQuery()
+
+	var stopped atomic.Bool
+	go func() {
+		for elm := range sub {
+			// the queries still buffered are dropped once stopped
+			if stopped.Load() {
+				continue
+			}
+			callback.OnDnsQuery(newDnsQuery(elm.(*dns.Query)))
+		}
+	}()
+
+	return &EventPoll{onStop: func() {
+		stopped.Store(true)
+		dns.UnSubscribeQuery(sub)
+	}}
+}
+
+func QueryDnsStatistics() *DnsStatistics {
+	stats := dns.QueryStatistics()
+
+	return &DnsStatistics{
+		Total:  stats.Total,
+		Cache:  stats.Cache,
+		FakeIP: stats.FakeIP,
+		Failed: stats.Failed,
+	}
+}
+
+func newDnsQuery(q *dns.Query) *DnsQuery {
+	return &DnsQuery{
+		Time:     q.Time.UnixNano() / 1e6,
+		Domain:   q.Domain,
+		Type:     q.Type,
+		Upstream: q.Upstream,
+		Latency:  q.Latency,
+		Answer:   strings.Join(q.Answer, ","),
+		Cache:    q.Cache,
+		FakeIP:   q.FakeIP,
+		Client:   q.Client,
+		Error:    q.Error,
+	}
+}
+
+type DnsResult struct {
+	Rcode    string
+	Answer   string
+	Upstream string
+	TTL      int64
+	Cache    bool
+	FakeIP   bool
+}
+
+func ResolveDomain(name, qtype string) (*DnsResult, error) {
+	r, ok := resolver.DefaultResolver.(*dns.Resolver)
+	if !ok {
+		return nil, errors.New("DNS is not enabled")
+	}
+
+	t, exist := D.StringToType[strings.ToUpper(qtype)]
+	if !exist {
+		return nil, fmt.Errorf("unknown dns type: %s", qtype)
+	}
+
+	result, err := r.Lookup(name, t, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &DnsResult{
+		Rcode:    result.Rcode,
+		Answer:   strings.Join(result.Answer, "\n"),
+		Upstream: result.Upstream,
+		TTL:      int64(result.TTL),
+		Cache:    result.Cache,
+		FakeIP:   result.FakeIP,
+	}, nil
+}
@@ -69,6 +69,8 @@ func StartUrlTest(group string, callback DoneCallback) {
 			providers = group.GetProviders()
 		case *outboundgroup.Selector:
 			providers = group.GetProviders()
+		case *outboundgroup.Relay:
+			providers = group.GetProviders()
 		default:
 			return
 		}
@@ -137,6 +139,16 @@ func QueryAllProxyGroups(collection ProxyGroupCollection) {
 					providers: group.GetProviders(),
 				},
 			)
+		case *outboundgroup.Relay:
+			collection.Add(
+				&ProxyGroupItem{
+					Name:      group.Name(),
+					Type:      group.Type().String(),
+					Current:   "",
+					Delay:     int(p.LastDelay()),
+					providers: group.GetProviders(),
+				},
+			)
 		default:
 			continue
 		}
@@ -69,3 +69,24 @@ func parseAddr(addr string) (net.IP, string, error) {
 	ip := net.ParseIP(host)
 	return ip, port, nil
 }
+
+// ResetConn close the connection of the client with a tcp reset, it's simply closed if that's impossible
+func ResetConn(conn C.ServerAdapter) {
+	c := net.Conn(conn)
+	switch adapter := conn.(type) {
+	case *HTTPAdapter:
+		c = adapter.Conn
+	case *SocketAdapter:
+		c = adapter.Conn
+	}
+
+	switch c := c.(type) {
+	case *net.TCPConn:
+		// a zero linger makes close send RST
+		c.SetLinger(0)
+	case interface{ Reset() }:
+		c.Reset()
+		return
+	}
+	c.Close()
+}
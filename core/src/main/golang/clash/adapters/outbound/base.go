@@ -4,22 +4,42 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/Dreamacro/clash/common/queue"
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/resolver"
 	C "github.com/Dreamacro/clash/constant"
 )
 
 var (
 	defaultURLTestTimeout = time.Second * 5
+
+	errProxyDestroyed = errors.New("proxy is destroyed")
 )
 
 type Base struct {
-	name string
-	tp   C.AdapterType
-	udp  bool
+	name  string
+	addr  string
+	tp    C.AdapterType
+	udp   bool
+	iface string
+	rmark int
+	// ipVersion is empty when it follows the global ip-version
+	ipVersion resolver.IPVersion
+	resolve   string
+}
+
+// BasicOption is squashed into the option of every proxy
+type BasicOption struct {
+	Interface   string             `proxy:"interface-name,omitempty"`
+	RoutingMark int                `proxy:"routing-mark,omitempty"`
+	IPVersion   resolver.IPVersion `proxy:"ip-version,omitempty"`
+	Resolve     string             `proxy:"resolve,omitempty"`
 }
 
 func (b *Base) Name() string {
@@ -30,10 +50,51 @@ func (b *Base) Type() C.AdapterType {
 	return b.tp
 }
 
+func (b *Base) Addr() string {
+	return b.addr
+}
+
+func (b *Base) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return nil, errors.New("no support")
+}
+
 func (b *Base) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
 	return nil, errors.New("no support")
 }
 
+func (b *Base) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	return nil, errors.New("no support")
+}
+
+func (b *Base) Unwrap(metadata *C.Metadata) C.Proxy {
+	return nil
+}
+
+func (b *Base) Destroy() {}
+
+func (b *Base) Dialer() C.Dialer {
+	if b.iface == "" && b.rmark == 0 && b.ipVersion == "" {
+		return LocalDialer
+	}
+	return &localDialer{options: b.dialOptions()}
+}
+
+// dialOptions bind the sockets to the interface and the routing mark of the proxy,
+// and pick the address family of the server by its ip-version
+func (b *Base) dialOptions() []dialer.Option {
+	var options []dialer.Option
+	if b.iface != "" {
+		options = append(options, dialer.WithInterface(b.iface))
+	}
+	if b.rmark != 0 {
+		options = append(options, dialer.WithRoutingMark(b.rmark))
+	}
+	if b.ipVersion != "" {
+		options = append(options, dialer.WithIPVersion(b.ipVersion))
+	}
+	return options
+}
+
 func (b *Base) SupportUDP() bool {
 	return b.udp
 }
@@ -45,7 +106,7 @@ func (b *Base) MarshalJSON() ([]byte, error) {
 }
 
 func NewBase(name string, tp C.AdapterType, udp bool) *Base {
-	return &Base{name, tp, udp}
+	return &Base{name: name, tp: tp, udp: udp}
 }
 
 type conn struct {
@@ -65,6 +126,11 @@ func newConn(c net.Conn, a C.ProxyAdapter) C.Conn {
 	return &conn{c, []string{a.Name()}}
 }
 
+// NewConn is the conn dialed by a, for the groups wrapping the conn of their proxies
+func NewConn(c net.Conn, a C.ProxyAdapter) C.Conn {
+	return newConn(c, a)
+}
+
 type PacketConn interface {
 	net.PacketConn
 	WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error)
@@ -91,12 +157,57 @@ type Proxy struct {
 	C.ProxyAdapter
 	history *queue.Queue
 	alive   bool
+
+	// the adapter is destroyed after the proxy is destroyed and its last connection is closed
+	mux       sync.Mutex
+	inUse     int
+	destroyed bool
 }
 
 func (p *Proxy) Alive() bool {
 	return p.alive
 }
 
+// acquire count a connection of the proxy, it fails once the proxy is destroyed
+func (p *Proxy) acquire() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.destroyed {
+		return errProxyDestroyed
+	}
+	p.inUse++
+	return nil
+}
+
+func (p *Proxy) release() {
+	p.mux.Lock()
+	p.inUse--
+	drained := p.destroyed && p.inUse == 0
+	p.mux.Unlock()
+
+	if drained {
+		p.ProxyAdapter.Destroy()
+	}
+}
+
+// Destroy release the resources of the adapter when the connections in use are closed,
+// so replacing the proxies doesn't break the in-flight connections
+func (p *Proxy) Destroy() {
+	p.mux.Lock()
+	if p.destroyed {
+		p.mux.Unlock()
+		return
+	}
+	p.destroyed = true
+	drained := p.inUse == 0
+	p.mux.Unlock()
+
+	if drained {
+		p.ProxyAdapter.Destroy()
+	}
+}
+
 func (p *Proxy) Dial(metadata *C.Metadata) (C.Conn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
 	defer cancel()
@@ -104,11 +215,135 @@ func (p *Proxy) Dial(metadata *C.Metadata) (C.Conn, error) {
 }
 
 func (p *Proxy) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	metadata, err := remoteMetadata(p.ProxyAdapter, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.acquire(); err != nil {
+		return nil, err
+	}
+
 	conn, err := p.ProxyAdapter.DialContext(ctx, metadata)
 	if err != nil {
 		p.alive = false
+		p.release()
+		return nil, err
+	}
+
+	// the tunnel closes a reset conn at once, there is nothing to drain
+	if _, ok := conn.(C.InboundResetter); ok {
+		p.release()
+		return conn, nil
 	}
-	return conn, err
+	return &proxyConn{Conn: conn, releaser: &releaser{proxy: p}}, nil
+}
+
+// contextDialer is an adapter reaching its server with a dialer by itself, instead of wrapping a stream to it
+type contextDialer interface {
+	DialContextWithDialer(ctx context.Context, d C.Dialer, metadata *C.Metadata) (C.Conn, error)
+}
+
+func (p *Proxy) DialContextWithDialer(ctx context.Context, d C.Dialer, metadata *C.Metadata) (C.Conn, error) {
+	metadata, err := remoteMetadata(p.ProxyAdapter, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.acquire(); err != nil {
+		return nil, err
+	}
+
+	var conn C.Conn
+	if cd, ok := p.ProxyAdapter.(contextDialer); ok {
+		conn, err = cd.DialContextWithDialer(ctx, d, metadata)
+	} else {
+		conn, err = p.streamWithDialer(ctx, d, metadata)
+	}
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, releaser: &releaser{proxy: p}}, nil
+}
+
+// streamWithDialer wrap the protocol of the adapter around the connection to the server dialed by d
+func (p *Proxy) streamWithDialer(ctx context.Context, d C.Dialer, metadata *C.Metadata) (C.Conn, error) {
+	if p.Addr() == "" {
+		return nil, fmt.Errorf("%s can't be reached through a dialer", p.Name())
+	}
+
+	c, err := d.DialContext(ctx, p.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", p.Addr(), err)
+	}
+
+	sc, err := p.ProxyAdapter.StreamConn(c, metadata)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return newConn(sc, p.ProxyAdapter), nil
+}
+
+func (p *Proxy) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	metadata, err := remoteMetadata(p.ProxyAdapter, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.acquire(); err != nil {
+		return nil, err
+	}
+
+	conn, err := p.ProxyAdapter.StreamConn(c, metadata)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return &proxyStreamConn{Conn: conn, releaser: &releaser{proxy: p}}, nil
+}
+
+func (p *Proxy) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return p.dialUDP(metadata, p.ProxyAdapter.DialUDP)
+}
+
+func (p *Proxy) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	return p.dialUDP(metadata, func(metadata *C.Metadata) (C.PacketConn, error) {
+		return p.ProxyAdapter.DialUDPWithDialer(d, metadata)
+	})
+}
+
+func (p *Proxy) dialUDP(metadata *C.Metadata, dial func(*C.Metadata) (C.PacketConn, error)) (C.PacketConn, error) {
+	if err := p.acquire(); err != nil {
+		return nil, err
+	}
+
+	pc, err := p.resolveUDP(metadata, dial)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return &proxyPacketConn{PacketConn: pc, releaser: &releaser{proxy: p}}, nil
+}
+
+// resolveUDP resolve the destinations of the packets for the adapters with resolve: local
+func (p *Proxy) resolveUDP(metadata *C.Metadata, dial func(*C.Metadata) (C.PacketConn, error)) (C.PacketConn, error) {
+	r, ok := p.ProxyAdapter.(localResolver)
+	if !ok || !r.resolvesLocally() {
+		return dial(metadata)
+	}
+
+	metadata, err := r.remoteMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := dial(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvePacketConn{PacketConn: pc, adapter: p.ProxyAdapter}, nil
 }
 
 func (p *Proxy) DelayHistory() []C.DelayHistory {
@@ -205,5 +440,45 @@ func (p *Proxy) URLTest(ctx context.Context, url string) (t uint16, err error) {
 }
 
 func NewProxy(adapter C.ProxyAdapter) *Proxy {
-	return &Proxy{adapter, queue.New(10), true}
+	return &Proxy{ProxyAdapter: adapter, history: queue.New(10), alive: true}
+}
+
+// releaser release the proxy of a connection once
+type releaser struct {
+	once  sync.Once
+	proxy *Proxy
+}
+
+func (r *releaser) release() {
+	r.once.Do(r.proxy.release)
+}
+
+type proxyConn struct {
+	C.Conn
+	*releaser
+}
+
+func (c *proxyConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
+
+type proxyStreamConn struct {
+	net.Conn
+	*releaser
+}
+
+func (c *proxyStreamConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
+
+type proxyPacketConn struct {
+	C.PacketConn
+	*releaser
+}
+
+func (c *proxyPacketConn) Close() error {
+	defer c.release()
+	return c.PacketConn.Close()
 }
@@ -0,0 +1,57 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pipeAdapter struct {
+	*Base
+	destroyed int
+}
+
+func (pa *pipeAdapter) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	c, _ := net.Pipe()
+	return newConn(c, pa), nil
+}
+
+func (pa *pipeAdapter) Destroy() {
+	pa.destroyed++
+}
+
+func TestProxy_DestroyDrain(t *testing.T) {
+	adapter := &pipeAdapter{Base: NewBase("pipe", C.Direct, false)}
+	proxy := NewProxy(adapter)
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "80"}
+
+	c, err := proxy.DialContext(context.Background(), metadata)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pipe"}, []string(c.Chains()))
+
+	proxy.Destroy()
+	assert.Equal(t, 0, adapter.destroyed)
+
+	_, err = proxy.DialContext(context.Background(), metadata)
+	assert.Equal(t, errProxyDestroyed, err)
+
+	c.Close()
+	assert.Equal(t, 1, adapter.destroyed)
+
+	// closed and destroyed only once
+	c.Close()
+	proxy.Destroy()
+	assert.Equal(t, 1, adapter.destroyed)
+}
+
+func TestProxy_DestroyIdle(t *testing.T) {
+	adapter := &pipeAdapter{Base: NewBase("pipe", C.Direct, false)}
+	proxy := NewProxy(adapter)
+
+	proxy.Destroy()
+	assert.Equal(t, 1, adapter.destroyed)
+}
@@ -13,13 +13,18 @@ type Direct struct {
 	*Base
 }
 
+type DirectOption struct {
+	BasicOption `proxy:",squash"`
+	Name        string `proxy:"name"`
+}
+
 func (d *Direct) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
 	address := net.JoinHostPort(metadata.Host, metadata.DstPort)
 	if metadata.DstIP != nil {
 		address = net.JoinHostPort(metadata.DstIP.String(), metadata.DstPort)
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", address)
+	c, err := dialer.DialContext(ctx, "tcp", address, d.dialOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -27,21 +32,30 @@ func (d *Direct) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn,
 	return newConn(c, d), nil
 }
 
+func (d *Direct) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return c, nil
+}
+
 func (d *Direct) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
-	pc, err := dialer.ListenPacket("udp", "")
+	return d.DialUDPWithDialer(d.Dialer(), metadata)
+}
+
+func (d *Direct) DialUDPWithDialer(dl C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	pc, err := dl.ListenPacket(metadata.RemoteAddress())
 	if err != nil {
 		return nil, err
 	}
-	return newPacketConn(&directPacketConn{pc}, d), nil
+	return newPacketConn(&directPacketConn{PacketConn: pc, ipVersion: d.ipVersion}, d), nil
 }
 
 type directPacketConn struct {
 	net.PacketConn
+	ipVersion resolver.IPVersion
 }
 
 func (dp *directPacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error) {
 	if !metadata.Resolved() {
-		ip, err := resolver.ResolveIP(metadata.Host)
+		ip, err := resolver.ResolveIPWithVersion(metadata.Host, dp.ipVersion)
 		if err != nil {
 			return 0, err
 		}
@@ -51,11 +65,20 @@ func (dp *directPacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n
 }
 
 func NewDirect() *Direct {
+	return NewDirectWithOption(DirectOption{Name: "DIRECT"})
+}
+
+// NewDirectWithOption return a direct proxy bound to the interface and the routing mark of option
+func NewDirectWithOption(option DirectOption) *Direct {
 	return &Direct{
 		Base: &Base{
-			name: "DIRECT",
-			tp:   C.Direct,
-			udp:  true,
+			name:      option.Name,
+			tp:        C.Direct,
+			udp:       true,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
 	}
 }
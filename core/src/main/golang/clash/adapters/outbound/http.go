@@ -26,31 +26,43 @@ type Http struct {
 }
 
 type HttpOption struct {
-	Name           string `proxy:"name"`
-	Server         string `proxy:"server"`
-	Port           int    `proxy:"port"`
-	UserName       string `proxy:"username,omitempty"`
-	Password       string `proxy:"password,omitempty"`
-	TLS            bool   `proxy:"tls,omitempty"`
-	SkipCertVerify bool   `proxy:"skip-cert-verify,omitempty"`
+	BasicOption    `proxy:",squash"`
+	Name           string                 `proxy:"name"`
+	Server         string                 `proxy:"server"`
+	Port           int                    `proxy:"port"`
+	UserName       string                 `proxy:"username,omitempty"`
+	Password       string                 `proxy:"password,omitempty"`
+	TLS            bool                   `proxy:"tls,omitempty"`
+	SkipCertVerify bool                   `proxy:"skip-cert-verify,omitempty"`
+	TLSOpts        map[string]interface{} `proxy:"tls-opts,omitempty"`
 }
 
-func (h *Http) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", h.addr)
-	if err == nil && h.tlsConfig != nil {
+func (h *Http) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	if h.tlsConfig != nil {
 		cc := tls.Client(c, h.tlsConfig)
-		err = cc.Handshake()
+		if err := cc.Handshake(); err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
+		}
 		c = cc
 	}
 
+	if err := h.shakeHand(metadata, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (h *Http) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	c, err := dialer.DialContext(ctx, "tcp", h.addr, h.dialOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
 	}
 	tcpKeepAlive(c)
-	if err := h.shakeHand(metadata, c); err != nil {
+
+	c, err = h.StreamConn(c, metadata)
+	if err != nil {
 		return nil, err
 	}
-
 	return newConn(c, h), nil
 }
 
@@ -100,24 +112,30 @@ func (h *Http) shakeHand(metadata *C.Metadata, rw io.ReadWriter) error {
 	return fmt.Errorf("can not connect remote err code: %d", resp.StatusCode)
 }
 
-func NewHttp(option HttpOption) *Http {
+func NewHttp(option HttpOption) (*Http, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
 	var tlsConfig *tls.Config
 	if option.TLS {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: option.SkipCertVerify,
-			ClientSessionCache: getClientSessionCache(),
-			ServerName:         option.Server,
+		var err error
+		if tlsConfig, err = newTLSConfig(option.TLSOpts, option.Server, option.SkipCertVerify); err != nil {
+			return nil, fmt.Errorf("http %s initialize tls error: %w", addr, err)
 		}
 	}
 
 	return &Http{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.Http,
+			name:      option.Name,
+			addr:      addr,
+			tp:        C.Http,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
-		addr:      net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+		addr:      addr,
 		user:      option.UserName,
 		pass:      option.Password,
 		tlsConfig: tlsConfig,
-	}
+	}, nil
 }
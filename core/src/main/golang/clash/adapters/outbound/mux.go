@@ -0,0 +1,57 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Dreamacro/clash/common/structure"
+	"github.com/Dreamacro/clash/component/mux"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type muxOption struct {
+	Enabled     bool `mux:"enabled,omitempty"`
+	MaxStreams  int  `mux:"max-streams,omitempty"`
+	Padding     bool `mux:"padding,omitempty"`
+	IdleTimeout int  `mux:"idle-timeout,omitempty"`
+}
+
+// muxMetadata is the destination asking the proxy server for a mux connection
+var muxMetadata = &C.Metadata{
+	NetWork:  C.TCP,
+	AddrType: C.AtypDomainName,
+	Host:     "sp.mux.sing-box.arpa",
+	DstPort:  "444",
+}
+
+// newMuxClient return nil if mux-opts isn't enabled, dial connects the proxy server
+// and stream does the handshake of the proxy on it
+func newMuxClient(opts map[string]interface{}, dial func(ctx context.Context) (net.Conn, error), stream func(net.Conn, *C.Metadata) (net.Conn, error)) (*mux.Client, error) {
+	option := muxOption{}
+	decoder := structure.NewDecoder(structure.Option{TagName: "mux", WeaklyTypedInput: true})
+	if err := decoder.Decode(opts, &option); err != nil {
+		return nil, err
+	}
+	if !option.Enabled {
+		return nil, nil
+	}
+
+	return mux.NewClient(func(ctx context.Context) (net.Conn, error) {
+		c, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		sc, err := stream(c, muxMetadata)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return sc, nil
+	}, mux.Option{
+		MaxStreams:  option.MaxStreams,
+		Padding:     option.Padding,
+		IdleTimeout: time.Duration(option.IdleTimeout) * time.Second,
+	}), nil
+}
@@ -7,22 +7,55 @@ import (
 	C "github.com/Dreamacro/clash/constant"
 )
 
+// ParseProxy parse a proxy of the config file
 func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
+	return parseProxy(mapping, true)
+}
+
+// ParseProviderProxy parse a proxy of a proxy provider, the provider may be fetched from
+// remote so its proxies can't start an external plugin
+func ParseProviderProxy(mapping map[string]interface{}) (C.Proxy, error) {
+	return parseProxy(mapping, false)
+}
+
+func parseProxy(mapping map[string]interface{}, local bool) (C.Proxy, error) {
 	decoder := structure.NewDecoder(structure.Option{TagName: "proxy", WeaklyTypedInput: true})
 	proxyType, existType := mapping["type"].(string)
 	if !existType {
 		return nil, fmt.Errorf("Missing type")
 	}
 
+	basicOption := &BasicOption{}
+	if err := decoder.Decode(mapping, basicOption); err != nil {
+		return nil, err
+	}
+	if err := basicOption.IPVersion.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateResolve(basicOption.Resolve); err != nil {
+		return nil, err
+	}
+
 	var proxy C.ProxyAdapter
 	err := fmt.Errorf("Cannot parse")
 	switch proxyType {
+	case "direct":
+		directOption := &DirectOption{}
+		err = decoder.Decode(mapping, directOption)
+		if err != nil {
+			break
+		}
+		proxy = NewDirectWithOption(*directOption)
 	case "ss":
 		ssOption := &ShadowSocksOption{}
 		err = decoder.Decode(mapping, ssOption)
 		if err != nil {
 			break
 		}
+		if !local && isExternalPlugin(ssOption.Plugin) {
+			err = fmt.Errorf("ss %s plugin %s is only allowed in the config file", ssOption.Name, ssOption.Plugin)
+			break
+		}
 		proxy, err = NewShadowSocks(*ssOption)
 	case "ssr":
 		ssrOption := &ShadowSocksROption{}
@@ -37,14 +70,14 @@ func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
 		if err != nil {
 			break
 		}
-		proxy = NewSocks5(*socksOption)
+		proxy, err = NewSocks5(*socksOption)
 	case "http":
 		httpOption := &HttpOption{}
 		err = decoder.Decode(mapping, httpOption)
 		if err != nil {
 			break
 		}
-		proxy = NewHttp(*httpOption)
+		proxy, err = NewHttp(*httpOption)
 	case "vmess":
 		vmessOption := &VmessOption{}
 		err = decoder.Decode(mapping, vmessOption)
@@ -59,6 +92,34 @@ func ParseProxy(mapping map[string]interface{}) (C.Proxy, error) {
 			break
 		}
 		proxy, err = NewSnell(*snellOption)
+	case "trojan":
+		trojanOption := &TrojanOption{}
+		err = decoder.Decode(mapping, trojanOption)
+		if err != nil {
+			break
+		}
+		proxy, err = NewTrojan(*trojanOption)
+	case "vless":
+		vlessOption := &VlessOption{}
+		err = decoder.Decode(mapping, vlessOption)
+		if err != nil {
+			break
+		}
+		proxy, err = NewVless(*vlessOption)
+	case "wireguard":
+		wireGuardOption := &WireGuardOption{}
+		err = decoder.Decode(mapping, wireGuardOption)
+		if err != nil {
+			break
+		}
+		proxy, err = NewWireGuard(*wireGuardOption)
+	case "ssh":
+		sshOption := &SshOption{}
+		err = decoder.Decode(mapping, sshOption)
+		if err != nil {
+			break
+		}
+		proxy, err = NewSsh(*sshOption)
 	default:
 		return nil, fmt.Errorf("Unsupport proxy type: %s", proxyType)
 	}
@@ -1,37 +1,204 @@
 package outbound
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 
 	C "github.com/Dreamacro/clash/constant"
 )
 
+const (
+	// dropTimeout is how long REJECT-DROP holds a connection
+	dropTimeout = 30 * time.Second
+)
+
+type rejectMode int
+
+const (
+	rejectClose rejectMode = iota
+	rejectDrop
+	rejectReset
+	rejectPage
+	rejectTinyGIF
+)
+
+var (
+	blockPage = []byte("<html><head><title>403 Forbidden</title></head><body><h1>403 Forbidden</h1><p>The request is blocked by a rule of clash.</p></body></html>\n")
+
+	// tinyGIF is a transparent 1x1 gif
+	tinyGIF = []byte{
+		0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+	}
+)
+
 type Reject struct {
 	*Base
+	mode rejectMode
 }
 
 func (r *Reject) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	switch r.mode {
+	case rejectDrop:
+		return newConn(newDropConn(dropTimeout), r), nil
+	case rejectReset:
+		return &resetConn{newConn(&NopConn{}, r)}, nil
+	case rejectPage, rejectTinyGIF:
+		// the plain http requests of the http proxy are answered, the others can't understand it
+		if metadata.Type == C.HTTP {
+			return newConn(r.newResponseConn(), r), nil
+		}
+	}
 	return newConn(&NopConn{}, r), nil
 }
 
+func (r *Reject) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	c.Close()
+	return &NopConn{}, nil
+}
+
 func (r *Reject) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
 	return nil, errors.New("match reject rule")
 }
 
-func NewReject() *Reject {
+func (r *Reject) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	return r.DialUDP(metadata)
+}
+
+func (r *Reject) newResponseConn() net.Conn {
+	status, contentType, body := http.StatusForbidden, "text/html; charset=utf-8", blockPage
+	if r.mode == rejectTinyGIF {
+		status, contentType, body = http.StatusOK, "image/gif", tinyGIF
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	fmt.Fprintf(buf, "Content-Type: %s\r\nContent-Length: %d\r\nCache-Control: no-cache\r\nConnection: close\r\n\r\n", contentType, len(body))
+	buf.Write(body)
+	return &responseConn{response: buf}
+}
+
+func newReject(name string, mode rejectMode) *Reject {
 	return &Reject{
 		Base: &Base{
-			name: "REJECT",
+			name: name,
 			tp:   C.Reject,
 			udp:  true,
 		},
+		mode: mode,
 	}
 }
 
+// NewReject close the connections at once
+func NewReject() *Reject {
+	return newReject("REJECT", rejectClose)
+}
+
+// NewRejectDrop hold the connections silently until dropTimeout
+func NewRejectDrop() *Reject {
+	return newReject("REJECT-DROP", rejectDrop)
+}
+
+// NewRejectReset reset the tcp connections of the clients
+func NewRejectReset() *Reject {
+	return newReject("REJECT-RESET", rejectReset)
+}
+
+// NewRejectPage answer the plain http requests with a 403 page
+func NewRejectPage() *Reject {
+	return newReject("REJECT-PAGE", rejectPage)
+}
+
+// NewRejectTinyGIF answer the plain http requests with a 1x1 gif
+func NewRejectTinyGIF() *Reject {
+	return newReject("REJECT-TINYGIF", rejectTinyGIF)
+}
+
+// resetConn asks the tunnel to reset the inbound connection instead of relaying to it
+type resetConn struct {
+	C.Conn
+}
+
+// ResetInbound implements C.InboundResetter
+func (rc *resetConn) ResetInbound() {}
+
+// dropConn discards the writes, the reads block until it's closed or timeout
+type dropConn struct {
+	NopConn
+	closed chan struct{}
+	once   sync.Once
+	timer  *time.Timer
+	mux    sync.Mutex
+}
+
+func newDropConn(timeout time.Duration) *dropConn {
+	dc := &dropConn{closed: make(chan struct{})}
+	dc.timer = time.AfterFunc(timeout, func() { dc.Close() })
+	return dc
+}
+
+func (dc *dropConn) Read(b []byte) (int, error) {
+	<-dc.closed
+	return 0, io.EOF
+}
+
+func (dc *dropConn) Write(b []byte) (int, error) {
+	select {
+	case <-dc.closed:
+		return 0, io.EOF
+	default:
+		return len(b), nil
+	}
+}
+
+func (dc *dropConn) Close() error {
+	dc.once.Do(func() {
+		close(dc.closed)
+		dc.timer.Stop()
+	})
+	return nil
+}
+
+// SetDeadline closes dc at t, nothing is going to be read anyway
+func (dc *dropConn) SetDeadline(t time.Time) error {
+	return dc.SetReadDeadline(t)
+}
+
+// SetReadDeadline moves the close of dc to t, the relay sets it to stop the reading,
+// a zero t keeps the current one
+func (dc *dropConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+	dc.timer.Reset(time.Until(t))
+	return nil
+}
+
+// responseConn discards the request and reads a canned http response
+type responseConn struct {
+	NopConn
+	response *bytes.Buffer
+}
+
+func (rc *responseConn) Read(b []byte) (int, error) {
+	return rc.response.Read(b)
+}
+
+func (rc *responseConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
 type NopConn struct{}
 
 func (rw *NopConn) Read(b []byte) (int, error) {
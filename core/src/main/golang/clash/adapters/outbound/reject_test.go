@@ -0,0 +1,121 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapters/inbound"
+	"github.com/Dreamacro/clash/component/socks5"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dialReject(t *testing.T, r *Reject, tp C.Type) C.Conn {
+	c, err := NewProxy(r).DialContext(context.Background(), &C.Metadata{Type: tp, NetWork: C.TCP, Host: "example.com", DstPort: "80"})
+	assert.Nil(t, err)
+	return c
+}
+
+// readWithin read c in the background, the result is nil if it's still blocked after d
+func readWithin(c net.Conn, d time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func TestReject_Drop(t *testing.T) {
+	c := dialReject(t, NewRejectDrop(), C.SOCKS)
+	defer c.Close()
+
+	// the writes are swallowed, the reads hang
+	n, err := c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 18, n)
+	assert.Nil(t, readWithin(c, 100*time.Millisecond))
+
+	// a later deadline replaces the earlier one
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	c.SetReadDeadline(time.Time{})
+	assert.Nil(t, readWithin(c, 150*time.Millisecond))
+	assert.Equal(t, io.EOF, readWithin(c, time.Second))
+
+	_, err = c.Write([]byte{0})
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReject_Reset(t *testing.T) {
+	c := dialReject(t, NewRejectReset(), C.SOCKS)
+	_, ok := c.(C.InboundResetter)
+	assert.True(t, ok)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	server, err := l.Accept()
+	assert.Nil(t, err)
+
+	// the tunnel resets the client instead of closing it
+	inbound.ResetConn(inbound.NewSocket(socks5.ParseAddr("example.com:80"), server, C.SOCKS, C.TCP))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, syscall.ECONNRESET))
+}
+
+func TestReject_Page(t *testing.T) {
+	c := dialReject(t, NewRejectPage(), C.HTTP)
+	defer c.Close()
+
+	_, err := c.Write([]byte("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.Nil(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, blockPage, body)
+
+	// the other inbounds can't understand a page, they are closed
+	c = dialReject(t, NewRejectPage(), C.SOCKS)
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReject_TinyGIF(t *testing.T) {
+	c := dialReject(t, NewRejectTinyGIF(), C.HTTP)
+	defer c.Close()
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/gif", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, tinyGIF, body)
+
+	c = dialReject(t, NewRejectTinyGIF(), C.HTTPCONNECT)
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
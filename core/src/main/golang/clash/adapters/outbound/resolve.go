@@ -0,0 +1,88 @@
+package outbound
+
+import (
+	"fmt"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// the modes of resolve, where the host of the destination is resolved
+const (
+	// ResolveRemote send the host to the server, it's the default
+	ResolveRemote = "remote"
+	// ResolveLocal resolve the host by clash and send the ip
+	ResolveLocal = "local"
+)
+
+// ValidateResolve return an error if mode is neither empty nor a known mode
+func ValidateResolve(mode string) error {
+	switch mode {
+	case "", ResolveRemote, ResolveLocal:
+		return nil
+	}
+	return fmt.Errorf("unsupported resolve %s", mode)
+}
+
+// SetResolve set the resolve mode of the groups, the proxies take it from their options
+func (b *Base) SetResolve(mode string) {
+	b.resolve = mode
+}
+
+// remoteMetadata return the metadata sent to the server, with resolve: local its host is replaced by the ip
+func (b *Base) remoteMetadata(metadata *C.Metadata) (*C.Metadata, error) {
+	if b.resolve != ResolveLocal || metadata.Host == "" {
+		return metadata, nil
+	}
+
+	ip := metadata.DstIP
+	if ip == nil {
+		var err error
+		if ip, err = resolver.ResolveIPWithVersion(metadata.Host, b.ipVersion); err != nil {
+			return nil, fmt.Errorf("resolve %s error: %w", metadata.Host, err)
+		}
+	}
+
+	m := *metadata
+	m.Host = ""
+	if ip4 := ip.To4(); ip4 != nil {
+		m.AddrType = C.AtypIPv4
+		m.DstIP = ip4
+	} else {
+		m.AddrType = C.AtypIPv6
+		m.DstIP = ip
+	}
+	return &m, nil
+}
+
+// localResolver is implemented by Base, every proxy and group embeds it
+type localResolver interface {
+	remoteMetadata(*C.Metadata) (*C.Metadata, error)
+	resolvesLocally() bool
+}
+
+func (b *Base) resolvesLocally() bool {
+	return b.resolve == ResolveLocal
+}
+
+// remoteMetadata is Base.remoteMetadata of a
+func remoteMetadata(a C.ProxyAdapter, metadata *C.Metadata) (*C.Metadata, error) {
+	if r, ok := a.(localResolver); ok {
+		return r.remoteMetadata(metadata)
+	}
+	return metadata, nil
+}
+
+// resolvePacketConn resolve the destination of every packet written to it
+type resolvePacketConn struct {
+	C.PacketConn
+	adapter C.ProxyAdapter
+}
+
+func (pc *resolvePacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error) {
+	metadata, err = remoteMetadata(pc.adapter, metadata)
+	if err != nil {
+		return 0, err
+	}
+	return pc.PacketConn.WriteWithMetadata(p, metadata)
+}
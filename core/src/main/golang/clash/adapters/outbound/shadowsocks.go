@@ -6,11 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/Dreamacro/clash/common/structure"
-	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/mux"
+	"github.com/Dreamacro/clash/component/shadowsocks2022"
 	obfs "github.com/Dreamacro/clash/component/simple-obfs"
+	"github.com/Dreamacro/clash/component/sip003"
 	"github.com/Dreamacro/clash/component/socks5"
 	v2rayObfs "github.com/Dreamacro/clash/component/v2ray-plugin"
 	C "github.com/Dreamacro/clash/constant"
@@ -27,17 +31,29 @@ type ShadowSocks struct {
 	obfsMode    string
 	obfsOption  *simpleObfsOption
 	v2rayOption *v2rayObfs.Option
+
+	// plugin is the external SIP003 plugin, the connections go through its local port
+	plugin *sip003.Plugin
+
+	mux *mux.Client
+
+	// uotVersion is the udp over tcp version, 0 when the packets are sent over udp
+	uotVersion int
 }
 
 type ShadowSocksOption struct {
-	Name       string                 `proxy:"name"`
-	Server     string                 `proxy:"server"`
-	Port       int                    `proxy:"port"`
-	Password   string                 `proxy:"password"`
-	Cipher     string                 `proxy:"cipher"`
-	UDP        bool                   `proxy:"udp,omitempty"`
-	Plugin     string                 `proxy:"plugin,omitempty"`
-	PluginOpts map[string]interface{} `proxy:"plugin-opts,omitempty"`
+	BasicOption       `proxy:",squash"`
+	Name              string                 `proxy:"name"`
+	Server            string                 `proxy:"server"`
+	Port              int                    `proxy:"port"`
+	Password          string                 `proxy:"password"`
+	Cipher            string                 `proxy:"cipher"`
+	UDP               bool                   `proxy:"udp,omitempty"`
+	UDPOverTCP        bool                   `proxy:"udp-over-tcp,omitempty"`
+	UDPOverTCPVersion int                    `proxy:"udp-over-tcp-version,omitempty"`
+	Plugin            string                 `proxy:"plugin,omitempty"`
+	PluginOpts        map[string]interface{} `proxy:"plugin-opts,omitempty"`
+	MuxOpts           map[string]interface{} `proxy:"mux-opts,omitempty"`
 
 	// deprecated when bump to 1.0
 	Obfs     string `proxy:"obfs,omitempty"`
@@ -57,14 +73,11 @@ type v2rayObfsOption struct {
 	Headers        map[string]string `obfs:"headers,omitempty"`
 	SkipCertVerify bool              `obfs:"skip-cert-verify,omitempty"`
 	Mux            bool              `obfs:"mux,omitempty"`
+	// the tls of the plugin is set up in plugin-opts only, ss has no tls of its own
+	TLSOpts map[string]interface{} `obfs:"tls-opts,omitempty"`
 }
 
-func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", ss.server)
-	if err != nil {
-		return nil, fmt.Errorf("%s connect error: %w", ss.server, err)
-	}
-	tcpKeepAlive(c)
+func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 	switch ss.obfsMode {
 	case "tls":
 		c = obfs.NewTLSObfs(c, ss.obfsOption.Host)
@@ -79,18 +92,66 @@ func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata) (C
 		}
 	}
 	c = ss.cipher.StreamConn(c)
-	_, err = c.Write(serializesSocksAddr(metadata))
-	return newConn(c, ss), err
+	_, err := c.Write(serializesSocksAddr(metadata))
+	return c, err
+}
+
+func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	if ss.mux != nil {
+		c, err := ss.mux.DialContext(ctx, serializesSocksAddr(metadata))
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", ss.server, err)
+		}
+		return newConn(c, ss), nil
+	}
+
+	c, err := ss.dialServer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", ss.server, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	sc, err := ss.StreamConn(c, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(sc, ss), nil
+}
+
+func (ss *ShadowSocks) dialServer(ctx context.Context) (net.Conn, error) {
+	return ss.dialServerWithDialer(ctx, ss.Dialer())
+}
+
+func (ss *ShadowSocks) dialServerWithDialer(ctx context.Context, d C.Dialer) (net.Conn, error) {
+	if ss.plugin != nil {
+		// the plugin listens on loopback and reaches the server by itself
+		return (&net.Dialer{}).DialContext(ctx, "tcp", ss.plugin.Addr())
+	}
+	return d.DialContext(ctx, ss.server)
 }
 
 func (ss *ShadowSocks) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
-	pc, err := dialer.ListenPacket("udp", "")
+	return ss.DialUDPWithDialer(ss.Dialer(), metadata)
+}
+
+func (ss *ShadowSocks) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	if ss.uotVersion != 0 {
+		return ss.dialUoT(d, metadata)
+	}
+
+	pc, err := d.ListenPacket(ss.server)
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := resolveUDPAddr("udp", ss.server)
+	addr, err := resolveUDPAddr("udp", ss.server, ss.ipVersion)
 	if err != nil {
+		pc.Close()
 		return nil, err
 	}
 
@@ -98,17 +159,71 @@ func (ss *ShadowSocks) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
 	return newPacketConn(&ssPacketConn{PacketConn: pc, rAddr: addr}, ss), nil
 }
 
+// dialUoT carry the packets on a stream to the server instead of udp
+func (ss *ShadowSocks) dialUoT(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
+	defer cancel()
+	c, err := ss.dialServerWithDialer(ctx, d)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", ss.server, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	sc, err := ss.StreamConn(c, uotMetadata(ss.uotVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := newUoTPacketConn(sc, ss.uotVersion, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(pc, ss), nil
+}
+
 func (ss *ShadowSocks) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]string{
 		"type": ss.Type().String(),
 	})
 }
 
+// Destroy kill the plugin process and close the mux connections
+func (ss *ShadowSocks) Destroy() {
+	if ss.mux != nil {
+		ss.mux.Close()
+	}
+	if ss.plugin != nil {
+		ss.plugin.Close()
+	}
+}
+
+// isExternalPlugin report whether plugin is a SIP003 executable rather than a built-in one
+func isExternalPlugin(plugin string) bool {
+	return plugin != "" && plugin != "obfs" && plugin != "v2ray-plugin"
+}
+
 func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 	cipher := option.Cipher
 	password := option.Password
-	ciph, err := core.PickCipher(cipher, nil, password)
+
+	var ciph core.Cipher
+	var err error
+	uotVersion, err := parseUoTVersion(option.UDPOverTCP, option.UDPOverTCPVersion)
+	if err != nil {
+		return nil, fmt.Errorf("ss %s initialize udp over tcp error: %w", server, err)
+	}
+
+	if shadowsocks2022.IsShadowsocks2022(cipher) {
+		ciph, err = shadowsocks2022.New(cipher, password)
+	} else {
+		ciph, err = core.PickCipher(cipher, nil, password)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("ss %s initialize error: %w", server, err)
 	}
@@ -153,10 +268,8 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 
 		var tlsConfig *tls.Config
 		if opts.TLS {
-			tlsConfig = &tls.Config{
-				ServerName:         opts.Host,
-				InsecureSkipVerify: opts.SkipCertVerify,
-				ClientSessionCache: getClientSessionCache(),
+			if tlsConfig, err = newTLSConfig(opts.TLSOpts, opts.Host, opts.SkipCertVerify); err != nil {
+				return nil, fmt.Errorf("ss %s initialize v2ray-plugin tls error: %w", server, err)
 			}
 		}
 		v2rayOption = &v2rayObfs.Option{
@@ -168,11 +281,38 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 		}
 	}
 
-	return &ShadowSocks{
+	var plugin *sip003.Plugin
+	addr := server
+	if isExternalPlugin(option.Plugin) {
+		// a plugin is looked up in PATH by name, or relative to the config directory by path
+		path := option.Plugin
+		if strings.ContainsRune(path, filepath.Separator) {
+			path = C.Path.Resolve(path)
+		}
+
+		plugin, err = sip003.Start(sip003.Option{
+			Path:       path,
+			RemoteHost: option.Server,
+			RemotePort: option.Port,
+			Options:    sip003.EncodeOptions(option.PluginOpts),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ss %s start plugin %s error: %w", server, option.Plugin, err)
+		}
+		// the stream must go through the plugin, it can't be relayed
+		addr = ""
+	}
+
+	ss := &ShadowSocks{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.Shadowsocks,
-			udp:  option.UDP,
+			name:      option.Name,
+			addr:      addr,
+			tp:        C.Shadowsocks,
+			udp:       option.UDP || option.UDPOverTCP,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
 		server: server,
 		cipher: ciph,
@@ -180,7 +320,15 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 		obfsMode:    obfsMode,
 		v2rayOption: v2rayOption,
 		obfsOption:  obfsOption,
-	}, nil
+		plugin:      plugin,
+		uotVersion:  uotVersion,
+	}
+
+	if ss.mux, err = newMuxClient(option.MuxOpts, ss.dialServer, ss.StreamConn); err != nil {
+		ss.Destroy()
+		return nil, fmt.Errorf("ss %s initialize mux error: %w", server, err)
+	}
+	return ss, nil
 }
 
 type ssPacketConn struct {
@@ -210,6 +358,8 @@ func (spc *ssPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 		return 0, nil, e
 	}
 	addr := socks5.SplitAddr(b[:n])
+	// addr is overwritten by the payload
+	udpAddr := addr.UDPAddr()
 	copy(b, b[len(addr):])
-	return n - len(addr), addr.UDPAddr(), e
+	return n - len(addr), udpAddr, e
 }
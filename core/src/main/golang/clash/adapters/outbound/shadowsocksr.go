@@ -9,88 +9,96 @@ import (
 
 	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/shadowsocksr/encryption"
+	"github.com/Dreamacro/clash/component/shadowsocksr/encryption/stream"
+	"github.com/Dreamacro/clash/component/shadowsocksr/obfs"
+	"github.com/Dreamacro/clash/component/shadowsocksr/protocol"
 	C "github.com/Dreamacro/clash/constant"
 )
 
 type ShadowSocksR struct {
 	*Base
-	server string
-	cipher encryption.Cipher
-
-	host string
-	port uint16
-
-	protocol      string
-	protocolParam string
-	protocolData  interface{}
-	obfs          string
-	obfsParam     string
-	obfsData      interface{}
+	server   string
+	cipher   encryption.Cipher
+	obfs     obfs.Obfs
+	protocol protocol.Protocol
 }
 
 type ShadowSocksROption struct {
+	BasicOption   `proxy:",squash"`
 	Name          string `proxy:"name"`
 	Server        string `proxy:"server"`
 	Port          int    `proxy:"port"`
 	Password      string `proxy:"password"`
 	Cipher        string `proxy:"cipher"`
 	Protocol      string `proxy:"protocol"`
-	ProtocolParam string `proxy:"protocolparam"`
+	ProtocolParam string `proxy:"protocol-param,omitempty"`
 	Obfs          string `proxy:"obfs"`
-	ObfsParam     string `proxy:"obfsparam"`
+	ObfsParam     string `proxy:"obfs-param,omitempty"`
+	UDP           bool   `proxy:"udp,omitempty"`
 
-	// TODO: Add UDP support
-	// UDP bool `proxy:"udp,omitempty"`
+	// deprecated when bump to 1.0, the names used by the older ssr configs
+	ProtocolParamOld string `proxy:"protocolparam,omitempty"`
+	ObfsParamOld     string `proxy:"obfsparam,omitempty"`
 }
 
-func (ssr *ShadowSocksR) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", ssr.server)
+func (ssr *ShadowSocksR) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	c = ssr.obfs.StreamConn(c)
+	c, err := ssr.cipher.StreamConn(c)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ssr.server, err)
 	}
-	tcpKeepAlive(c)
-
-	// c = shadowsocksr.NewConnLogger(c, "before_sending")
+	var iv []byte
+	if conn, ok := c.(*stream.ShadowSocksRStreamConn); ok {
+		iv = conn.WriteIV
+	}
+	c = ssr.protocol.StreamConn(c, iv)
 
-	if c, err = ssr.cipher.StreamConn(c); err != nil {
+	if _, err := c.Write(serializesSocksAddr(metadata)); err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ssr.server, err)
 	}
+	return c, nil
+}
 
-	// ssconn := SSRUtils.NewSSTCPConn(c, cipher)
-	// if ssconn.Conn == nil || ssconn.RemoteAddr() == nil {
-	// 	return nil, fmt.Errorf("%s connect error: cannot establish connection", ssr.server)
-	// }
-
-	// ssconn.IObfs = SSRObfs.NewObfs(ssr.obfs)
-	// obfsServerInfo := &SSRServer.ServerInfoForObfs{
-	// 	Host:   ssr.host,
-	// 	Port:   ssr.port,
-	// 	TcpMss: 1460,
-	// 	Param:  ssr.obfsParam,
-	// }
-	// ssconn.IObfs.SetServerInfo(obfsServerInfo)
-	// ssconn.IObfs.SetData(ssconn.IObfs.GetData())
-
-	// ssconn.IProtocol = SSRProtocol.NewProtocol(ssr.protocol)
-	// protocolServerInfo := &SSRServer.ServerInfoForObfs{
-	// 	Host:   ssr.host,
-	// 	Port:   ssr.port,
-	// 	TcpMss: 1460,
-	// 	Param:  ssr.protocolParam,
-	// }
-	// ssconn.IProtocol.SetServerInfo(protocolServerInfo)
-	// ssconn.IProtocol.SetData(ssconn.IProtocol.GetData())
-
-	// c = shadowsocksr.NewConnLogger(c, "before_encryption")
-
-	addr := serializesSocksAddr(metadata)
-	if _, err := c.Write(addr); err != nil {
+func (ssr *ShadowSocksR) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	c, err := dialer.DialContext(ctx, "tcp", ssr.server, ssr.dialOptions()...)
+	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ssr.server, err)
 	}
+	tcpKeepAlive(c)
 
+	c, err = ssr.StreamConn(c, metadata)
+	if err != nil {
+		return nil, err
+	}
 	return newConn(c, ssr), nil
 }
 
+func (ssr *ShadowSocksR) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return ssr.DialUDPWithDialer(ssr.Dialer(), metadata)
+}
+
+func (ssr *ShadowSocksR) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	pc, err := d.ListenPacket(ssr.server)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := resolveUDPAddr("udp", ssr.server, ssr.ipVersion)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	// the obfs doesn't apply to the udp relay
+	epc, err := ssr.cipher.PacketConn(pc)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	epc = ssr.protocol.PacketConn(epc)
+	return newPacketConn(&ssPacketConn{PacketConn: epc, rAddr: addr}, ssr), nil
+}
+
 func (ssr *ShadowSocksR) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]string{
 		"type": ssr.Type().String(),
@@ -100,27 +108,53 @@ func (ssr *ShadowSocksR) MarshalJSON() ([]byte, error) {
 func NewShadowSocksR(option ShadowSocksROption) (*ShadowSocksR, error) {
 	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 
+	if option.ProtocolParam == "" {
+		option.ProtocolParam = option.ProtocolParamOld
+	}
+	if option.ObfsParam == "" {
+		option.ObfsParam = option.ObfsParamOld
+	}
+
 	ciph, err := encryption.PickCipher(option.Cipher, nil, option.Password)
 	if err != nil {
 		return nil, fmt.Errorf("ssr %s initialize error: %w", server, err)
 	}
 
+	obfsPlugin, err := obfs.PickObfs(option.Obfs, &obfs.Base{
+		Host:   option.Server,
+		Port:   option.Port,
+		Key:    ciph.Key(),
+		IVSize: ciph.IVSize(),
+		Param:  option.ObfsParam,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssr %s initialize error: %w", server, err)
+	}
+
+	protocolPlugin, err := protocol.PickProtocol(option.Protocol, &protocol.Base{
+		Key:      ciph.Key(),
+		Param:    option.ProtocolParam,
+		Overhead: obfsPlugin.Overhead(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssr %s initialize error: %w", server, err)
+	}
+
 	return &ShadowSocksR{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.ShadowsocksR,
-			udp:  false,
+			name:      option.Name,
+			addr:      server,
+			tp:        C.ShadowsocksR,
+			udp:       option.UDP,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
 
-		server: server,
-		cipher: ciph,
-
-		host: option.Server,
-		port: uint16(option.Port),
-
-		obfs:          option.Obfs,
-		obfsParam:     option.ObfsParam,
-		protocol:      option.Protocol,
-		protocolParam: option.ProtocolParam,
+		server:   server,
+		cipher:   ciph,
+		obfs:     obfsPlugin,
+		protocol: protocolPlugin,
 	}, nil
 }
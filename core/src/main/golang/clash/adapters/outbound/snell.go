@@ -7,7 +7,7 @@ import (
 	"strconv"
 
 	"github.com/Dreamacro/clash/common/structure"
-	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/mux"
 	obfs "github.com/Dreamacro/clash/component/simple-obfs"
 	"github.com/Dreamacro/clash/component/snell"
 	C "github.com/Dreamacro/clash/constant"
@@ -18,22 +18,20 @@ type Snell struct {
 	server     string
 	psk        []byte
 	obfsOption *simpleObfsOption
+	mux        *mux.Client
 }
 
 type SnellOption struct {
-	Name     string                 `proxy:"name"`
-	Server   string                 `proxy:"server"`
-	Port     int                    `proxy:"port"`
-	Psk      string                 `proxy:"psk"`
-	ObfsOpts map[string]interface{} `proxy:"obfs-opts,omitempty"`
+	BasicOption `proxy:",squash"`
+	Name        string                 `proxy:"name"`
+	Server      string                 `proxy:"server"`
+	Port        int                    `proxy:"port"`
+	Psk         string                 `proxy:"psk"`
+	ObfsOpts    map[string]interface{} `proxy:"obfs-opts,omitempty"`
+	MuxOpts     map[string]interface{} `proxy:"mux-opts,omitempty"`
 }
 
-func (s *Snell) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", s.server)
-	if err != nil {
-		return nil, fmt.Errorf("%s connect error: %w", s.server, err)
-	}
-	tcpKeepAlive(c)
+func (s *Snell) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 	switch s.obfsOption.Mode {
 	case "tls":
 		c = obfs.NewTLSObfs(c, s.obfsOption.Host)
@@ -43,10 +41,39 @@ func (s *Snell) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn,
 	}
 	c = snell.StreamConn(c, s.psk)
 	port, _ := strconv.Atoi(metadata.DstPort)
-	err = snell.WriteHeader(c, metadata.String(), uint(port))
+	err := snell.WriteHeader(c, metadata.String(), uint(port))
+	return c, err
+}
+
+func (s *Snell) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	if s.mux != nil {
+		c, err := s.mux.DialContext(ctx, serializesSocksAddr(metadata))
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", s.server, err)
+		}
+		return newConn(c, s), nil
+	}
+
+	c, err := s.dialServer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", s.server, err)
+	}
+
+	c, err = s.StreamConn(c, metadata)
 	return newConn(c, s), err
 }
 
+func (s *Snell) dialServer(ctx context.Context) (net.Conn, error) {
+	return s.Dialer().DialContext(ctx, s.server)
+}
+
+// Destroy close the mux connections
+func (s *Snell) Destroy() {
+	if s.mux != nil {
+		s.mux.Close()
+	}
+}
+
 func NewSnell(option SnellOption) (*Snell, error) {
 	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 	psk := []byte(option.Psk)
@@ -61,13 +88,24 @@ func NewSnell(option SnellOption) (*Snell, error) {
 		return nil, fmt.Errorf("snell %s obfs mode error: %s", server, obfsOption.Mode)
 	}
 
-	return &Snell{
+	s := &Snell{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.Snell,
+			name:      option.Name,
+			addr:      server,
+			tp:        C.Snell,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
 		server:     server,
 		psk:        psk,
 		obfsOption: obfsOption,
-	}, nil
+	}
+
+	var err error
+	if s.mux, err = newMuxClient(option.MuxOpts, s.dialServer, s.StreamConn); err != nil {
+		return nil, fmt.Errorf("snell %s initialize mux error: %w", server, err)
+	}
+	return s, nil
 }
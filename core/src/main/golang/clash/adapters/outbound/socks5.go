@@ -9,7 +9,7 @@ import (
 	"net"
 	"strconv"
 
-	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/mux"
 	"github.com/Dreamacro/clash/component/socks5"
 	C "github.com/Dreamacro/clash/constant"
 )
@@ -22,82 +22,109 @@ type Socks5 struct {
 	tls            bool
 	skipCertVerify bool
 	tlsConfig      *tls.Config
+	mux            *mux.Client
+
+	// uotVersion is the udp over tcp version, 0 when the packets are sent over udp
+	uotVersion int
 }
 
 type Socks5Option struct {
-	Name           string `proxy:"name"`
-	Server         string `proxy:"server"`
-	Port           int    `proxy:"port"`
-	UserName       string `proxy:"username,omitempty"`
-	Password       string `proxy:"password,omitempty"`
-	TLS            bool   `proxy:"tls,omitempty"`
-	UDP            bool   `proxy:"udp,omitempty"`
-	SkipCertVerify bool   `proxy:"skip-cert-verify,omitempty"`
+	BasicOption       `proxy:",squash"`
+	Name              string                 `proxy:"name"`
+	Server            string                 `proxy:"server"`
+	Port              int                    `proxy:"port"`
+	UserName          string                 `proxy:"username,omitempty"`
+	Password          string                 `proxy:"password,omitempty"`
+	TLS               bool                   `proxy:"tls,omitempty"`
+	UDP               bool                   `proxy:"udp,omitempty"`
+	UDPOverTCP        bool                   `proxy:"udp-over-tcp,omitempty"`
+	UDPOverTCPVersion int                    `proxy:"udp-over-tcp-version,omitempty"`
+	SkipCertVerify    bool                   `proxy:"skip-cert-verify,omitempty"`
+	MuxOpts           map[string]interface{} `proxy:"mux-opts,omitempty"`
+	TLSOpts           map[string]interface{} `proxy:"tls-opts,omitempty"`
 }
 
-func (ss *Socks5) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", ss.addr)
-
-	if err == nil && ss.tls {
+func (ss *Socks5) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	if ss.tls {
 		cc := tls.Client(c, ss.tlsConfig)
-		err = cc.Handshake()
+		if err := cc.Handshake(); err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+		}
 		c = cc
 	}
 
+	if _, err := socks5.ClientHandshake(c, serializesSocksAddr(metadata), socks5.CmdConnect, ss.socksUser()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (ss *Socks5) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	if ss.mux != nil {
+		c, err := ss.mux.DialContext(ctx, serializesSocksAddr(metadata))
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+		}
+		return newConn(c, ss), nil
+	}
+
+	c, err := ss.dialServer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
 	}
-	tcpKeepAlive(c)
-	var user *socks5.User
-	if ss.user != "" {
-		user = &socks5.User{
-			Username: ss.user,
-			Password: ss.pass,
+
+	defer func() {
+		if err != nil {
+			c.Close()
 		}
-	}
-	if _, err := socks5.ClientHandshake(c, serializesSocksAddr(metadata), socks5.CmdConnect, user); err != nil {
+	}()
+
+	sc, err := ss.StreamConn(c, metadata)
+	if err != nil {
 		return nil, err
 	}
-	return newConn(c, ss), nil
+	return newConn(sc, ss), nil
+}
+
+func (ss *Socks5) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return ss.DialUDPWithDialer(ss.Dialer(), metadata)
 }
 
-func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
+func (ss *Socks5) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
+	if ss.uotVersion != 0 {
+		return ss.dialUoT(d, metadata)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
 	defer cancel()
-	c, err := dialer.DialContext(ctx, "tcp", ss.addr)
+	c, err := d.DialContext(ctx, ss.addr)
 	if err != nil {
 		err = fmt.Errorf("%s connect error: %w", ss.addr, err)
 		return
 	}
 
-	if ss.tls {
-		cc := tls.Client(c, ss.tlsConfig)
-		err = cc.Handshake()
-		c = cc
-	}
-
 	defer func() {
 		if err != nil {
 			c.Close()
 		}
 	}()
 
-	tcpKeepAlive(c)
-	var user *socks5.User
-	if ss.user != "" {
-		user = &socks5.User{
-			Username: ss.user,
-			Password: ss.pass,
+	if ss.tls {
+		cc := tls.Client(c, ss.tlsConfig)
+		if err = cc.Handshake(); err != nil {
+			err = fmt.Errorf("%s connect error: %w", ss.addr, err)
+			return
 		}
+		c = cc
 	}
 
-	bindAddr, err := socks5.ClientHandshake(c, serializesSocksAddr(metadata), socks5.CmdUDPAssociate, user)
+	bindAddr, err := socks5.ClientHandshake(c, serializesSocksAddr(metadata), socks5.CmdUDPAssociate, ss.socksUser())
 	if err != nil {
 		err = fmt.Errorf("client hanshake error: %w", err)
 		return
 	}
 
-	pc, err := dialer.ListenPacket("udp", "")
+	pc, err := d.ListenPacket(bindAddr.String())
 	if err != nil {
 		return
 	}
@@ -113,29 +140,93 @@ func (ss *Socks5) DialUDP(metadata *C.Metadata) (_ C.PacketConn, err error) {
 	return newPacketConn(&socksPacketConn{PacketConn: pc, rAddr: bindAddr.UDPAddr(), tcpConn: c}, ss), nil
 }
 
-func NewSocks5(option Socks5Option) *Socks5 {
+// dialUoT carry the packets on a stream connected to the magic address instead of udp
+func (ss *Socks5) dialUoT(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
+	defer cancel()
+	c, err := d.DialContext(ctx, ss.addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	sc, err := ss.StreamConn(c, uotMetadata(ss.uotVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := newUoTPacketConn(sc, ss.uotVersion, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(pc, ss), nil
+}
+
+func (ss *Socks5) dialServer(ctx context.Context) (net.Conn, error) {
+	return ss.Dialer().DialContext(ctx, ss.addr)
+}
+
+// Destroy close the mux connections
+func (ss *Socks5) Destroy() {
+	if ss.mux != nil {
+		ss.mux.Close()
+	}
+}
+
+func (ss *Socks5) socksUser() *socks5.User {
+	if ss.user == "" {
+		return nil
+	}
+	return &socks5.User{
+		Username: ss.user,
+		Password: ss.pass,
+	}
+}
+
+func NewSocks5(option Socks5Option) (*Socks5, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	uotVersion, err := parseUoTVersion(option.UDPOverTCP, option.UDPOverTCPVersion)
+	if err != nil {
+		return nil, fmt.Errorf("socks5 %s initialize udp over tcp error: %w", addr, err)
+	}
+
 	var tlsConfig *tls.Config
 	if option.TLS {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: option.SkipCertVerify,
-			ClientSessionCache: getClientSessionCache(),
-			ServerName:         option.Server,
+		if tlsConfig, err = newTLSConfig(option.TLSOpts, option.Server, option.SkipCertVerify); err != nil {
+			return nil, fmt.Errorf("socks5 %s initialize tls error: %w", addr, err)
 		}
 	}
 
-	return &Socks5{
+	ss := &Socks5{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.Socks5,
-			udp:  option.UDP,
+			name:      option.Name,
+			addr:      addr,
+			tp:        C.Socks5,
+			udp:       option.UDP || option.UDPOverTCP,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
-		addr:           net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+		addr:           addr,
 		user:           option.UserName,
 		pass:           option.Password,
 		tls:            option.TLS,
 		skipCertVerify: option.SkipCertVerify,
 		tlsConfig:      tlsConfig,
+		uotVersion:     uotVersion,
+	}
+
+	if ss.mux, err = newMuxClient(option.MuxOpts, ss.dialServer, ss.StreamConn); err != nil {
+		return nil, fmt.Errorf("socks5 %s initialize mux error: %w", addr, err)
 	}
+	return ss, nil
 }
 
 type socksPacketConn struct {
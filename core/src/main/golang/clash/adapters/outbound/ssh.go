@@ -0,0 +1,110 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/component/ssh"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type Ssh struct {
+	*Base
+	server string
+	client *ssh.Client
+}
+
+type SshOption struct {
+	BasicOption          `proxy:",squash"`
+	Name                 string   `proxy:"name"`
+	Server               string   `proxy:"server"`
+	Port                 int      `proxy:"port"`
+	UserName             string   `proxy:"username"`
+	Password             string   `proxy:"password,omitempty"`
+	PrivateKey           string   `proxy:"private-key,omitempty"`
+	PrivateKeyPassphrase string   `proxy:"private-key-passphrase,omitempty"`
+	HostKey              []string `proxy:"host-key,omitempty"`
+	KnownHosts           string   `proxy:"known-hosts,omitempty"`
+	SkipHostKeyVerify    bool     `proxy:"skip-host-key-verify,omitempty"`
+	KeepAliveInterval    int      `proxy:"keep-alive-interval,omitempty"`
+}
+
+func (s *Ssh) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	c, err := s.client.DialContext(ctx, metadata.RemoteAddress())
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", s.server, err)
+	}
+	return newConn(c, s), nil
+}
+
+// DialContextWithDialer reach the server with d on a connection of its own, it's closed with the conn
+func (s *Ssh) DialContextWithDialer(ctx context.Context, d C.Dialer, metadata *C.Metadata) (C.Conn, error) {
+	client := s.client.WithDialer(d)
+	c, err := client.DialContext(ctx, metadata.RemoteAddress())
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("%s connect error: %w", s.server, err)
+	}
+	return newConn(&ownerConn{Conn: c, release: func() { client.Close() }}, s), nil
+}
+
+// Destroy close the connection kept by the client
+func (s *Ssh) Destroy() {
+	s.client.Close()
+}
+
+func NewSsh(option SshOption) (*Ssh, error) {
+	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	sshOption := ssh.Option{
+		Server:               server,
+		User:                 option.UserName,
+		Password:             option.Password,
+		PrivateKeyPassphrase: option.PrivateKeyPassphrase,
+		HostKeys:             option.HostKey,
+		SkipHostKeyVerify:    option.SkipHostKeyVerify,
+		KeepAlive:            time.Duration(option.KeepAliveInterval) * time.Second,
+	}
+	base := &Base{
+		name:      option.Name,
+		addr:      server,
+		tp:        C.Ssh,
+		iface:     option.Interface,
+		rmark:     option.RoutingMark,
+		ipVersion: option.IPVersion,
+		resolve:   option.Resolve,
+	}
+	sshOption.Dialer = base.Dialer()
+
+	// private-key is either the key itself or the path of it
+	if option.PrivateKey != "" {
+		if strings.Contains(option.PrivateKey, "PRIVATE KEY") {
+			sshOption.PrivateKey = []byte(option.PrivateKey)
+		} else {
+			key, err := ioutil.ReadFile(C.Path.Resolve(option.PrivateKey))
+			if err != nil {
+				return nil, fmt.Errorf("ssh %s load private key error: %w", server, err)
+			}
+			sshOption.PrivateKey = key
+		}
+	}
+	if option.KnownHosts != "" {
+		sshOption.KnownHosts = C.Path.Resolve(option.KnownHosts)
+	}
+
+	client, err := ssh.NewClient(sshOption)
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s initialize error: %w", server, err)
+	}
+
+	return &Ssh{
+		Base:   base,
+		server: server,
+		client: client,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package outbound
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Dreamacro/clash/common/structure"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type tlsOption struct {
+	ServerName     string   `tls:"servername,omitempty"`
+	ALPN           []string `tls:"alpn,omitempty"`
+	Fingerprint    string   `tls:"fingerprint,omitempty"`
+	ClientCert     string   `tls:"client-cert,omitempty"`
+	ClientKey      string   `tls:"client-key,omitempty"`
+	MinVersion     string   `tls:"min-version,omitempty"`
+	SkipCertVerify bool     `tls:"skip-cert-verify,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig build the tls config of a proxy from tls-opts, serverName and skipCertVerify are
+// the values from the options of the proxy, an empty serverName leaves it to the transport
+func newTLSConfig(opts map[string]interface{}, serverName string, skipCertVerify bool) (*tls.Config, error) {
+	option := tlsOption{}
+	decoder := structure.NewDecoder(structure.Option{TagName: "tls", WeaklyTypedInput: true})
+	if err := decoder.Decode(opts, &option); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         option.ALPN,
+		InsecureSkipVerify: skipCertVerify || option.SkipCertVerify,
+		ClientSessionCache: getClientSessionCache(),
+	}
+	if option.ServerName != "" {
+		config.ServerName = option.ServerName
+	}
+
+	if option.MinVersion != "" {
+		version, ok := tlsVersions[option.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls min-version %s", option.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if option.ClientCert != "" || option.ClientKey != "" {
+		cert, err := loadKeyPair(option.ClientCert, option.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if option.Fingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(option.Fingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid tls fingerprint %s", option.Fingerprint)
+		}
+
+		// the pinned certificate is trusted by itself, it may be self-signed,
+		// VerifyConnection runs on resumed connections too
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyFingerprint(state, fingerprint)
+		}
+	}
+
+	return config, nil
+}
+
+func verifyFingerprint(state tls.ConnectionState, fingerprint []byte) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate from the server")
+	}
+
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	if !bytes.Equal(sum[:], fingerprint) {
+		return fmt.Errorf("certificate fingerprint mismatch, got %s", hex.EncodeToString(sum[:]))
+	}
+	return nil
+}
+
+// loadKeyPair accepts either the pem content or the path of the certificate and the key
+func loadKeyPair(cert, key string) (tls.Certificate, error) {
+	certPEM, err := readPEM(cert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := readPEM(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func readPEM(s string) ([]byte, error) {
+	if strings.Contains(s, "-----BEGIN") {
+		return []byte(s), nil
+	}
+	if s == "" {
+		return nil, errors.New("client-cert and client-key must be set together")
+	}
+	return ioutil.ReadFile(C.Path.Resolve(s))
+}
@@ -0,0 +1,210 @@
+package outbound
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/tlstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handshake connect a tls server of serverConfig with the client config built from opts
+func handshake(t *testing.T, serverConfig *tls.Config, opts map[string]interface{}) (*tls.ConnectionState, error) {
+	clientConfig, err := newTLSConfig(opts, "127.0.0.1", false)
+	if !assert.Nil(t, err) {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return nil, err
+	}
+	defer l.Close()
+
+	states := make(chan *tls.ConnectionState, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			states <- nil
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		tc := tls.Server(c, serverConfig)
+		if tc.Handshake() != nil {
+			states <- nil
+			return
+		}
+		state := tc.ConnectionState()
+		states <- &state
+	}()
+
+	c, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	if !assert.Nil(t, err) {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tc := tls.Client(c, clientConfig)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	// the server verifies the client certificate after the client has finished
+	if state := <-states; state != nil {
+		return state, nil
+	}
+	return nil, errors.New("handshake refused by the server")
+}
+
+func newServerConfig(t *testing.T) (*tls.Config, tls.Certificate) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, cert
+}
+
+func TestNewTLSConfig_Fingerprint(t *testing.T) {
+	serverConfig, cert := newServerConfig(t)
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	// the self-signed certificate is trusted by its pin
+	_, err := handshake(t, serverConfig, map[string]interface{}{"fingerprint": hex.EncodeToString(sum[:])})
+	assert.Nil(t, err)
+
+	// the colon separated form
+	colon := ""
+	for i, b := range sum {
+		if i != 0 {
+			colon += ":"
+		}
+		colon += hex.EncodeToString([]byte{b})
+	}
+	_, err = handshake(t, serverConfig, map[string]interface{}{"fingerprint": colon})
+	assert.Nil(t, err)
+
+	sum[0] ^= 0xff
+	_, err = handshake(t, serverConfig, map[string]interface{}{"fingerprint": hex.EncodeToString(sum[:])})
+	assert.Contains(t, err.Error(), "fingerprint mismatch")
+
+	_, err = newTLSConfig(map[string]interface{}{"fingerprint": "abcd"}, "", false)
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSConfig_Verify(t *testing.T) {
+	serverConfig, _ := newServerConfig(t)
+
+	// without a pin the self-signed certificate is refused
+	_, err := handshake(t, serverConfig, nil)
+	assert.NotNil(t, err)
+
+	_, err = handshake(t, serverConfig, map[string]interface{}{"skip-cert-verify": true})
+	assert.Nil(t, err)
+}
+
+func TestNewTLSConfig_MinVersion(t *testing.T) {
+	serverConfig, _ := newServerConfig(t)
+	serverConfig.MaxVersion = tls.VersionTLS12
+
+	state, err := handshake(t, serverConfig, map[string]interface{}{"skip-cert-verify": true, "min-version": "1.2"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), state.Version)
+
+	_, err = handshake(t, serverConfig, map[string]interface{}{"skip-cert-verify": true, "min-version": "1.3"})
+	assert.NotNil(t, err)
+
+	_, err = newTLSConfig(map[string]interface{}{"min-version": "1.4"}, "", false)
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSConfig_ClientCert(t *testing.T) {
+	clientCert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+	certPEM, keyPEM, err := tlstest.EncodePEM(clientCert)
+	assert.Nil(t, err)
+
+	serverConfig, _ := newServerConfig(t)
+	serverConfig.ClientAuth = tls.RequireAnyClientCert
+
+	// the pem content
+	state, err := handshake(t, serverConfig, map[string]interface{}{
+		"skip-cert-verify": true,
+		"client-cert":      string(certPEM),
+		"client-key":       string(keyPEM),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, clientCert.Certificate[0], state.PeerCertificates[0].Raw)
+
+	// the paths of the files
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "client.crt"), certPEM, 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "client.key"), keyPEM, 0o600))
+	state, err = handshake(t, serverConfig, map[string]interface{}{
+		"skip-cert-verify": true,
+		"client-cert":      filepath.Join(dir, "client.crt"),
+		"client-key":       filepath.Join(dir, "client.key"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, clientCert.Certificate[0], state.PeerCertificates[0].Raw)
+
+	// without a client certificate the server refuses
+	_, err = handshake(t, serverConfig, map[string]interface{}{"skip-cert-verify": true})
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSConfig_BadKeyPair(t *testing.T) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+	certPEM, _, err := tlstest.EncodePEM(cert)
+	assert.Nil(t, err)
+
+	other, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+	_, otherKeyPEM, err := tlstest.EncodePEM(other)
+	assert.Nil(t, err)
+
+	for _, opts := range []map[string]interface{}{
+		// the key doesn't match the certificate
+		{"client-cert": string(certPEM), "client-key": string(otherKeyPEM)},
+		// not a pem
+		{"client-cert": "-----BEGIN CERTIFICATE-----\ngarbage", "client-key": string(otherKeyPEM)},
+		// set alone
+		{"client-cert": string(certPEM)},
+		{"client-cert": "/nonexistent/client.crt", "client-key": "/nonexistent/client.key"},
+	} {
+		_, err := newTLSConfig(opts, "", false)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNewShadowSocks_PluginTLSOpts(t *testing.T) {
+	option := ShadowSocksOption{
+		Name:     "ss",
+		Server:   "127.0.0.1",
+		Port:     443,
+		Password: "password",
+		Cipher:   "aes-128-gcm",
+		Plugin:   "v2ray-plugin",
+		PluginOpts: map[string]interface{}{
+			"mode":     "websocket",
+			"tls":      true,
+			"tls-opts": map[string]interface{}{"min-version": "1.4"},
+		},
+	}
+
+	// tls-opts is read from plugin-opts
+	_, err := NewShadowSocks(option)
+	assert.NotNil(t, err)
+
+	option.PluginOpts["tls-opts"] = map[string]interface{}{"min-version": "1.3"}
+	ss, err := NewShadowSocks(option)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), ss.v2rayOption.TLSConfig.MinVersion)
+}
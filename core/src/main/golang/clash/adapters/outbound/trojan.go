@@ -0,0 +1,145 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/trojan"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type Trojan struct {
+	*Base
+	server   string
+	instance *trojan.Trojan
+}
+
+type TrojanOption struct {
+	BasicOption    `proxy:",squash"`
+	Name           string                 `proxy:"name"`
+	Server         string                 `proxy:"server"`
+	Port           int                    `proxy:"port"`
+	Password       string                 `proxy:"password"`
+	ALPN           []string               `proxy:"alpn,omitempty"`
+	SNI            string                 `proxy:"sni,omitempty"`
+	SkipCertVerify bool                   `proxy:"skip-cert-verify,omitempty"`
+	UDP            bool                   `proxy:"udp,omitempty"`
+	TLSOpts        map[string]interface{} `proxy:"tls-opts,omitempty"`
+}
+
+func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return t.streamConn(c, trojan.CommandTCP, metadata)
+}
+
+// streamConn finish the tls handshake and send the header within tcpTimeout
+func (t *Trojan) streamConn(c net.Conn, command byte, metadata *C.Metadata) (net.Conn, error) {
+	c.SetDeadline(time.Now().Add(tcpTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	tc, err := t.instance.StreamConn(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", t.server, err)
+	}
+
+	if err := t.instance.WriteHeader(tc, command, serializesSocksAddr(metadata)); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (t *Trojan) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	c, err := dialer.DialContext(ctx, "tcp", t.server, t.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", t.server, err)
+	}
+	tcpKeepAlive(c)
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	tc, err := t.StreamConn(c, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(tc, t), nil
+}
+
+func (t *Trojan) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return t.DialUDPWithDialer(t.Dialer(), metadata)
+}
+
+func (t *Trojan) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
+	defer cancel()
+	c, err := d.DialContext(ctx, t.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", t.server, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	tc, err := t.streamConn(c, trojan.CommandUDP, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := t.instance.PacketConn(tc)
+	return newPacketConn(&trojanPacketConn{PacketConn: pc, conn: tc}, t), nil
+}
+
+func NewTrojan(option TrojanOption) (*Trojan, error) {
+	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	serverName := option.Server
+	if option.SNI != "" {
+		serverName = option.SNI
+	}
+
+	tlsConfig, err := newTLSConfig(option.TLSOpts, serverName, option.SkipCertVerify)
+	if err != nil {
+		return nil, fmt.Errorf("trojan %s initialize tls error: %w", server, err)
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = option.ALPN
+	}
+
+	tOption := &trojan.Option{
+		Password:  option.Password,
+		TLSConfig: tlsConfig,
+	}
+
+	return &Trojan{
+		Base: &Base{
+			name:      option.Name,
+			addr:      server,
+			tp:        C.Trojan,
+			udp:       option.UDP,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
+		},
+		server:   server,
+		instance: trojan.New(tOption),
+	}, nil
+}
+
+type trojanPacketConn struct {
+	net.PacketConn
+	conn net.Conn
+}
+
+func (tpc *trojanPacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error) {
+	return trojan.WritePacket(tpc.conn, serializesSocksAddr(metadata), p)
+}
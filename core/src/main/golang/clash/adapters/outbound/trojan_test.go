@@ -0,0 +1,47 @@
+package outbound
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrojan_CloseOnHandshakeError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer c.Close()
+
+		// not a tls server
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.Copy(ioutil.Discard, c)
+		closed <- err
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	trojan, err := NewTrojan(TrojanOption{Name: "trojan", Server: addr.IP.String(), Port: addr.Port, Password: "password"})
+	assert.Nil(t, err)
+
+	metadata := &C.Metadata{NetWork: C.TCP, AddrType: C.AtypDomainName, Host: "example.com", DstPort: "80"}
+	_, err = trojan.DialContext(context.Background(), metadata)
+	assert.NotNil(t, err)
+
+	// the server sees the connection closed instead of the read deadline
+	assert.Nil(t, <-closed)
+}
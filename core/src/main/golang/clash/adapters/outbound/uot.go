@@ -0,0 +1,54 @@
+package outbound
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/component/uot"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// parseUoTVersion return the udp over tcp version of a proxy, 0 when it's off,
+// the legacy version is the default since more servers support it
+func parseUoTVersion(enable bool, version int) (int, error) {
+	if !enable {
+		return 0, nil
+	}
+	if version == 0 {
+		version = uot.LegacyVersion
+	}
+	if _, err := uot.RequestHost(version); err != nil {
+		return 0, fmt.Errorf("%w: %d", err, version)
+	}
+	return version, nil
+}
+
+// uotMetadata is the destination of the stream carrying the packets of version
+func uotMetadata(version int) *C.Metadata {
+	host, _ := uot.RequestHost(version)
+	return &C.Metadata{
+		NetWork:  C.TCP,
+		AddrType: C.AtypDomainName,
+		Host:     host,
+		DstPort:  "0",
+	}
+}
+
+// newUoTPacketConn carry the packets on c opened to the uot metadata, metadata is the
+// destination of the first packet
+func newUoTPacketConn(c net.Conn, version int, metadata *C.Metadata) (*uotPacketConn, error) {
+	if version == uot.Version {
+		if err := uot.WriteRequest(c, serializesSocksAddr(metadata)); err != nil {
+			return nil, err
+		}
+	}
+	return &uotPacketConn{uot.NewPacketConn(c)}, nil
+}
+
+type uotPacketConn struct {
+	*uot.PacketConn
+}
+
+func (pc *uotPacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error) {
+	return pc.WritePacket(p, serializesSocksAddr(metadata))
+}
@@ -0,0 +1,56 @@
+package outbound
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/Dreamacro/clash/common/structure"
+	"github.com/Dreamacro/clash/component/uot"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUoTVersion(t *testing.T) {
+	version, err := parseUoTVersion(false, uot.Version)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version)
+
+	version, err = parseUoTVersion(true, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uot.LegacyVersion, version)
+
+	version, err = parseUoTVersion(true, uot.Version)
+	assert.Nil(t, err)
+	assert.Equal(t, uot.Version, version)
+
+	_, err = parseUoTVersion(true, 3)
+	assert.NotNil(t, err)
+}
+
+func TestShadowSocks_UoTDefaultVersion(t *testing.T) {
+	decoder := structure.NewDecoder(structure.Option{TagName: "proxy", WeaklyTypedInput: true})
+	option := ShadowSocksOption{}
+	err := decoder.Decode(map[string]interface{}{
+		"name":         "ss",
+		"server":       "127.0.0.1",
+		"port":         8388,
+		"cipher":       "aes-128-gcm",
+		"password":     "password",
+		"udp-over-tcp": true,
+	}, &option)
+	assert.Nil(t, err)
+
+	ss, err := NewShadowSocks(option)
+	assert.Nil(t, err)
+	assert.Equal(t, uot.LegacyVersion, ss.uotVersion)
+	assert.True(t, ss.SupportUDP())
+}
+
+func TestUoTMetadata_Vector(t *testing.T) {
+	// atyp domain, length, host, port 0
+	legacy := "03" + "14" + hex.EncodeToString([]byte("sp.udp-over-tcp.arpa")) + "0000"
+	assert.Equal(t, legacy, hex.EncodeToString(serializesSocksAddr(uotMetadata(uot.LegacyVersion))))
+
+	v2 := "03" + "17" + hex.EncodeToString([]byte("sp.v2.udp-over-tcp.arpa")) + "0000"
+	assert.Equal(t, v2, hex.EncodeToString(serializesSocksAddr(uotMetadata(uot.Version))))
+}
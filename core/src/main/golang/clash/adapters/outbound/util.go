@@ -2,6 +2,7 @@ package outbound
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/component/socks5"
 	C "github.com/Dreamacro/clash/constant"
@@ -24,6 +26,39 @@ var (
 	once                     sync.Once
 )
 
+// LocalDialer reaches the server of a proxy through the local network
+var LocalDialer C.Dialer = &localDialer{}
+
+type localDialer struct {
+	options []dialer.Option
+}
+
+func (d *localDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	c, err := dialer.DialContext(ctx, "tcp", address, d.options...)
+	if err != nil {
+		return nil, err
+	}
+	tcpKeepAlive(c)
+	return c, nil
+}
+
+func (d *localDialer) ListenPacket(address string) (net.PacketConn, error) {
+	return dialer.ListenPacket("udp", "", d.options...)
+}
+
+// ownerConn run release after it's closed, it's the conn owning the client it's dialed by
+type ownerConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (oc *ownerConn) Close() error {
+	err := oc.Conn.Close()
+	oc.once.Do(oc.release)
+	return err
+}
+
 func urlToMetadata(rawURL string) (addr C.Metadata, err error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
@@ -86,13 +121,13 @@ func serializesSocksAddr(metadata *C.Metadata) []byte {
 	return bytes.Join(buf, nil)
 }
 
-func resolveUDPAddr(network, address string) (*net.UDPAddr, error) {
+func resolveUDPAddr(network, address string, version resolver.IPVersion) (*net.UDPAddr, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return nil, err
 	}
 
-	ip, err := resolver.ResolveIP(host)
+	ip, err := resolver.ResolveIPWithVersion(host, version)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,142 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/vless"
+	"github.com/Dreamacro/clash/component/vmess"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type Vless struct {
+	*Base
+	server string
+	client *vless.Client
+}
+
+type VlessOption struct {
+	BasicOption    `proxy:",squash"`
+	Name           string                 `proxy:"name"`
+	Server         string                 `proxy:"server"`
+	Port           int                    `proxy:"port"`
+	UUID           string                 `proxy:"uuid"`
+	TLS            bool                   `proxy:"tls,omitempty"`
+	UDP            bool                   `proxy:"udp,omitempty"`
+	Network        string                 `proxy:"network,omitempty"`
+	WSPath         string                 `proxy:"ws-path,omitempty"`
+	WSHeaders      map[string]string      `proxy:"ws-headers,omitempty"`
+	HTTP2Opts      HTTP2Options           `proxy:"h2-opts,omitempty"`
+	GrpcOpts       GrpcOptions            `proxy:"grpc-opts,omitempty"`
+	SkipCertVerify bool                   `proxy:"skip-cert-verify,omitempty"`
+	TLSOpts        map[string]interface{} `proxy:"tls-opts,omitempty"`
+}
+
+func (v *Vless) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return v.client.New(c, parseVmessAddr(metadata))
+}
+
+func (v *Vless) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	c, err := v.Dialer().DialContext(ctx, v.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", v.server, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	vc, err := v.StreamConn(c, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(vc, v), nil
+}
+
+func (v *Vless) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return v.DialUDPWithDialer(v.Dialer(), metadata)
+}
+
+func (v *Vless) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
+	// vless use stream-oriented udp as vmess does, so clash needs a net.UDPAddr
+	if !metadata.Resolved() {
+		ip, err := resolver.ResolveIPWithVersion(metadata.Host, v.ipVersion)
+		if err != nil {
+			return nil, errors.New("can't resolve ip")
+		}
+		metadata.DstIP = ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
+	defer cancel()
+	c, err := d.DialContext(ctx, v.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", v.server, err)
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	vc, err := v.StreamConn(c, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("new vless client error: %v", err)
+	}
+	return newPacketConn(&vmessPacketConn{Conn: vc, rAddr: metadata.UDPAddr()}, v), nil
+}
+
+func NewVless(option VlessOption) (*Vless, error) {
+	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	var tlsConfig *tls.Config
+	if option.TLS {
+		var err error
+		// the server name is left to the client, it prefers the host of ws-headers
+		if tlsConfig, err = newTLSConfig(option.TLSOpts, "", option.SkipCertVerify); err != nil {
+			return nil, fmt.Errorf("vless %s initialize tls error: %w", server, err)
+		}
+	}
+
+	client, err := vless.NewClient(vless.Config{
+		UUID: option.UUID,
+		TransportConfig: vmess.TransportConfig{
+			TLS:              option.TLS,
+			HostName:         option.Server,
+			Port:             strconv.Itoa(option.Port),
+			NetWork:          option.Network,
+			WebSocketPath:    option.WSPath,
+			WebSocketHeaders: option.WSHeaders,
+			HTTP2Hosts:       option.HTTP2Opts.Host,
+			HTTP2Path:        option.HTTP2Opts.Path,
+			GrpcServiceName:  option.GrpcOpts.GrpcServiceName,
+			TLSConfig:        tlsConfig,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vless{
+		Base: &Base{
+			name:      option.Name,
+			addr:      server,
+			tp:        C.Vless,
+			udp:       option.UDP,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
+		},
+		server: server,
+		client: client,
+	}, nil
+}
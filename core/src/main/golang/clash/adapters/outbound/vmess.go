@@ -2,13 +2,14 @@ package outbound
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
 
-	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/mux"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/component/vmess"
 	C "github.com/Dreamacro/clash/constant"
@@ -18,37 +19,81 @@ type Vmess struct {
 	*Base
 	server string
 	client *vmess.Client
+	mux    *mux.Client
 }
 
 type VmessOption struct {
-	Name           string            `proxy:"name"`
-	Server         string            `proxy:"server"`
-	Port           int               `proxy:"port"`
-	UUID           string            `proxy:"uuid"`
-	AlterID        int               `proxy:"alterId"`
-	Cipher         string            `proxy:"cipher"`
-	TLS            bool              `proxy:"tls,omitempty"`
-	UDP            bool              `proxy:"udp,omitempty"`
-	Network        string            `proxy:"network,omitempty"`
-	WSPath         string            `proxy:"ws-path,omitempty"`
-	WSHeaders      map[string]string `proxy:"ws-headers,omitempty"`
-	SkipCertVerify bool              `proxy:"skip-cert-verify,omitempty"`
-}
-
-func (v *Vmess) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
-	c, err := dialer.DialContext(ctx, "tcp", v.server)
+	BasicOption    `proxy:",squash"`
+	Name           string                 `proxy:"name"`
+	Server         string                 `proxy:"server"`
+	Port           int                    `proxy:"port"`
+	UUID           string                 `proxy:"uuid"`
+	AlterID        int                    `proxy:"alterId"`
+	Cipher         string                 `proxy:"cipher"`
+	TLS            bool                   `proxy:"tls,omitempty"`
+	UDP            bool                   `proxy:"udp,omitempty"`
+	Network        string                 `proxy:"network,omitempty"`
+	WSPath         string                 `proxy:"ws-path,omitempty"`
+	WSHeaders      map[string]string      `proxy:"ws-headers,omitempty"`
+	HTTP2Opts      HTTP2Options           `proxy:"h2-opts,omitempty"`
+	GrpcOpts       GrpcOptions            `proxy:"grpc-opts,omitempty"`
+	SkipCertVerify bool                   `proxy:"skip-cert-verify,omitempty"`
+	MuxOpts        map[string]interface{} `proxy:"mux-opts,omitempty"`
+	TLSOpts        map[string]interface{} `proxy:"tls-opts,omitempty"`
+}
+
+type HTTP2Options struct {
+	Host []string `proxy:"host,omitempty"`
+	Path string   `proxy:"path,omitempty"`
+}
+
+type GrpcOptions struct {
+	GrpcServiceName string `proxy:"grpc-service-name,omitempty"`
+}
+
+func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	return v.client.New(c, parseVmessAddr(metadata))
+}
+
+func (v *Vmess) DialContext(ctx context.Context, metadata *C.Metadata) (_ C.Conn, err error) {
+	if v.mux != nil {
+		c, err := v.mux.DialContext(ctx, serializesSocksAddr(metadata))
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", v.server, err)
+		}
+		return newConn(c, v), nil
+	}
+
+	c, err := v.dialServer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error", v.server)
 	}
-	tcpKeepAlive(c)
-	c, err = v.client.New(c, parseVmessAddr(metadata))
-	return newConn(c, v), err
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	vc, err := v.StreamConn(c, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(vc, v), nil
+}
+
+func (v *Vmess) dialServer(ctx context.Context) (net.Conn, error) {
+	return v.Dialer().DialContext(ctx, v.server)
 }
 
 func (v *Vmess) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	return v.DialUDPWithDialer(v.Dialer(), metadata)
+}
+
+func (v *Vmess) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (_ C.PacketConn, err error) {
 	// vmess use stream-oriented udp, so clash needs a net.UDPAddr
 	if !metadata.Resolved() {
-		ip, err := resolver.ResolveIP(metadata.Host)
+		ip, err := resolver.ResolveIPWithVersion(metadata.Host, v.ipVersion)
 		if err != nil {
 			return nil, errors.New("can't resolve ip")
 		}
@@ -57,46 +102,84 @@ func (v *Vmess) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), tcpTimeout)
 	defer cancel()
-	c, err := dialer.DialContext(ctx, "tcp", v.server)
+	c, err := d.DialContext(ctx, v.server)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error", v.server)
 	}
-	tcpKeepAlive(c)
-	c, err = v.client.New(c, parseVmessAddr(metadata))
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	vc, err := v.StreamConn(c, metadata)
 	if err != nil {
 		return nil, fmt.Errorf("new vmess client error: %v", err)
 	}
-	return newPacketConn(&vmessPacketConn{Conn: c, rAddr: metadata.UDPAddr()}, v), nil
+	return newPacketConn(&vmessPacketConn{Conn: vc, rAddr: metadata.UDPAddr()}, v), nil
 }
 
 func NewVmess(option VmessOption) (*Vmess, error) {
+	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	var tlsConfig *tls.Config
+	if option.TLS {
+		var err error
+		// the server name is left to the client, it prefers the host of ws-headers
+		if tlsConfig, err = newTLSConfig(option.TLSOpts, "", option.SkipCertVerify); err != nil {
+			return nil, fmt.Errorf("vmess %s initialize tls error: %w", server, err)
+		}
+	}
+
 	security := strings.ToLower(option.Cipher)
 	client, err := vmess.NewClient(vmess.Config{
-		UUID:             option.UUID,
-		AlterID:          uint16(option.AlterID),
-		Security:         security,
-		TLS:              option.TLS,
-		HostName:         option.Server,
-		Port:             strconv.Itoa(option.Port),
-		NetWork:          option.Network,
-		WebSocketPath:    option.WSPath,
-		WebSocketHeaders: option.WSHeaders,
-		SkipCertVerify:   option.SkipCertVerify,
-		SessionCache:     getClientSessionCache(),
+		UUID:     option.UUID,
+		AlterID:  uint16(option.AlterID),
+		Security: security,
+		TransportConfig: vmess.TransportConfig{
+			TLS:              option.TLS,
+			HostName:         option.Server,
+			Port:             strconv.Itoa(option.Port),
+			NetWork:          option.Network,
+			WebSocketPath:    option.WSPath,
+			WebSocketHeaders: option.WSHeaders,
+			HTTP2Hosts:       option.HTTP2Opts.Host,
+			HTTP2Path:        option.HTTP2Opts.Path,
+			GrpcServiceName:  option.GrpcOpts.GrpcServiceName,
+			TLSConfig:        tlsConfig,
+		},
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &Vmess{
+	v := &Vmess{
 		Base: &Base{
-			name: option.Name,
-			tp:   C.Vmess,
-			udp:  true,
+			name:      option.Name,
+			addr:      server,
+			tp:        C.Vmess,
+			udp:       true,
+			iface:     option.Interface,
+			rmark:     option.RoutingMark,
+			ipVersion: option.IPVersion,
+			resolve:   option.Resolve,
 		},
-		server: net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+		server: server,
 		client: client,
-	}, nil
+	}
+
+	if v.mux, err = newMuxClient(option.MuxOpts, v.dialServer, v.StreamConn); err != nil {
+		return nil, fmt.Errorf("vmess %s initialize mux error: %w", server, err)
+	}
+	return v, nil
+}
+
+// Destroy close the mux connections
+func (v *Vmess) Destroy() {
+	if v.mux != nil {
+		v.mux.Close()
+	}
 }
 
 func parseVmessAddr(metadata *C.Metadata) *vmess.DstAddr {
@@ -0,0 +1,70 @@
+package outbound
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// serveNonTLS accept a connection, answer it as a plain http server and report how it's closed
+func serveNonTLS(t *testing.T) (*net.TCPAddr, <-chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	closed := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer c.Close()
+
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.Copy(ioutil.Discard, c)
+		closed <- err
+	}()
+	return l.Addr().(*net.TCPAddr), closed
+}
+
+func TestVmess_CloseOnHandshakeError(t *testing.T) {
+	addr, closed := serveNonTLS(t)
+	vmess, err := NewVmess(VmessOption{Name: "vmess", Server: addr.IP.String(), Port: addr.Port, UUID: testUUID, Cipher: "auto", TLS: true})
+	assert.Nil(t, err)
+
+	metadata := &C.Metadata{NetWork: C.TCP, AddrType: C.AtypDomainName, Host: "example.com", DstPort: "80"}
+	_, err = vmess.DialContext(context.Background(), metadata)
+	assert.NotNil(t, err)
+	assert.Nil(t, <-closed)
+}
+
+func TestVless_CloseOnHandshakeError(t *testing.T) {
+	addr, closed := serveNonTLS(t)
+	vless, err := NewVless(VlessOption{Name: "vless", Server: addr.IP.String(), Port: addr.Port, UUID: testUUID, TLS: true, UDP: true})
+	assert.Nil(t, err)
+
+	metadata := &C.Metadata{NetWork: C.TCP, AddrType: C.AtypDomainName, Host: "example.com", DstPort: "80"}
+	_, err = vless.DialContext(context.Background(), metadata)
+	assert.NotNil(t, err)
+	assert.Nil(t, <-closed)
+
+	addr, closed = serveNonTLS(t)
+	vless, err = NewVless(VlessOption{Name: "vless", Server: addr.IP.String(), Port: addr.Port, UUID: testUUID, TLS: true, UDP: true})
+	assert.Nil(t, err)
+
+	metadata = &C.Metadata{NetWork: C.UDP, AddrType: C.AtypIPv4, DstIP: net.IPv4(127, 0, 0, 1), DstPort: "53"}
+	_, err = vless.DialUDP(metadata)
+	assert.NotNil(t, err)
+	assert.Nil(t, <-closed)
+}
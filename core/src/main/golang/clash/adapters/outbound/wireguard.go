@@ -0,0 +1,243 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/wireguard"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type WireGuard struct {
+	*Base
+	server string
+	ipv4   bool
+	ipv6   bool
+	tunnel *wireguard.Tunnel
+	option wireguard.Option
+}
+
+type WireGuardOption struct {
+	BasicOption  `proxy:",squash"`
+	Name         string   `proxy:"name"`
+	Server       string   `proxy:"server"`
+	Port         int      `proxy:"port"`
+	IP           string   `proxy:"ip,omitempty"`
+	IPv6         string   `proxy:"ipv6,omitempty"`
+	PrivateKey   string   `proxy:"private-key"`
+	PublicKey    string   `proxy:"public-key"`
+	PreSharedKey string   `proxy:"pre-shared-key,omitempty"`
+	AllowedIPs   []string `proxy:"allowed-ips,omitempty"`
+	MTU          int      `proxy:"mtu,omitempty"`
+	Reserved     []int    `proxy:"reserved,omitempty"`
+	UDP          bool     `proxy:"udp,omitempty"`
+}
+
+func (w *WireGuard) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	c, err := w.dialContext(ctx, w.tunnel, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(c, w), nil
+}
+
+// DialContextWithDialer reach the server with d through a tunnel of its own, it's closed with the conn
+func (w *WireGuard) DialContextWithDialer(ctx context.Context, d C.Dialer, metadata *C.Metadata) (C.Conn, error) {
+	tunnel, err := w.newTunnel(d)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := w.dialContext(ctx, tunnel, metadata)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	return newConn(&ownerConn{Conn: c, release: tunnel.Close}, w), nil
+}
+
+func (w *WireGuard) dialContext(ctx context.Context, tunnel *wireguard.Tunnel, metadata *C.Metadata) (net.Conn, error) {
+	ip, err := w.resolve(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	port, _ := strconv.Atoi(metadata.DstPort)
+	c, err := tunnel.DialContext(ctx, ip, port)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", w.server, err)
+	}
+	return c, nil
+}
+
+func (w *WireGuard) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	pc, err := w.listenPacket(w.tunnel, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return newPacketConn(pc, w), nil
+}
+
+// DialUDPWithDialer reach the server with d through a tunnel of its own, it's closed with the packet conn
+func (w *WireGuard) DialUDPWithDialer(d C.Dialer, metadata *C.Metadata) (C.PacketConn, error) {
+	tunnel, err := w.newTunnel(d)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := w.listenPacket(tunnel, metadata)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	pc.release = tunnel.Close
+	return newPacketConn(pc, w), nil
+}
+
+func (w *WireGuard) listenPacket(tunnel *wireguard.Tunnel, metadata *C.Metadata) (*wireGuardPacketConn, error) {
+	ip, err := w.resolve(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := tunnel.ListenPacket(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &wireGuardPacketConn{PacketConn: pc, wg: w}, nil
+}
+
+// newTunnel start a tunnel to the same peer reaching the server with d
+func (w *WireGuard) newTunnel(d C.Dialer) (*wireguard.Tunnel, error) {
+	option := w.option
+	option.Dialer = d
+	tunnel, err := wireguard.New(option)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard %s initialize error: %w", w.server, err)
+	}
+	return tunnel, nil
+}
+
+// resolve the destination locally, the netstack in the tunnel only dials ips
+func (w *WireGuard) resolve(metadata *C.Metadata) (net.IP, error) {
+	if metadata.Resolved() {
+		return metadata.DstIP, nil
+	}
+
+	switch {
+	case !w.ipv6:
+		return resolver.ResolveIPv4(metadata.Host)
+	case !w.ipv4:
+		return resolver.ResolveIPv6(metadata.Host)
+	default:
+		return resolver.ResolveIP(metadata.Host)
+	}
+}
+
+type wireGuardPacketConn struct {
+	net.PacketConn
+	wg *WireGuard
+	// release stop the tunnel owned by the packet conn
+	release func()
+}
+
+func (pc *wireGuardPacketConn) Close() error {
+	err := pc.PacketConn.Close()
+	if pc.release != nil {
+		pc.release()
+	}
+	return err
+}
+
+func (pc *wireGuardPacketConn) WriteWithMetadata(p []byte, metadata *C.Metadata) (n int, err error) {
+	ip, err := pc.wg.resolve(metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	port, _ := strconv.Atoi(metadata.DstPort)
+	return pc.WriteTo(p, &net.UDPAddr{IP: ip, Port: port})
+}
+
+// Destroy stop the wireguard device
+func (w *WireGuard) Destroy() {
+	w.tunnel.Close()
+}
+
+func NewWireGuard(option WireGuardOption) (*WireGuard, error) {
+	server := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+
+	wgOption := wireguard.Option{
+		Server:       server,
+		PrivateKey:   option.PrivateKey,
+		PublicKey:    option.PublicKey,
+		PreSharedKey: option.PreSharedKey,
+		AllowedIPs:   option.AllowedIPs,
+		MTU:          option.MTU,
+	}
+
+	var err error
+	if option.IP != "" {
+		if wgOption.IP, err = parseTunnelIP(option.IP); err != nil || wgOption.IP.To4() == nil {
+			return nil, fmt.Errorf("wireguard %s ip error: %s", server, option.IP)
+		}
+	}
+	if option.IPv6 != "" {
+		if wgOption.IPv6, err = parseTunnelIP(option.IPv6); err != nil || wgOption.IPv6.To4() != nil {
+			return nil, fmt.Errorf("wireguard %s ipv6 error: %s", server, option.IPv6)
+		}
+	}
+
+	if len(option.Reserved) != 0 {
+		if len(option.Reserved) != len(wgOption.Reserved) {
+			return nil, fmt.Errorf("wireguard %s reserved must be 3 bytes", server)
+		}
+		for i, b := range option.Reserved {
+			wgOption.Reserved[i] = byte(b)
+		}
+	}
+
+	base := &Base{
+		name:      option.Name,
+		addr:      server,
+		tp:        C.WireGuard,
+		udp:       option.UDP,
+		iface:     option.Interface,
+		rmark:     option.RoutingMark,
+		ipVersion: option.IPVersion,
+		resolve:   option.Resolve,
+	}
+	wgOption.Dialer = base.Dialer()
+
+	tunnel, err := wireguard.New(wgOption)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard %s initialize error: %w", server, err)
+	}
+
+	return &WireGuard{
+		Base:   base,
+		server: server,
+		ipv4:   wgOption.IP != nil,
+		ipv6:   wgOption.IPv6 != nil,
+		tunnel: tunnel,
+		option: wgOption,
+	}, nil
+}
+
+// parseTunnelIP accepts both the address and the cidr notation of wg-quick
+func parseTunnelIP(s string) (net.IP, error) {
+	if strings.Contains(s, "/") {
+		ip, _, err := net.ParseCIDR(s)
+		return ip, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %s", s)
+	}
+	return ip, nil
+}
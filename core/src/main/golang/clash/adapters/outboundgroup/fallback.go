@@ -44,6 +44,10 @@ func (f *Fallback) SupportUDP() bool {
 	return proxy.SupportUDP()
 }
 
+func (f *Fallback) Unwrap(metadata *C.Metadata) C.Proxy {
+	return f.findAliveProxy()
+}
+
 func (f *Fallback) MarshalJSON() ([]byte, error) {
 	var all []string
 	for _, proxy := range f.proxies() {
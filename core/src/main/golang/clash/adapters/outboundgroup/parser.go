@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Dreamacro/clash/adapters/outbound"
 	"github.com/Dreamacro/clash/adapters/provider"
 	"github.com/Dreamacro/clash/common/structure"
 	C "github.com/Dreamacro/clash/constant"
@@ -25,6 +26,7 @@ type GroupCommonOption struct {
 	Use      []string `group:"use,omitempty"`
 	URL      string   `group:"url,omitempty"`
 	Interval int      `group:"interval,omitempty"`
+	Resolve  string   `group:"resolve,omitempty"`
 }
 
 func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy, providersMap map[string]provider.ProxyProvider) (C.ProxyAdapter, error) {
@@ -39,6 +41,10 @@ func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy,
 		return nil, errFormat
 	}
 
+	if err := outbound.ValidateResolve(groupOption.Resolve); err != nil {
+		return nil, err
+	}
+
 	groupName := groupOption.Name
 
 	providers := []provider.ProxyProvider{}
@@ -63,8 +69,8 @@ func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy,
 
 			providers = append(providers, pd)
 		} else {
-			// select don't need health check
-			if groupOption.Type == "select" {
+			// select and relay don't need health check
+			if groupOption.Type == "select" || groupOption.Type == "relay" {
 				hc := provider.NewHealthCheck(ps, "", 0)
 				pd, err := provider.NewCompatibleProvider(groupName, ps, hc)
 				if err != nil {
@@ -98,7 +104,10 @@ func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy,
 		providers = append(providers, list...)
 	}
 
-	var group C.ProxyAdapter
+	var group interface {
+		C.ProxyAdapter
+		SetResolve(mode string)
+	}
 	switch groupOption.Type {
 	case "url-test":
 		group = NewURLTest(groupName, providers)
@@ -108,9 +117,12 @@ func ParseProxyGroup(config map[string]interface{}, proxyMap map[string]C.Proxy,
 		group = NewFallback(groupName, providers)
 	case "load-balance":
 		group = NewLoadBalance(groupName, providers)
+	case "relay":
+		group = NewRelay(groupName, providers)
 	default:
 		return nil, fmt.Errorf("%w: %s", errType, groupOption.Type)
 	}
+	group.SetResolve(groupOption.Resolve)
 
 	return group, nil
 }
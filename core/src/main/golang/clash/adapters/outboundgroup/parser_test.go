@@ -0,0 +1,79 @@
+package outboundgroup
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapters/outbound"
+	"github.com/Dreamacro/clash/adapters/provider"
+	trie "github.com/Dreamacro/clash/component/domain-trie"
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyGroup_Resolve(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	hosts := trie.New()
+	hosts.Insert("echo.test", net.ParseIP("127.0.0.1"))
+	defaultHosts := resolver.DefaultHosts
+	resolver.DefaultHosts = hosts
+	defer func() { resolver.DefaultHosts = defaultHosts }()
+
+	targets := make(chan string, 1)
+	l := serveConnect(t, targets)
+	defer l.Close()
+
+	proxyMap := map[string]C.Proxy{"http": newTestHttp(t, "http", l)}
+	port := strconv.Itoa(echo.Addr().(*net.TCPAddr).Port)
+	metadata := &C.Metadata{NetWork: C.TCP, AddrType: C.AtypDomainName, Host: "echo.test", DstPort: port}
+
+	for _, mode := range []string{"remote", "local"} {
+		group, err := ParseProxyGroup(map[string]interface{}{
+			"name":    "group",
+			"type":    "select",
+			"proxies": []string{"http"},
+			"resolve": mode,
+		}, proxyMap, map[string]provider.ProxyProvider{})
+		assert.Nil(t, err)
+
+		// the stand-in can't reach echo.test by itself, only local makes it through
+		c, err := outbound.NewProxy(group).DialContext(context.Background(), metadata)
+		if mode == "local" {
+			assert.Nil(t, err)
+			c.Close()
+			assert.Equal(t, "127.0.0.1:"+port, <-targets)
+		} else {
+			assert.NotNil(t, err)
+			assert.Equal(t, "echo.test:"+port, <-targets)
+		}
+	}
+	assert.Equal(t, "echo.test", metadata.Host)
+
+	_, err = ParseProxyGroup(map[string]interface{}{
+		"name":    "group",
+		"type":    "select",
+		"proxies": []string{"http"},
+		"resolve": "nowhere",
+	}, proxyMap, map[string]provider.ProxyProvider{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,186 @@
+package outboundgroup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/adapters/outbound"
+	"github.com/Dreamacro/clash/adapters/provider"
+	"github.com/Dreamacro/clash/common/singledo"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+type Relay struct {
+	*outbound.Base
+	single    *singledo.Single
+	providers []provider.ProxyProvider
+}
+
+func (r *Relay) DialContext(ctx context.Context, metadata *C.Metadata) (C.Conn, error) {
+	proxies, err := r.hops(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	last := proxies[len(proxies)-1]
+	c, err := last.DialContextWithDialer(ctx, newRelayDialer(proxies[:len(proxies)-1], proxies[0]), metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	r.appendToChains(c, proxies)
+	return c, nil
+}
+
+func (r *Relay) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
+	proxies, err := r.hops(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	last := proxies[len(proxies)-1]
+	pc, err := last.DialUDPWithDialer(newRelayDialer(proxies[:len(proxies)-1], proxies[0]), metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	r.appendToChains(pc, proxies)
+	return pc, nil
+}
+
+// appendToChains append the hops before the last one and the relay itself to the chains of c
+func (r *Relay) appendToChains(c C.Connection, proxies []C.Proxy) {
+	for i := len(proxies) - 2; i >= 0; i-- {
+		c.AppendToChains(proxies[i])
+	}
+	c.AppendToChains(r)
+}
+
+func (r *Relay) SupportUDP() bool {
+	for _, proxy := range r.proxies() {
+		if !proxy.SupportUDP() {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Relay) MarshalJSON() ([]byte, error) {
+	var all []string
+	for _, proxy := range r.proxies() {
+		all = append(all, proxy.Name())
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": r.Type().String(),
+		"all":  all,
+	})
+}
+
+func (r *Relay) GetProviders() []provider.ProxyProvider {
+	return r.providers
+}
+
+func (r *Relay) proxies() []C.Proxy {
+	elm, _, _ := r.single.Do(func() (interface{}, error) {
+		return getProvidersProxies(r.providers), nil
+	})
+
+	return elm.([]C.Proxy)
+}
+
+// hops return the proxies of the relay, the groups are replaced by the proxies they pick for metadata
+func (r *Relay) hops(metadata *C.Metadata) ([]C.Proxy, error) {
+	var proxies []C.Proxy
+	for _, proxy := range r.proxies() {
+		for p := proxy.Unwrap(metadata); p != nil; p = p.Unwrap(metadata) {
+			proxy = p
+		}
+
+		if proxy.Addr() == "" {
+			return nil, fmt.Errorf("%s can't be a hop of relay %s", proxy.Name(), r.Name())
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("relay %s has no proxy", r.Name())
+	}
+	return proxies, nil
+}
+
+// relayDialer reaches the server of the next hop through proxies,
+// the first hop is reached by local, the dialer of its own
+type relayDialer struct {
+	proxies []C.Proxy
+	local   C.Dialer
+}
+
+func newRelayDialer(proxies []C.Proxy, first C.Proxy) *relayDialer {
+	return &relayDialer{proxies: proxies, local: first.Dialer()}
+}
+
+func (d *relayDialer) next() *relayDialer {
+	return &relayDialer{proxies: d.proxies[:len(d.proxies)-1], local: d.local}
+}
+
+func (d *relayDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	if len(d.proxies) == 0 {
+		return d.local.DialContext(ctx, address)
+	}
+
+	metadata, err := addrToMetadata(address, C.TCP)
+	if err != nil {
+		return nil, err
+	}
+
+	last := d.proxies[len(d.proxies)-1]
+	return last.DialContextWithDialer(ctx, d.next(), metadata)
+}
+
+func (d *relayDialer) ListenPacket(address string) (net.PacketConn, error) {
+	if len(d.proxies) == 0 {
+		return d.local.ListenPacket(address)
+	}
+
+	metadata, err := addrToMetadata(address, C.UDP)
+	if err != nil {
+		return nil, err
+	}
+
+	last := d.proxies[len(d.proxies)-1]
+	return last.DialUDPWithDialer(d.next(), metadata)
+}
+
+func addrToMetadata(address string, network C.NetWork) (*C.Metadata, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+
+	metadata := &C.Metadata{
+		NetWork:  network,
+		AddrType: C.AtypDomainName,
+		Host:     host,
+		DstPort:  port,
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		metadata.Host = ""
+		metadata.DstIP = ip
+		metadata.AddrType = C.AtypIPv6
+		if ip.To4() != nil {
+			metadata.AddrType = C.AtypIPv4
+		}
+	}
+	return metadata, nil
+}
+
+func NewRelay(name string, providers []provider.ProxyProvider) *Relay {
+	return &Relay{
+		Base:      outbound.NewBase(name, C.Relay, false),
+		single:    singledo.NewSingle(defaultGetProxiesDuration),
+		providers: providers,
+	}
+}
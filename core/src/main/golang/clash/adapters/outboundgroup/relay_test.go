@@ -0,0 +1,334 @@
+package outboundgroup
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapters/outbound"
+	"github.com/Dreamacro/clash/adapters/provider"
+	"github.com/Dreamacro/clash/component/socks5"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/Dreamacro/go-shadowsocks2/core"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveConnect is a http proxy stand-in, it records the targets of CONNECT
+func serveConnect(t *testing.T, targets chan<- string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				targets <- req.Host
+
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+				go io.Copy(remote, c)
+				io.Copy(c, remote)
+			}()
+		}
+	}()
+	return l
+}
+
+func newTestHttp(t *testing.T, name string, l net.Listener) C.Proxy {
+	addr := l.Addr().(*net.TCPAddr)
+	h, err := outbound.NewHttp(outbound.HttpOption{Name: name, Server: addr.IP.String(), Port: addr.Port})
+	assert.Nil(t, err)
+	return outbound.NewProxy(h)
+}
+
+func newTestRelay(t *testing.T, proxies ...C.Proxy) *Relay {
+	pd, err := provider.NewCompatibleProvider("relay", proxies, provider.NewHealthCheck(proxies, "", 0))
+	assert.Nil(t, err)
+	return NewRelay("relay", []provider.ProxyProvider{pd})
+}
+
+func TestRelay(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	targets1, targets2 := make(chan string, 1), make(chan string, 1)
+	l1, l2 := serveConnect(t, targets1), serveConnect(t, targets2)
+	defer l1.Close()
+	defer l2.Close()
+
+	// the second hop is picked by a selector
+	hop1, hop2 := newTestHttp(t, "hop1", l1), newTestHttp(t, "hop2", l2)
+	pd, err := provider.NewCompatibleProvider("selector", []C.Proxy{hop2}, provider.NewHealthCheck(nil, "", 0))
+	assert.Nil(t, err)
+	selector := outbound.NewProxy(NewSelector("selector", []provider.ProxyProvider{pd}))
+	relay := newTestRelay(t, hop1, selector)
+
+	echoAddr := echo.Addr().(*net.TCPAddr)
+	metadata := &C.Metadata{
+		NetWork:  C.TCP,
+		AddrType: C.AtypIPv4,
+		DstIP:    echoAddr.IP,
+		DstPort:  strconv.Itoa(echoAddr.Port),
+	}
+	c, err := relay.DialContext(context.Background(), metadata)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	// every hop connects the next one
+	assert.Equal(t, l2.Addr().String(), <-targets1)
+	assert.Equal(t, echo.Addr().String(), <-targets2)
+	assert.Equal(t, C.Chain{"hop2", "hop1", "relay"}, c.Chains())
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	// http doesn't relay udp
+	assert.False(t, relay.SupportUDP())
+	_, err = relay.DialUDP(metadata)
+	assert.NotNil(t, err)
+}
+
+// serveShadowsocksUDP is a shadowsocks udp relay stand-in, it relays one packet and its reply
+func serveShadowsocksUDP(t *testing.T) net.PacketConn {
+	ciph, _ := core.PickCipher("AES-128-GCM", nil, "password")
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		pc := ciph.PacketConn(l)
+		buf := make([]byte, 2048)
+		n, client, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		target := append(socks5.Addr{}, socks5.SplitAddr(buf[:n])...)
+
+		remote, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer remote.Close()
+		remote.WriteTo(buf[len(target):n], target.UDPAddr())
+		n, _, err = remote.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(append(target, buf[:n]...), client)
+	}()
+	return l
+}
+
+func newTestShadowsocks(name string, l net.PacketConn) C.Proxy {
+	addr := l.LocalAddr().(*net.UDPAddr)
+	ss, _ := outbound.NewShadowSocks(outbound.ShadowSocksOption{
+		Name: name, Server: addr.IP.String(), Port: addr.Port, Password: "password", Cipher: "AES-128-GCM", UDP: true,
+	})
+	return outbound.NewProxy(ss)
+}
+
+func TestRelay_UDP(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		n, addr, err := echo.ReadFrom(buf)
+		if err == nil {
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	l1, l2 := serveShadowsocksUDP(t), serveShadowsocksUDP(t)
+	defer l1.Close()
+	defer l2.Close()
+	relay := newTestRelay(t, newTestShadowsocks("hop1", l1), newTestShadowsocks("hop2", l2))
+	assert.True(t, relay.SupportUDP())
+
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+	metadata := &C.Metadata{
+		NetWork:  C.UDP,
+		AddrType: C.AtypIPv4,
+		DstIP:    echoAddr.IP,
+		DstPort:  strconv.Itoa(echoAddr.Port),
+	}
+	pc, err := relay.DialUDP(metadata)
+	assert.Nil(t, err)
+	defer pc.Close()
+	assert.Equal(t, C.Chain{"hop2", "hop1", "relay"}, pc.Chains())
+
+	_, err = pc.WriteWithMetadata([]byte("ping"), metadata)
+	assert.Nil(t, err)
+	buf := make([]byte, 2048)
+	n, addr, err := pc.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf[:n])
+	assert.Equal(t, echo.LocalAddr(), addr)
+}
+
+// serveSSH is a ssh server stand-in, it forwards the direct-tcpip channels of any user
+func serveSSH(t *testing.T) net.Listener {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	hostKey, err := ssh.NewSignerFromKey(key)
+	assert.Nil(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					c.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for newChan := range chans {
+					var target struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}
+					ssh.Unmarshal(newChan.ExtraData(), &target)
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChan.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, chReqs, _ := newChan.Accept()
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						io.Copy(ch, remote)
+						ch.Close()
+					}()
+					go func() {
+						io.Copy(remote, ch)
+						remote.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func newTestSsh(t *testing.T, name string, l net.Listener) C.Proxy {
+	addr := l.Addr().(*net.TCPAddr)
+	s, err := outbound.NewSsh(outbound.SshOption{
+		Name: name, Server: addr.IP.String(), Port: addr.Port, UserName: "user", Password: "password", SkipHostKeyVerify: true,
+	})
+	assert.Nil(t, err)
+	return outbound.NewProxy(s)
+}
+
+func TestRelay_SshHop(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	l1, l2 := serveSSH(t), serveSSH(t)
+	defer l1.Close()
+	defer l2.Close()
+	hop1, hop2 := newTestSsh(t, "hop1", l1), newTestSsh(t, "hop2", l2)
+	defer hop1.Destroy()
+	defer hop2.Destroy()
+	relay := newTestRelay(t, hop1, hop2)
+
+	echoAddr := echo.Addr().(*net.TCPAddr)
+	c, err := relay.DialContext(context.Background(), &C.Metadata{
+		NetWork:  C.TCP,
+		AddrType: C.AtypIPv4,
+		DstIP:    echoAddr.IP,
+		DstPort:  strconv.Itoa(echoAddr.Port),
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, C.Chain{"hop2", "hop1", "relay"}, c.Chains())
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestRelay_DirectHop(t *testing.T) {
+	relay := newTestRelay(t, outbound.NewProxy(outbound.NewDirect()))
+	_, err := relay.DialContext(context.Background(), &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "80"})
+	assert.NotNil(t, err)
+}
+
+func TestAddrToMetadata(t *testing.T) {
+	metadata, err := addrToMetadata("1.2.3.4:443", C.TCP)
+	assert.Nil(t, err)
+	assert.Equal(t, C.AtypIPv4, metadata.AddrType)
+	assert.Equal(t, "1.2.3.4:443", metadata.RemoteAddress())
+
+	metadata, err = addrToMetadata("[::1]:443", C.UDP)
+	assert.Nil(t, err)
+	assert.Equal(t, C.AtypIPv6, metadata.AddrType)
+	assert.Equal(t, C.UDP, metadata.NetWork)
+
+	metadata, err = addrToMetadata("example.com:443", C.TCP)
+	assert.Nil(t, err)
+	assert.Equal(t, C.AtypDomainName, metadata.AddrType)
+	assert.Equal(t, "example.com", metadata.Host)
+
+	_, err = addrToMetadata("example.com", C.TCP)
+	assert.NotNil(t, err)
+}
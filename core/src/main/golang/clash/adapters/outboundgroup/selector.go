@@ -38,6 +38,10 @@ func (s *Selector) SupportUDP() bool {
 	return s.selected.SupportUDP()
 }
 
+func (s *Selector) Unwrap(metadata *C.Metadata) C.Proxy {
+	return s.selected
+}
+
 func (s *Selector) MarshalJSON() ([]byte, error) {
 	var all []string
 	for _, proxy := range s.proxies() {
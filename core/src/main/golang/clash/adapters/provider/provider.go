@@ -108,6 +108,7 @@ func (pp *ProxySetProvider) Destroy() error {
 		pp.ticker.Stop()
 	}
 
+	destroyProxies(pp.proxies)
 	return nil
 }
 
@@ -202,6 +203,7 @@ func (pp *ProxySetProvider) pull() error {
 	log.Infoln("[Provider] %s's proxies update", pp.Name())
 
 	if err := ioutil.WriteFile(pp.vehicle.Path(), buf, fileMode); err != nil {
+		destroyProxies(proxies)
 		return err
 	}
 
@@ -225,8 +227,9 @@ func (pp *ProxySetProvider) parse(buf []byte) ([]C.Proxy, error) {
 
 	proxies := []C.Proxy{}
 	for idx, mapping := range schema.Proxies {
-		proxy, err := outbound.ParseProxy(mapping)
+		proxy, err := outbound.ParseProviderProxy(mapping)
 		if err != nil {
+			destroyProxies(proxies)
 			return nil, fmt.Errorf("Proxy %d error: %w", idx, err)
 		}
 		proxies = append(proxies, proxy)
@@ -240,9 +243,19 @@ func (pp *ProxySetProvider) parse(buf []byte) ([]C.Proxy, error) {
 }
 
 func (pp *ProxySetProvider) setProxies(proxies []C.Proxy) {
+	old := pp.proxies
 	pp.proxies = proxies
 	pp.healthCheck.setProxy(proxies)
 	go pp.healthCheck.check()
+
+	// the replaced proxies are owned by the provider only
+	destroyProxies(old)
+}
+
+func destroyProxies(proxies []C.Proxy) {
+	for _, proxy := range proxies {
+		proxy.Destroy()
+	}
 }
 
 func NewProxySetProvider(name string, interval time.Duration, vehicle Vehicle, hc *HealthCheck) *ProxySetProvider {
@@ -3,6 +3,7 @@ package provider
 import (
 	"context"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"time"
 
@@ -87,7 +88,9 @@ func (h *HTTPVehicle) Read() ([]byte, error) {
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		DialContext:           dialer.DialContext,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
 	}
 
 	client := http.Client{Transport: transport}
@@ -44,6 +44,11 @@ func (c *cache) Get(key interface{}) interface{} {
 	return elm.Payload
 }
 
+// Delete element in Cache
+func (c *cache) Delete(key interface{}) {
+	c.mapping.Delete(key)
+}
+
 // GetWithExpire element in Cache with Expire Time
 func (c *cache) GetWithExpire(key interface{}) (payload interface{}, expired time.Time) {
 	item, exist := c.mapping.Load(key)
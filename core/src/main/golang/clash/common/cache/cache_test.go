@@ -22,6 +22,14 @@ func TestCache_Basic(t *testing.T) {
 	assert.Equal(t, s.(string), "a", "should recv 'a'")
 }
 
+func TestCache_Delete(t *testing.T) {
+	c := New(200 * time.Millisecond)
+	c.Put("int", 1, time.Second)
+	c.Delete("int")
+
+	assert.Nil(t, c.Get("int"))
+}
+
 func TestCache_TTL(t *testing.T) {
 	interval := 200 * time.Millisecond
 	ttl := 20 * time.Millisecond
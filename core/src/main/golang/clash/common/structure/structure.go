@@ -44,6 +44,14 @@ func (d *Decoder) Decode(src map[string]interface{}, dst interface{}) error {
 		omitempty := false
 		if len(str) > 1 {
 			omitempty = str[1] == "omitempty"
+
+			// the fields of a squashed struct are decoded from src as its parent's
+			if str[1] == "squash" {
+				if err := d.Decode(src, v.Field(idx).Addr().Interface()); err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
 		value, ok := src[key]
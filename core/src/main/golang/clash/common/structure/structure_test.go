@@ -23,6 +23,11 @@ type BazOptional struct {
 	Bar string `test:"bar,omitempty"`
 }
 
+type BazSquash struct {
+	BazOptional `test:",squash"`
+	Qux         bool `test:"qux"`
+}
+
 func TestStructure_Basic(t *testing.T) {
 	rawMap := map[string]interface{}{
 		"foo":   1,
@@ -85,6 +90,27 @@ func TestStructure_Optional(t *testing.T) {
 	}
 }
 
+func TestStructure_Squash(t *testing.T) {
+	rawMap := map[string]interface{}{
+		"foo": 1,
+		"qux": true,
+	}
+
+	goal := &BazSquash{
+		BazOptional: BazOptional{Foo: 1},
+		Qux:         true,
+	}
+
+	s := &BazSquash{}
+	err := decoder.Decode(rawMap, s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(s, goal) {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
 func TestStructure_MissingKey(t *testing.T) {
 	rawMap := map[string]interface{}{
 		"foo": 1,
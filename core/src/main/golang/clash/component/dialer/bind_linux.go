@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package dialer
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToDevice(fd uintptr, name string) error {
+	err := syscall.BindToDevice(int(fd), name)
+	// SO_BINDTODEVICE needs CAP_NET_RAW on older kernels, the source address is bound anyway
+	if errors.Is(err, syscall.EPERM) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package dialer
+
+// bindToDevice is a no-op, only the source address is bound to the interface
+func bindToDevice(fd uintptr, name string) error {
+	return nil
+}
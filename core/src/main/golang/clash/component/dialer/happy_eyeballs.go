@@ -0,0 +1,211 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/resolver"
+)
+
+// the delays of RFC 8305
+const (
+	// resolutionDelay is how long the answer of the preferred family is waited for
+	// once the other family has been resolved
+	resolutionDelay = 50 * time.Millisecond
+	// connectionAttemptDelay is how long an attempt runs alone before the next address is tried
+	connectionAttemptDelay = 250 * time.Millisecond
+	// failureTTL is how long a failed address is tried after the others of its family
+	failureTTL = time.Minute
+)
+
+// failedAddrs records the addresses failed recently
+var failedAddrs = cache.New(failureTTL)
+
+type lookupResult struct {
+	ips  []net.IP
+	err  error
+	ipv6 bool
+}
+
+type attemptResult struct {
+	net.Conn
+	error
+	ip net.IP
+}
+
+// happyEyeballsDialContext dial host:port as RFC 8305 describes, all the addresses of host are
+// attempted in turn with the families interleaved, network with 4 or 6 resolve only that family
+func happyEyeballsDialContext(ctx context.Context, network, host, port string, opt *option) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	version := opt.ipVersion.OrDefault()
+	// with a preference, the attempts start from the preferred family, otherwise from
+	// the family resolved first
+	waitPreferred := version == resolver.PreferIPv4 || version == resolver.PreferIPv6
+	preferIPv6 := version == resolver.PreferIPv6
+	nextIPv6 := preferIPv6
+
+	lookups := make(chan lookupResult, 2)
+	pending := 0
+	lookup := func(ipv6 bool) {
+		pending++
+		go func() {
+			result := lookupResult{ipv6: ipv6}
+			if ipv6 {
+				result.ips, result.err = resolver.ResolveAllIPv6(host)
+			} else {
+				result.ips, result.err = resolver.ResolveAllIPv4(host)
+			}
+			lookups <- result
+		}()
+	}
+	if !strings.HasSuffix(network, "6") {
+		lookup(false)
+	}
+	if !strings.HasSuffix(network, "4") {
+		lookup(true)
+	}
+
+	results := make(chan attemptResult)
+
+	var (
+		ipv4s, ipv6s   []net.IP
+		lookupErr      error
+		dialErr        error
+		started        bool
+		attempting     int
+		resolutionWait <-chan time.Time
+		attemptWait    <-chan time.Time
+	)
+
+	// next pops the address to attempt, the families take turns
+	next := func() net.IP {
+		if len(ipv6s) == 0 || (!nextIPv6 && len(ipv4s) != 0) {
+			ip := ipv4s[0]
+			ipv4s = ipv4s[1:]
+			nextIPv6 = true
+			return ip
+		}
+
+		ip := ipv6s[0]
+		ipv6s = ipv6s[1:]
+		nextIPv6 = false
+		return ip
+	}
+	startAttempt := func() {
+		if attemptWait != nil || len(ipv4s)+len(ipv6s) == 0 {
+			return
+		}
+
+		attempting++
+		go dialAttempt(ctx, network, next(), port, opt, results)
+		attemptWait = time.After(connectionAttemptDelay)
+	}
+
+	for {
+		if pending == 0 && attempting == 0 && len(ipv4s)+len(ipv6s) == 0 {
+			if dialErr != nil {
+				return nil, dialErr
+			}
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			return nil, resolver.ErrIPNotFound
+		}
+
+		select {
+		case res := <-lookups:
+			pending--
+			if res.err != nil {
+				if lookupErr == nil || res.ipv6 == preferIPv6 {
+					lookupErr = res.err
+				}
+			} else if res.ipv6 {
+				ipv6s = sortByFailure(res.ips)
+			} else {
+				ipv4s = sortByFailure(res.ips)
+			}
+
+			if !started {
+				switch {
+				case !waitPreferred && res.err == nil:
+					nextIPv6 = res.ipv6
+					started = true
+				case pending == 0 || res.ipv6 == preferIPv6:
+					started = true
+				case resolutionWait == nil:
+					resolutionWait = time.After(resolutionDelay)
+				}
+			}
+			if started {
+				startAttempt()
+			}
+		case <-resolutionWait:
+			resolutionWait = nil
+			started = true
+			startAttempt()
+		case <-attemptWait:
+			attemptWait = nil
+			startAttempt()
+		case res := <-results:
+			attempting--
+			if res.error == nil {
+				failedAddrs.Delete(res.ip.String())
+				return res.Conn, nil
+			}
+
+			// the attempts canceled with ctx say nothing about the address
+			if ctx.Err() == nil {
+				failedAddrs.Put(res.ip.String(), true, failureTTL)
+			}
+			if dialErr == nil {
+				dialErr = res.error
+			}
+
+			// a failure starts the next attempt at once
+			attemptWait = nil
+			startAttempt()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// dialAttempt dial ip:port and send the result, the conn is closed when ctx is done before
+// the result is taken, the dial has lost to another attempt then
+func dialAttempt(ctx context.Context, network string, ip net.IP, port string, opt *option, results chan<- attemptResult) {
+	ipNetwork := strings.TrimRight(network, "46") + "4"
+	if ip.To4() == nil {
+		ipNetwork = strings.TrimRight(network, "46") + "6"
+	}
+
+	dialer := Dialer()
+	opt.bindDialer(dialer, ipNetwork, ip)
+	c, err := dialer.DialContext(ctx, ipNetwork, net.JoinHostPort(ip.String(), port))
+
+	select {
+	case results <- attemptResult{c, err, ip}:
+	case <-ctx.Done():
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// sortByFailure moves the addresses failed recently to the end, the order is kept otherwise
+func sortByFailure(ips []net.IP) []net.IP {
+	sorted := make([]net.IP, 0, len(ips))
+	failed := []net.IP{}
+	for _, ip := range ips {
+		if failedAddrs.Get(ip.String()) != nil {
+			failed = append(failed, ip)
+		} else {
+			sorted = append(sorted, ip)
+		}
+	}
+	return append(sorted, failed...)
+}
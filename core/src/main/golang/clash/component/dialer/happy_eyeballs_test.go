@@ -0,0 +1,202 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/resolver"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errRefused = errors.New("refused by test")
+
+// fakeResolver answer every host with its addresses after the delays
+type fakeResolver struct {
+	ipv4s, ipv6s         []net.IP
+	ipv4Delay, ipv6Delay time.Duration
+}
+
+func (fr *fakeResolver) ResolveIP(host string) (net.IP, error) {
+	return nil, resolver.ErrIPNotFound
+}
+
+func (fr *fakeResolver) ResolveIPv4(host string) (net.IP, error) {
+	return nil, resolver.ErrIPNotFound
+}
+
+func (fr *fakeResolver) ResolveIPv6(host string) (net.IP, error) {
+	return nil, resolver.ErrIPNotFound
+}
+
+func (fr *fakeResolver) ResolveAllIPv4(host string) ([]net.IP, error) {
+	time.Sleep(fr.ipv4Delay)
+	if len(fr.ipv4s) == 0 {
+		return nil, resolver.ErrIPNotFound
+	}
+	return fr.ipv4s, nil
+}
+
+func (fr *fakeResolver) ResolveAllIPv6(host string) ([]net.IP, error) {
+	time.Sleep(fr.ipv6Delay)
+	if len(fr.ipv6s) == 0 {
+		return nil, resolver.ErrIPNotFound
+	}
+	return fr.ipv6s, nil
+}
+
+// attempts records the attempted addresses in order, the sockets to the addresses
+// in refuse fail at once and the ones in delay connect late, the others connect
+type attempts struct {
+	mux    sync.Mutex
+	order  []string
+	refuse map[string]bool
+	delay  map[string]time.Duration
+}
+
+func (a *attempts) list() []string {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return append([]string{}, a.order...)
+}
+
+func (a *attempts) hook(dialer *net.Dialer) {
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		host, _, _ := net.SplitHostPort(address)
+		ip := net.ParseIP(host).String()
+
+		a.mux.Lock()
+		a.order = append(a.order, ip)
+		a.mux.Unlock()
+
+		time.Sleep(a.delay[ip])
+		if a.refuse[ip] {
+			return errRefused
+		}
+		return nil
+	}
+}
+
+// setup install r and the hook of a, with a clean record of the failed addresses
+func setup(t *testing.T, r *fakeResolver, a *attempts) {
+	resolver.DefaultResolver = r
+	DialerHook = a.hook
+	failedAddrs = cache.New(failureTTL)
+	t.Cleanup(func() {
+		resolver.DefaultResolver = nil
+		DialerHook = nil
+	})
+}
+
+func listen(t *testing.T) (net.Listener, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func ips(addrs ...string) []net.IP {
+	ret := []net.IP{}
+	for _, addr := range addrs {
+		ret = append(ret, net.ParseIP(addr))
+	}
+	return ret
+}
+
+func TestHappyEyeballs_PreferredWithinResolutionDelay(t *testing.T) {
+	a := &attempts{refuse: map[string]bool{"127.0.0.1": true, "::1": true}}
+	setup(t, &fakeResolver{
+		ipv4s:     ips("127.0.0.1"),
+		ipv6s:     ips("::1"),
+		ipv6Delay: resolutionDelay / 2,
+	}, a)
+
+	// the ipv6 answer arrives within resolutionDelay, it's attempted first
+	_, err := DialContext(context.Background(), "tcp", "example.test:80", WithIPVersion(resolver.PreferIPv6))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"::1", "127.0.0.1"}, a.list())
+}
+
+func TestHappyEyeballs_PreferredAfterResolutionDelay(t *testing.T) {
+	a := &attempts{refuse: map[string]bool{"127.0.0.1": true, "::1": true}}
+	setup(t, &fakeResolver{
+		ipv4s:     ips("127.0.0.1"),
+		ipv6s:     ips("::1"),
+		ipv6Delay: resolutionDelay * 4,
+	}, a)
+
+	// the ipv6 answer is late, the attempts don't wait for it
+	_, err := DialContext(context.Background(), "tcp", "example.test:80", WithIPVersion(resolver.PreferIPv6))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"127.0.0.1", "::1"}, a.list())
+}
+
+func TestHappyEyeballs_Interleave(t *testing.T) {
+	all := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1", "fd00::2"}
+	a := &attempts{refuse: map[string]bool{}}
+	for _, ip := range all {
+		a.refuse[ip] = true
+	}
+	setup(t, &fakeResolver{
+		ipv4s: ips(all[:3]...),
+		ipv6s: ips(all[3:]...),
+	}, a)
+
+	// every failure starts the next attempt at once, without connectionAttemptDelay
+	start := time.Now()
+	_, err := DialContext(context.Background(), "tcp", "example.test:80", WithIPVersion(resolver.PreferIPv4))
+	assert.Less(t, int64(time.Since(start)), int64(connectionAttemptDelay))
+
+	// the families take turns from the preferred one
+	assert.Equal(t, []string{"10.0.0.1", "fd00::1", "10.0.0.2", "fd00::2", "10.0.0.3"}, a.list())
+
+	// all the addresses failed, the first failure is returned
+	assert.True(t, errors.Is(err, errRefused))
+
+	// the failed addresses are tried after the others next time
+	assert.Equal(t, ips("10.0.0.4", "10.0.0.1"), sortByFailure(ips("10.0.0.1", "10.0.0.4")))
+}
+
+func TestHappyEyeballs_AttemptDelay(t *testing.T) {
+	_, port := listen(t)
+	a := &attempts{delay: map[string]time.Duration{"127.0.0.2": connectionAttemptDelay * 4}}
+	setup(t, &fakeResolver{ipv4s: ips("127.0.0.2", "127.0.0.1")}, a)
+
+	// the first attempt hangs, the second starts after connectionAttemptDelay and wins
+	start := time.Now()
+	c, err := DialContext(context.Background(), "tcp", net.JoinHostPort("example.test", port))
+	assert.Nil(t, err)
+	defer c.Close()
+
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(connectionAttemptDelay))
+	assert.Less(t, int64(elapsed), int64(connectionAttemptDelay*4))
+	assert.Equal(t, "127.0.0.1", c.RemoteAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestHappyEyeballs_CloseLoser(t *testing.T) {
+	l, port := listen(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// nobody takes the result, as if another attempt had won
+	results := make(chan attemptResult)
+	go dialAttempt(ctx, "tcp", net.IPv4(127, 0, 0, 1), port, &option{}, results)
+
+	sc, err := l.Accept()
+	assert.Nil(t, err)
+	defer sc.Close()
+
+	// the dial returns, the connection is closed
+	cancel()
+	sc.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = sc.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
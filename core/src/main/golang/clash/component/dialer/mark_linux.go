@@ -0,0 +1,10 @@
+//go:build linux
+// +build linux
+
+package dialer
+
+import "syscall"
+
+func setMark(fd uintptr, mark int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package dialer
+
+import "errors"
+
+func setMark(fd uintptr, mark int) error {
+	return errors.New("routing-mark is only supported on linux")
+}
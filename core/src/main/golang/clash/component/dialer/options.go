@@ -0,0 +1,166 @@
+package dialer
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Dreamacro/clash/common/singledo"
+	"github.com/Dreamacro/clash/component/resolver"
+)
+
+// Option customizes a single dial or listen, on top of the global hooks
+type Option func(*option)
+
+var (
+	defaultMux     sync.RWMutex
+	defaultOptions []Option
+)
+
+// SetDefaultOptions replace the options applied to every dial and listen before their own ones,
+// experimental.interface-name is installed with it
+func SetDefaultOptions(options ...Option) {
+	defaultMux.Lock()
+	defer defaultMux.Unlock()
+	defaultOptions = options
+}
+
+type option struct {
+	interfaceName string
+	routingMark   int
+	ipVersion     resolver.IPVersion
+}
+
+// WithInterface bind the socket to the interface, with SO_BINDTODEVICE on linux,
+// elsewhere only the source address is picked from the interface
+func WithInterface(name string) Option {
+	return func(o *option) {
+		o.interfaceName = name
+	}
+}
+
+// WithRoutingMark set SO_MARK on the socket, the policy routing picks the table by it
+func WithRoutingMark(mark int) Option {
+	return func(o *option) {
+		o.routingMark = mark
+	}
+}
+
+// WithIPVersion pick the address family of tcp and udp dials, empty follows resolver.DefaultIPVersion
+func WithIPVersion(version resolver.IPVersion) Option {
+	return func(o *option) {
+		o.ipVersion = version
+	}
+}
+
+func applyOptions(options []Option) *option {
+	o := &option{}
+
+	defaultMux.RLock()
+	for _, f := range defaultOptions {
+		f(o)
+	}
+	defaultMux.RUnlock()
+
+	for _, f := range options {
+		f(o)
+	}
+	return o
+}
+
+// BindDialer applies the default options and options to dialer which is going to dial ip,
+// it's for the clients building their own dialer, like the dns client
+func BindDialer(dialer *net.Dialer, network string, ip net.IP, options ...Option) {
+	applyOptions(options).bindDialer(dialer, network, ip)
+}
+
+// bindDialer applies o to dialer which is going to dial ip
+func (o *option) bindDialer(dialer *net.Dialer, network string, ip net.IP) {
+	if o.interfaceName != "" {
+		if addrs, err := interfaceAddrs(o.interfaceName); err == nil {
+			switch network {
+			case "tcp", "tcp4", "tcp6":
+				if addr, err := lookupTCPAddr(ip, addrs); err == nil {
+					dialer.LocalAddr = addr
+				}
+			case "udp", "udp4", "udp6":
+				if addr, err := lookupUDPAddr(ip, addrs); err == nil {
+					dialer.LocalAddr = addr
+				}
+			}
+		}
+		dialer.Control = bindControl(dialer.Control, o.interfaceName)
+	}
+	if o.routingMark != 0 {
+		dialer.Control = markControl(dialer.Control, o.routingMark)
+	}
+}
+
+// bindListenConfig applies o to lc, the address is replaced when it's unspecified
+func (o *option) bindListenConfig(lc *net.ListenConfig, address string) string {
+	if o.interfaceName != "" && address == "" {
+		if ip := interfaceIPv4(o.interfaceName); ip != nil {
+			address = net.JoinHostPort(ip.String(), "0")
+		}
+	}
+	if o.interfaceName != "" {
+		lc.Control = bindControl(lc.Control, o.interfaceName)
+	}
+	if o.routingMark != 0 {
+		lc.Control = markControl(lc.Control, o.routingMark)
+	}
+	return address
+}
+
+type controlFunc = func(network, address string, c syscall.RawConn) error
+
+// chainControl run prev, the socket hooks installed by DialerHook and ListenConfigHook, before f
+func chainControl(prev controlFunc, f func(fd uintptr) error) controlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		if prev != nil {
+			if err := prev(network, address, c); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = f(fd)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+func bindControl(prev controlFunc, name string) controlFunc {
+	return chainControl(prev, func(fd uintptr) error {
+		return bindToDevice(fd, name)
+	})
+}
+
+func markControl(prev controlFunc, mark int) controlFunc {
+	return chainControl(prev, func(fd uintptr) error {
+		return setMark(fd, mark)
+	})
+}
+
+var interfaceSingles sync.Map
+
+// interfaceAddrs return the addresses of the interface, cached for a few seconds
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	single, _ := interfaceSingles.LoadOrStore(name, singledo.NewSingle(5*time.Second))
+	elm, err, _ := single.(*singledo.Single).Do(func() (interface{}, error) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return iface.Addrs()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elm.([]net.Addr), nil
+}
@@ -0,0 +1,73 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOption_DialerControl(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	// the hook installed by DialerHook, like the VpnService protect of the android client
+	called := false
+	dialer := &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		called = true
+		return nil
+	}}
+	applyOptions([]Option{WithInterface("lo"), WithRoutingMark(1)}).bindDialer(dialer, "tcp", net.IPv4(127, 0, 0, 1))
+
+	// SO_MARK fails without CAP_NET_ADMIN, the hook runs before it anyway
+	if conn, err := dialer.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+	}
+	assert.True(t, called)
+}
+
+func TestOption_DialerControlError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	errProtect := errors.New("protect failed")
+	dialer := &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		return errProtect
+	}}
+	applyOptions([]Option{WithRoutingMark(1)}).bindDialer(dialer, "tcp", net.IPv4(127, 0, 0, 1))
+
+	_, err = dialer.Dial("tcp", ln.Addr().String())
+	assert.True(t, errors.Is(err, errProtect))
+}
+
+func TestOption_ListenConfigControl(t *testing.T) {
+	called := false
+	lc := &net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		called = true
+		return nil
+	}}
+	address := applyOptions([]Option{WithInterface("lo")}).bindListenConfig(lc, "127.0.0.1:0")
+
+	pc, err := lc.ListenPacket(context.Background(), "udp", address)
+	assert.Nil(t, err)
+	pc.Close()
+	assert.True(t, called)
+}
+
+func TestSetDefaultOptions(t *testing.T) {
+	SetDefaultOptions(WithInterface("lo"), WithRoutingMark(1))
+	defer SetDefaultOptions()
+
+	// the options of a dial override the default ones
+	o := applyOptions([]Option{WithInterface("eth0")})
+	assert.Equal(t, "eth0", o.interfaceName)
+	assert.Equal(t, 1, o.routingMark)
+
+	SetDefaultOptions()
+	assert.Equal(t, &option{}, applyOptions(nil))
+}
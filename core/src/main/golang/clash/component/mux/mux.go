@@ -0,0 +1,267 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"github.com/hashicorp/yamux"
+)
+
+// The protocol is the yamux flavour of sing-mux, the proxy server opens a mux session
+// when it's asked for sp.mux.sing-box.arpa:444, every stream starts with its own destination.
+const (
+	version0      = 0
+	version1      = 1
+	protocolYamux = 2 // h2mux is 0 and smux is 1
+
+	statusSuccess = 0
+	statusError   = 1
+
+	// DefaultMaxStreams is the number of streams sharing one connection
+	DefaultMaxStreams = 8
+	// DefaultIdleTimeout is how long a connection without streams is kept
+	DefaultIdleTimeout = 30 * time.Second
+)
+
+// Option of mux
+type Option struct {
+	MaxStreams  int
+	Padding     bool
+	IdleTimeout time.Duration
+}
+
+// DialFunc connects the proxy server and asks it for sp.mux.sing-box.arpa:444
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// Client dials the streams over the shared connections of a proxy
+type Client struct {
+	dial   DialFunc
+	option Option
+
+	mux      sync.Mutex
+	sessions []*session
+	closed   bool
+}
+
+type session struct {
+	*yamux.Session
+	idle *time.Timer
+	// streams counts the streams picked this session, guarded by Client.mux
+	streams int
+}
+
+// NewClient return a client which connects the proxy server with dial
+func NewClient(dial DialFunc, option Option) *Client {
+	if option.MaxStreams <= 0 {
+		option.MaxStreams = DefaultMaxStreams
+	}
+	if option.IdleTimeout <= 0 {
+		option.IdleTimeout = DefaultIdleTimeout
+	}
+	return &Client{dial: dial, option: option}
+}
+
+// DialContext open a stream to destination, it reuses a connection with free streams
+func (c *Client) DialContext(ctx context.Context, destination socks5.Addr) (net.Conn, error) {
+	s, err := c.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := s.OpenStream()
+	if err != nil {
+		c.release(s)
+		return nil, err
+	}
+
+	// flags, 0 for a tcp stream
+	request := append([]byte{0, 0}, destination...)
+	if _, err := stream.Write(request); err != nil {
+		stream.Close()
+		c.release(s)
+		return nil, err
+	}
+
+	return &streamConn{Conn: stream, reader: bufio.NewReader(stream), client: c, session: s}, nil
+}
+
+// Close all the connections, the client can't dial any more
+func (c *Client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.closed = true
+	for _, s := range c.sessions {
+		s.idle.Stop()
+		s.Close()
+	}
+	c.sessions = nil
+	return nil
+}
+
+// session pick a connection with free streams, or make a new one
+func (c *Client) session(ctx context.Context) (*session, error) {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return nil, errors.New("mux client closed")
+	}
+
+	for _, s := range c.sessions {
+		if !s.IsClosed() && s.streams < c.option.MaxStreams {
+			s.streams++
+			s.idle.Stop()
+			c.mux.Unlock()
+			return s, nil
+		}
+	}
+	c.mux.Unlock()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.writeRequest(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.option.Padding {
+		conn = newPaddingConn(conn)
+	}
+
+	config := yamux.DefaultConfig()
+	config.LogOutput = ioutil.Discard
+	ys, err := yamux.Client(conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &session{Session: ys, streams: 1}
+	s.idle = time.AfterFunc(c.option.IdleTimeout, func() { c.closeIdle(s) })
+	s.idle.Stop()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		ys.Close()
+		return nil, errors.New("mux client closed")
+	}
+	c.sessions = append(c.sessions, s)
+	return s, nil
+}
+
+// writeRequest asks for a yamux session, the padding is announced by version 1
+func (c *Client) writeRequest(conn net.Conn) error {
+	if !c.option.Padding {
+		_, err := conn.Write([]byte{version0, protocolYamux})
+		return err
+	}
+
+	paddingLen := rand.Intn(256)
+	request := make([]byte, 5+paddingLen)
+	request[0], request[1], request[2] = version1, protocolYamux, 1
+	binary.BigEndian.PutUint16(request[3:5], uint16(paddingLen))
+	_, err := conn.Write(request)
+	return err
+}
+
+// release is called when a stream of s is closed, s is closed after it has been idle for a while
+func (c *Client) release(s *session) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	s.streams--
+	if s.IsClosed() {
+		c.remove(s)
+		return
+	}
+	if s.streams == 0 {
+		s.idle.Reset(c.option.IdleTimeout)
+	}
+}
+
+func (c *Client) closeIdle(s *session) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if s.streams == 0 {
+		s.Close()
+		c.remove(s)
+	}
+}
+
+// remove s from the sessions, c.mux must be held
+func (c *Client) remove(s *session) {
+	for i, session := range c.sessions {
+		if session == s {
+			c.sessions = append(c.sessions[:i], c.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// streamConn reads the status of the stream before the data
+type streamConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	client  *Client
+	session *session
+
+	statusOnce sync.Once
+	statusErr  error
+	closeOnce  sync.Once
+}
+
+func (sc *streamConn) Read(b []byte) (int, error) {
+	sc.statusOnce.Do(func() {
+		sc.statusErr = readStatus(sc.reader)
+	})
+	if sc.statusErr != nil {
+		return 0, sc.statusErr
+	}
+	return sc.reader.Read(b)
+}
+
+func (sc *streamConn) Close() error {
+	err := sc.Conn.Close()
+	sc.closeOnce.Do(func() {
+		sc.client.release(sc.session)
+	})
+	return err
+}
+
+func readStatus(r *bufio.Reader) error {
+	status, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case statusSuccess:
+		return nil
+	case statusError:
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(r, message); err != nil {
+			return err
+		}
+		return errors.New("mux stream error: " + string(message))
+	default:
+		return errors.New("mux stream unknown status")
+	}
+}
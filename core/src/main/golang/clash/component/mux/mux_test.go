@@ -0,0 +1,252 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+)
+
+// server is a stand-in for the mux server of a proxy, every stream echoes,
+// except the streams to "error.test" which are refused
+type server struct {
+	listener net.Listener
+	conns    int32
+	padding  int32
+}
+
+func newServer(t *testing.T) *server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &server{listener: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *server) dial(ctx context.Context) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, "tcp", s.listener.Addr().String())
+}
+
+func (s *server) serve(c net.Conn) {
+	defer c.Close()
+
+	var request [2]byte
+	if _, err := io.ReadFull(c, request[:]); err != nil || request[1] != protocolYamux {
+		return
+	}
+
+	var conn net.Conn = c
+	if request[0] == version1 {
+		var padding [3]byte
+		if _, err := io.ReadFull(c, padding[:]); err != nil {
+			return
+		}
+		if _, err := io.CopyN(ioutil.Discard, c, int64(binary.BigEndian.Uint16(padding[1:]))); err != nil {
+			return
+		}
+		if padding[0] == 1 {
+			atomic.AddInt32(&s.padding, 1)
+			conn = newPaddingConn(c)
+		}
+	}
+
+	config := yamux.DefaultConfig()
+	config.LogOutput = ioutil.Discard
+	session, err := yamux.Server(conn, config)
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go serveStream(stream)
+	}
+}
+
+func serveStream(stream net.Conn) {
+	defer stream.Close()
+
+	var flags [2]byte
+	if _, err := io.ReadFull(stream, flags[:]); err != nil {
+		return
+	}
+	addr, err := socks5.ReadAddr(stream, make([]byte, socks5.MaxAddrLen))
+	if err != nil {
+		return
+	}
+
+	if addr.String() == "error.test:80" {
+		message := []byte("refused")
+		response := append([]byte{statusError}, binary.AppendUvarint(nil, uint64(len(message)))...)
+		stream.Write(append(response, message...))
+		return
+	}
+
+	stream.Write([]byte{statusSuccess})
+	io.Copy(stream, stream)
+}
+
+func echo(t *testing.T, c net.Conn, data string) {
+	_, err := c.Write([]byte(data))
+	assert.Nil(t, err)
+
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(buf))
+}
+
+func TestClient_SharedSession(t *testing.T) {
+	s := newServer(t)
+	defer s.listener.Close()
+
+	client := NewClient(s.dial, Option{MaxStreams: 2})
+	defer client.Close()
+
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		c, err := client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+		assert.Nil(t, err)
+		echo(t, c, "hello")
+		conns = append(conns, c)
+	}
+
+	// two streams on each connection
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.conns))
+
+	// the stream closed frees a place on its connection
+	conns[0].Close()
+	c, err := client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+	assert.Nil(t, err)
+	echo(t, c, "again")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.conns))
+
+	for _, c := range append(conns[1:], c) {
+		c.Close()
+	}
+}
+
+func TestClient_IdleTimeout(t *testing.T) {
+	s := newServer(t)
+	defer s.listener.Close()
+
+	client := NewClient(s.dial, Option{IdleTimeout: 50 * time.Millisecond})
+	defer client.Close()
+
+	c, err := client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+	assert.Nil(t, err)
+	echo(t, c, "hello")
+	c.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	client.mux.Lock()
+	assert.Empty(t, client.sessions)
+	client.mux.Unlock()
+
+	// a new connection is made after the idle one is closed
+	c, err = client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+	assert.Nil(t, err)
+	echo(t, c, "hello")
+	c.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.conns))
+}
+
+func TestClient_Padding(t *testing.T) {
+	s := newServer(t)
+	defer s.listener.Close()
+
+	client := NewClient(s.dial, Option{Padding: true})
+	defer client.Close()
+
+	c, err := client.DialContext(context.Background(), socks5.ParseAddr("1.2.3.4:443"))
+	assert.Nil(t, err)
+	defer c.Close()
+
+	// more writes than paddingFrames, across the end of the padding
+	for i := 0; i < paddingFrames; i++ {
+		echo(t, c, "hello")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&s.padding))
+}
+
+func TestClient_StreamError(t *testing.T) {
+	s := newServer(t)
+	defer s.listener.Close()
+
+	client := NewClient(s.dial, Option{})
+	defer client.Close()
+
+	c, err := client.DialContext(context.Background(), socks5.ParseAddr("error.test:80"))
+	assert.Nil(t, err)
+	defer c.Close()
+
+	_, err = c.Read(make([]byte, 1))
+	assert.EqualError(t, err, "mux stream error: refused")
+}
+
+func TestClient_Close(t *testing.T) {
+	s := newServer(t)
+	defer s.listener.Close()
+
+	client := NewClient(s.dial, Option{})
+	c, err := client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+	assert.Nil(t, err)
+	echo(t, c, "hello")
+
+	assert.Nil(t, client.Close())
+	_, err = bufio.NewReader(c).ReadByte()
+	assert.NotNil(t, err)
+
+	_, err = client.DialContext(context.Background(), socks5.ParseAddr("example.com:80"))
+	assert.NotNil(t, err)
+}
+
+// TestClient_Request checks the session request against the wire bytes of sing-mux
+func TestClient_Request(t *testing.T) {
+	read := func(option Option) []byte {
+		client, server := net.Pipe()
+		defer server.Close()
+
+		go func() {
+			c := NewClient(nil, option)
+			c.writeRequest(client)
+			client.Close()
+		}()
+
+		buf, err := ioutil.ReadAll(server)
+		assert.Nil(t, err)
+		return buf
+	}
+
+	// version 0, yamux
+	assert.Equal(t, []byte{0x00, 0x02}, read(Option{}))
+
+	// version 1, yamux, padding enabled, then the length of the padding
+	request := read(Option{Padding: true})
+	assert.Equal(t, []byte{0x01, 0x02, 0x01}, request[:3])
+	assert.Equal(t, len(request)-5, int(binary.BigEndian.Uint16(request[3:5])))
+}
@@ -0,0 +1,106 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+)
+
+// paddingFrames is the number of reads and writes padded at the start of a connection,
+// it hides the length of the handshakes inside the streams
+const paddingFrames = 16
+
+// paddingConn frames the first paddingFrames writes as [data length][padding length][data][padding],
+// and strips the padding from the first paddingFrames reads
+type paddingConn struct {
+	net.Conn
+
+	writeFrames int
+	readFrames  int
+	// readRemain and paddingRemain belong to the frame being read
+	readRemain    int
+	paddingRemain int
+}
+
+func newPaddingConn(conn net.Conn) net.Conn {
+	return &paddingConn{Conn: conn}
+}
+
+func (pc *paddingConn) Read(b []byte) (int, error) {
+	if pc.readRemain > 0 {
+		return pc.readFrame(b)
+	}
+	if pc.readFrames >= paddingFrames {
+		return pc.Conn.Read(b)
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(pc.Conn, header[:]); err != nil {
+		return 0, err
+	}
+	pc.readFrames++
+	pc.readRemain = int(binary.BigEndian.Uint16(header[:2]))
+	pc.paddingRemain = int(binary.BigEndian.Uint16(header[2:]))
+
+	if pc.readRemain == 0 {
+		if err := pc.discardPadding(); err != nil {
+			return 0, err
+		}
+		return pc.Read(b)
+	}
+	return pc.readFrame(b)
+}
+
+func (pc *paddingConn) readFrame(b []byte) (int, error) {
+	if len(b) > pc.readRemain {
+		b = b[:pc.readRemain]
+	}
+	n, err := pc.Conn.Read(b)
+	pc.readRemain -= n
+	if err == nil && pc.readRemain == 0 {
+		err = pc.discardPadding()
+	}
+	return n, err
+}
+
+func (pc *paddingConn) discardPadding() error {
+	_, err := io.CopyN(ioutil.Discard, pc.Conn, int64(pc.paddingRemain))
+	pc.paddingRemain = 0
+	return err
+}
+
+func (pc *paddingConn) Write(b []byte) (int, error) {
+	if pc.writeFrames >= paddingFrames {
+		return pc.Conn.Write(b)
+	}
+
+	written := 0
+	for len(b) > 0 && pc.writeFrames < paddingFrames {
+		data := b
+		if len(data) > 0xffff {
+			data = data[:0xffff]
+		}
+
+		paddingLen := 256 + rand.Intn(512)
+		frame := make([]byte, 4+len(data)+paddingLen)
+		binary.BigEndian.PutUint16(frame[:2], uint16(len(data)))
+		binary.BigEndian.PutUint16(frame[2:4], uint16(paddingLen))
+		copy(frame[4:], data)
+		rand.Read(frame[4+len(data):])
+
+		if _, err := pc.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		pc.writeFrames++
+		written += len(data)
+		b = b[len(data):]
+	}
+
+	if len(b) > 0 {
+		n, err := pc.Conn.Write(b)
+		return written + n, err
+	}
+	return written, nil
+}
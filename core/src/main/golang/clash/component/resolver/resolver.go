@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"errors"
+	"math/rand"
 	"net"
 	"strings"
 
@@ -17,34 +18,55 @@ var (
 )
 
 var (
-	ErrIPNotFound = errors.New("couldn't find ip")
-	ErrIPVersion  = errors.New("ip version error")
+	ErrIPNotFound   = errors.New("couldn't find ip")
+	ErrIPVersion    = errors.New("ip version error")
+	ErrIPv6Disabled = errors.New("ipv6 is disabled by dns")
 )
 
 type Resolver interface {
 	ResolveIP(host string) (ip net.IP, err error)
 	ResolveIPv4(host string) (ip net.IP, err error)
 	ResolveIPv6(host string) (ip net.IP, err error)
+	ResolveAllIPv4(host string) (ips []net.IP, err error)
+	ResolveAllIPv6(host string) (ips []net.IP, err error)
 }
 
-// ResolveIPv4 with a host, return ipv4
+// ResolveIPv4 with a host, return one of its ipv4
 func ResolveIPv4(host string) (net.IP, error) {
+	ips, err := ResolveAllIPv4(host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
+
+// ResolveIPv6 with a host, return one of its ipv6
+func ResolveIPv6(host string) (net.IP, error) {
+	ips, err := ResolveAllIPv6(host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
+
+// ResolveAllIPv4 with a host, return all the ipv4 of it
+func ResolveAllIPv4(host string) ([]net.IP, error) {
 	if node := DefaultHosts.Search(host); node != nil {
 		if ip := node.Data.(net.IP).To4(); ip != nil {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 	}
 
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if !strings.Contains(host, ":") {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 		return nil, ErrIPVersion
 	}
 
 	if DefaultResolver != nil {
-		return DefaultResolver.ResolveIPv4(host)
+		return DefaultResolver.ResolveAllIPv4(host)
 	}
 
 	ipAddrs, err := net.LookupIP(host)
@@ -52,33 +74,37 @@ func ResolveIPv4(host string) (net.IP, error) {
 		return nil, err
 	}
 
+	ips := []net.IP{}
 	for _, ip := range ipAddrs {
 		if ip4 := ip.To4(); ip4 != nil {
-			return ip4, nil
+			ips = append(ips, ip4)
 		}
 	}
+	if len(ips) == 0 {
+		return nil, ErrIPNotFound
+	}
 
-	return nil, ErrIPNotFound
+	return ips, nil
 }
 
-// ResolveIPv6 with a host, return ipv6
-func ResolveIPv6(host string) (net.IP, error) {
+// ResolveAllIPv6 with a host, return all the ipv6 of it
+func ResolveAllIPv6(host string) ([]net.IP, error) {
 	if node := DefaultHosts.Search(host); node != nil {
-		if ip := node.Data.(net.IP).To16(); ip != nil {
-			return ip, nil
+		if ip := node.Data.(net.IP); ip.To4() == nil {
+			return []net.IP{ip}, nil
 		}
 	}
 
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if strings.Contains(host, ":") {
-			return ip, nil
+			return []net.IP{ip}, nil
 		}
 		return nil, ErrIPVersion
 	}
 
 	if DefaultResolver != nil {
-		return DefaultResolver.ResolveIPv6(host)
+		return DefaultResolver.ResolveAllIPv6(host)
 	}
 
 	ipAddrs, err := net.LookupIP(host)
@@ -86,17 +112,44 @@ func ResolveIPv6(host string) (net.IP, error) {
 		return nil, err
 	}
 
+	ips := []net.IP{}
 	for _, ip := range ipAddrs {
 		if ip.To4() == nil {
-			return ip, nil
+			ips = append(ips, ip)
 		}
 	}
+	if len(ips) == 0 {
+		return nil, ErrIPNotFound
+	}
 
-	return nil, ErrIPNotFound
+	return ips, nil
 }
 
-// ResolveIP with a host, return ip
+// ResolveIP with a host, return ip of the family allowed by DefaultIPVersion
 func ResolveIP(host string) (net.IP, error) {
+	return ResolveIPWithVersion(host, "")
+}
+
+// ResolveIPWithVersion with a host, return ip of the family allowed by version,
+// an empty version follows DefaultIPVersion
+func ResolveIPWithVersion(host string, version IPVersion) (net.IP, error) {
+	switch version.OrDefault() {
+	case IPv4Only:
+		return ResolveIPv4(host)
+	case IPv6Only:
+		return ResolveIPv6(host)
+	case PreferIPv4:
+		if ip, err := ResolveIPv4(host); err == nil {
+			return ip, nil
+		}
+		return ResolveIPv6(host)
+	case PreferIPv6:
+		if ip, err := ResolveIPv6(host); err == nil {
+			return ip, nil
+		}
+		return ResolveIPv4(host)
+	}
+
 	if node := DefaultHosts.Search(host); node != nil {
 		return node.Data.(net.IP), nil
 	}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveIPWithVersion(t *testing.T) {
+	ip, err := ResolveIPWithVersion("::1", IPv4Only)
+	assert.Nil(t, ip)
+	assert.Equal(t, ErrIPVersion, err)
+
+	ip, err = ResolveIPWithVersion("127.0.0.1", IPv6Only)
+	assert.Nil(t, ip)
+	assert.Equal(t, ErrIPVersion, err)
+
+	ip, err = ResolveIPWithVersion("::1", PreferIPv4)
+	assert.Nil(t, err)
+	assert.Equal(t, "::1", ip.String())
+
+	ip, err = ResolveIPWithVersion("127.0.0.1", PreferIPv6)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", ip.String())
+}
+
+func TestIPVersion_OrDefault(t *testing.T) {
+	defer func() { SetDefaultIPVersion(DualStack) }()
+
+	SetDefaultIPVersion(IPv6Only)
+	assert.Equal(t, IPv6Only, IPVersion("").OrDefault())
+	assert.Equal(t, PreferIPv4, PreferIPv4.OrDefault())
+
+	assert.Nil(t, IPVersion("").Validate())
+	assert.NotNil(t, IPVersion("ipv5").Validate())
+}
+
+// allResolver only answers the queries for all the addresses
+type allResolver struct{}
+
+var errSingle = errors.New("single address queried")
+
+func (ar *allResolver) ResolveIP(host string) (net.IP, error)   { return nil, errSingle }
+func (ar *allResolver) ResolveIPv4(host string) (net.IP, error) { return nil, errSingle }
+func (ar *allResolver) ResolveIPv6(host string) (net.IP, error) { return nil, errSingle }
+
+func (ar *allResolver) ResolveAllIPv4(host string) ([]net.IP, error) {
+	return []net.IP{net.IPv4(1, 1, 1, 1), net.IPv4(1, 0, 0, 1)}, nil
+}
+
+func (ar *allResolver) ResolveAllIPv6(host string) ([]net.IP, error) {
+	return []net.IP{net.ParseIP("2606:4700::1111")}, nil
+}
+
+func TestResolveIPv4_All(t *testing.T) {
+	DefaultResolver = &allResolver{}
+	defer func() { DefaultResolver = nil }()
+
+	ip, err := ResolveIPv4("example.com")
+	assert.Nil(t, err)
+	assert.Contains(t, []string{"1.1.1.1", "1.0.0.1"}, ip.String())
+
+	ip, err = ResolveIPv6("example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "2606:4700::1111", ip.String())
+}
@@ -0,0 +1,52 @@
+package resolver
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// IPVersion is the policy of the address family used to reach a host
+type IPVersion string
+
+const (
+	DualStack  IPVersion = "dual"
+	IPv4Only   IPVersion = "ipv4-only"
+	IPv6Only   IPVersion = "ipv6-only"
+	PreferIPv4 IPVersion = "prefer-ipv4"
+	PreferIPv6 IPVersion = "prefer-ipv6"
+)
+
+// defaultIPVersion is used when a proxy doesn't set its own ip-version,
+// it's swapped by the config while the dials read it
+var defaultIPVersion atomic.Value
+
+func init() {
+	defaultIPVersion.Store(DualStack)
+}
+
+// DefaultIPVersion return the global ip-version
+func DefaultIPVersion() IPVersion {
+	return defaultIPVersion.Load().(IPVersion)
+}
+
+// SetDefaultIPVersion change the global ip-version
+func SetDefaultIPVersion(v IPVersion) {
+	defaultIPVersion.Store(v)
+}
+
+// Validate return an error if v is neither empty nor a known policy
+func (v IPVersion) Validate() error {
+	switch v {
+	case "", DualStack, IPv4Only, IPv6Only, PreferIPv4, PreferIPv6:
+		return nil
+	}
+	return fmt.Errorf("unsupported ip-version %s", string(v))
+}
+
+// OrDefault return DefaultIPVersion if v is empty
+func (v IPVersion) OrDefault() IPVersion {
+	if v == "" {
+		return DefaultIPVersion()
+	}
+	return v
+}
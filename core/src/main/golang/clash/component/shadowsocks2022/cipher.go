@@ -0,0 +1,167 @@
+package shadowsocks2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// Header types
+const (
+	HeaderTypeClient byte = 0
+	HeaderTypeServer byte = 1
+)
+
+const (
+	// MaxPaddingLength is the max length of the random padding of the request header
+	MaxPaddingLength = 900
+	// maxTimeDiff is the max difference between the timestamp of the header and now
+	maxTimeDiff = 30 * time.Second
+
+	sessionSubkeyContext  = "shadowsocks 2022 session subkey"
+	identitySubkeyContext = "shadowsocks 2022 identity subkey"
+)
+
+// timeNow is the clock of the header timestamps
+var timeNow = time.Now
+
+var (
+	ErrBadKey           = errors.New("bad key length, expect a base64 encoded key of the cipher key size")
+	ErrBadHeaderType    = errors.New("bad header type")
+	ErrBadTimestamp     = errors.New("timestamp is too far away")
+	ErrBadRequestSalt   = errors.New("request salt mismatch")
+	ErrBadSessionID     = errors.New("client session id mismatch")
+	ErrReplay           = errors.New("replayed salt or packet")
+	ErrIdentityHeader   = errors.New("identity header is only supported by aes methods")
+	ErrPacketTooShort   = errors.New("packet too short")
+	ErrCipherNotSupport = errors.New("cipher not supported")
+)
+
+type method struct {
+	keySize int
+	aes     bool
+}
+
+var methods = map[string]method{
+	"2022-blake3-aes-128-gcm":       {keySize: 16, aes: true},
+	"2022-blake3-aes-256-gcm":       {keySize: 32, aes: true},
+	"2022-blake3-chacha20-poly1305": {keySize: 32, aes: false},
+}
+
+// IsShadowsocks2022 return whether method is one of the 2022 edition methods
+func IsShadowsocks2022(method string) bool {
+	_, ok := methods[strings.ToLower(method)]
+	return ok
+}
+
+// Cipher implements the shadowsocks 2022 edition (SIP022)
+type Cipher struct {
+	keySize int
+	aes     bool
+
+	// psk is the user psk, identityPSKs are the psks of the relays in order
+	psk          []byte
+	identityPSKs [][]byte
+}
+
+func (c *Cipher) StreamConn(conn net.Conn) net.Conn {
+	return newStreamConn(conn, c)
+}
+
+func (c *Cipher) PacketConn(pc net.PacketConn) net.PacketConn {
+	return newPacketConn(pc, c)
+}
+
+func (c *Cipher) newAEAD(key []byte) cipher.AEAD {
+	if c.aes {
+		block, _ := aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		return aead
+	}
+	aead, _ := chacha20poly1305.New(key)
+	return aead
+}
+
+// sessionAEAD derive the session subkey of psk and salt (the session id for udp)
+func (c *Cipher) sessionAEAD(salt []byte) cipher.AEAD {
+	material := make([]byte, 0, len(c.psk)+len(salt))
+	material = append(material, c.psk...)
+	material = append(material, salt...)
+	key := make([]byte, c.keySize)
+	blake3.DeriveKey(key, sessionSubkeyContext, material)
+	return c.newAEAD(key)
+}
+
+// nextPSK return the psk after identityPSKs[i], the one whose hash is carried by the i-th identity header
+func (c *Cipher) nextPSK(i int) []byte {
+	if i+1 < len(c.identityPSKs) {
+		return c.identityPSKs[i+1]
+	}
+	return c.psk
+}
+
+func pskHash(psk []byte) []byte {
+	hash := blake3.Sum256(psk)
+	return hash[:aes.BlockSize]
+}
+
+// New return a Cipher of method, password is a base64 psk or psks of relays and the user joined by ':'
+func New(method, password string) (*Cipher, error) {
+	m, ok := methods[strings.ToLower(method)]
+	if !ok {
+		return nil, ErrCipherNotSupport
+	}
+
+	var psks [][]byte
+	for _, encoded := range strings.Split(password, ":") {
+		psk, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode psk error: %w", err)
+		}
+		if len(psk) != m.keySize {
+			return nil, ErrBadKey
+		}
+		psks = append(psks, psk)
+	}
+
+	if len(psks) > 1 && !m.aes {
+		return nil, ErrIdentityHeader
+	}
+
+	return &Cipher{
+		keySize:      m.keySize,
+		aes:          m.aes,
+		psk:          psks[len(psks)-1],
+		identityPSKs: psks[:len(psks)-1],
+	}, nil
+}
+
+func checkTimestamp(ts uint64) error {
+	if ts > math.MaxInt64 {
+		return ErrBadTimestamp
+	}
+	diff := timeNow().Sub(time.Unix(int64(ts), 0))
+	if diff > maxTimeDiff || diff < -maxTimeDiff {
+		return ErrBadTimestamp
+	}
+	return nil
+}
+
+// increment the little-endian nonce counter
+func increment(b []byte) {
+	for i := range b {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
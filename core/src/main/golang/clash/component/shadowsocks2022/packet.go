@@ -0,0 +1,277 @@
+package shadowsocks2022
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// windowSize is the count of packet ids before the newest one that are still accepted
+	windowSize = 64
+
+	// sessionTimeout is the idle time after which a server session is forgotten, its packets
+	// replayed later are too old to pass the timestamp check anyway
+	sessionTimeout = 2 * maxTimeDiff
+)
+
+// slidingWindow reject the packet ids already received or too old, like the anti-replay window of ipsec
+type slidingWindow struct {
+	last   uint64
+	bitmap uint64
+	init   bool
+}
+
+func (sw *slidingWindow) check(id uint64) bool {
+	if !sw.init {
+		sw.init = true
+		sw.last = id
+		sw.bitmap = 1
+		return true
+	}
+
+	if id > sw.last {
+		shift := id - sw.last
+		if shift >= windowSize {
+			sw.bitmap = 1
+		} else {
+			sw.bitmap = sw.bitmap<<shift | 1
+		}
+		sw.last = id
+		return true
+	}
+
+	diff := sw.last - id
+	if diff >= windowSize || sw.bitmap&(1<<diff) != 0 {
+		return false
+	}
+	sw.bitmap |= 1 << diff
+	return true
+}
+
+type serverSession struct {
+	aead     cipher.AEAD
+	window   slidingWindow
+	lastSeen time.Time
+}
+
+type packetConn struct {
+	net.PacketConn
+	cipher *Cipher
+
+	sessionID []byte
+	packetID  uint64
+	aead      cipher.AEAD
+	wMux      sync.Mutex
+
+	sessions  map[uint64]*serverSession
+	lastSweep time.Time
+	rMux      sync.Mutex
+}
+
+func newPacketConn(pc net.PacketConn, c *Cipher) *packetConn {
+	sessionID := make([]byte, 8)
+	rand.Read(sessionID)
+
+	var aead cipher.AEAD
+	if c.aes {
+		aead = c.sessionAEAD(sessionID)
+	} else {
+		aead, _ = chacha20poly1305.NewX(c.psk)
+	}
+
+	return &packetConn{
+		PacketConn: pc,
+		cipher:     c,
+		sessionID:  sessionID,
+		aead:       aead,
+		sessions:   map[uint64]*serverSession{},
+		lastSweep:  time.Now(),
+	}
+}
+
+// WriteTo send b, which should start with the socks address of the target, to addr
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := socks5.SplitAddr(b)
+	if target == nil {
+		return 0, ErrPacketTooShort
+	}
+
+	pc.wMux.Lock()
+	packetID := pc.packetID
+	pc.packetID++
+	pc.wMux.Unlock()
+
+	// SessionID PacketID
+	header := make([]byte, 16)
+	copy(header, pc.sessionID)
+	binary.BigEndian.PutUint64(header[8:], packetID)
+
+	// Type Timestamp PaddingLen Addr Payload
+	body := &bytes.Buffer{}
+	body.WriteByte(HeaderTypeClient)
+	binary.Write(body, binary.BigEndian, uint64(timeNow().Unix()))
+	binary.Write(body, binary.BigEndian, uint16(0))
+	body.Write(b)
+
+	buf := &bytes.Buffer{}
+	if pc.cipher.aes {
+		c := pc.cipher
+		firstPSK := c.psk
+		if len(c.identityPSKs) != 0 {
+			firstPSK = c.identityPSKs[0]
+		}
+		block, _ := aes.NewCipher(firstPSK)
+		encrypted := make([]byte, 16)
+		block.Encrypt(encrypted, header)
+		buf.Write(encrypted)
+
+		for i, psk := range c.identityPSKs {
+			identity := make([]byte, aes.BlockSize)
+			hash := pskHash(c.nextPSK(i))
+			for j := range identity {
+				identity[j] = hash[j] ^ header[j]
+			}
+			block, _ := aes.NewCipher(psk)
+			block.Encrypt(identity, identity)
+			buf.Write(identity)
+		}
+
+		buf.Write(pc.aead.Seal(nil, header[4:16], body.Bytes(), nil))
+	} else {
+		nonce := make([]byte, chacha20poly1305.NonceSizeX)
+		rand.Read(nonce)
+		plaintext := append(header, body.Bytes()...)
+		buf.Write(nonce)
+		buf.Write(pc.aead.Seal(nil, nonce, plaintext, nil))
+	}
+
+	if _, err := pc.PacketConn.WriteTo(buf.Bytes(), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom read a packet into b, which start with the socks address of the source
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := pool.BufPool.Get().([]byte)
+	defer pool.BufPool.Put(buf[:cap(buf)])
+
+	for {
+		n, addr, err := pc.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		payload, err := pc.open(buf[:n])
+		if err != nil {
+			// drop the invalid packet
+			continue
+		}
+
+		return copy(b, payload), addr, nil
+	}
+}
+
+// open decrypt and verify a packet from the server, return the socks address and payload
+func (pc *packetConn) open(packet []byte) ([]byte, error) {
+	var header, body []byte
+	var sessionID uint64
+	var session *serverSession
+
+	pc.rMux.Lock()
+	defer pc.rMux.Unlock()
+
+	if pc.cipher.aes {
+		if len(packet) < 16 {
+			return nil, ErrPacketTooShort
+		}
+		header = packet[:16]
+		block, _ := aes.NewCipher(pc.cipher.psk)
+		block.Decrypt(header, header)
+
+		sessionID = binary.BigEndian.Uint64(header)
+		session = pc.sessions[sessionID]
+		if session == nil {
+			session = &serverSession{aead: pc.cipher.sessionAEAD(header[:8])}
+		}
+
+		plaintext, err := session.aead.Open(packet[16:16], header[4:16], packet[16:], nil)
+		if err != nil {
+			return nil, err
+		}
+		body = plaintext
+	} else {
+		if len(packet) < chacha20poly1305.NonceSizeX {
+			return nil, ErrPacketTooShort
+		}
+		nonce := packet[:chacha20poly1305.NonceSizeX]
+		ciphertext := packet[chacha20poly1305.NonceSizeX:]
+		plaintext, err := pc.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(plaintext) < 16 {
+			return nil, ErrPacketTooShort
+		}
+		header, body = plaintext[:16], plaintext[16:]
+
+		sessionID = binary.BigEndian.Uint64(header)
+		session = pc.sessions[sessionID]
+		if session == nil {
+			session = &serverSession{}
+		}
+	}
+
+	// Type Timestamp ClientSessionID PaddingLen
+	if len(body) < 1+8+8+2 {
+		return nil, ErrPacketTooShort
+	}
+	if body[0] != HeaderTypeServer {
+		return nil, ErrBadHeaderType
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(body[1:9])); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(body[9:17], pc.sessionID) {
+		return nil, ErrBadSessionID
+	}
+	paddingLen := int(binary.BigEndian.Uint16(body[17:19]))
+	if len(body) < 19+paddingLen {
+		return nil, ErrPacketTooShort
+	}
+
+	if !session.window.check(binary.BigEndian.Uint64(header[8:])) {
+		return nil, ErrReplay
+	}
+	now := time.Now()
+	session.lastSeen = now
+	pc.sessions[sessionID] = session
+	pc.sweep(now)
+
+	return body[19+paddingLen:], nil
+}
+
+// sweep forget the sessions idle for sessionTimeout, at most once in sessionTimeout
+func (pc *packetConn) sweep(now time.Time) {
+	if now.Sub(pc.lastSweep) < sessionTimeout {
+		return
+	}
+	pc.lastSweep = now
+
+	for id, session := range pc.sessions {
+		if now.Sub(session.lastSeen) >= sessionTimeout {
+			delete(pc.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package shadowsocks2022
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+func newKey(size int) string {
+	key := make([]byte, size)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// stand-in server state of one direction
+type aeadStream struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (as *aeadStream) seal(plaintext []byte) []byte {
+	ciphertext := as.aead.Seal(nil, as.nonce, plaintext, nil)
+	increment(as.nonce)
+	return ciphertext
+}
+
+func (as *aeadStream) open(r io.Reader, length int) ([]byte, error) {
+	buf := make([]byte, length+as.aead.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	plaintext, err := as.aead.Open(buf[:0], as.nonce, buf, nil)
+	increment(as.nonce)
+	return plaintext, err
+}
+
+func newAEADStream(c *Cipher, salt []byte) *aeadStream {
+	aead := c.sessionAEAD(salt)
+	return &aeadStream{aead: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+// serveStream is a shadowsocks 2022 stand-in server, it checks the identity headers
+// as the first relay would and echoes the payload back
+func serveStream(conn net.Conn, c *Cipher, addrs chan<- socks5.Addr) error {
+	defer conn.Close()
+
+	salt := make([]byte, c.keySize)
+	if _, err := io.ReadFull(conn, salt); err != nil {
+		return err
+	}
+
+	for i, psk := range c.identityPSKs {
+		identity := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(conn, identity); err != nil {
+			return err
+		}
+		subkey := make([]byte, c.keySize)
+		blake3.DeriveKey(subkey, identitySubkeyContext, append(append([]byte{}, psk...), salt...))
+		block, _ := aes.NewCipher(subkey)
+		block.Decrypt(identity, identity)
+		if !bytes.Equal(identity, pskHash(c.nextPSK(i))) {
+			return errors.New("identity mismatch")
+		}
+	}
+
+	reader := newAEADStream(c, salt)
+	fixed, err := reader.open(conn, 11)
+	if err != nil {
+		return err
+	}
+	if fixed[0] != HeaderTypeClient {
+		return ErrBadHeaderType
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(fixed[1:9])); err != nil {
+		return err
+	}
+	variable, err := reader.open(conn, int(binary.BigEndian.Uint16(fixed[9:])))
+	if err != nil {
+		return err
+	}
+	addr := socks5.SplitAddr(variable)
+	paddingLen := int(binary.BigEndian.Uint16(variable[len(addr):]))
+	initial := variable[len(addr)+2+paddingLen:]
+	if (paddingLen == 0 && len(initial) == 0) || paddingLen > MaxPaddingLength {
+		return errors.New("bad padding")
+	}
+	addrs <- addr
+
+	respSalt := make([]byte, c.keySize)
+	rand.Read(respSalt)
+	writer := newAEADStream(c, respSalt)
+	headerSent := false
+
+	for {
+		payload := initial
+		initial = nil
+		if len(payload) == 0 {
+			length, err := reader.open(conn, 2)
+			if err != nil {
+				return err
+			}
+			payload, err = reader.open(conn, int(binary.BigEndian.Uint16(length)))
+			if err != nil {
+				return err
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if !headerSent {
+			headerSent = true
+			fixed := make([]byte, 1+8+c.keySize+2)
+			fixed[0] = HeaderTypeServer
+			binary.BigEndian.PutUint64(fixed[1:], uint64(time.Now().Unix()))
+			copy(fixed[9:], salt)
+			binary.BigEndian.PutUint16(fixed[9+c.keySize:], uint16(len(payload)))
+			buf.Write(respSalt)
+			buf.Write(writer.seal(fixed))
+		} else {
+			length := make([]byte, 2)
+			binary.BigEndian.PutUint16(length, uint16(len(payload)))
+			buf.Write(writer.seal(length))
+		}
+		buf.Write(writer.seal(payload))
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func testStream(t *testing.T, method, password string) {
+	c, err := New(method, password)
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	addrs := make(chan socks5.Addr, 1)
+	go serveStream(right, c, addrs)
+
+	conn := c.StreamConn(left)
+	defer conn.Close()
+
+	go conn.Write(socks5.ParseAddr("example.com:443"))
+	assert.Equal(t, "example.com:443", (<-addrs).String())
+
+	for _, payload := range [][]byte{[]byte("ping"), bytes.Repeat([]byte{'a'}, maxPayloadLength+100)} {
+		go conn.Write(payload)
+		buf := make([]byte, len(payload))
+		_, err := io.ReadFull(conn, buf)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, buf)
+	}
+}
+
+func TestStreamConn(t *testing.T) {
+	testStream(t, "2022-blake3-aes-128-gcm", newKey(16))
+	testStream(t, "2022-blake3-aes-256-gcm", newKey(32))
+	testStream(t, "2022-blake3-chacha20-poly1305", newKey(32))
+}
+
+func TestStreamConn_IdentityHeader(t *testing.T) {
+	testStream(t, "2022-blake3-aes-128-gcm", newKey(16)+":"+newKey(16)+":"+newKey(16))
+}
+
+func TestStreamConn_LongFirstWrite(t *testing.T) {
+	c, err := New("2022-blake3-aes-128-gcm", newKey(16))
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	addrs := make(chan socks5.Addr, 1)
+	go serveStream(right, c, addrs)
+
+	conn := c.StreamConn(left)
+	defer conn.Close()
+
+	// the payload beyond the variable header goes in the following chunks
+	payload := bytes.Repeat([]byte{'a'}, maxPayloadLength+100)
+	go conn.Write(append(socks5.ParseAddr("example.com:443"), payload...))
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+
+	select {
+	case addr := <-addrs:
+		assert.Equal(t, "example.com:443", addr.String())
+	default:
+		t.Error("the request header is rejected")
+	}
+}
+
+// the vectors are generated by sing-shadowsocks v0.2.7 with 2022-blake3-aes-256-gcm, the timestamp
+// 1700000000, iPSK 0x01 * 32, uPSK 0x02 * 32 and the salts counting from 0x00
+const (
+	vectorPSKs = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+	// sha256 of the request to example.com:443 with 1024 bytes 'a' and the identity header
+	vectorRequestSum = "bb8cf6d12f54c7961518d1f893dff515202f180063988b9f9204d795b4fb564d"
+	// the response "pong" of the server with uPSK to the request salt 0x00..0x1f
+	vectorResponse = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3fa7072aa4ad51dd2bdd645426b199428b" +
+		"0be95056f742c064d3c699b43c8a04d906dc5eab1f96ae73e5a70fc433f321e4073202fc9c23b67844c43e042bad0d34c24f2f7c81713235" +
+		"48a8ddda4c8570"
+)
+
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (cc *captureConn) Write(b []byte) (int, error) {
+	return cc.buf.Write(b)
+}
+
+func TestStreamConn_Vector(t *testing.T) {
+	defer func(now func() time.Time, pool *saltPool) {
+		timeNow, responseSalts = now, pool
+	}(timeNow, responseSalts)
+	timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+	responseSalts = newSaltPool(2 * maxTimeDiff)
+
+	salt := make([]byte, 32)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+
+	c, err := New("2022-blake3-aes-256-gcm", vectorPSKs)
+	assert.Nil(t, err)
+	capture := &captureConn{}
+	sc := newStreamConn(capture, c)
+	_, err = sc.writeHeader(salt, append(socks5.ParseAddr("example.com:443"), bytes.Repeat([]byte{'a'}, 1024)...))
+	assert.Nil(t, err)
+	sum := sha256.Sum256(capture.buf.Bytes())
+	assert.Equal(t, vectorRequestSum, hex.EncodeToString(sum[:]))
+
+	response, _ := hex.DecodeString(vectorResponse)
+	sc = newStreamConn(&fakeConn{Reader: bytes.NewReader(response)}, c)
+	sc.requestSalt = salt
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(sc, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestStreamConn_ReplayedResponse(t *testing.T) {
+	c, err := New("2022-blake3-aes-128-gcm", newKey(16))
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	sc := c.StreamConn(left).(*streamConn)
+	go func() {
+		io.Copy(io.Discard, right)
+	}()
+	_, err = sc.Write(socks5.ParseAddr("example.com:443"))
+	assert.Nil(t, err)
+
+	respSalt := make([]byte, c.keySize)
+	rand.Read(respSalt)
+	response := func(salt []byte, ts time.Time) []byte {
+		writer := newAEADStream(c, salt)
+		fixed := make([]byte, 1+8+c.keySize+2)
+		fixed[0] = HeaderTypeServer
+		binary.BigEndian.PutUint64(fixed[1:], uint64(ts.Unix()))
+		copy(fixed[9:], sc.requestSalt)
+		binary.BigEndian.PutUint16(fixed[9+c.keySize:], 4)
+		return append(append(append([]byte{}, salt...), writer.seal(fixed)...), writer.seal([]byte("pong"))...)
+	}
+
+	read := func(packet []byte) error {
+		another := newStreamConn(nil, c)
+		another.requestSalt = sc.requestSalt
+		another.Conn = &fakeConn{Reader: bytes.NewReader(packet)}
+		_, err := another.Read(make([]byte, 4))
+		return err
+	}
+
+	assert.Nil(t, read(response(respSalt, time.Now())))
+	assert.Equal(t, ErrReplay, read(response(respSalt, time.Now())))
+
+	staleSalt := make([]byte, c.keySize)
+	rand.Read(staleSalt)
+	assert.Equal(t, ErrBadTimestamp, read(response(staleSalt, time.Now().Add(-time.Minute))))
+}
+
+type fakeConn struct {
+	net.Conn
+	io.Reader
+}
+
+func (fc *fakeConn) Read(b []byte) (int, error) {
+	return fc.Reader.Read(b)
+}
+
+// servePacket is the udp side of the stand-in server, it echoes the packets back
+func servePacket(pc net.PacketConn, c *Cipher) error {
+	serverSessionID := make([]byte, 8)
+	rand.Read(serverSessionID)
+	var packetID uint64
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		packet := buf[:n]
+
+		var header, body []byte
+		if c.aes {
+			header = packet[:16]
+			firstPSK := c.psk
+			if len(c.identityPSKs) != 0 {
+				firstPSK = c.identityPSKs[0]
+			}
+			block, _ := aes.NewCipher(firstPSK)
+			block.Decrypt(header, header)
+			packet = packet[16:]
+
+			for i, psk := range c.identityPSKs {
+				identity := packet[:16]
+				block, _ := aes.NewCipher(psk)
+				block.Decrypt(identity, identity)
+				for j := range identity {
+					identity[j] ^= header[j]
+				}
+				if !bytes.Equal(identity, pskHash(c.nextPSK(i))) {
+					return errors.New("identity mismatch")
+				}
+				packet = packet[16:]
+			}
+
+			body, err = c.sessionAEAD(header[:8]).Open(nil, header[4:16], packet, nil)
+		} else {
+			aead, _ := chacha20poly1305.NewX(c.psk)
+			var plaintext []byte
+			plaintext, err = aead.Open(nil, packet[:24], packet[24:], nil)
+			if err == nil {
+				header, body = plaintext[:16], plaintext[16:]
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if body[0] != HeaderTypeClient {
+			return ErrBadHeaderType
+		}
+		paddingLen := int(binary.BigEndian.Uint16(body[9:11]))
+		payload := body[11+paddingLen:]
+
+		respHeader := make([]byte, 16)
+		copy(respHeader, serverSessionID)
+		binary.BigEndian.PutUint64(respHeader[8:], packetID)
+		packetID++
+
+		respBody := &bytes.Buffer{}
+		respBody.WriteByte(HeaderTypeServer)
+		binary.Write(respBody, binary.BigEndian, uint64(time.Now().Unix()))
+		respBody.Write(header[:8])
+		binary.Write(respBody, binary.BigEndian, uint16(3))
+		respBody.Write([]byte{1, 2, 3})
+		respBody.Write(payload)
+
+		resp := &bytes.Buffer{}
+		if c.aes {
+			sealed := c.sessionAEAD(serverSessionID).Seal(nil, respHeader[4:16], respBody.Bytes(), nil)
+			block, _ := aes.NewCipher(c.psk)
+			block.Encrypt(respHeader, respHeader)
+			resp.Write(respHeader)
+			resp.Write(sealed)
+		} else {
+			aead, _ := chacha20poly1305.NewX(c.psk)
+			nonce := make([]byte, 24)
+			rand.Read(nonce)
+			resp.Write(nonce)
+			resp.Write(aead.Seal(nil, nonce, append(respHeader, respBody.Bytes()...), nil))
+		}
+
+		// send twice, the second one is a replay
+		pc.WriteTo(resp.Bytes(), addr)
+		pc.WriteTo(resp.Bytes(), addr)
+	}
+}
+
+func testPacket(t *testing.T, method, password string) {
+	c, err := New(method, password)
+	assert.Nil(t, err)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+	go servePacket(server, c)
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	pc := c.PacketConn(client)
+	defer pc.Close()
+
+	buf := make([]byte, 1024)
+	for _, payload := range []string{"hello", "world"} {
+		packet := append([]byte(socks5.ParseAddr("1.1.1.1:53")), payload...)
+		_, err = pc.WriteTo(packet, server.LocalAddr())
+		assert.Nil(t, err)
+
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, packet, buf[:n])
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	testPacket(t, "2022-blake3-aes-128-gcm", newKey(16))
+	testPacket(t, "2022-blake3-aes-256-gcm", newKey(32))
+	testPacket(t, "2022-blake3-chacha20-poly1305", newKey(32))
+}
+
+func TestPacketConn_IdentityHeader(t *testing.T) {
+	testPacket(t, "2022-blake3-aes-256-gcm", newKey(32)+":"+newKey(32))
+}
+
+func TestSlidingWindow(t *testing.T) {
+	sw := &slidingWindow{}
+	assert.True(t, sw.check(0))
+	assert.True(t, sw.check(2))
+	assert.False(t, sw.check(2))
+	assert.True(t, sw.check(1))
+	assert.False(t, sw.check(0))
+	assert.True(t, sw.check(100))
+	assert.False(t, sw.check(100-windowSize))
+	assert.True(t, sw.check(100-windowSize+1))
+}
+
+func TestSaltPool(t *testing.T) {
+	sp := newSaltPool(100 * time.Millisecond)
+	assert.True(t, sp.check([]byte("a")))
+	assert.False(t, sp.check([]byte("a")))
+
+	// the salt moves to the previous bucket and is still remembered
+	sp.rotated = sp.rotated.Add(-100 * time.Millisecond)
+	assert.True(t, sp.check([]byte("b")))
+	assert.False(t, sp.check([]byte("a")))
+
+	// and it's dropped with the bucket
+	sp.rotated = sp.rotated.Add(-100 * time.Millisecond)
+	assert.True(t, sp.check([]byte("c")))
+	assert.True(t, sp.check([]byte("a")))
+	assert.False(t, sp.check([]byte("b")))
+
+	// both buckets are dropped after a long idle
+	sp.rotated = sp.rotated.Add(-200 * time.Millisecond)
+	assert.True(t, sp.check([]byte("b")))
+	assert.True(t, sp.check([]byte("c")))
+}
+
+func TestPacketConn_SessionExpire(t *testing.T) {
+	c, err := New("2022-blake3-aes-128-gcm", newKey(16))
+	assert.Nil(t, err)
+	pc := c.PacketConn(nil).(*packetConn)
+
+	now := time.Now()
+	pc.sessions[1] = &serverSession{lastSeen: now.Add(-sessionTimeout)}
+	pc.sessions[2] = &serverSession{lastSeen: now}
+
+	// no sweep within sessionTimeout of the last one
+	pc.sweep(now)
+	assert.Len(t, pc.sessions, 2)
+
+	pc.lastSweep = now.Add(-sessionTimeout)
+	pc.sweep(now)
+	assert.Len(t, pc.sessions, 1)
+	assert.NotNil(t, pc.sessions[2])
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("2022-blake3-aes-128-gcm", newKey(32))
+	assert.Equal(t, ErrBadKey, err)
+
+	_, err = New("2022-blake3-chacha20-poly1305", newKey(32)+":"+newKey(32))
+	assert.Equal(t, ErrIdentityHeader, err)
+
+	_, err = New("2022-blake3-aes-128-gcm", "not base64")
+	assert.NotNil(t, err)
+
+	assert.True(t, IsShadowsocks2022("2022-BLAKE3-AES-128-GCM"))
+	assert.False(t, IsShadowsocks2022("aes-128-gcm"))
+}
@@ -0,0 +1,285 @@
+package shadowsocks2022
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	mRand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"lukechampine.com/blake3"
+)
+
+const (
+	// maxPayloadLength is the max length of a chunk
+	maxPayloadLength = 0xFFFF
+)
+
+// saltPool remember the salts seen in the last 2 * maxTimeDiff at least, headers older than that are
+// rejected by timestamp. The salts are kept in two buckets of interval, the older one is dropped as
+// a whole on the rotation, so a check never scans the pool.
+type saltPool struct {
+	current  map[string]struct{}
+	previous map[string]struct{}
+	rotated  time.Time
+	interval time.Duration
+	mux      sync.Mutex
+}
+
+func newSaltPool(interval time.Duration) *saltPool {
+	return &saltPool{
+		current:  map[string]struct{}{},
+		previous: map[string]struct{}{},
+		rotated:  time.Now(),
+		interval: interval,
+	}
+}
+
+// check return false if salt is already in the pool, otherwise add it
+func (sp *saltPool) check(salt []byte) bool {
+	sp.mux.Lock()
+	defer sp.mux.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(sp.rotated); elapsed >= sp.interval {
+		sp.previous = sp.current
+		if elapsed >= 2*sp.interval {
+			sp.previous = map[string]struct{}{}
+		}
+		sp.current = map[string]struct{}{}
+		sp.rotated = now
+	}
+
+	if _, ok := sp.current[string(salt)]; ok {
+		return false
+	}
+	if _, ok := sp.previous[string(salt)]; ok {
+		return false
+	}
+	sp.current[string(salt)] = struct{}{}
+	return true
+}
+
+var responseSalts = newSaltPool(2 * maxTimeDiff)
+
+type streamConn struct {
+	net.Conn
+	cipher *Cipher
+
+	requestSalt []byte
+	writer      cipher.AEAD
+	wNonce      []byte
+	wMux        sync.Mutex
+
+	reader cipher.AEAD
+	rNonce []byte
+	buf    []byte
+	rMux   sync.Mutex
+}
+
+func newStreamConn(conn net.Conn, c *Cipher) *streamConn {
+	return &streamConn{Conn: conn, cipher: c}
+}
+
+func (sc *streamConn) seal(dst, plaintext []byte) []byte {
+	dst = sc.writer.Seal(dst, sc.wNonce, plaintext, nil)
+	increment(sc.wNonce)
+	return dst
+}
+
+func (sc *streamConn) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := sc.reader.Open(ciphertext[:0], sc.rNonce, ciphertext, nil)
+	increment(sc.rNonce)
+	return plaintext, err
+}
+
+// Write send the request header with the first write, which should start with the socks address
+func (sc *streamConn) Write(b []byte) (int, error) {
+	sc.wMux.Lock()
+	defer sc.wMux.Unlock()
+
+	if sc.writer == nil {
+		salt := make([]byte, sc.cipher.keySize)
+		rand.Read(salt)
+		return sc.writeHeader(salt, b)
+	}
+
+	buf := &bytes.Buffer{}
+	sc.writeChunks(buf, b)
+
+	if _, err := sc.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeChunks split b into the chunks of maxPayloadLength
+func (sc *streamConn) writeChunks(buf *bytes.Buffer, b []byte) {
+	for offset := 0; offset < len(b); offset += maxPayloadLength {
+		end := offset + maxPayloadLength
+		if end > len(b) {
+			end = len(b)
+		}
+		sc.writeChunk(buf, b[offset:end])
+	}
+}
+
+func (sc *streamConn) writeChunk(buf *bytes.Buffer, payload []byte) {
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(payload)))
+	buf.Write(sc.seal(nil, length))
+	buf.Write(sc.seal(nil, payload))
+}
+
+func (sc *streamConn) writeHeader(salt, b []byte) (int, error) {
+	addr := socks5.SplitAddr(b)
+	if addr == nil {
+		return 0, errors.New("the first write should start with a socks address")
+	}
+
+	// the variable header is a chunk too, the payload beyond it follows in chunks
+	payload := b[len(addr):]
+	var rest []byte
+	if max := maxPayloadLength - len(addr) - 2; len(payload) > max {
+		payload, rest = payload[:max], payload[max:]
+	}
+
+	c := sc.cipher
+	sc.requestSalt = salt
+	sc.writer = c.sessionAEAD(salt)
+	sc.wNonce = make([]byte, sc.writer.NonceSize())
+
+	buf := &bytes.Buffer{}
+	buf.Write(salt)
+
+	// extensible identity headers
+	for i, psk := range c.identityPSKs {
+		material := make([]byte, 0, len(psk)+len(salt))
+		material = append(material, psk...)
+		material = append(material, salt...)
+		subkey := make([]byte, c.keySize)
+		blake3.DeriveKey(subkey, identitySubkeyContext, material)
+
+		block, _ := aes.NewCipher(subkey)
+		identity := make([]byte, aes.BlockSize)
+		block.Encrypt(identity, pskHash(c.nextPSK(i)))
+		buf.Write(identity)
+	}
+
+	// Addr PaddingLen Padding Payload, padding is required without an initial payload
+	variable := &bytes.Buffer{}
+	variable.Write(addr)
+	paddingLen := 0
+	if len(payload) == 0 {
+		paddingLen = mRand.Intn(MaxPaddingLength) + 1
+	}
+	binary.Write(variable, binary.BigEndian, uint16(paddingLen))
+	variable.Write(make([]byte, paddingLen))
+	variable.Write(payload)
+
+	// Type Timestamp Length
+	fixed := make([]byte, 1+8+2)
+	fixed[0] = HeaderTypeClient
+	binary.BigEndian.PutUint64(fixed[1:], uint64(timeNow().Unix()))
+	binary.BigEndian.PutUint16(fixed[9:], uint16(variable.Len()))
+
+	buf.Write(sc.seal(nil, fixed))
+	buf.Write(sc.seal(nil, variable.Bytes()))
+	sc.writeChunks(buf, rest)
+
+	if _, err := sc.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (sc *streamConn) Read(b []byte) (int, error) {
+	sc.rMux.Lock()
+	defer sc.rMux.Unlock()
+
+	if len(sc.buf) == 0 {
+		var err error
+		if sc.reader == nil {
+			err = sc.readHeader()
+		} else {
+			err = sc.readChunk()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, sc.buf)
+	sc.buf = sc.buf[n:]
+	return n, nil
+}
+
+func (sc *streamConn) readHeader() error {
+	c := sc.cipher
+	salt := make([]byte, c.keySize)
+	if _, err := io.ReadFull(sc.Conn, salt); err != nil {
+		return err
+	}
+
+	sc.reader = c.sessionAEAD(salt)
+	sc.rNonce = make([]byte, sc.reader.NonceSize())
+	overhead := sc.reader.Overhead()
+
+	// Type Timestamp RequestSalt Length
+	fixed := make([]byte, 1+8+c.keySize+2+overhead)
+	if _, err := io.ReadFull(sc.Conn, fixed); err != nil {
+		return err
+	}
+	fixed, err := sc.open(fixed)
+	if err != nil {
+		return err
+	}
+
+	if fixed[0] != HeaderTypeServer {
+		return ErrBadHeaderType
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(fixed[1:9])); err != nil {
+		return err
+	}
+	if !bytes.Equal(fixed[9:9+c.keySize], sc.requestSalt) {
+		return ErrBadRequestSalt
+	}
+	if !responseSalts.check(salt) {
+		return ErrReplay
+	}
+
+	return sc.readPayload(int(binary.BigEndian.Uint16(fixed[9+c.keySize:])))
+}
+
+func (sc *streamConn) readChunk() error {
+	length := make([]byte, 2+sc.reader.Overhead())
+	if _, err := io.ReadFull(sc.Conn, length); err != nil {
+		return err
+	}
+	length, err := sc.open(length)
+	if err != nil {
+		return err
+	}
+	return sc.readPayload(int(binary.BigEndian.Uint16(length)))
+}
+
+func (sc *streamConn) readPayload(length int) error {
+	payload := make([]byte, length+sc.reader.Overhead())
+	if _, err := io.ReadFull(sc.Conn, payload); err != nil {
+		return err
+	}
+	payload, err := sc.open(payload)
+	if err != nil {
+		return err
+	}
+	sc.buf = payload
+	return nil
+}
@@ -15,10 +15,21 @@ import (
 type Cipher interface {
 	StreamConn(net.Conn) (net.Conn, error)
 	PacketConn(net.PacketConn) (net.PacketConn, error)
+	// Key and IVSize are used by the protocol plugins to derive their mac keys
+	Key() []byte
+	IVSize() int
 }
 
-type none struct{}
+type none struct {
+	key []byte
+}
 
+func (n *none) Key() []byte {
+	return n.key
+}
+func (none) IVSize() int {
+	return 0
+}
 func (none) StreamConn(c net.Conn) (net.Conn, error) {
 	return c, nil
 }
@@ -53,18 +64,21 @@ func PickCipher(name string, key []byte, password string) (Cipher, error) {
 	name = strings.ToUpper(name)
 
 	if name == "NONE" {
-		return &none{}, nil
+		if len(key) == 0 {
+			key = Kdf(password, 16)
+		}
+		return &none{key: key}, nil
 	}
 
 	if choice, ok := streamList[name]; ok {
 		if len(key) == 0 {
-			key = kdf(password, choice.KeySize)
+			key = Kdf(password, choice.KeySize)
 		}
 		if len(key) != choice.KeySize {
 			return nil, shadowstream.KeySizeError(choice.KeySize)
 		}
 		ciph, err := choice.New(key)
-		return &stream.ShadowSocksRStreamCipher{ciph, key}, err
+		return stream.NewShadowSocksRStreamCipher(ciph, key), err
 	}
 
 	return nil, core.ErrCipherNotSupported
@@ -73,8 +87,8 @@ func PickCipher(name string, key []byte, password string) (Cipher, error) {
 // Copied from the go-shadowsocks2 project because this function is not made public
 // https://github.com/shadowsocks/go-shadowsocks2
 // Licensed under Apache License 2.0
-// key-derivation function from original Shadowsocks
-func kdf(password string, keyLen int) []byte {
+// Kdf is the key-derivation function from original Shadowsocks
+func Kdf(password string, keyLen int) []byte {
 	var b, prev []byte
 	h := md5.New()
 	for len(b) < keyLen {
@@ -2,7 +2,6 @@ package stream
 
 import (
 	"crypto/rand"
-	"fmt"
 	"io"
 	"net"
 
@@ -11,7 +10,15 @@ import (
 
 type ShadowSocksRStreamCipher struct {
 	shadowstream.Cipher
-	Key []byte
+	key []byte
+}
+
+func NewShadowSocksRStreamCipher(ciph shadowstream.Cipher, key []byte) *ShadowSocksRStreamCipher {
+	return &ShadowSocksRStreamCipher{Cipher: ciph, key: key}
+}
+
+func (ciph *ShadowSocksRStreamCipher) Key() []byte {
+	return ciph.key
 }
 
 func (ciph *ShadowSocksRStreamCipher) StreamConn(c net.Conn) (net.Conn, error) {
@@ -19,7 +26,7 @@ func (ciph *ShadowSocksRStreamCipher) StreamConn(c net.Conn) (net.Conn, error) {
 }
 
 func (ciph *ShadowSocksRStreamCipher) PacketConn(c net.PacketConn) (net.PacketConn, error) {
-	return nil, fmt.Errorf("packet conn for ssr not implemented")
+	return shadowstream.NewPacketConn(c, ciph.Cipher), nil
 }
 
 type ShadowSocksRStreamConn struct {
@@ -45,7 +52,7 @@ func NewShadowSocksRStreamConn(c net.Conn, ciph ShadowSocksRStreamCipher) (*Shad
 		Cipher:  ciph,
 		ReadIV:  nil,
 		WriteIV: WriteIV,
-		Key:     ciph.Key,
+		Key:     ciph.key,
 	}, nil
 }
 
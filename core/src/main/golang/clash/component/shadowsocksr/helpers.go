@@ -41,7 +41,7 @@ func (c *ConnLogger) Read(b []byte) (int, error) {
 	if err == nil {
 		log.Debugln("[%s] successfully read %d bytes: %s", c.name, n, hex.EncodeToString(b[:n]))
 	} else {
-		log.Debugln("[%s] failed to read because: %w", c.name, err)
+		log.Debugln("[%s] failed to read because: %v", c.name, err)
 	}
 	return n, err
 }
@@ -51,7 +51,7 @@ func (c *ConnLogger) Write(b []byte) (int, error) {
 	if err == nil {
 		log.Debugln("[%s] successfully write %d bytes: %s", c.name, n, hex.EncodeToString(b[:n]))
 	} else {
-		log.Debugln("[%s] failed to write because: %w", c.name, err)
+		log.Debugln("[%s] failed to write because: %v", c.name, err)
 	}
 	return n, err
 }
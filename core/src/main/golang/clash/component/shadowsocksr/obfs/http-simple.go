@@ -1,150 +1,174 @@
 package obfs
 
 import (
+	"bytes"
 	"fmt"
-	"math/rand"
 	"net"
-	"net/url"
 	"strconv"
 	"strings"
-
-	"github.com/Dreamacro/clash/component/shadowsocksr"
 )
 
-type ShadowSocksRHTTPSimpleObfs struct {
-	net.Conn
-	headerSent      bool
-	tcpHeaderLength int
-	ivLen           int
-
-	Method       string
-	RequestURI   []string
-	Host         string
-	Port         int
-	UserAgent    string
-	CustomHeader string
+var UserAgents = []string{
+	"Mozilla/5.0 (Windows NT 6.3; WOW64; rv:40.0) Gecko/20100101 Firefox/40.0",
+	"Mozilla/5.0 (Windows NT 6.3; WOW64; rv:40.0) Gecko/20100101 Firefox/44.0",
+	"Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/535.11 (KHTML, like Gecko) Ubuntu/11.10 Chromium/27.0.1453.93 Chrome/27.0.1453.93 Safari/537.36",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:35.0) Gecko/20100101 Firefox/35.0",
+	"Mozilla/5.0 (compatible; WOW64; MSIE 10.0; Windows NT 6.2)",
+	"Mozilla/5.0 (Windows; U; Windows NT 6.1; en-US) AppleWebKit/533.20.25 (KHTML, like Gecko) Version/5.0.4 Safari/533.20.27",
+	"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.3; Trident/7.0; .NET4.0E; .NET4.0C)",
+	"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+	"Mozilla/5.0 (Linux; Android 4.4; Nexus 5 Build/BuildID) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/30.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (iPad; CPU OS 5_0 like Mac OS X) AppleWebKit/534.46 (KHTML, like Gecko) Version/5.1 Mobile/9A334 Safari/7534.48.3",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 5_0 like Mac OS X) AppleWebKit/534.46 (KHTML, like Gecko) Version/5.1 Mobile/9A334 Safari/7534.48.3",
+}
+
+// httpObfs is http_simple, or http_post if post is set
+type httpObfs struct {
+	*Base
+	post bool
+
+	hosts        []string
+	customHeader string
 }
 
-var (
-	RequestURIs = [][]string{
-		[]string{"/", ""},
-		[]string{"/login.php?redir=", ""},
-		[]string{"/register.php?code=", ""},
-		[]string{"/s?ie=utf-8&f=8&rsv_bp=1&rsv_idx=1&ch=&bar=&wd=", "&rn="},
-		[]string{"/post.php?id=", "&goto=view.php"},
+func newHTTPObfs(b *Base, post bool) *httpObfs {
+	param := b.Param
+	if param == "" {
+		param = b.Host
 	}
-	UserAgents = []string{
-		"Mozilla/5.0 (Windows NT 6.3; WOW64; rv:40.0) Gecko/20100101 Firefox/40.0",
-		"Mozilla/5.0 (Windows NT 6.3; WOW64; rv:40.0) Gecko/20100101 Firefox/44.0",
-		"Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36",
-		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/535.11 (KHTML, like Gecko) Ubuntu/11.10 Chromium/27.0.1453.93 Chrome/27.0.1453.93 Safari/537.36",
-		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:35.0) Gecko/20100101 Firefox/35.0",
-		"Mozilla/5.0 (compatible; WOW64; MSIE 10.0; Windows NT 6.2)",
-		"Mozilla/5.0 (Windows; U; Windows NT 6.1; en-US) AppleWebKit/533.20.25 (KHTML, like Gecko) Version/5.0.4 Safari/533.20.27",
-		"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.3; Trident/7.0; .NET4.0E; .NET4.0C)",
-		"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
-		"Mozilla/5.0 (Linux; Android 4.4; Nexus 5 Build/BuildID) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/30.0.0.0 Mobile Safari/537.36",
-		"Mozilla/5.0 (iPad; CPU OS 5_0 like Mac OS X) AppleWebKit/534.46 (KHTML, like Gecko) Version/5.1 Mobile/9A334 Safari/7534.48.3",
-		"Mozilla/5.0 (iPhone; CPU iPhone OS 5_0 like Mac OS X) AppleWebKit/534.46 (KHTML, like Gecko) Version/5.1 Mobile/9A334 Safari/7534.48.3",
+
+	// param is "host1,host2#Header: value\nHeader: value"
+	var customHeader string
+	if pos := strings.Index(param, "#"); pos >= 0 {
+		customHeader = strings.ReplaceAll(param[pos+1:], "\\n", "\n")
+		customHeader = strings.ReplaceAll(customHeader, "\r\n", "\n")
+		customHeader = strings.ReplaceAll(customHeader, "\n", "\r\n")
+		param = param[:pos]
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(param, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = []string{b.Host}
 	}
-)
 
-func NewShadowSocksRHTTPSimpleObfs(c net.Conn, param string) net.Conn {
-	host := "bing.com"
-	port := 80
-	customHeader := ""
+	return &httpObfs{Base: b, post: post, hosts: hosts, customHeader: customHeader}
+}
 
-	if len(param) > 0 {
-		paramParts := strings.Split(param, "#")
-		domains := strings.Split(paramParts[0], ",")
-		domain := domains[rand.Intn(len(domains))]
+func (h *httpObfs) StreamConn(c net.Conn) net.Conn {
+	return &httpConn{Conn: c, httpObfs: h}
+}
 
-		domainParts := strings.Split(domain, ":")
-		host = strings.TrimSpace(domainParts[0])
-		if len(domainParts) > 1 {
-			port, _ = strconv.Atoi(domainParts[1])
-		}
+func (h *httpObfs) Overhead() int {
+	return 0
+}
 
-		if len(paramParts) > 1 {
-			customHeader = strings.Replace(paramParts[1], "\\n", "\r\n", -1)
-		}
+// header return the request header carrying data in the uri
+func (h *httpObfs) header(data []byte) []byte {
+	buf := &bytes.Buffer{}
+
+	method := "GET"
+	if h.post {
+		method = "POST"
+	}
+	buf.WriteString(method + " /")
+	for _, b := range data {
+		fmt.Fprintf(buf, "%%%02x", b)
 	}
+	buf.WriteString(" HTTP/1.1\r\n")
 
-	return &ShadowSocksRHTTPSimpleObfs{
-		Conn:       c,
-		headerSent: false,
+	host := h.hosts[randIntn(len(h.hosts))]
+	if h.Port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(h.Port))
+	}
+	buf.WriteString("Host: " + host + "\r\n")
 
-		Method:       "GET",
-		RequestURI:   RequestURIs[rand.Intn(len(RequestURIs))],
-		Host:         host,
-		Port:         port,
-		UserAgent:    UserAgents[rand.Intn(len(UserAgents))],
-		CustomHeader: customHeader,
+	if h.customHeader != "" {
+		buf.WriteString(h.customHeader + "\r\n\r\n")
+		return buf.Bytes()
 	}
-}
 
-func (c *ShadowSocksRHTTPSimpleObfs) SetTCPHeaderLength(b []byte, defaultLength int) {
-	c.tcpHeaderLength = shadowsocksr.GetPacketTCPHeaderSize(b, defaultLength)
+	buf.WriteString("User-Agent: " + UserAgents[randIntn(len(UserAgents))] + "\r\n")
+	buf.WriteString("Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n")
+	buf.WriteString("Accept-Language: en-US,en;q=0.8\r\n")
+	buf.WriteString("Accept-Encoding: gzip, deflate\r\n")
+	if h.post {
+		buf.WriteString("Content-Type: multipart/form-data; boundary=" + randomBoundary() + "\r\n")
+	}
+	buf.WriteString("DNT: 1\r\n")
+	buf.WriteString("Connection: keep-alive\r\n\r\n")
+	return buf.Bytes()
 }
 
-func (c *ShadowSocksRHTTPSimpleObfs) randomBoundary() string {
+func randomBoundary() string {
+	const set = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, 32)
-	set := []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
-	for i := 0; i < 32; i++ {
-		b[i] = set[rand.Intn(len(set))]
+	for i := range b {
+		b[i] = set[randIntn(len(set))]
 	}
 	return string(b)
 }
 
-func (c *ShadowSocksRHTTPSimpleObfs) Write(b []byte) (int, error) {
+type httpConn struct {
+	net.Conn
+	*httpObfs
+
+	headerSent bool
+	headerRecv bool
+	buf        []byte
+}
+
+// Write send the head of the first write encoded in the request uri, and the rest as the body
+func (c *httpConn) Write(b []byte) (int, error) {
 	if c.headerSent {
 		return c.Conn.Write(b)
 	}
 
-	dataLength := len(b)
-	var dataInHeader []byte
-	if headSize := c.ivLen + c.tcpHeaderLength; dataLength-headSize > 64 {
-		dataInHeader = make([]byte, headSize+rand.Intn(64))
-	} else {
-		dataInHeader = make([]byte, dataLength)
+	headLen := len(b)
+	if headSize := c.IVSize + 30; len(b)-headSize > 64 {
+		headLen = headSize + randIntn(65)
 	}
-	copy(dataInHeader, b[0:len(dataInHeader)])
-
-	httpBuf := fmt.Sprintf("%s %s%s%s HTTP/1.1\r\nHost: %s:%d\r\n",
-		c.Method,
-		c.RequestURI[0],
-		url.QueryEscape(string(dataInHeader)),
-		c.RequestURI[1],
-		c.Host,
-		c.Port)
-
-	if len(c.CustomHeader) > 0 {
-		httpBuf = httpBuf + c.CustomHeader + "\r\n\r\n"
-	} else {
-		var contentType string
-		if c.Method == "GET" {
-			contentType = "Content-Type: multipart/form-data; boundary=" + c.randomBoundary() + "\r\n"
-		}
-		httpBuf = httpBuf +
-			"User-Agent: " + c.UserAgent + "\r\n" +
-			"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
-			"Accept-Language: en-US,en;q=0.8\r\n" +
-			"Accept-Encoding: gzip, deflate\r\n" +
-			contentType +
-			"DNT: 1\r\n" +
-			"Connection: keep-alive\r\n" +
-			"\r\n"
+
+	buf := c.header(b[:headLen])
+	buf = append(buf, b[headLen:]...)
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
 	}
+	c.headerSent = true
+	return len(b), nil
+}
 
-	var dataToWrite []byte
-	if len(dataInHeader) < dataLength {
-		dataToWrite = make([]byte, len(httpBuf)+(dataLength-len(dataInHeader)))
-		copy(dataToWrite, []byte(httpBuf))
-		copy(dataToWrite[len(httpBuf):], b[len(dataInHeader):])
-	} else {
-		dataToWrite = []byte(httpBuf)
+// Read strip the response header
+func (c *httpConn) Read(b []byte) (int, error) {
+	if c.headerRecv {
+		if len(c.buf) > 0 {
+			n := copy(b, c.buf)
+			c.buf = c.buf[n:]
+			return n, nil
+		}
+		return c.Conn.Read(b)
 	}
 
-	c.headerSent = true
-	return c.Conn.Write(dataToWrite)
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf, buf[:n]...)
+
+		if pos := bytes.Index(c.buf, []byte("\r\n\r\n")); pos >= 0 {
+			c.buf = c.buf[pos+4:]
+			c.headerRecv = true
+			return c.Read(b)
+		}
+		if len(c.buf) > 64*1024 {
+			return 0, errHeaderTooLong
+		}
+	}
 }
@@ -0,0 +1,57 @@
+package obfs
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mRand "math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	errHeaderTooLong = errors.New("obfs response header too long")
+	errTLSHandshake  = errors.New("tls1.2_ticket_auth handshake error")
+	errTLSRecord     = errors.New("tls1.2_ticket_auth unexpected record")
+)
+
+// the sources of the randomness and the time, the tests fix them to check the packets byte by byte
+var (
+	randRead = rand.Read
+	randIntn = mRand.Intn
+	now      = time.Now
+)
+
+// Base is the information of the server shared by the obfs plugins
+type Base struct {
+	Host   string
+	Port   int
+	Key    []byte
+	IVSize int
+	Param  string
+}
+
+// Obfs disguises the encrypted stream, it is the outermost layer of a ssr connection
+type Obfs interface {
+	StreamConn(net.Conn) net.Conn
+	// Overhead is the bytes added to every write, reported to the server by auth_chain_a
+	Overhead() int
+}
+
+// PickObfs return the obfs plugin of name, the "_compatible" variants are the same on the client side
+func PickObfs(name string, b *Base) (Obfs, error) {
+	switch strings.TrimSuffix(strings.ToLower(name), "_compatible") {
+	case "", "plain":
+		return &plain{}, nil
+	case "http_simple":
+		return newHTTPObfs(b, false), nil
+	case "http_post":
+		return newHTTPObfs(b, true), nil
+	case "random_head":
+		return &randomHead{}, nil
+	case "tls1.2_ticket_auth":
+		return newTLS12TicketAuth(b), nil
+	}
+	return nil, fmt.Errorf("obfs %s not supported", name)
+}
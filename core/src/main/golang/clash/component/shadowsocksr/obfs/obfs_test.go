@@ -0,0 +1,343 @@
+package obfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	mRand "math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/shadowsocksr/encryption"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = encryption.Kdf("password", 16)
+
+// fixRandom make the packets the same as the ones of the python ssr run with os.urandom returning
+// 0x5a bytes, random.randint and random.choice taking 0x5a5a % n and time.time() returning 1700000000
+func fixRandom(t *testing.T) {
+	randRead = func(b []byte) (int, error) {
+		for i := range b {
+			b[i] = 0x5a
+		}
+		return len(b), nil
+	}
+	randIntn = func(n int) int { return 0x5a5a % n }
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	t.Cleanup(func() { randRead, randIntn, now = rand.Read, mRand.Intn, time.Now })
+}
+
+// writeConn records the writes
+type writeConn struct {
+	net.Conn
+	written []byte
+}
+
+func (c *writeConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func TestHTTPSimple_Request(t *testing.T) {
+	o, err := PickObfs("http_simple", &Base{Host: "server", Port: 8388, IVSize: 16, Param: "cdn.example.com#User-Agent: test\\nAccept: */*"})
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := o.StreamConn(left)
+	go c.Write([]byte{0x01, 0xab})
+
+	buf := make([]byte, 1024)
+	n, _ := right.Read(buf)
+	assert.Equal(t, "GET /%01%ab HTTP/1.1\r\nHost: cdn.example.com:8388\r\nUser-Agent: test\r\nAccept: */*\r\n\r\n", string(buf[:n]))
+}
+
+func testHTTPObfs(t *testing.T, name string) {
+	o, err := PickObfs(name, &Base{Host: "server", Port: 80, IVSize: 16})
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := o.StreamConn(left)
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go c.Write(data)
+
+	br := bufio.NewReader(right)
+	req, err := http.ReadRequest(br)
+	assert.Nil(t, err)
+	assert.Equal(t, "server", req.Host)
+	if name == "http_post" {
+		assert.Equal(t, "POST", req.Method)
+		assert.True(t, strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data; boundary="))
+	} else {
+		assert.Equal(t, "GET", req.Method)
+		assert.Empty(t, req.Header.Get("Content-Type"))
+	}
+
+	// every byte in the uri is escaped, the rest of data is the body
+	head, err := hex.DecodeString(strings.ReplaceAll(req.RequestURI[1:], "%", ""))
+	assert.Nil(t, err)
+	assert.True(t, len(head) >= 16+30 && len(head) <= 16+30+64)
+	body := make([]byte, len(data)-len(head))
+	_, err = io.ReadFull(br, body)
+	assert.Nil(t, err)
+	assert.Equal(t, data, append(head, body...))
+
+	// the response header is stripped
+	go func() {
+		right.Write([]byte("HTTP/1.1 200 OK\r\nServer: nginx\r\n"))
+		right.Write([]byte("Connection: keep-alive\r\n\r\npo"))
+		right.Write([]byte("ng"))
+	}()
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pong"), buf)
+}
+
+func TestHTTPSimple(t *testing.T) {
+	testHTTPObfs(t, "http_simple")
+}
+
+func TestHTTPPost(t *testing.T) {
+	testHTTPObfs(t, "http_post")
+}
+
+func TestHTTPObfs_Vector(t *testing.T) {
+	fixRandom(t)
+
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	// 46 bytes of the iv and the head, and 55 random bytes are sent in the uri
+	uri := "/"
+	for _, b := range data[:101] {
+		uri += fmt.Sprintf("%%%02x", b)
+	}
+
+	headers := map[string]string{
+		"http_simple": "GET " + uri + " HTTP/1.1\r\nHost: server:8388\r\n" +
+			"User-Agent: Mozilla/5.0 (Windows; U; Windows NT 6.1; en-US) AppleWebKit/533.20.25 (KHTML, like Gecko) Version/5.0.4 Safari/533.20.27\r\n" +
+			"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\nAccept-Language: en-US,en;q=0.8\r\n" +
+			"Accept-Encoding: gzip, deflate\r\nDNT: 1\r\nConnection: keep-alive\r\n\r\n",
+		"http_post": "POST " + uri + " HTTP/1.1\r\nHost: a.example.com\r\n" +
+			"User-Agent: Mozilla/5.0 (Windows; U; Windows NT 6.1; en-US) AppleWebKit/533.20.25 (KHTML, like Gecko) Version/5.0.4 Safari/533.20.27\r\n" +
+			"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\nAccept-Language: en-US,en;q=0.8\r\n" +
+			"Accept-Encoding: gzip, deflate\r\nContent-Type: multipart/form-data; boundary=eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee\r\n" +
+			"DNT: 1\r\nConnection: keep-alive\r\n\r\n",
+	}
+	bases := map[string]*Base{
+		"http_simple": {Host: "server", Port: 8388, IVSize: 16},
+		"http_post":   {Host: "server", Port: 80, IVSize: 16, Param: "a.example.com,b.example.com"},
+	}
+
+	for name, header := range headers {
+		o, _ := PickObfs(name, bases[name])
+		wc := &writeConn{}
+		o.StreamConn(wc).Write(data)
+		assert.Equal(t, header, string(wc.written[:len(wc.written)-99]), name)
+		assert.Equal(t, data[101:], wc.written[len(wc.written)-99:], name)
+	}
+}
+
+func TestRandomHead(t *testing.T) {
+	o, err := PickObfs("random_head", &Base{})
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := o.StreamConn(left)
+	go c.Write([]byte("hello"))
+
+	buf := make([]byte, 1024)
+	n, err := right.Read(buf)
+	assert.Nil(t, err)
+	assert.True(t, n >= 8 && n <= 103)
+	assert.Equal(t, uint32(0xFFFFFFFF), crc32.ChecksumIEEE(buf[:n]))
+
+	// the data is held back until the server replies
+	right.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = right.Read(buf)
+	assert.NotNil(t, err)
+	right.SetReadDeadline(time.Time{})
+
+	go func() {
+		right.Write([]byte("random reply"))
+		n, _ := right.Read(buf)
+		right.Write(buf[:n])
+	}()
+
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(c, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), reply)
+}
+
+func TestRandomHead_Vector(t *testing.T) {
+	fixRandom(t)
+
+	wc := &writeConn{}
+	(&randomHead{}).StreamConn(wc).Write([]byte("hello"))
+	assert.Equal(t, strings.Repeat("5a", 94)+"8d1dacdc", hex.EncodeToString(wc.written))
+}
+
+// testServerHello is the server hello, a session ticket, change cipher spec, finished and "pong",
+// packed like the python server with the key of "password" and the client id 0x00 ~ 0x1f
+const testServerHello = "16030300510200004d03035f5e10002222222222222222222222222222222222221a3949cd433ac9cbf35720" +
+	"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1fc02f000005ff010001001603030044040000" +
+	"4033333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333333" +
+	"3333333333333333333333333333331403030001011603030028444444444444444444444444444444444444444444444444" +
+	"444444444444fccff862544097a465851703030004706f6e67"
+
+func newTestTLS12TicketAuth() *tls12TicketAuth {
+	o, _ := PickObfs("tls1.2_ticket_auth", &Base{Host: "1.2.3.4", Key: testKey, Param: "cloudflare.com"})
+	t := o.(*tls12TicketAuth)
+	for i := range t.clientID {
+		t.clientID[i] = byte(i)
+	}
+	return t
+}
+
+func readTLSRecord(t *testing.T, r io.Reader) (byte, []byte) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	assert.Nil(t, err)
+	payload := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	_, err = io.ReadFull(r, payload)
+	assert.Nil(t, err)
+	return header[0], payload
+}
+
+func TestTLS12TicketAuth(t *testing.T) {
+	o := newTestTLS12TicketAuth()
+	mac := func(data []byte) []byte {
+		h := hmac.New(sha1.New, append(append([]byte{}, testKey...), o.clientID...))
+		h.Write(data)
+		return h.Sum(nil)[:10]
+	}
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := o.StreamConn(left)
+	go c.Write([]byte("hello"))
+
+	// client hello
+	recordType, hello := readTLSRecord(t, right)
+	assert.Equal(t, byte(recordTypeHandshake), recordType)
+	assert.Equal(t, byte(0x01), hello[0])
+	assert.Equal(t, mac(hello[6:28]), hello[28:38])
+	assert.InDelta(t, time.Now().Unix(), int64(binary.BigEndian.Uint32(hello[6:])), 1)
+	assert.Equal(t, byte(32), hello[38])
+	assert.Equal(t, o.clientID, hello[39:71])
+	assert.True(t, bytes.Contains(hello, []byte("\x00\x0ecloudflare.com")))
+
+	// the stand-in server read the change cipher spec and finished, then the data held back
+	serverHello, _ := hex.DecodeString(testServerHello)
+	received := make(chan []byte, 2)
+	go func() {
+		// the pipe is unbuffered, "pong" is sent after the client finished
+		right.Write(serverHello[:len(serverHello)-9])
+		finish := make([]byte, 6+5+32)
+		io.ReadFull(right, finish)
+		received <- finish
+		_, payload := readTLSRecord(t, right)
+		received <- payload
+		right.Write(serverHello[len(serverHello)-9:])
+	}()
+
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pong"), buf)
+
+	finish := <-received
+	assert.Equal(t, []byte{recordTypeChangeCipherSpec, 3, 3, 0, 1, 1, recordTypeHandshake, 3, 3, 0, 32}, finish[:11])
+	assert.Equal(t, mac(finish[:len(finish)-10]), finish[len(finish)-10:])
+	assert.Equal(t, []byte("hello"), <-received)
+
+	// large writes are split into records
+	data := make([]byte, 10000)
+	go c.Write(data)
+	var written []byte
+	for len(written) < len(data) {
+		recordType, payload := readTLSRecord(t, right)
+		assert.Equal(t, byte(recordTypeApplicationData), recordType)
+		written = append(written, payload...)
+	}
+	assert.Equal(t, data, written)
+}
+
+func TestTLS12TicketAuth_Vector(t *testing.T) {
+	fixRandom(t)
+
+	o, _ := PickObfs("tls1.2_ticket_auth", &Base{Host: "server", Key: testKey, Param: "cloudflare.com"})
+	wc := &writeConn{}
+	c := o.StreamConn(wc).(*tls12TicketConn)
+	c.Write([]byte("ping"))
+
+	hello := "16030101e8010001e403036553f100" +
+		strings.Repeat("5a", 18) +
+		"ed8c70cab39b8ea0139820" +
+		strings.Repeat("5a", 32) +
+		"001cc02bc02fcca9cca8cc14cc13c00ac014c009c013009c0035002f000a0100017fff0100010000000013001100000e636c6f7564666c6172652e636f6d0017000000230120" +
+		strings.Repeat("5a", 288) +
+		"000d0016001406010603050105030401040303010303020102030005000501000000000012000075500000000b00020100000a0006000400170018"
+	assert.Equal(t, hello, hex.EncodeToString(wc.written))
+
+	// the finished is followed by the data held back
+	finish := "1403030001011603030020" +
+		strings.Repeat("5a", 22) +
+		"57b663c7640b67c090a3170303000470696e67"
+	assert.Equal(t, finish, hex.EncodeToString(append(c.clientFinish(), c.sendBuf...)))
+
+	// 2750 bytes are sent in the first record
+	wc.written = nil
+	c.handshaked = true
+	c.Write(make([]byte, 5000))
+	assert.Equal(t, "1703030abe", hex.EncodeToString(wc.written[:5]))
+	assert.Equal(t, "17030308ca", hex.EncodeToString(wc.written[5+2750:5+2750+5]))
+}
+
+func TestTLS12TicketAuth_BadServer(t *testing.T) {
+	o := newTestTLS12TicketAuth()
+	left, right := net.Pipe()
+	defer right.Close()
+	c := o.StreamConn(left)
+	go c.Write([]byte("hello"))
+	readTLSRecord(t, right)
+
+	// the finished is tampered
+	serverHello, _ := hex.DecodeString(testServerHello)
+	serverHello[len(serverHello)-20] ^= 1
+	go right.Write(serverHello)
+
+	_, err := c.Read(make([]byte, 4))
+	assert.Equal(t, errTLSHandshake, err)
+}
+
+func TestPickObfs(t *testing.T) {
+	for _, name := range []string{"plain", "http_simple", "http_post", "random_head", "tls1.2_ticket_auth", "http_simple_compatible"} {
+		_, err := PickObfs(name, &Base{Key: testKey})
+		assert.Nil(t, err, name)
+	}
+
+	_, err := PickObfs("tls1.3_ticket_auth", &Base{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,13 @@
+package obfs
+
+import "net"
+
+type plain struct{}
+
+func (plain) StreamConn(c net.Conn) net.Conn {
+	return c
+}
+
+func (plain) Overhead() int {
+	return 0
+}
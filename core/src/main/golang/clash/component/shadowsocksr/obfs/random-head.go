@@ -0,0 +1,81 @@
+package obfs
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"sync"
+)
+
+type randomHead struct{}
+
+func (randomHead) StreamConn(c net.Conn) net.Conn {
+	return &randomHeadConn{Conn: c}
+}
+
+func (randomHead) Overhead() int {
+	return 0
+}
+
+// randomHeadConn send a random head first, the data written before the server replies is held back until then
+type randomHeadConn struct {
+	net.Conn
+
+	headerSent bool
+	rawTrans   bool
+	buf        []byte
+	mux        sync.Mutex
+}
+
+func (c *randomHeadConn) Write(b []byte) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.rawTrans {
+		return c.Conn.Write(b)
+	}
+
+	c.buf = append(c.buf, b...)
+	if !c.headerSent {
+		if _, err := c.Conn.Write(randomHeadData()); err != nil {
+			return 0, err
+		}
+		c.headerSent = true
+	}
+	return len(b), nil
+}
+
+// Read discard the first reply of the server, then flush the data held back
+func (c *randomHeadConn) Read(b []byte) (int, error) {
+	c.mux.Lock()
+	rawTrans := c.rawTrans
+	c.mux.Unlock()
+	if rawTrans {
+		return c.Conn.Read(b)
+	}
+
+	buf := make([]byte, 2048)
+	if _, err := c.Conn.Read(buf); err != nil {
+		return 0, err
+	}
+
+	c.mux.Lock()
+	c.rawTrans = true
+	_, err := c.Conn.Write(c.buf)
+	c.buf = nil
+	c.mux.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// randomHeadData is 4 ~ 99 random bytes and a crc32 that make the crc32 of the whole head 0xFFFFFFFF
+func randomHeadData() []byte {
+	size := make([]byte, 1)
+	randRead(size)
+	data := make([]byte, int(size[0])%96+4, int(size[0])%96+8)
+	randRead(data)
+	return binary.LittleEndian.AppendUint32(data, 0xFFFFFFFF-crc32.ChecksumIEEE(data))
+}
@@ -0,0 +1,301 @@
+package obfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	recordTypeChangeCipherSpec = 0x14
+	recordTypeHandshake        = 0x16
+	recordTypeApplicationData  = 0x17
+)
+
+var tlsVersion = []byte{0x03, 0x03}
+
+// tls12TicketAuth mimic a tls 1.2 session resumption with a session ticket
+type tls12TicketAuth struct {
+	*Base
+
+	// clientID is the session id, shared by the connections to the server
+	clientID []byte
+	tickets  map[string][]byte
+	mux      sync.Mutex
+}
+
+func newTLS12TicketAuth(b *Base) *tls12TicketAuth {
+	clientID := make([]byte, 32)
+	randRead(clientID)
+	return &tls12TicketAuth{Base: b, clientID: clientID, tickets: map[string][]byte{}}
+}
+
+func (t *tls12TicketAuth) StreamConn(c net.Conn) net.Conn {
+	return &tls12TicketConn{Conn: c, tls12TicketAuth: t}
+}
+
+func (t *tls12TicketAuth) Overhead() int {
+	return 5
+}
+
+func (t *tls12TicketAuth) hmacSHA1(data []byte) []byte {
+	h := hmac.New(sha1.New, append(append([]byte{}, t.Key...), t.clientID...))
+	h.Write(data)
+	return h.Sum(nil)[:10]
+}
+
+// authData is the random of the hello, which is the time, 18 random bytes and the hmac of them
+func (t *tls12TicketAuth) authData() []byte {
+	data := make([]byte, 22, 32)
+	binary.BigEndian.PutUint32(data, uint32(now().Unix()))
+	randRead(data[4:])
+	return append(data, t.hmacSHA1(data)...)
+}
+
+func (t *tls12TicketAuth) ticket(host string) []byte {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if ticket, ok := t.tickets[host]; ok {
+		return ticket
+	}
+	ticket := make([]byte, (randIntn(17)+8)*16)
+	randRead(ticket)
+	t.tickets[host] = ticket
+	return ticket
+}
+
+func (t *tls12TicketAuth) clientHello() []byte {
+	host := t.Param
+	if host == "" {
+		host = t.Host
+	}
+	// no sni for ip address
+	if host != "" && host[len(host)-1] >= '0' && host[len(host)-1] <= '9' {
+		host = ""
+	}
+	hosts := strings.Split(host, ",")
+	host = strings.TrimSpace(hosts[randIntn(len(hosts))])
+
+	ext := &bytes.Buffer{}
+	ext.Write(mustDecodeHex("ff01000100"))
+	// server_name
+	ext.Write([]byte{0x00, 0x00})
+	binary.Write(ext, binary.BigEndian, uint16(len(host)+5))
+	binary.Write(ext, binary.BigEndian, uint16(len(host)+3))
+	ext.WriteByte(0x00)
+	binary.Write(ext, binary.BigEndian, uint16(len(host)))
+	ext.WriteString(host)
+	ext.Write(mustDecodeHex("00170000"))
+	// session_ticket
+	ticket := t.ticket(host)
+	ext.Write([]byte{0x00, 0x23})
+	binary.Write(ext, binary.BigEndian, uint16(len(ticket)))
+	ext.Write(ticket)
+	ext.Write(mustDecodeHex("000d001600140601060305010503040104030301030302010203"))
+	ext.Write(mustDecodeHex("000500050100000000"))
+	ext.Write(mustDecodeHex("00120000"))
+	ext.Write(mustDecodeHex("75500000"))
+	ext.Write(mustDecodeHex("000b00020100"))
+	ext.Write(mustDecodeHex("000a0006000400170018"))
+
+	hello := &bytes.Buffer{}
+	hello.Write(tlsVersion)
+	hello.Write(t.authData())
+	hello.WriteByte(0x20)
+	hello.Write(t.clientID)
+	hello.Write(mustDecodeHex("001cc02bc02fcca9cca8cc14cc13c00ac014c009c013009c0035002f000a0100"))
+	binary.Write(hello, binary.BigEndian, uint16(ext.Len()))
+	hello.Write(ext.Bytes())
+
+	handshake := &bytes.Buffer{}
+	handshake.Write([]byte{0x01, 0x00})
+	binary.Write(handshake, binary.BigEndian, uint16(hello.Len()))
+	handshake.Write(hello.Bytes())
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{recordTypeHandshake, 0x03, 0x01})
+	binary.Write(buf, binary.BigEndian, uint16(handshake.Len()))
+	buf.Write(handshake.Bytes())
+	return buf.Bytes()
+}
+
+// clientFinish is the change cipher spec and the finished message
+func (t *tls12TicketAuth) clientFinish() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(recordTypeChangeCipherSpec)
+	buf.Write(tlsVersion)
+	buf.Write([]byte{0x00, 0x01, 0x01})
+	buf.WriteByte(recordTypeHandshake)
+	buf.Write(tlsVersion)
+	buf.Write([]byte{0x00, 0x20})
+	random := make([]byte, 22)
+	randRead(random)
+	buf.Write(random)
+	buf.Write(t.hmacSHA1(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// verifyServerHello check the auth data in the server hello and the hmac at the end of the handshake
+func (t *tls12TicketAuth) verifyServerHello(data []byte) bool {
+	if len(data) < 11+32+1+32 {
+		return false
+	}
+	if !hmac.Equal(t.hmacSHA1(data[11:33]), data[33:43]) {
+		return false
+	}
+	return hmac.Equal(t.hmacSHA1(data[:len(data)-10]), data[len(data)-10:])
+}
+
+func appendRecord(buf []byte, b []byte) []byte {
+	buf = append(buf, recordTypeApplicationData)
+	buf = append(buf, tlsVersion...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(b)))
+	return append(buf, b...)
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+type tls12TicketConn struct {
+	net.Conn
+	*tls12TicketAuth
+
+	helloSent   bool
+	handshaked  bool
+	sendBuf     []byte
+	wMux        sync.Mutex
+	recvPayload []byte
+}
+
+func (c *tls12TicketConn) Write(b []byte) (int, error) {
+	c.wMux.Lock()
+	defer c.wMux.Unlock()
+
+	if c.handshaked {
+		var buf []byte
+		data := b
+		for len(data) > 2048 {
+			size := randIntn(4096) + 100
+			if size > len(data) {
+				size = len(data)
+			}
+			buf = appendRecord(buf, data[:size])
+			data = data[size:]
+		}
+		if len(data) > 0 {
+			buf = appendRecord(buf, data)
+		}
+		if _, err := c.Conn.Write(buf); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	// hold back the data until the handshake is done
+	c.sendBuf = appendRecord(c.sendBuf, b)
+	if !c.helloSent {
+		if _, err := c.Conn.Write(c.clientHello()); err != nil {
+			return 0, err
+		}
+		c.helloSent = true
+	}
+	return len(b), nil
+}
+
+func (c *tls12TicketConn) Read(b []byte) (int, error) {
+	c.wMux.Lock()
+	handshaked := c.handshaked
+	c.wMux.Unlock()
+
+	if !handshaked {
+		if err := c.handshake(); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(c.recvPayload) == 0 {
+		recordType, payload, err := c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		if recordType != recordTypeApplicationData {
+			return 0, errTLSRecord
+		}
+		c.recvPayload = payload
+	}
+
+	n := copy(b, c.recvPayload)
+	c.recvPayload = c.recvPayload[n:]
+	return n, nil
+}
+
+// handshake read the server hello, the optional session ticket, change cipher spec and finished,
+// then send the client finished and the data held back
+func (c *tls12TicketConn) handshake() error {
+	var data []byte
+	changeCipherSpec := false
+	for {
+		recordType, payload, err := c.readRecord()
+		if err != nil {
+			return err
+		}
+		data = append(data, recordType)
+		data = append(data, tlsVersion...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(payload)))
+		data = append(data, payload...)
+
+		if changeCipherSpec {
+			if recordType != recordTypeHandshake {
+				return errTLSHandshake
+			}
+			break
+		}
+
+		switch recordType {
+		case recordTypeChangeCipherSpec:
+			changeCipherSpec = true
+		case recordTypeHandshake:
+		default:
+			return errTLSHandshake
+		}
+	}
+
+	if !c.verifyServerHello(data) {
+		return errTLSHandshake
+	}
+
+	c.wMux.Lock()
+	defer c.wMux.Unlock()
+
+	buf := append(c.clientFinish(), c.sendBuf...)
+	c.sendBuf = nil
+	if _, err := c.Conn.Write(buf); err != nil {
+		return err
+	}
+	c.handshaked = true
+	return nil
+}
+
+func (c *tls12TicketConn) readRecord() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
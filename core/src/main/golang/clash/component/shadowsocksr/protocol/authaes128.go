@@ -0,0 +1,226 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/shadowsocksr/encryption"
+)
+
+const authAES128UnitLen = 8100
+
+type authAES128 struct {
+	*Base
+	data     *authData
+	hashFunc func() hash.Hash
+	salt     string
+
+	// userID and userKey are from the param "uid:password" of a multi-user server
+	userID  []byte
+	userKey []byte
+}
+
+func newAuthAES128(b *Base, sha bool) *authAES128 {
+	a := &authAES128{Base: b, data: &authData{}, hashFunc: md5.New, salt: "auth_aes128_md5"}
+	if sha {
+		a.hashFunc, a.salt = sha1.New, "auth_aes128_sha1"
+	}
+
+	a.userKey = b.Key
+	if items := strings.SplitN(b.Param, ":", 2); len(items) == 2 {
+		if uid, err := strconv.ParseUint(items[0], 10, 32); err == nil {
+			a.userID = binary.LittleEndian.AppendUint32(nil, uint32(uid))
+			h := a.hashFunc()
+			h.Write([]byte(items[1]))
+			a.userKey = h.Sum(nil)
+		}
+	}
+	return a
+}
+
+func (a *authAES128) StreamConn(c net.Conn, iv []byte) net.Conn {
+	return newConn(c, &authAES128Session{authAES128: a, iv: iv, packID: 1, recvID: 1})
+}
+
+func (a *authAES128) PacketConn(pc net.PacketConn) net.PacketConn {
+	uid := a.userID
+	if uid == nil {
+		uid = randBytes(4)
+	}
+	return newPacketConn(pc, &authAES128PacketSession{authAES128: a, uid: uid})
+}
+
+func (a *authAES128) hmac(key, data []byte) []byte {
+	h := hmac.New(a.hashFunc, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+type authAES128Session struct {
+	*authAES128
+	iv         []byte
+	headerSent bool
+	packID     uint32
+	recvID     uint32
+}
+
+func (s *authAES128Session) encode(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	if !s.headerSent {
+		n := headLen(b)
+		s.packAuthData(buf, b[:n])
+		b = b[n:]
+		s.headerSent = true
+	}
+	for len(b) > authAES128UnitLen {
+		s.packData(buf, b[:authAES128UnitLen])
+		b = b[authAES128UnitLen:]
+	}
+	s.packData(buf, b)
+	return buf.Bytes()
+}
+
+func (s *authAES128Session) rndData(size int) []byte {
+	if size > 1200 {
+		return []byte{1}
+	}
+
+	var rnd []byte
+	switch {
+	case s.packID > 4:
+		rnd = randBytes(randIntn(32))
+	case size > 900:
+		rnd = randBytes(randIntn(128))
+	default:
+		rnd = randBytes(randIntn(512))
+	}
+
+	if len(rnd) < 128 {
+		return append([]byte{byte(len(rnd) + 1)}, rnd...)
+	}
+	return append(binary.LittleEndian.AppendUint16([]byte{255}, uint16(len(rnd)+3)), rnd...)
+}
+
+func (s *authAES128Session) macKey(id uint32) []byte {
+	return binary.LittleEndian.AppendUint32(append([]byte{}, s.userKey...), id)
+}
+
+// packData is Length HMAC(Length)[:2] RandomData Data HMAC[:4], the mac key is the user key and the pack id
+func (s *authAES128Session) packData(buf *bytes.Buffer, b []byte) {
+	rnd := s.rndData(len(b))
+	macKey := s.macKey(s.packID)
+
+	data := make([]byte, 0, len(rnd)+len(b)+8)
+	data = binary.LittleEndian.AppendUint16(data, uint16(2+2+len(rnd)+len(b)+4))
+	data = append(data, s.hmac(macKey, data[:2])[:2]...)
+	data = append(data, rnd...)
+	data = append(data, b...)
+	data = append(data, s.hmac(macKey, data)[:4]...)
+	buf.Write(data)
+	s.packID++
+}
+
+// packAuthData is CheckHead UID AES(UTC ClientID ConnectionID Length RandomLength) HMAC[:4] RandomData Data HMAC[:4]
+func (s *authAES128Session) packAuthData(buf *bytes.Buffer, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	rndLen := randIntn(1024)
+	if len(b) > 400 {
+		rndLen = randIntn(512)
+	}
+
+	macKey := append(append([]byte{}, s.iv...), s.Key...)
+
+	header := s.data.next()
+	header = binary.LittleEndian.AppendUint16(header, uint16(7+4+16+4+len(b)+rndLen+4))
+	header = binary.LittleEndian.AppendUint16(header, uint16(rndLen))
+	block, _ := aes.NewCipher(encryption.Kdf(base64.StdEncoding.EncodeToString(s.userKey)+s.salt, 16))
+	block.Encrypt(header, header)
+
+	uid := s.userID
+	if uid == nil {
+		uid = randBytes(4)
+	}
+
+	checkHead := randBytes(1)
+	checkHead = append(checkHead, s.hmac(macKey, checkHead)[:6]...)
+
+	data := make([]byte, 0, 7+4+16+4+len(b)+rndLen+4)
+	data = append(data, checkHead...)
+	data = append(data, uid...)
+	data = append(data, header...)
+	data = append(data, s.hmac(macKey, data[7:])[:4]...)
+	data = append(data, randBytes(rndLen)...)
+	data = append(data, b...)
+	data = append(data, s.hmac(s.userKey, data)[:4]...)
+	buf.Write(data)
+}
+
+func (s *authAES128Session) decode(buf []byte) ([]byte, []byte, error) {
+	var payload []byte
+	for len(buf) > 4 {
+		macKey := s.macKey(s.recvID)
+		if !hmac.Equal(s.hmac(macKey, buf[:2])[:2], buf[2:4]) {
+			return nil, nil, errAuthChecksum
+		}
+		length := int(binary.LittleEndian.Uint16(buf))
+		if length >= 8192 || length < 7 {
+			return nil, nil, errAuthDataError
+		}
+		if length > len(buf) {
+			break
+		}
+		if !hmac.Equal(s.hmac(macKey, buf[:length-4])[:4], buf[length-4:length]) {
+			return nil, nil, errAuthChecksum
+		}
+		s.recvID++
+
+		pos := int(buf[4])
+		if pos < 255 {
+			pos += 4
+		} else {
+			pos = int(binary.LittleEndian.Uint16(buf[5:7])) + 4
+		}
+		if pos > length-4 {
+			return nil, nil, errAuthDataError
+		}
+		payload = append(payload, buf[pos:length-4]...)
+		buf = buf[length:]
+	}
+	return payload, buf, nil
+}
+
+type authAES128PacketSession struct {
+	*authAES128
+	uid []byte
+}
+
+// encodePacket is Data UID HMAC[:4], the mac key is the user key
+func (s *authAES128PacketSession) encodePacket(b []byte) []byte {
+	data := make([]byte, 0, len(b)+8)
+	data = append(data, b...)
+	data = append(data, s.uid...)
+	return append(data, s.hmac(s.userKey, data)[:4]...)
+}
+
+// decodePacket verify Data HMAC[:4], the server mac with the key of the server
+func (s *authAES128PacketSession) decodePacket(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errAuthDataError
+	}
+	if !hmac.Equal(s.hmac(s.Key, b[:len(b)-4])[:4], b[len(b)-4:]) {
+		return nil, errAuthChecksum
+	}
+	return b[:len(b)-4], nil
+}
@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/shadowsocksr/encryption"
+)
+
+const (
+	authChainAUnitLen  = 2800
+	authChainAOverhead = 4
+)
+
+// xorShift128Plus is the random generator which decide the length and position of the random data
+type xorShift128Plus struct {
+	s [2]uint64
+}
+
+func (r *xorShift128Plus) next() uint64 {
+	x := r.s[0]
+	y := r.s[1]
+	r.s[0] = y
+	x ^= x << 23
+	x ^= y ^ (x >> 17) ^ (y >> 26)
+	r.s[1] = x
+	return x + y
+}
+
+func (r *xorShift128Plus) initFromBin(b []byte) {
+	full := make([]byte, 16)
+	copy(full, b)
+	r.s[0] = binary.LittleEndian.Uint64(full[0:8])
+	r.s[1] = binary.LittleEndian.Uint64(full[8:16])
+}
+
+func (r *xorShift128Plus) initFromBinLen(b []byte, length int) {
+	full := make([]byte, 16)
+	copy(full, b)
+	binary.LittleEndian.PutUint16(full, uint16(length))
+	r.s[0] = binary.LittleEndian.Uint64(full[0:8])
+	r.s[1] = binary.LittleEndian.Uint64(full[8:16])
+	for i := 0; i < 4; i++ {
+		r.next()
+	}
+}
+
+type authChainA struct {
+	*Base
+	data *authData
+
+	// userID and userKey are from the param "uid:password" of a multi-user server
+	userID  []byte
+	userKey []byte
+}
+
+func newAuthChainA(b *Base) *authChainA {
+	a := &authChainA{Base: b, data: &authData{}, userKey: b.Key}
+	if items := strings.SplitN(b.Param, ":", 2); len(items) == 2 {
+		if uid, err := strconv.ParseUint(items[0], 10, 32); err == nil {
+			a.userID = binary.LittleEndian.AppendUint32(nil, uint32(uid))
+			a.userKey = []byte(items[1])
+		}
+	}
+	return a
+}
+
+func (a *authChainA) StreamConn(c net.Conn, iv []byte) net.Conn {
+	return newConn(c, &authChainASession{authChainA: a, iv: iv, packID: 1, recvID: 1})
+}
+
+func (a *authChainA) PacketConn(pc net.PacketConn) net.PacketConn {
+	uid := a.userID
+	if uid == nil {
+		uid = randBytes(4)
+	}
+	return newPacketConn(pc, &authChainAPacketSession{authChainA: a, uid: uid})
+}
+
+type authChainASession struct {
+	*authChainA
+	iv         []byte
+	headerSent bool
+	packID     uint32
+	recvID     uint32
+
+	lastClientHash []byte
+	lastServerHash []byte
+	randomClient   xorShift128Plus
+	randomServer   xorShift128Plus
+	encrypter      *rc4.Cipher
+	decrypter      *rc4.Cipher
+}
+
+func hmacMD5(key, data []byte) []byte {
+	h := hmac.New(md5.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func (s *authChainASession) encode(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	if !s.headerSent {
+		n := headLen(b)
+		s.packAuthData(buf, b[:n])
+		b = b[n:]
+		s.headerSent = true
+	}
+	for len(b) > authChainAUnitLen {
+		s.packData(buf, b[:authChainAUnitLen])
+		b = b[authChainAUnitLen:]
+	}
+	s.packData(buf, b)
+	return buf.Bytes()
+}
+
+func rndDataLen(size int, lastHash []byte, random *xorShift128Plus) int {
+	if size > 1440 {
+		return 0
+	}
+	random.initFromBinLen(lastHash, size)
+	switch {
+	case size > 1300:
+		return int(random.next() % 31)
+	case size > 900:
+		return int(random.next() % 127)
+	case size > 400:
+		return int(random.next() % 521)
+	}
+	return int(random.next() % 1021)
+}
+
+func rndStartPos(rndLen int, random *xorShift128Plus) int {
+	if rndLen > 0 {
+		return int(random.next() % 8589934609 % uint64(rndLen))
+	}
+	return 0
+}
+
+// packData is Length^LastHash[14:16] RandomData[:pos] RC4(Data) RandomData[pos:] HMAC[:2]
+func (s *authChainASession) packData(buf *bytes.Buffer, b []byte) {
+	encrypted := make([]byte, len(b))
+	s.encrypter.XORKeyStream(encrypted, b)
+
+	rndLen := rndDataLen(len(b), s.lastClientHash, &s.randomClient)
+	rnd := randBytes(rndLen)
+
+	data := make([]byte, 0, 2+len(b)+rndLen+2)
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(b))^binary.LittleEndian.Uint16(s.lastClientHash[14:16]))
+	if len(b) == 0 {
+		data = append(data, rnd...)
+	} else {
+		pos := rndStartPos(rndLen, &s.randomClient)
+		data = append(data, rnd[:pos]...)
+		data = append(data, encrypted...)
+		data = append(data, rnd[pos:]...)
+	}
+
+	s.lastClientHash = hmacMD5(binary.LittleEndian.AppendUint32(append([]byte{}, s.userKey...), s.packID), data)
+	data = append(data, s.lastClientHash[:2]...)
+	buf.Write(data)
+	s.packID++
+}
+
+// packAuthData is CheckHead UID^LastClientHash[8:12] AES(UTC ClientID ConnectionID Overhead 0) HMAC[:4], followed by the first packet
+func (s *authChainASession) packAuthData(buf *bytes.Buffer, b []byte) {
+	macKey := append(append([]byte{}, s.iv...), s.Key...)
+
+	checkHead := randBytes(4)
+	s.lastClientHash = hmacMD5(macKey, checkHead)
+	checkHead = append(checkHead, s.lastClientHash[:8]...)
+
+	uid := s.userID
+	if uid == nil {
+		uid = randBytes(4)
+	}
+	uid = binary.LittleEndian.AppendUint32(nil, binary.LittleEndian.Uint32(uid)^binary.LittleEndian.Uint32(s.lastClientHash[8:12]))
+
+	header := s.data.next()
+	header = binary.LittleEndian.AppendUint16(header, uint16(s.Overhead+authChainAOverhead))
+	header = binary.LittleEndian.AppendUint16(header, 0)
+	userKey := base64.StdEncoding.EncodeToString(s.userKey)
+	block, _ := aes.NewCipher(encryption.Kdf(userKey+"auth_chain_a", 16))
+	block.Encrypt(header, header)
+
+	data := append(uid, header...)
+	s.lastServerHash = hmacMD5(s.userKey, data)
+
+	buf.Write(checkHead)
+	buf.Write(data)
+	buf.Write(s.lastServerHash[:4])
+
+	rc4Key := encryption.Kdf(userKey+base64.StdEncoding.EncodeToString(s.lastClientHash), 16)
+	s.encrypter, _ = rc4.NewCipher(rc4Key)
+	s.decrypter, _ = rc4.NewCipher(rc4Key)
+
+	s.packData(buf, b)
+}
+
+func (s *authChainASession) decode(buf []byte) ([]byte, []byte, error) {
+	var payload []byte
+	for len(buf) > 4 {
+		dataLen := int(binary.LittleEndian.Uint16(buf) ^ binary.LittleEndian.Uint16(s.lastServerHash[14:16]))
+		rndLen := rndDataLen(dataLen, s.lastServerHash, &s.randomServer)
+		length := dataLen + rndLen
+		if length >= 4096 {
+			return nil, nil, errAuthDataError
+		}
+		if length+4 > len(buf) {
+			break
+		}
+
+		serverHash := hmacMD5(binary.LittleEndian.AppendUint32(append([]byte{}, s.userKey...), s.recvID), buf[:length+2])
+		if !hmac.Equal(serverHash[:2], buf[length+2:length+4]) {
+			return nil, nil, errAuthChecksum
+		}
+
+		pos := 2
+		if dataLen > 0 && rndLen > 0 {
+			pos += rndStartPos(rndLen, &s.randomServer)
+		}
+		data := make([]byte, dataLen)
+		s.decrypter.XORKeyStream(data, buf[pos:pos+dataLen])
+		s.lastServerHash = serverHash
+
+		// the first packet is led by the tcp mss of the server
+		if s.recvID == 1 {
+			if len(data) < 2 {
+				return nil, nil, errAuthDataError
+			}
+			data = data[2:]
+		}
+		s.recvID++
+		payload = append(payload, data...)
+		buf = buf[length+4:]
+	}
+	return payload, buf, nil
+}
+
+type authChainAPacketSession struct {
+	*authChainA
+	uid []byte
+}
+
+func udpRndDataLen(lastHash []byte, random *xorShift128Plus) int {
+	random.initFromBin(lastHash)
+	return int(random.next() % 127)
+}
+
+func (s *authChainAPacketSession) rc4(lastHash []byte) *rc4.Cipher {
+	key := base64.StdEncoding.EncodeToString(s.userKey) + base64.StdEncoding.EncodeToString(lastHash)
+	c, _ := rc4.NewCipher(encryption.Kdf(key, 16))
+	return c
+}
+
+// encodePacket is RC4(Data) RandomData AuthData UID^HMAC[:4] HMAC[:1], the length of
+// the random data and the rc4 key is from the hmac of the auth data with the key of the server
+func (s *authChainAPacketSession) encodePacket(b []byte) []byte {
+	authData := randBytes(3)
+	md5Data := hmacMD5(s.Key, authData)
+
+	var random xorShift128Plus
+	rndLen := udpRndDataLen(md5Data, &random)
+
+	data := make([]byte, len(b), len(b)+rndLen+8)
+	s.rc4(md5Data).XORKeyStream(data, b)
+	data = append(data, randBytes(rndLen)...)
+	data = append(data, authData...)
+	data = binary.LittleEndian.AppendUint32(data, binary.LittleEndian.Uint32(s.uid)^binary.LittleEndian.Uint32(md5Data))
+	return append(data, hmacMD5(s.userKey, data)[:1]...)
+}
+
+// decodePacket unpack RC4(Data) RandomData AuthData HMAC[:1], the auth data of the server is 7 bytes
+func (s *authChainAPacketSession) decodePacket(b []byte) ([]byte, error) {
+	if len(b) <= 8 {
+		return nil, errAuthDataError
+	}
+	if !hmac.Equal(hmacMD5(s.userKey, b[:len(b)-1])[:1], b[len(b)-1:]) {
+		return nil, errAuthChecksum
+	}
+	md5Data := hmacMD5(s.Key, b[len(b)-8:len(b)-1])
+
+	var random xorShift128Plus
+	rndLen := udpRndDataLen(md5Data, &random)
+	if len(b) < 8+rndLen {
+		return nil, errAuthDataError
+	}
+
+	data := make([]byte, len(b)-8-rndLen)
+	s.rc4(md5Data).XORKeyStream(data, b[:len(data)])
+	return data, nil
+}
@@ -1,200 +1,148 @@
 package protocol
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/binary"
 	"hash/adler32"
 	"hash/crc32"
-	"math/rand"
 	"net"
-	"sync"
-	"time"
-
-	"github.com/Dreamacro/clash/component/shadowsocksr"
 )
 
-type AuthSHA1v4 struct {
-	net.Conn
-	authenticated bool
-	clientInfo    *ClientInfo
+const authSHA1v4UnitLen = 8100
 
-	iv  []byte
-	key []byte
+type authSHA1v4 struct {
+	*Base
+	data *authData
 }
 
-type ClientInfo struct {
-	ClientID []byte
-	ConnID   uint32
-	Mux      sync.Mutex
+func newAuthSHA1v4(b *Base) *authSHA1v4 {
+	return &authSHA1v4{Base: b, data: &authData{}}
 }
 
-func NewAuthSHA1v4Protocol(c net.Conn, param string) (net.Conn, error) {
-	return &AuthSHA1v4{
-		Conn:          c,
-		authenticated: false,
-	}, nil
+func (a *authSHA1v4) StreamConn(c net.Conn, iv []byte) net.Conn {
+	return newConn(c, &authSHA1v4Session{authSHA1v4: a, iv: iv})
 }
 
-func (c *AuthSHA1v4) Read(b []byte) (int, error) {
-	return 0, nil
+// PacketConn is a passthrough, auth_sha1_v4 doesn't pack the udp packets
+func (a *authSHA1v4) PacketConn(pc net.PacketConn) net.PacketConn {
+	return pc
 }
 
-func (c *AuthSHA1v4) Write(b []byte) (int, error) {
-	dataLength := len(b)
-	offset := 0
-
-	var outData []byte
-
-	if !c.authenticated && dataLength > 0 {
-		authLength := dataLength
-		if headSize := shadowsocksr.GetPacketTCPHeaderSize(b, 30); headSize <= dataLength {
-			authLength = headSize
-		}
-
-		packedData := c.packWithAuth(b[:authLength])
-		c.authenticated = true
-		outData = append(outData, packedData...)
-		dataLength -= authLength
-		offset += authLength
-	}
+type authSHA1v4Session struct {
+	*authSHA1v4
+	iv         []byte
+	headerSent bool
+}
 
-	const blockSize = 4096
-	for dataLength > blockSize {
-		packedData := c.pack(b[offset : offset+blockSize])
-		outData = append(outData, packedData...)
-		dataLength -= blockSize
-		offset += blockSize
+func (s *authSHA1v4Session) encode(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	if !s.headerSent {
+		n := headLen(b)
+		s.packAuthData(buf, b[:n])
+		b = b[n:]
+		s.headerSent = true
 	}
-
-	if dataLength > 0 {
-		packedData := c.pack(b[offset:])
-		outData = append(outData, packedData...)
+	for len(b) > authSHA1v4UnitLen {
+		s.packData(buf, b[:authSHA1v4UnitLen])
+		b = b[authSHA1v4UnitLen:]
 	}
-
-	return c.Conn.Write(outData)
+	s.packData(buf, b)
+	return buf.Bytes()
 }
 
-func (c *AuthSHA1v4) packWithAuth(b []byte) []byte {
-	dataLength := len(b)
-	randLength := c.getRandomDataLength(b)
-	ObfsHMACSHA1Len := 10
-
-	dataOffset := randLength + 4 + 2
-	outLength := dataOffset + dataLength + 12 + ObfsHMACSHA1Len
-	outData := make([]byte, outLength)
-
-	c.clientInfo.Mux.Lock()
-	c.clientInfo.ConnID++
-	if c.clientInfo.ConnID > 0xFF000000 {
-		c.clientInfo.ClientID = nil
-	}
-	if len(c.clientInfo.ClientID) == 0 {
-		c.clientInfo.ClientID = make([]byte, 8)
-		rand.Read(c.clientInfo.ClientID)
-		c.clientInfo.ConnID = rand.Uint32() & 0xFFFFF
+// rndData is the random padding, led by its length including the length field
+func (s *authSHA1v4Session) rndData(size int) []byte {
+	if size > 1200 {
+		return []byte{1}
 	}
-	var clientID []byte
-	copy(clientID, c.clientInfo.ClientID)
-	connID := c.clientInfo.ConnID
-	c.clientInfo.Mux.Unlock()
-
-	// 0-1, out length
-	binary.BigEndian.PutUint16(outData[0:], uint16(outLength&0xFFFF))
-
-	// 2~6, crc of out length+salt+key
-	salt := []byte("auth_sha1_v4")
-	crcData := make([]byte, len(salt)+len(c.key)+2)
-	copy(crcData[0:2], outData[0:2])
-	copy(crcData[2:], salt)
-	copy(crcData[2+len(salt):], c.key)
-	crc32 := crc32.ChecksumIEEE(crcData)
-	binary.LittleEndian.PutUint32(outData[2:], crc32)
-
-	// 6~rand length+6, rand numbers
-	rand.Read(outData[6:dataOffset])
-
-	// 6, rand length
-	if randLength < 128 {
-		outData[6] = byte(randLength & 0xFF)
+
+	var rnd []byte
+	if size > 400 {
+		rnd = randBytes(randIntn(256))
 	} else {
-		// 6, magic number 0xFF
-		outData[6] = 0xFF
-		// 7-8, rand length
-		binary.BigEndian.PutUint16(outData[7:], uint16(randLength&0xFFFF))
+		rnd = randBytes(randIntn(512))
 	}
 
-	// rand length+6~rand length+10, time stamp
-	now := time.Now().Unix()
-	binary.LittleEndian.PutUint32(outData[dataOffset:dataOffset+4], uint32(now))
-
-	// rand length+10~rand length+14, client ID
-	copy(outData[dataOffset+4:dataOffset+8], clientID[0:4])
-
-	// rand length+14~rand length+18, connection ID
-	binary.LittleEndian.PutUint32(outData[dataOffset+8:dataOffset+12], connID)
-
-	// rand length+18~rand length+18+data length, data
-	copy(outData[dataOffset+12:], b)
-
-	key := make([]byte, len(c.iv)+len(c.key))
-	copy(key, c.iv)
-	copy(key[len(c.iv):], c.key)
-	hmacSHA1 := hmac.New(sha1.New, key)
-	hmacSHA1.Write(outData[:outLength-ObfsHMACSHA1Len])
-	h := hmacSHA1.Sum(nil)[:10]
-
-	// out length-10~out length/rand length+18+data length~end, hmac
-	copy(outData[outLength-ObfsHMACSHA1Len:], h[0:ObfsHMACSHA1Len])
-
-	return outData
+	if len(rnd) < 128 {
+		return append([]byte{byte(len(rnd) + 1)}, rnd...)
+	}
+	return append(binary.BigEndian.AppendUint16([]byte{255}, uint16(len(rnd)+3)), rnd...)
 }
 
-func (c *AuthSHA1v4) pack(b []byte) []byte {
-	dataLength := len(b)
-	randLength := c.getRandomDataLength(b)
-
-	outLength := randLength + dataLength + 8
-	outData := make([]byte, outLength)
-
-	// 0~1, out length
-	binary.BigEndian.PutUint16(outData[0:2], uint16(outLength&0xFFFF))
-
-	// 2~3, crc of out length
-	crc32 := crc32.ChecksumIEEE(b)
-	binary.LittleEndian.PutUint16(outData[2:4], uint16(crc32&0xFFFF))
-
-	// 4~rand length+4, rand number
-	rand.Read(outData[4 : 4+randLength])
-
-	// 4, rand length
-	if randLength < 128 {
-		outData[4] = byte(randLength & 0xFF)
-	} else {
-		// 4, magic number 0xFF
-		outData[4] = 0xFF
-		// 5~6, rand length
-		binary.BigEndian.PutUint16(outData[5:], uint16(randLength&0xFFFF))
-	}
+// packData is Length(BE) CRC16(Length) RandomData Data Adler32
+func (s *authSHA1v4Session) packData(buf *bytes.Buffer, b []byte) {
+	rnd := s.rndData(len(b))
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(2+2+len(rnd)+len(b)+4))
+
+	data := make([]byte, 0, len(rnd)+len(b)+8)
+	data = append(data, length...)
+	data = binary.LittleEndian.AppendUint16(data, uint16(crc32.ChecksumIEEE(length)))
+	data = append(data, rnd...)
+	data = append(data, b...)
+	data = binary.LittleEndian.AppendUint32(data, adler32.Checksum(data))
+	buf.Write(data)
+}
 
-	// rand length+4~out length-4, data
-	if dataLength > 0 {
-		copy(outData[randLength+4:], b)
+// packAuthData is Length(BE) CRC32(Length Salt Key) RandomData UTC ClientID ConnectionID Data HMAC-SHA1(IV Key)
+func (s *authSHA1v4Session) packAuthData(buf *bytes.Buffer, b []byte) {
+	if len(b) == 0 {
+		return
 	}
 
-	// out length-4~end, adler32 of full data
-	adler := adler32.Checksum(outData[:outLength-4])
-	binary.LittleEndian.PutUint32(outData[outLength-4:], adler)
-
-	return outData
+	rnd := s.rndData(len(b))
+	auth := s.data.next()
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(2+4+len(rnd)+len(auth)+len(b)+10))
+
+	crc := crc32.NewIEEE()
+	crc.Write(length)
+	crc.Write([]byte("auth_sha1_v4"))
+	crc.Write(s.Key)
+
+	data := make([]byte, 0, len(rnd)+len(b)+28)
+	data = append(data, length...)
+	data = binary.LittleEndian.AppendUint32(data, crc.Sum32())
+	data = append(data, rnd...)
+	data = append(data, auth...)
+	data = append(data, b...)
+
+	h := hmac.New(sha1.New, append(append([]byte{}, s.iv...), s.Key...))
+	h.Write(data)
+	buf.Write(data)
+	buf.Write(h.Sum(nil)[:10])
 }
 
-func (c *AuthSHA1v4) getRandomDataLength(b []byte) int {
-	if len(b) <= 400 {
-		return rand.Intn(1024) + 1
-	} else if len(b) <= 1300 {
-		return rand.Intn(128) + 1
-	} else {
-		return 1
+func (s *authSHA1v4Session) decode(buf []byte) ([]byte, []byte, error) {
+	var payload []byte
+	for len(buf) > 4 {
+		if uint16(crc32.ChecksumIEEE(buf[:2])) != binary.LittleEndian.Uint16(buf[2:4]) {
+			return nil, nil, errAuthChecksum
+		}
+		length := int(binary.BigEndian.Uint16(buf))
+		if length >= 8192 || length < 7 {
+			return nil, nil, errAuthDataError
+		}
+		if length > len(buf) {
+			break
+		}
+		if adler32.Checksum(buf[:length-4]) != binary.LittleEndian.Uint32(buf[length-4:]) {
+			return nil, nil, errAuthChecksum
+		}
+
+		pos := int(buf[4])
+		if pos < 255 {
+			pos += 4
+		} else {
+			pos = int(binary.BigEndian.Uint16(buf[5:7])) + 4
+		}
+		if pos > length-4 {
+			return nil, nil, errAuthDataError
+		}
+		payload = append(payload, buf[pos:length-4]...)
+		buf = buf[length:]
 	}
+	return payload, buf, nil
 }
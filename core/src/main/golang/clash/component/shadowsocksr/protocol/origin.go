@@ -0,0 +1,13 @@
+package protocol
+
+import "net"
+
+type origin struct{}
+
+func (origin) StreamConn(c net.Conn, iv []byte) net.Conn {
+	return c
+}
+
+func (origin) PacketConn(pc net.PacketConn) net.PacketConn {
+	return pc
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mRand "math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/component/shadowsocksr"
+)
+
+var (
+	errAuthDataError = errors.New("protocol data error")
+	errAuthChecksum  = errors.New("protocol data incorrect checksum")
+)
+
+// the sources of the randomness and the time, the tests fix them to check the packets byte by byte
+var (
+	randRead = rand.Read
+	randIntn = mRand.Intn
+	now      = time.Now
+)
+
+// Base is the information of the server shared by the protocol plugins
+type Base struct {
+	Key   []byte
+	Param string
+	// Overhead is the overhead of the obfs
+	Overhead int
+}
+
+// Protocol packs the plaintext before the stream cipher, it is the innermost layer of a ssr connection
+type Protocol interface {
+	// StreamConn wrap the conn of the stream cipher, iv is the iv of the encrypting stream
+	StreamConn(c net.Conn, iv []byte) net.Conn
+	// PacketConn wrap the packet conn of the stream cipher for the udp relay
+	PacketConn(pc net.PacketConn) net.PacketConn
+}
+
+// PickProtocol return the protocol plugin of name
+func PickProtocol(name string, b *Base) (Protocol, error) {
+	switch strings.TrimSuffix(strings.ToLower(name), "_compatible") {
+	case "", "origin":
+		return &origin{}, nil
+	case "auth_sha1_v4":
+		return newAuthSHA1v4(b), nil
+	case "auth_aes128_md5":
+		return newAuthAES128(b, false), nil
+	case "auth_aes128_sha1":
+		return newAuthAES128(b, true), nil
+	case "auth_chain_a":
+		return newAuthChainA(b), nil
+	}
+	return nil, fmt.Errorf("protocol %s not supported", name)
+}
+
+// authData is the client id and connection id shared by the connections to the server
+type authData struct {
+	clientID     []byte
+	connectionID uint32
+	mux          sync.Mutex
+}
+
+// next return the time, client id and the next connection id
+func (a *authData) next() []byte {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.connectionID > 0xFF000000 {
+		a.clientID = nil
+	}
+	if a.clientID == nil {
+		a.clientID = randBytes(4)
+		id := randBytes(4)
+		a.connectionID = binary.LittleEndian.Uint32(id) & 0xFFFFFF
+	}
+	a.connectionID++
+
+	data := make([]byte, 0, 12)
+	data = binary.LittleEndian.AppendUint32(data, uint32(now().Unix()))
+	data = append(data, a.clientID...)
+	return binary.LittleEndian.AppendUint32(data, a.connectionID)
+}
+
+// session is the state of a protocol in a connection
+type session interface {
+	// encode pack b to be sent
+	encode(b []byte) []byte
+	// decode unpack the complete packets in buf, return the payload and the bytes left
+	decode(buf []byte) (payload []byte, remain []byte, err error)
+}
+
+type conn struct {
+	net.Conn
+	session session
+
+	wMux    sync.Mutex
+	buf     []byte
+	recv    []byte
+	payload []byte
+}
+
+func newConn(c net.Conn, s session) *conn {
+	return &conn{Conn: c, session: s, buf: make([]byte, 4096)}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.wMux.Lock()
+	defer c.wMux.Unlock()
+
+	if _, err := c.Conn.Write(c.session.encode(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	for len(c.payload) == 0 {
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		c.recv = append(c.recv, c.buf[:n]...)
+
+		c.payload, c.recv, err = c.session.decode(c.recv)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+// packetSession is the state of a protocol in a udp relay
+type packetSession interface {
+	// encodePacket pack the packet b to be sent
+	encodePacket(b []byte) []byte
+	// decodePacket unpack the received packet b
+	decodePacket(b []byte) ([]byte, error)
+}
+
+type packetConn struct {
+	net.PacketConn
+	session packetSession
+}
+
+func newPacketConn(pc net.PacketConn, s packetSession) *packetConn {
+	return &packetConn{PacketConn: pc, session: s}
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if _, err := pc.PacketConn.WriteTo(pc.session.encodePacket(b), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := pool.BufPool.Get().([]byte)
+	defer pool.BufPool.Put(buf[:cap(buf)])
+	for {
+		n, addr, err := pc.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// the packets failed to be verified are dropped like the server does
+		payload, err := pc.session.decodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		return copy(b, payload), addr, nil
+	}
+}
+
+// headLen return the length of the data packed with the auth header in the first write
+func headLen(b []byte) int {
+	size := shadowsocksr.GetPacketTCPHeaderSize(b, 30) + randIntn(32)
+	if size > len(b) {
+		return len(b)
+	}
+	return size
+}
+
+func randBytes(n int) []byte {
+	b := make([]byte, n)
+	randRead(b)
+	return b
+}
@@ -0,0 +1,480 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	mRand "math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/shadowsocksr/encryption"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKey = encryption.Kdf("password", 16)
+	testIV  = []byte("0123456789abcdef")
+	// the first write of a connection, a socks address followed by the payload
+	testRequest = append([]byte{1, 1, 1, 1, 1, 0, 80}, []byte("GET / HTTP/1.1\r\nHost: one.one.one.one\r\n\r\n")...)
+)
+
+func TestKdf(t *testing.T) {
+	assert.Equal(t, "5f4dcc3b5aa765d61d8327deb882cf99", hex.EncodeToString(testKey))
+}
+
+func TestXorShift128Plus(t *testing.T) {
+	r := &xorShift128Plus{}
+	r.initFromBinLen([]byte("0123456789abcdef"), 100)
+	assert.Equal(t, uint64(8485754629422504333), r.next())
+	assert.Equal(t, uint64(10556380749914241350), r.next())
+	assert.Equal(t, uint64(14743939536613142812), r.next())
+}
+
+// fixRandom make the packets the same as the ones of the python ssr run with os.urandom returning
+// 0x5a bytes, random.randint and random.choice taking 0x5a5a % n and time.time() returning 1700000000
+func fixRandom(t *testing.T) {
+	randRead = func(b []byte) (int, error) {
+		for i := range b {
+			b[i] = 0x5a
+		}
+		return len(b), nil
+	}
+	randIntn = func(n int) int { return 0x5a5a % n }
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	t.Cleanup(func() { randRead, randIntn, now = rand.Read, mRand.Intn, time.Now })
+}
+
+func mustDecodeHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func readFrom(t *testing.T, c net.Conn, n int, packet []byte) []byte {
+	left, right := net.Pipe()
+	defer left.Close()
+	go func() {
+		// the packet arrives in pieces
+		right.Write(packet[:len(packet)/2])
+		right.Write(packet[len(packet)/2:])
+		right.Close()
+	}()
+
+	if cc, ok := c.(*conn); ok {
+		cc.Conn = left
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	return buf
+}
+
+func TestAuthSHA1v4_Decode(t *testing.T) {
+	// server packet of "pong" with 2 bytes padding, packed like the python server
+	packet := mustDecodeHex("000f6e0f03aabb706f6e67a903ff10")
+	c := newAuthSHA1v4(&Base{Key: testKey}).StreamConn(nil, testIV)
+	assert.Equal(t, []byte("pongpong"), readFrom(t, c, 8, append(packet, packet...)))
+
+	packet[len(packet)-1] ^= 1
+	_, _, err := (&authSHA1v4Session{}).decode(packet)
+	assert.Equal(t, errAuthChecksum, err)
+}
+
+// decodeAuthSHA1v4 is the server side of auth_sha1_v4, return the payload and the client id and connection id
+func decodeAuthSHA1v4(t *testing.T, b []byte) ([]byte, []byte, uint32) {
+	length := int(binary.BigEndian.Uint16(b))
+	crc := crc32.NewIEEE()
+	crc.Write(b[:2])
+	crc.Write([]byte("auth_sha1_v4"))
+	crc.Write(testKey)
+	assert.Equal(t, crc.Sum32(), binary.LittleEndian.Uint32(b[2:6]))
+
+	h := hmac.New(sha1.New, append(append([]byte{}, testIV...), testKey...))
+	h.Write(b[:length-10])
+	assert.Equal(t, h.Sum(nil)[:10], b[length-10:length])
+
+	pos := int(b[6])
+	if pos < 255 {
+		pos += 6
+	} else {
+		pos = int(binary.BigEndian.Uint16(b[7:9])) + 6
+	}
+	utc := int64(binary.LittleEndian.Uint32(b[pos:]))
+	assert.InDelta(t, time.Now().Unix(), utc, 1)
+	clientID := b[pos+4 : pos+8]
+	connectionID := binary.LittleEndian.Uint32(b[pos+8:])
+	payload := append([]byte{}, b[pos+12:length-10]...)
+
+	rest, remain, err := (&authSHA1v4Session{}).decode(b[length:])
+	assert.Nil(t, err)
+	assert.Empty(t, remain)
+	return append(payload, rest...), clientID, connectionID
+}
+
+func TestAuthSHA1v4_Encode(t *testing.T) {
+	a := newAuthSHA1v4(&Base{Key: testKey})
+
+	s1 := &authSHA1v4Session{authSHA1v4: a, iv: testIV}
+	payload, clientID, connectionID := decodeAuthSHA1v4(t, s1.encode(testRequest))
+	assert.Equal(t, testRequest, payload)
+
+	// the following writes are plain packets
+	rest, _, err := s1.decode(s1.encode(make([]byte, authSHA1v4UnitLen+100)))
+	assert.Nil(t, err)
+	assert.Len(t, rest, authSHA1v4UnitLen+100)
+
+	// connections share the client id
+	s2 := &authSHA1v4Session{authSHA1v4: a, iv: testIV}
+	_, clientID2, connectionID2 := decodeAuthSHA1v4(t, s2.encode(testRequest))
+	assert.Equal(t, clientID, clientID2)
+	assert.Equal(t, connectionID+1, connectionID2)
+}
+
+func TestAuthAES128_Decode(t *testing.T) {
+	// server packets of "pong" and "ping", the second one with a 200 bytes padding
+	packets := map[string]string{
+		"md5":  "0f0074c103aabb706f6e67bed6fe14d700b50bffcb00" + strings.Repeat("11", 200) + "70696e67f2776e55",
+		"sha1": "0f00d8bd03aabb706f6e670fc7205dd7007daeffcb00" + strings.Repeat("11", 200) + "70696e670ad6a9c4",
+	}
+
+	for name, packet := range packets {
+		a := newAuthAES128(&Base{Key: testKey}, name == "sha1")
+		c := a.StreamConn(nil, testIV)
+		assert.Equal(t, []byte("pongping"), readFrom(t, c, 8, mustDecodeHex(packet)), name)
+
+		// the mac key of the first packet is bound to the recv id
+		s := &authAES128Session{authAES128: a, recvID: 2}
+		_, _, err := s.decode(mustDecodeHex(packet))
+		assert.Equal(t, errAuthChecksum, err, name)
+	}
+}
+
+// decodeAuthAES128 is the server side of auth_aes128_*, return the payload, uid and connection id
+func decodeAuthAES128(t *testing.T, a *authAES128, b []byte) ([]byte, []byte, uint32) {
+	mac := func(key, data []byte) []byte {
+		h := hmac.New(a.hashFunc, key)
+		h.Write(data)
+		return h.Sum(nil)
+	}
+	macKey := append(append([]byte{}, testIV...), testKey...)
+
+	assert.Equal(t, mac(macKey, b[:1])[:6], b[1:7])
+	uid := b[7:11]
+	assert.Equal(t, mac(macKey, b[7:27])[:4], b[27:31])
+
+	header := make([]byte, 16)
+	block, _ := aes.NewCipher(encryption.Kdf(base64.StdEncoding.EncodeToString(a.userKey)+a.salt, 16))
+	block.Decrypt(header, b[11:27])
+	assert.InDelta(t, time.Now().Unix(), int64(binary.LittleEndian.Uint32(header)), 1)
+	connectionID := binary.LittleEndian.Uint32(header[8:])
+	length := int(binary.LittleEndian.Uint16(header[12:]))
+	rndLen := int(binary.LittleEndian.Uint16(header[14:]))
+
+	assert.Equal(t, mac(a.userKey, b[:length-4])[:4], b[length-4:length])
+	payload := append([]byte{}, b[31+rndLen:length-4]...)
+
+	// the server decode the client packets like the client decode the server packets
+	server := &authAES128Session{authAES128: a, recvID: 1}
+	rest, remain, err := server.decode(b[length:])
+	assert.Nil(t, err)
+	assert.Empty(t, remain)
+	return append(payload, rest...), uid, connectionID
+}
+
+func TestAuthAES128_Encode(t *testing.T) {
+	for _, sha := range []bool{false, true} {
+		a := newAuthAES128(&Base{Key: testKey}, sha)
+		s1 := &authAES128Session{authAES128: a, iv: testIV, packID: 1}
+		payload, uid1, connectionID := decodeAuthAES128(t, a, s1.encode(testRequest))
+		assert.Equal(t, testRequest, payload)
+
+		s2 := &authAES128Session{authAES128: a, iv: testIV, packID: 1}
+		_, uid2, connectionID2 := decodeAuthAES128(t, a, s2.encode(testRequest))
+		assert.Equal(t, connectionID+1, connectionID2)
+		// uid is random without the protocol param
+		assert.NotEqual(t, uid1, uid2)
+	}
+}
+
+func TestAuthAES128_Param(t *testing.T) {
+	a := newAuthAES128(&Base{Key: testKey, Param: "1024:userpassword"}, false)
+	userKey := md5.Sum([]byte("userpassword"))
+	assert.Equal(t, userKey[:], a.userKey)
+
+	s := &authAES128Session{authAES128: a, iv: testIV, packID: 1}
+	payload, uid, _ := decodeAuthAES128(t, a, s.encode(testRequest))
+	assert.Equal(t, testRequest, payload)
+	assert.Equal(t, []byte{0x00, 0x04, 0x00, 0x00}, uid)
+}
+
+func TestAuthAES128_Packet(t *testing.T) {
+	a := newAuthAES128(&Base{Key: testKey, Param: "1024:userpassword"}, false)
+	s := &authAES128PacketSession{authAES128: a, uid: a.userID}
+
+	b := s.encodePacket(testRequest)
+	assert.Equal(t, testRequest, b[:len(testRequest)])
+	assert.Equal(t, []byte{0x00, 0x04, 0x00, 0x00}, b[len(testRequest):len(b)-4])
+	mac := hmac.New(md5.New, a.userKey)
+	mac.Write(b[:len(b)-4])
+	assert.Equal(t, mac.Sum(nil)[:4], b[len(b)-4:])
+
+	// the server packet is signed with the key of the server
+	payload, err := s.decodePacket(mustDecodeHex("01010101010035706f6e670a142950"))
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{1, 1, 1, 1, 1, 0, 53}, "pong"...), payload)
+
+	_, err = s.decodePacket(mustDecodeHex("01010101010035706f6e670a142951"))
+	assert.Equal(t, errAuthChecksum, err)
+}
+
+func TestAuthAES128_Vector(t *testing.T) {
+	fixRandom(t)
+
+	// the first two writes of a connection, and a udp packet with the param "1024:userpassword"
+	vectors := map[string][2]string{
+		"md5": {
+			"5a7ee4333a76f95a5a5a5a3f507324bb2d3fb1d3823284159103fcc779cb7c" +
+				strings.Repeat("5a", 602) +
+				"01010101010050474554202f20485454502f312e310d0a486f73743a206f6e652e0bae4ce672004e525b" +
+				strings.Repeat("5a", 90) +
+				"6f6e652e6f6e652e6f6e650d0a0d0a16ffdb0b6f0099315b" +
+				strings.Repeat("5a", 90) +
+				"7365636f6e642077726974652118d4de",
+			"01010101010050474554202f20485454502f312e310d0a486f73743a206f6e652e6f6e652e6f6e652e6f6e650d0a0d0a00040000961f32bc",
+		},
+		"sha1": {
+			"5a47307b29717a5a5a5a5a2102cdbf658ff2fc21bb6639f828d59ab8516674" +
+				strings.Repeat("5a", 602) +
+				"01010101010050474554202f20485454502f312e310d0a486f73743a206f6e652eadc8fe397200d69c5b" +
+				strings.Repeat("5a", 90) +
+				"6f6e652e6f6e652e6f6e650d0a0d0a5bceb4846f00c6c35b" +
+				strings.Repeat("5a", 90) +
+				"7365636f6e64207772697465563691a2",
+			"01010101010050474554202f20485454502f312e310d0a486f73743a206f6e652e6f6e652e6f6e652e6f6e650d0a0d0a00040000125757d3",
+		},
+	}
+
+	for name, vector := range vectors {
+		a := newAuthAES128(&Base{Key: testKey}, name == "sha1")
+		s := &authAES128Session{authAES128: a, iv: testIV, packID: 1}
+		b := append(s.encode(testRequest), s.encode([]byte("second write"))...)
+		assert.Equal(t, vector[0], hex.EncodeToString(b), name)
+
+		a = newAuthAES128(&Base{Key: testKey, Param: "1024:userpassword"}, name == "sha1")
+		ps := &authAES128PacketSession{authAES128: a, uid: a.userID}
+		assert.Equal(t, vector[1], hex.EncodeToString(ps.encodePacket(testRequest)), name)
+	}
+}
+
+func TestAuthChainA_Decode(t *testing.T) {
+	// server packets of the tcp mss 1460 and "pong", then "ping", with 10 bytes padding
+	packet := mustDecodeHex("6bc15a5a5a5a5a5a5a5a5a08a6e5634b235a29bb7a655a5a5a5a5a5a5a5a5a2b22bef55a7002")
+	lastServerHash := md5.Sum([]byte("640"))
+	lastClientHash := []byte{16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31}
+	userKey := base64.StdEncoding.EncodeToString(testKey)
+	rc4Key := encryption.Kdf(userKey+base64.StdEncoding.EncodeToString(lastClientHash), 16)
+
+	newSession := func() *authChainASession {
+		s := &authChainASession{authChainA: newAuthChainA(&Base{Key: testKey}), recvID: 1, lastServerHash: lastServerHash[:]}
+		s.decrypter, _ = rc4.NewCipher(rc4Key)
+		return s
+	}
+
+	s := newSession()
+	c := newConn(nil, s)
+	assert.Equal(t, []byte("pongping"), readFrom(t, c, 8, packet))
+
+	packet[len(packet)-1] ^= 1
+	_, _, err := newSession().decode(packet)
+	assert.Equal(t, errAuthChecksum, err)
+}
+
+// decodeAuthChainA is the server side of auth_chain_a, return the payload, uid and overhead
+func decodeAuthChainA(t *testing.T, a *authChainA, b []byte) ([]byte, []byte, int) {
+	macKey := append(append([]byte{}, testIV...), testKey...)
+	lastClientHash := hmacMD5(macKey, b[:4])
+	assert.Equal(t, lastClientHash[:8], b[4:12])
+
+	uid := binary.LittleEndian.AppendUint32(nil, binary.LittleEndian.Uint32(b[12:16])^binary.LittleEndian.Uint32(lastClientHash[8:12]))
+	userKey := base64.StdEncoding.EncodeToString(a.userKey)
+	header := make([]byte, 16)
+	block, _ := aes.NewCipher(encryption.Kdf(userKey+"auth_chain_a", 16))
+	block.Decrypt(header, b[16:32])
+	assert.InDelta(t, time.Now().Unix(), int64(binary.LittleEndian.Uint32(header)), 1)
+	overhead := int(binary.LittleEndian.Uint16(header[12:]))
+
+	lastServerHash := hmacMD5(a.userKey, b[12:32])
+	assert.Equal(t, lastServerHash[:4], b[32:36])
+	b = b[36:]
+
+	decrypter, _ := rc4.NewCipher(encryption.Kdf(userKey+base64.StdEncoding.EncodeToString(lastClientHash), 16))
+	random := &xorShift128Plus{}
+	var payload []byte
+	for packID := uint32(1); len(b) > 0; packID++ {
+		dataLen := int(binary.LittleEndian.Uint16(b) ^ binary.LittleEndian.Uint16(lastClientHash[14:16]))
+		rndLen := rndDataLen(dataLen, lastClientHash, random)
+		length := dataLen + rndLen
+
+		lastClientHash = hmacMD5(binary.LittleEndian.AppendUint32(append([]byte{}, a.userKey...), packID), b[:length+2])
+		assert.Equal(t, lastClientHash[:2], b[length+2:length+4])
+
+		pos := 2
+		if dataLen > 0 && rndLen > 0 {
+			pos += rndStartPos(rndLen, random)
+		}
+		data := make([]byte, dataLen)
+		decrypter.XORKeyStream(data, b[pos:pos+dataLen])
+		payload = append(payload, data...)
+		b = b[length+4:]
+	}
+	return payload, uid, overhead
+}
+
+func TestAuthChainA_Encode(t *testing.T) {
+	a := newAuthChainA(&Base{Key: testKey, Overhead: 5})
+	s := &authChainASession{authChainA: a, iv: testIV, packID: 1}
+
+	b := s.encode(testRequest)
+	b = append(b, s.encode([]byte("second write"))...)
+	b = append(b, s.encode(make([]byte, authChainAUnitLen+100))...)
+
+	payload, _, overhead := decodeAuthChainA(t, a, b)
+	assert.Equal(t, append(append(append([]byte{}, testRequest...), "second write"...), make([]byte, authChainAUnitLen+100)...), payload)
+	assert.Equal(t, 5+authChainAOverhead, overhead)
+}
+
+func TestAuthChainA_Param(t *testing.T) {
+	a := newAuthChainA(&Base{Key: testKey, Param: "1024:userpassword"})
+	assert.Equal(t, []byte("userpassword"), a.userKey)
+
+	s := &authChainASession{authChainA: a, iv: testIV, packID: 1}
+	payload, uid, _ := decodeAuthChainA(t, a, s.encode(testRequest))
+	assert.Equal(t, testRequest, payload)
+	assert.Equal(t, []byte{0x00, 0x04, 0x00, 0x00}, uid)
+}
+
+func TestAuthChainA_Packet(t *testing.T) {
+	a := newAuthChainA(&Base{Key: testKey})
+	s := &authChainAPacketSession{authChainA: a, uid: []byte{1, 2, 3, 4}}
+
+	// the server side of the client packet
+	b := s.encodePacket(testRequest)
+	assert.Equal(t, hmacMD5(testKey, b[:len(b)-1])[:1], b[len(b)-1:])
+	authData := b[len(b)-8 : len(b)-5]
+	md5Data := hmacMD5(testKey, authData)
+	uid := binary.LittleEndian.Uint32(b[len(b)-5:]) ^ binary.LittleEndian.Uint32(md5Data)
+	assert.Equal(t, uint32(0x04030201), uid)
+
+	var random xorShift128Plus
+	rndLen := udpRndDataLen(md5Data, &random)
+	payload := make([]byte, len(b)-8-rndLen)
+	s.rc4(md5Data).XORKeyStream(payload, b[:len(payload)])
+	assert.Equal(t, testRequest, payload)
+
+	// the server packet with 7 bytes auth data and 4 bytes padding
+	payload, err := s.decodePacket(mustDecodeHex("91c0037c921a56db4e5cd45a5a5a5a02000007070707f8"))
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{1, 1, 1, 1, 1, 0, 53}, "pong"...), payload)
+
+	_, err = s.decodePacket(mustDecodeHex("91c0037c921a56db4e5cd45a5a5a5a02000007070707f9"))
+	assert.Equal(t, errAuthChecksum, err)
+}
+
+func TestAuthChainA_Vector(t *testing.T) {
+	fixRandom(t)
+
+	// the first two writes of a connection behind an obfs of 5 bytes overhead
+	vector := "5a5a5a5a3a6bc04c80b26f2d564996e3f8b3878a81eefd2e4d293651b75d9c3c9b5452b85d28" +
+		strings.Repeat("5a", 713) +
+		"bbec6bd88574c13ea59fc1ee793f5e5ae7b29473781a39ca70c793bf57d3b10bd5" +
+		strings.Repeat("5a", 31) +
+		"61aeae41" +
+		strings.Repeat("5a", 251) +
+		"b4433c8b47370a8e48a81c4f9c7d0b" +
+		strings.Repeat("5a", 665) +
+		"53d11e9b" +
+		strings.Repeat("5a", 501) +
+		"2384d999b6239fabfb61868d" +
+		strings.Repeat("5a", 185) +
+		"1574"
+	a := newAuthChainA(&Base{Key: testKey, Overhead: 5})
+	s := &authChainASession{authChainA: a, iv: testIV, packID: 1}
+	b := append(s.encode(testRequest), s.encode([]byte("second write"))...)
+	assert.Equal(t, vector, hex.EncodeToString(b))
+
+	// the first write and a udp packet with the param "1024:userpassword"
+	vector = "5a5a5a5a3a6bc04c80b26f2d0c17ccb99b409bf58596d9a96387764c5ea399fd0919ce545d28" +
+		strings.Repeat("5a", 713) +
+		"91896a853fc3781086f568562d8186c94650cd1e31f44eded48f97aa5668a58eb0" +
+		strings.Repeat("5a", 31) +
+		"35f99ba9" +
+		strings.Repeat("5a", 494) +
+		"9d1fb738d9c385e4efa2af5337b39c" +
+		strings.Repeat("5a", 58) +
+		"553e"
+	a = newAuthChainA(&Base{Key: testKey, Param: "1024:userpassword"})
+	s = &authChainASession{authChainA: a, iv: testIV, packID: 1}
+	assert.Equal(t, vector, hex.EncodeToString(s.encode(testRequest)))
+
+	vector = "690bbfc8f614c6865a262b92c9cd4e24d4224c9eef1712922241abf88cf55e06dfb2aab64a61fe160afdb98057539ed2" +
+		strings.Repeat("5a", 63) +
+		"4bde7047fd"
+	ps := &authChainAPacketSession{authChainA: a, uid: a.userID}
+	assert.Equal(t, vector, hex.EncodeToString(ps.encodePacket(testRequest)))
+}
+
+func TestPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	a := newAuthAES128(&Base{Key: testKey}, false)
+	pc := a.PacketConn(client)
+
+	n, err := pc.WriteTo([]byte("ping"), server.LocalAddr())
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	buf := make([]byte, 1024)
+	n, _, err = server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf[:4])
+	assert.Equal(t, 4+4+4, n)
+
+	// the packet failed to be verified is dropped
+	server.WriteTo([]byte("bad packet"), client.LocalAddr())
+	server.WriteTo(mustDecodeHex("01010101010035706f6e670a142950"), client.LocalAddr())
+	n, _, err = pc.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{1, 1, 1, 1, 1, 0, 53}, "pong"...), buf[:n])
+
+	// the protocols without udp support are passthrough
+	for _, name := range []string{"origin", "auth_sha1_v4"} {
+		p, _ := PickProtocol(name, &Base{Key: testKey})
+		assert.Equal(t, client, p.PacketConn(client), name)
+	}
+}
+
+func TestPickProtocol(t *testing.T) {
+	for _, name := range []string{"origin", "auth_sha1_v4", "auth_sha1_v4_compatible", "auth_aes128_md5", "auth_aes128_sha1", "auth_chain_a"} {
+		_, err := PickProtocol(name, &Base{Key: testKey})
+		assert.Nil(t, err, name)
+	}
+
+	_, err := PickProtocol("auth_chain_z", &Base{Key: testKey})
+	assert.NotNil(t, err)
+}
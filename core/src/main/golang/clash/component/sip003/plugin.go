@@ -0,0 +1,223 @@
+package sip003
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+)
+
+var (
+	// restartDelay is the delay before the first restart of a crashed plugin, it doubles up to maxRestartDelay
+	restartDelay    = time.Second
+	maxRestartDelay = time.Minute
+
+	// startTimeout is how long Start waits for the plugin to listen
+	startTimeout = 5 * time.Second
+)
+
+var ErrStartTimeout = errors.New("plugin isn't listening after start")
+
+// Option of plugin
+type Option struct {
+	// Path is the executable of the plugin
+	Path       string
+	RemoteHost string
+	RemotePort int
+	// Options is passed to the plugin as SS_PLUGIN_OPTIONS
+	Options string
+}
+
+// Plugin is a SIP003 plugin process listening on a local port,
+// it's restarted when it exits until Close
+type Plugin struct {
+	option Option
+	addr   string
+
+	mux    sync.Mutex
+	cmd    *exec.Cmd
+	closed bool
+}
+
+// Start launch the plugin on a free local port
+func Start(option Option) (*Plugin, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plugin{
+		option: option,
+		addr:   net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+	}
+
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	if err := p.waitListening(); err != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		return nil, err
+	}
+	go p.supervise()
+	return p, nil
+}
+
+// Addr is the local address the plugin listens on
+func (p *Plugin) Addr() string {
+	return p.addr
+}
+
+// Close kill the plugin and stop restarting it
+func (p *Plugin) Close() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// start the process, it replaces p.cmd
+func (p *Plugin) start() error {
+	host, port, _ := net.SplitHostPort(p.addr)
+
+	cmd := exec.Command(p.option.Path)
+	cmd.Env = append(os.Environ(),
+		"SS_REMOTE_HOST="+p.option.RemoteHost,
+		"SS_REMOTE_PORT="+strconv.Itoa(p.option.RemotePort),
+		"SS_LOCAL_HOST="+host,
+		"SS_LOCAL_PORT="+port,
+		"SS_PLUGIN_OPTIONS="+p.option.Options,
+	)
+	setSysProcAttr(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.cmd = cmd
+	return nil
+}
+
+// waitListening poll the local port until the plugin accepts on it, or startTimeout passes
+func (p *Plugin) waitListening() error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		c, err := net.DialTimeout("tcp", p.addr, time.Second)
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrStartTimeout
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// supervise restart the plugin whenever it exits, with a growing delay
+func (p *Plugin) supervise() {
+	delay := restartDelay
+	for {
+		p.mux.Lock()
+		cmd := p.cmd
+		p.mux.Unlock()
+
+		started := time.Now()
+		err := cmd.Wait()
+		// the plugin had been working for a while, it's not crashing on start
+		if time.Since(started) > maxRestartDelay {
+			delay = restartDelay
+		}
+
+		for {
+			p.mux.Lock()
+			closed := p.closed
+			p.mux.Unlock()
+			if closed {
+				return
+			}
+
+			log.Warnln("[Plugin] %s exited: %v, restart in %s", p.option.Path, err, delay)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+
+			p.mux.Lock()
+			if p.closed {
+				p.mux.Unlock()
+				return
+			}
+			err = p.start()
+			p.mux.Unlock()
+			if err == nil {
+				break
+			}
+		}
+	}
+}
+
+// EncodeOptions serializes opts in the SIP003 format, key=value joined by semicolons
+func EncodeOptions(opts map[string]interface{}) string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `=`, `\=`, `;`, `\;`)
+	var options []string
+	for _, key := range keys {
+		switch value := opts[key].(type) {
+		case bool:
+			if value {
+				options = append(options, escaper.Replace(key))
+			}
+		case nil:
+			options = append(options, escaper.Replace(key))
+		default:
+			options = append(options, escaper.Replace(key)+"="+escaper.Replace(toString(value)))
+		}
+	}
+	return strings.Join(options, ";")
+}
+
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0, errors.New("unexpected listener address")
+	}
+	return addr.Port, nil
+}
@@ -0,0 +1,214 @@
+package sip003
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain runs the test binary as a stub plugin when it's launched by Plugin,
+// the stub writes SS_PLUGIN_OPTIONS to every connection and then forwards it to the remote
+func TestMain(m *testing.M) {
+	if os.Getenv("SIP003_STUB_PLUGIN") != "" && os.Getenv("SS_LOCAL_PORT") != "" {
+		// a hanging plugin never listens
+		if os.Getenv("SIP003_STUB_PLUGIN") == "hang" {
+			select {}
+		}
+		stubPlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func stubPlugin() {
+	l, err := net.Listen("tcp", net.JoinHostPort(os.Getenv("SS_LOCAL_HOST"), os.Getenv("SS_LOCAL_PORT")))
+	if err != nil {
+		os.Exit(1)
+	}
+
+	remote := net.JoinHostPort(os.Getenv("SS_REMOTE_HOST"), os.Getenv("SS_REMOTE_PORT"))
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+
+		go func() {
+			defer c.Close()
+			rc, err := net.Dial("tcp", remote)
+			if err != nil {
+				return
+			}
+			defer rc.Close()
+
+			c.Write([]byte(os.Getenv("SS_PLUGIN_OPTIONS") + "\n"))
+			go io.Copy(rc, c)
+			io.Copy(c, rc)
+		}()
+	}
+}
+
+func startStub(t *testing.T, remote net.Listener) *Plugin {
+	os.Setenv("SIP003_STUB_PLUGIN", "1")
+	t.Cleanup(func() { os.Unsetenv("SIP003_STUB_PLUGIN") })
+
+	addr := remote.Addr().(*net.TCPAddr)
+	p, err := Start(Option{
+		Path:       os.Args[0],
+		RemoteHost: addr.IP.String(),
+		RemotePort: addr.Port,
+		Options:    "mode=test",
+	})
+	assert.Nil(t, err)
+	return p
+}
+
+// dialPlugin waits for the plugin to listen, then checks it forwards to the echo server
+func dialPlugin(t *testing.T, p *Plugin) {
+	var c net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if c, err = net.Dial("tcp", p.Addr()); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	options, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "mode=test\n", options)
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func serveEcho(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestPlugin(t *testing.T) {
+	echo := serveEcho(t)
+	defer echo.Close()
+
+	p := startStub(t, echo)
+	defer p.Close()
+
+	// the plugin is listening when Start returns
+	c, err := net.Dial("tcp", p.Addr())
+	assert.Nil(t, err)
+	c.Close()
+	dialPlugin(t, p)
+}
+
+func TestPlugin_Restart(t *testing.T) {
+	restartDelay = 10 * time.Millisecond
+	defer func() { restartDelay = time.Second }()
+
+	echo := serveEcho(t)
+	defer echo.Close()
+
+	p := startStub(t, echo)
+	defer p.Close()
+	dialPlugin(t, p)
+
+	// the crashed plugin is started again on the same port
+	p.mux.Lock()
+	crashed := p.cmd
+	p.mux.Unlock()
+	crashed.Process.Kill()
+
+	restarted := false
+	for i := 0; i < 50 && !restarted; i++ {
+		time.Sleep(100 * time.Millisecond)
+		p.mux.Lock()
+		restarted = p.cmd != crashed
+		p.mux.Unlock()
+	}
+	assert.True(t, restarted)
+	dialPlugin(t, p)
+}
+
+func TestPlugin_Close(t *testing.T) {
+	restartDelay = 10 * time.Millisecond
+	defer func() { restartDelay = time.Second }()
+
+	echo := serveEcho(t)
+	defer echo.Close()
+
+	p := startStub(t, echo)
+	dialPlugin(t, p)
+
+	p.mux.Lock()
+	cmd := p.cmd
+	p.mux.Unlock()
+	assert.Nil(t, p.Close())
+
+	// killed and not restarted
+	time.Sleep(100 * time.Millisecond)
+	assert.NotNil(t, cmd.Process.Signal(syscall.Signal(0)))
+	p.mux.Lock()
+	assert.True(t, cmd == p.cmd)
+	p.mux.Unlock()
+	_, err := net.Dial("tcp", p.Addr())
+	assert.NotNil(t, err)
+}
+
+func TestStart_NotFound(t *testing.T) {
+	_, err := Start(Option{Path: "/nonexistent/plugin", RemoteHost: "127.0.0.1", RemotePort: 8388})
+	assert.NotNil(t, err)
+}
+
+func TestStart_Timeout(t *testing.T) {
+	startTimeout = 200 * time.Millisecond
+	defer func() { startTimeout = 5 * time.Second }()
+
+	os.Setenv("SIP003_STUB_PLUGIN", "hang")
+	defer os.Unsetenv("SIP003_STUB_PLUGIN")
+
+	_, err := Start(Option{Path: os.Args[0], RemoteHost: "127.0.0.1", RemotePort: 8388})
+	assert.Equal(t, ErrStartTimeout, err)
+}
+
+func TestEncodeOptions(t *testing.T) {
+	assert.Equal(t, "", EncodeOptions(nil))
+	assert.Equal(t, "host=a\\;b;mode=websocket;mux=4;tls", EncodeOptions(map[string]interface{}{
+		"mode":     "websocket",
+		"host":     "a;b",
+		"tls":      true,
+		"insecure": false,
+		"mux":      4,
+	}))
+	assert.Equal(t, "path=/\\=x;port="+strconv.Itoa(443), EncodeOptions(map[string]interface{}{
+		"path": "/=x",
+		"port": float64(443),
+	}))
+}
@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package sip003
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setSysProcAttr kill the plugin with clash, it's not left running when clash crashes
+func setSysProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
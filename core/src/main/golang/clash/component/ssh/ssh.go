@@ -0,0 +1,485 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultKeepAlive is the interval of keepalive requests
+	DefaultKeepAlive = 30 * time.Second
+)
+
+var (
+	errClosed        = errors.New("ssh client closed")
+	errHostKeyMissed = errors.New("missing host-key or known-hosts, set skip-host-key-verify to connect without verifying the server")
+)
+
+// Option of ssh
+type Option struct {
+	Server               string
+	User                 string
+	Password             string
+	PrivateKey           []byte
+	PrivateKeyPassphrase string
+	// HostKeys pins the key of the server, in the authorized_keys format or as a SHA256 fingerprint
+	HostKeys []string
+	// KnownHosts is the path of a known_hosts file
+	KnownHosts string
+	// SkipHostKeyVerify accept any key of the server when neither HostKeys nor KnownHosts is set
+	SkipHostKeyVerify bool
+	// KeepAlive is DefaultKeepAlive when it's 0
+	KeepAlive time.Duration
+	// Dialer reaches the server, through the local network or the previous hops of a relay
+	Dialer C.Dialer
+}
+
+// Client keeps one ssh connection to the server, the dials are the channels of it.
+// The connection is made again on the next dial after it's lost.
+type Client struct {
+	server    string
+	config    *ssh.ClientConfig
+	keepAlive time.Duration
+	dialer    C.Dialer
+
+	// group shares one handshake among the dials waiting for the connection
+	group  singleflight.Group
+	mux    sync.Mutex
+	client *ssh.Client
+	closed bool
+}
+
+// NewClient check option, the connection is made on the first dial
+func NewClient(option Option) (*Client, error) {
+	var auth []ssh.AuthMethod
+	if len(option.PrivateKey) != 0 {
+		var signer ssh.Signer
+		var err error
+		if option.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(option.PrivateKey, []byte(option.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(option.PrivateKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse private key error: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if option.Password != "" {
+		auth = append(auth, ssh.Password(option.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("missing password or private key")
+	}
+
+	hostKeyCallback, err := hostKeyCallback(option)
+	if err != nil {
+		return nil, err
+	}
+
+	keepAlive := option.KeepAlive
+	if keepAlive < 0 {
+		return nil, fmt.Errorf("invalid keep-alive interval %s", keepAlive)
+	}
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+
+	return &Client{
+		server: option.Server,
+		config: &ssh.ClientConfig{
+			User:            option.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+		},
+		keepAlive: keepAlive,
+		dialer:    option.Dialer,
+	}, nil
+}
+
+// WithDialer return a client of the same server reaching it with d, it keeps a connection of its own
+func (c *Client) WithDialer(d C.Dialer) *Client {
+	return &Client{
+		server:    c.server,
+		config:    c.config,
+		keepAlive: c.keepAlive,
+		dialer:    d,
+	}
+}
+
+// DialContext open a direct-tcpip channel to address, the server resolves the domain of it
+func (c *Client) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	client, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial("tcp", address)
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return newConn(r.conn), nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Close the current connection, the client can't dial any more
+func (c *Client) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.closed = true
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+func (c *Client) connect(ctx context.Context) (*ssh.Client, error) {
+	if client, err := c.current(); client != nil || err != nil {
+		return client, err
+	}
+
+	// the dial and the handshake are out of the lock, Close doesn't wait for them
+	v, err, _ := c.group.Do(c.server, func() (interface{}, error) {
+		if client, err := c.current(); client != nil || err != nil {
+			return client, err
+		}
+		return c.handshake(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ssh.Client), nil
+}
+
+// current return the connection kept by the client, it's nil when there is none
+func (c *Client) current() (*ssh.Client, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.closed {
+		return nil, errClosed
+	}
+	return c.client, nil
+}
+
+func (c *Client) handshake(ctx context.Context) (*ssh.Client, error) {
+	conn, err := c.dialer.DialContext(ctx, c.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", c.server, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, c.server, c.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s handshake error: %w", c.server, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// the client is closed during the handshake
+	if c.closed {
+		client.Close()
+		return nil, errClosed
+	}
+
+	c.client = client
+	go c.keepAliveLoop(client)
+	go func() {
+		client.Wait()
+		c.mux.Lock()
+		if c.client == client {
+			c.client = nil
+		}
+		c.mux.Unlock()
+	}()
+	return client, nil
+}
+
+// keepAliveLoop close client when the server stops answering
+func (c *Client) keepAliveLoop(client *ssh.Client) {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case err := <-replied:
+			if err == nil {
+				continue
+			}
+		case <-time.After(c.keepAlive):
+		}
+		client.Close()
+		return
+	}
+}
+
+func hostKeyCallback(option Option) (ssh.HostKeyCallback, error) {
+	if option.KnownHosts != "" {
+		callback, err := knownhosts.New(option.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("load known hosts error: %w", err)
+		}
+		return callback, nil
+	}
+
+	if len(option.HostKeys) == 0 {
+		if !option.SkipHostKeyVerify {
+			return nil, errHostKeyMissed
+		}
+		log.Warnln("[SSH] %s is connected without verifying the host key", option.Server)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	var keys []ssh.PublicKey
+	var fingerprints []string
+	for _, hostKey := range option.HostKeys {
+		if strings.HasPrefix(hostKey, "SHA256:") {
+			fingerprints = append(fingerprints, hostKey)
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse host key %s error: %w", hostKey, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, k := range keys {
+			if k.Type() == key.Type() && bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		for _, f := range fingerprints {
+			if f == fingerprint {
+				return nil
+			}
+		}
+		return fmt.Errorf("host key %s of %s mismatch", fingerprint, hostname)
+	}, nil
+}
+
+// conn is a channel of the ssh connection. The channel doesn't support deadlines, so the reads
+// and the writes run in the background and the deadlines only stop the waits for them, the
+// result of a timed out one is taken by the next call.
+type conn struct {
+	net.Conn
+
+	rMux    sync.Mutex
+	buf     []byte
+	pending []byte
+	readErr error
+	reading chan ioResult
+
+	wMux    sync.Mutex
+	writing chan ioResult
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+type ioResult struct {
+	n   int
+	err error
+}
+
+func newConn(c net.Conn) net.Conn {
+	return &conn{
+		Conn:          c,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.rMux.Lock()
+	defer c.rMux.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.reading == nil {
+			if c.buf == nil {
+				c.buf = make([]byte, 16*1024)
+			}
+			ch := make(chan ioResult, 1)
+			go func() {
+				n, err := c.Conn.Read(c.buf)
+				ch <- ioResult{n, err}
+			}()
+			c.reading = ch
+		}
+
+		select {
+		case r := <-c.reading:
+			c.reading = nil
+			c.pending = c.buf[:r.n]
+			c.readErr = r.err
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.wMux.Lock()
+	defer c.wMux.Unlock()
+
+	// a write timed out before is still going on
+	if c.writing != nil {
+		select {
+		case r := <-c.writing:
+			c.writing = nil
+			if r.err != nil {
+				return 0, r.err
+			}
+		case <-c.writeDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	// b belongs to the caller again once the write times out
+	data := append([]byte{}, b...)
+	ch := make(chan ioResult, 1)
+	go func() {
+		n, err := c.Conn.Write(data)
+		ch <- ioResult{n, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.n, r.err
+	case <-c.writeDeadline.wait():
+		c.writing = ch
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline is closed when the time is up, the same as the one of net.Pipe
+type deadline struct {
+	mux    sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	// wait for the timer to close cancel if it's firing
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,405 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) (ssh.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+type testServer struct {
+	net.Listener
+	hostKey  ssh.Signer
+	accepted int32
+	conns    chan ssh.Conn
+}
+
+// serveSSH is a ssh server forwarding direct-tcpip channels, it accepts the password "password" and clientKey
+func serveSSH(t *testing.T, clientKey ssh.PublicKey) *testServer {
+	hostKey, _ := newSigner(t)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "user" && string(password) == "password" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := &testServer{Listener: l, hostKey: hostKey, conns: make(chan ssh.Conn, 8)}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+
+			go func() {
+				conn, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					c.Close()
+					return
+				}
+				s.conns <- conn
+				go ssh.DiscardRequests(reqs)
+
+				for newChan := range chans {
+					var target struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}
+					if newChan.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChan.ExtraData(), &target) != nil {
+						newChan.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChan.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, chReqs, _ := newChan.Accept()
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						io.Copy(ch, remote)
+						ch.Close()
+					}()
+					go func() {
+						io.Copy(remote, ch)
+						remote.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// netDialer dial the server directly and count the dials
+type netDialer struct {
+	dials int32
+}
+
+func (nd *netDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	atomic.AddInt32(&nd.dials, 1)
+	return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+}
+
+func (nd *netDialer) ListenPacket(address string) (net.PacketConn, error) {
+	return net.ListenPacket("udp", "")
+}
+
+// blockedDialer dial after unblock is closed
+type blockedDialer struct {
+	netDialer
+	unblock chan struct{}
+}
+
+func (bd *blockedDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	atomic.AddInt32(&bd.dials, 1)
+	<-bd.unblock
+	return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+}
+
+func serveEcho(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func assertEcho(t *testing.T, client *Client, address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.DialContext(ctx, address)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestClient_Password(t *testing.T) {
+	echo := serveEcho(t)
+	defer echo.Close()
+	server := serveSSH(t, nil)
+	defer server.Close()
+
+	client, err := NewClient(Option{
+		Server:   server.Addr().String(),
+		User:     "user",
+		Password: "password",
+		HostKeys: []string{string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey()))},
+		Dialer:   &netDialer{},
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// the dials share one connection
+	assertEcho(t, client, echo.Addr().String())
+	assertEcho(t, client, echo.Addr().String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.accepted))
+
+	// and it's made again after it's lost
+	(<-server.conns).Close()
+	time.Sleep(100 * time.Millisecond)
+	assertEcho(t, client, echo.Addr().String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.accepted))
+}
+
+func TestClient_WithDialer(t *testing.T) {
+	echo := serveEcho(t)
+	defer echo.Close()
+	server := serveSSH(t, nil)
+	defer server.Close()
+
+	local, relay := &netDialer{}, &netDialer{}
+	client, err := NewClient(Option{Server: server.Addr().String(), User: "user", Password: "password", SkipHostKeyVerify: true, Dialer: local})
+	assert.Nil(t, err)
+	defer client.Close()
+	assertEcho(t, client, echo.Addr().String())
+
+	// the client with another dialer keeps a connection of its own
+	other := client.WithDialer(relay)
+	defer other.Close()
+	assertEcho(t, other, echo.Addr().String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&local.dials))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&relay.dials))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.accepted))
+}
+
+func TestClient_PrivateKey(t *testing.T) {
+	echo := serveEcho(t)
+	defer echo.Close()
+	signer, privateKey := newSigner(t)
+	server := serveSSH(t, signer.PublicKey())
+	defer server.Close()
+
+	client, err := NewClient(Option{
+		Server:     server.Addr().String(),
+		User:       "user",
+		PrivateKey: privateKey,
+		HostKeys:   []string{ssh.FingerprintSHA256(server.hostKey.PublicKey())},
+		Dialer:     &netDialer{},
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+	assertEcho(t, client, echo.Addr().String())
+}
+
+func TestClient_HostKeyMismatch(t *testing.T) {
+	server := serveSSH(t, nil)
+	defer server.Close()
+	other, _ := newSigner(t)
+
+	client, err := NewClient(Option{
+		Server:   server.Addr().String(),
+		User:     "user",
+		Password: "password",
+		HostKeys: []string{ssh.FingerprintSHA256(other.PublicKey())},
+		Dialer:   &netDialer{},
+	})
+	assert.Nil(t, err)
+
+	_, err = client.DialContext(context.Background(), "127.0.0.1:80")
+	assert.NotNil(t, err)
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	server := serveSSH(t, nil)
+	defer server.Close()
+
+	client, err := NewClient(Option{
+		Server:            server.Addr().String(),
+		User:              "user",
+		Password:          "password",
+		SkipHostKeyVerify: true,
+		KeepAlive:         50 * time.Millisecond,
+		Dialer:            &netDialer{},
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	sshClient, err := client.connect(context.Background())
+	assert.Nil(t, err)
+
+	// the connection is kept as long as the server answers
+	time.Sleep(200 * time.Millisecond)
+	current, err := client.connect(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, sshClient, current)
+}
+
+func TestClient_Close(t *testing.T) {
+	echo := serveEcho(t)
+	defer echo.Close()
+	server := serveSSH(t, nil)
+	defer server.Close()
+
+	client, err := NewClient(Option{Server: server.Addr().String(), User: "user", Password: "password", SkipHostKeyVerify: true, Dialer: &netDialer{}})
+	assert.Nil(t, err)
+	assertEcho(t, client, echo.Addr().String())
+
+	client.Close()
+	_, err = client.DialContext(context.Background(), echo.Addr().String())
+	assert.Equal(t, errClosed, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.accepted))
+}
+
+func TestClient_ConcurrentConnect(t *testing.T) {
+	server := serveSSH(t, nil)
+	defer server.Close()
+
+	dialer := &blockedDialer{unblock: make(chan struct{})}
+	client, err := NewClient(Option{Server: server.Addr().String(), User: "user", Password: "password", SkipHostKeyVerify: true, Dialer: dialer})
+	assert.Nil(t, err)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.connect(context.Background())
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&dialer.dials) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Close doesn't wait for the pending handshake
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close is blocked by the handshake")
+	}
+
+	// the dials share the handshake, and its connection isn't kept after Close
+	close(dialer.unblock)
+	assert.Equal(t, errClosed, <-errs)
+	assert.Equal(t, errClosed, <-errs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialer.dials))
+	assert.Nil(t, client.client)
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(Option{Server: "127.0.0.1:22", User: "user"})
+	assert.NotNil(t, err)
+
+	_, err = NewClient(Option{Server: "127.0.0.1:22", User: "user", PrivateKey: []byte("invalid")})
+	assert.NotNil(t, err)
+
+	_, err = NewClient(Option{Server: "127.0.0.1:22", User: "user", Password: "password", HostKeys: []string{"invalid"}})
+	assert.NotNil(t, err)
+
+	_, err = NewClient(Option{Server: "127.0.0.1:22", User: "user", Password: "password", SkipHostKeyVerify: true, KeepAlive: -time.Second})
+	assert.NotNil(t, err)
+
+	// the server is verified unless it's skipped explicitly
+	_, err = NewClient(Option{Server: "127.0.0.1:22", User: "user", Password: "password"})
+	assert.Equal(t, errHostKeyMissed, err)
+}
+
+func TestConn_ReadDeadline(t *testing.T) {
+	left, right := net.Pipe()
+	defer right.Close()
+	c := newConn(left)
+	defer c.Close()
+
+	buf := make([]byte, 4)
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c.Read(buf)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	// the channel is still open, and the read goes on after the deadline is cleared
+	c.SetReadDeadline(time.Time{})
+	go right.Write([]byte("ping"))
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	// a deadline set from another goroutine stop the pending read
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.SetReadDeadline(time.Now())
+	}()
+	_, err = c.Read(buf)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+}
+
+func TestConn_WriteDeadline(t *testing.T) {
+	left, right := net.Pipe()
+	defer right.Close()
+	c := newConn(left)
+	defer c.Close()
+
+	// nobody reads the other side
+	c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c.Write([]byte("ping"))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	// the timed out write is finished before the next one
+	c.SetWriteDeadline(time.Time{})
+	go func() {
+		c.Write([]byte("pong"))
+	}()
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(right, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pingpong"), buf)
+}
@@ -0,0 +1,227 @@
+package trojan
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/socks5"
+)
+
+const (
+	// maxLength is the max payload length of a udp packet
+	maxLength = 8192
+)
+
+var (
+	defaultALPN = []string{"h2", "http/1.1"}
+
+	crlf = []byte{'\r', '\n'}
+
+	bufferPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+)
+
+// Command types
+const (
+	CommandTCP byte = 1
+	CommandUDP byte = 3
+)
+
+// Option of trojan
+type Option struct {
+	Password string
+	// TLSConfig is the tls of the stream, alpn and the min version are filled in when empty
+	TLSConfig *tls.Config
+}
+
+// Trojan is trojan connection generator
+type Trojan struct {
+	option      *Option
+	hexPassword []byte
+	tlsConfig   *tls.Config
+}
+
+// StreamConn wrap conn with tls, and finish the handshake
+func (t *Trojan) StreamConn(conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Client(conn, t.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// WriteHeader send the request header, the payload could follow immediately
+func (t *Trojan) WriteHeader(w io.Writer, command byte, socks5Addr []byte) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	defer buf.Reset()
+
+	buf.Write(t.hexPassword)
+	buf.Write(crlf)
+	buf.WriteByte(command)
+	buf.Write(socks5Addr)
+	buf.Write(crlf)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// PacketConn return a net.PacketConn over the stream with CommandUDP header written
+func (t *Trojan) PacketConn(conn net.Conn) net.PacketConn {
+	return &PacketConn{Conn: conn}
+}
+
+func writePacket(w io.Writer, socks5Addr, payload []byte) (int, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	defer buf.Reset()
+
+	buf.Write(socks5Addr)
+	binary.Write(buf, binary.BigEndian, uint16(len(payload)))
+	buf.Write(crlf)
+	buf.Write(payload)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// WritePacket send payload to socks5Addr, split into several packets when it's too large
+func WritePacket(w io.Writer, socks5Addr, payload []byte) (int, error) {
+	if len(payload) <= maxLength {
+		return writePacket(w, socks5Addr, payload)
+	}
+
+	offset := 0
+	total := len(payload)
+	for offset < total {
+		cursor := offset + maxLength
+		if cursor > total {
+			cursor = total
+		}
+
+		n, err := writePacket(w, socks5Addr, payload[offset:cursor])
+		if err != nil {
+			return offset + n, err
+		}
+
+		offset = cursor
+	}
+
+	return total, nil
+}
+
+// ReadPacket read a packet into payload, return the source, the length read and the length remained
+func ReadPacket(r io.Reader, payload []byte) (net.Addr, int, int, error) {
+	addr, err := socks5.ReadAddr(r, payload)
+	if err != nil {
+		return nil, 0, 0, errors.New("read addr error")
+	}
+	uAddr := addr.UDPAddr()
+
+	if _, err = io.ReadFull(r, payload[:2]); err != nil {
+		return nil, 0, 0, errors.New("read length error")
+	}
+
+	total := int(binary.BigEndian.Uint16(payload[:2]))
+	if total > maxLength {
+		return nil, 0, 0, errors.New("packet invalid")
+	}
+
+	// read crlf
+	if _, err = io.ReadFull(r, payload[:2]); err != nil {
+		return nil, 0, 0, errors.New("read crlf error")
+	}
+
+	length := len(payload)
+	if total < length {
+		length = total
+	}
+
+	if _, err = io.ReadFull(r, payload[:length]); err != nil {
+		return nil, 0, 0, errors.New("read packet error")
+	}
+
+	return uAddr, length, total - length, nil
+}
+
+// New return a Trojan instance
+func New(option *Option) *Trojan {
+	tlsConfig := &tls.Config{}
+	if option.TLSConfig != nil {
+		tlsConfig = option.TLSConfig.Clone()
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = defaultALPN
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	return &Trojan{option, hexSha224([]byte(option.Password)), tlsConfig}
+}
+
+// PacketConn is the udp over trojan stream
+type PacketConn struct {
+	net.Conn
+	remain int
+	rAddr  net.Addr
+	mux    sync.Mutex
+}
+
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return WritePacket(pc, socks5.ParseAddrToSocksAddr(addr), b)
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+
+	// the rest of a packet larger than b
+	if pc.remain != 0 {
+		length := len(b)
+		if pc.remain < length {
+			length = pc.remain
+		}
+
+		n, err := pc.Conn.Read(b[:length])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		pc.remain -= n
+		addr := pc.rAddr
+		if pc.remain == 0 {
+			pc.rAddr = nil
+		}
+
+		return n, addr, nil
+	}
+
+	addr, n, remain, err := ReadPacket(pc.Conn, b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if remain != 0 {
+		pc.remain = remain
+		pc.rAddr = addr
+	}
+
+	return n, addr, nil
+}
+
+func hexSha224(data []byte) []byte {
+	buf := make([]byte, 56)
+	hash := sha256.Sum224(data)
+	hex.Encode(buf, hash[:])
+	return buf
+}
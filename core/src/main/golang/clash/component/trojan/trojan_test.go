@@ -0,0 +1,155 @@
+package trojan
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/socks5"
+	"github.com/Dreamacro/clash/component/tlstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const password = "example"
+
+// request is the header received by the stand-in server
+type request struct {
+	password []byte
+	command  byte
+	addr     socks5.Addr
+}
+
+// serveTrojan is a trojan stand-in server, it echoes tcp streams and udp packets back
+func serveTrojan(ln net.Listener, requests chan<- *request) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			req := &request{password: make([]byte, 56)}
+			buf := make([]byte, socks5.MaxAddrLen)
+			if _, err := io.ReadFull(conn, req.password); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+				return
+			}
+			req.command = buf[2]
+			if req.addr, err = socks5.ReadAddr(conn, buf); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+				return
+			}
+			requests <- req
+
+			if req.command == CommandTCP {
+				io.Copy(conn, conn)
+				return
+			}
+
+			payload := make([]byte, maxLength)
+			for {
+				addr, n, _, err := ReadPacket(conn, payload)
+				if err != nil {
+					return
+				}
+				if _, err := WritePacket(conn, socks5.ParseAddrToSocksAddr(addr), payload[:n]); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func newTestServer(t *testing.T) (string, chan *request) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	requests := make(chan *request, 1)
+	go serveTrojan(ln, requests)
+	return ln.Addr().String(), requests
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	instance := New(&Option{Password: password, TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+	conn, err := instance.StreamConn(c)
+	assert.Nil(t, err)
+	return conn
+}
+
+func TestTrojan_TCP(t *testing.T) {
+	addr, requests := newTestServer(t)
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	instance := New(&Option{Password: password})
+	target := socks5.ParseAddr("example.com:80")
+	assert.Nil(t, instance.WriteHeader(conn, CommandTCP, target))
+
+	req := <-requests
+	assert.Equal(t, hexSha224([]byte(password)), req.password)
+	assert.Equal(t, CommandTCP, req.command)
+	assert.Equal(t, "example.com:80", req.addr.String())
+
+	_, err := conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestTrojan_UDP(t *testing.T) {
+	addr, requests := newTestServer(t)
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	instance := New(&Option{Password: password})
+	target := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+	assert.Nil(t, instance.WriteHeader(conn, CommandUDP, socks5.ParseAddrToSocksAddr(target)))
+
+	req := <-requests
+	assert.Equal(t, CommandUDP, req.command)
+	assert.Equal(t, "1.1.1.1:53", req.addr.String())
+
+	pc := instance.PacketConn(conn)
+	_, err := pc.WriteTo([]byte("hello"), target)
+	assert.Nil(t, err)
+
+	buf := make([]byte, 1024)
+	n, from, err := pc.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), buf[:n])
+	assert.Equal(t, target.String(), from.String())
+
+	// a payload larger than maxLength is split, and a short buffer reads the rest later
+	large := bytes.Repeat([]byte{'a'}, maxLength+100)
+	n, err = pc.WriteTo(large, target)
+	assert.Nil(t, err)
+	assert.Equal(t, len(large), n)
+
+	received := 0
+	for received < len(large) {
+		n, _, err = pc.ReadFrom(buf)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, n, len(buf))
+		received += n
+	}
+	assert.Equal(t, len(large), received)
+}
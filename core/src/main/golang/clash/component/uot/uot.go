@@ -0,0 +1,201 @@
+package uot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/socks5"
+)
+
+// the UDP over TCP protocol of sing-box, the stream is opened to the magic address through
+// the proxy and the datagrams are carried on it with length framing
+const (
+	Version       = 2
+	LegacyVersion = 1
+
+	MagicAddress       = "sp.v2.udp-over-tcp.arpa"
+	LegacyMagicAddress = "sp.udp-over-tcp.arpa"
+)
+
+// the address families of the packets, they differ from the SOCKS address types
+const (
+	familyIPv4 = 0x00
+	familyIPv6 = 0x01
+	familyFqdn = 0x02
+)
+
+const maxLength = 0xffff
+
+var (
+	ErrVersion      = errors.New("unsupported udp over tcp version")
+	ErrAddressType  = errors.New("unsupported udp over tcp address type")
+	ErrPacketLength = errors.New("udp over tcp packet too large")
+)
+
+var bufferPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
+// RequestHost return the magic host the stream of version is opened to, the port is 0
+func RequestHost(version int) (string, error) {
+	switch version {
+	case LegacyVersion:
+		return LegacyMagicAddress, nil
+	case Version:
+		return MagicAddress, nil
+	default:
+		return "", ErrVersion
+	}
+}
+
+// WriteRequest send the request header of version 2, the packets follow with their addresses,
+// socks5Addr is the destination of the first packet
+func WriteRequest(w io.Writer, socks5Addr []byte) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	defer buf.Reset()
+
+	// isConnect is false, the destination of every packet is sent along
+	buf.WriteByte(0)
+	buf.Write(socks5Addr)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WritePacket send payload to socks5Addr
+func WritePacket(w io.Writer, socks5Addr, payload []byte) (int, error) {
+	if len(payload) > maxLength {
+		return 0, ErrPacketLength
+	}
+	if len(socks5Addr) == 0 {
+		return 0, ErrAddressType
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	defer buf.Reset()
+
+	switch socks5Addr[0] {
+	case socks5.AtypIPv4:
+		buf.WriteByte(familyIPv4)
+	case socks5.AtypIPv6:
+		buf.WriteByte(familyIPv6)
+	case socks5.AtypDomainName:
+		buf.WriteByte(familyFqdn)
+	default:
+		return 0, ErrAddressType
+	}
+	buf.Write(socks5Addr[1:])
+	binary.Write(buf, binary.BigEndian, uint16(len(payload)))
+	buf.Write(payload)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// ReadPacket read a packet into payload, return the source and the length read,
+// the rest of a packet larger than payload is discarded
+func ReadPacket(r io.Reader, payload []byte) (socks5.Addr, int, error) {
+	addr, err := readAddr(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total uint16
+	if err := binary.Read(r, binary.BigEndian, &total); err != nil {
+		return nil, 0, err
+	}
+
+	length := int(total)
+	if length > len(payload) {
+		length = len(payload)
+	}
+	if _, err := io.ReadFull(r, payload[:length]); err != nil {
+		return nil, 0, err
+	}
+	if remain := int(total) - length; remain != 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(remain)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return addr, length, nil
+}
+
+// readAddr read the address of a packet as a SOCKS address
+func readAddr(r io.Reader) (socks5.Addr, error) {
+	addr := make(socks5.Addr, socks5.MaxAddrLen)
+	if _, err := io.ReadFull(r, addr[:1]); err != nil {
+		return nil, err
+	}
+
+	// the address is read after its family, or its length for a domain
+	start, length := 1, 0
+	switch addr[0] {
+	case familyIPv4:
+		addr[0] = socks5.AtypIPv4
+		length = 1 + net.IPv4len + 2
+	case familyIPv6:
+		addr[0] = socks5.AtypIPv6
+		length = 1 + net.IPv6len + 2
+	case familyFqdn:
+		addr[0] = socks5.AtypDomainName
+		if _, err := io.ReadFull(r, addr[1:2]); err != nil {
+			return nil, err
+		}
+		start, length = 2, 1+1+int(addr[1])+2
+	default:
+		return nil, ErrAddressType
+	}
+
+	if _, err := io.ReadFull(r, addr[start:length]); err != nil {
+		return nil, err
+	}
+	return addr[:length], nil
+}
+
+// PacketConn is the udp over tcp stream
+type PacketConn struct {
+	net.Conn
+	rMux sync.Mutex
+	wMux sync.Mutex
+}
+
+// NewPacketConn return a net.PacketConn over conn, the request header must have been written
+func NewPacketConn(conn net.Conn) *PacketConn {
+	return &PacketConn{Conn: conn}
+}
+
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return pc.WritePacket(b, socks5.ParseAddrToSocksAddr(addr))
+}
+
+// WritePacket send b to socks5Addr, the concurrent packets aren't interleaved
+func (pc *PacketConn) WritePacket(b []byte, socks5Addr []byte) (int, error) {
+	pc.wMux.Lock()
+	defer pc.wMux.Unlock()
+
+	return WritePacket(pc.Conn, socks5Addr, b)
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.rMux.Lock()
+	defer pc.rMux.Unlock()
+
+	addr, n, err := ReadPacket(pc.Conn, b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// the server replies from the ips it sent to, a domain source can't be written back
+	udpAddr := addr.UDPAddr()
+	if udpAddr == nil {
+		return 0, nil, ErrAddressType
+	}
+	return n, udpAddr, nil
+}
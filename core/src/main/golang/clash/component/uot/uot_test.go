@@ -0,0 +1,163 @@
+package uot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/socks5"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUoT_Packet(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4:53", "[2001:db8::1]:443", "example.com:8080"} {
+		buf := &bytes.Buffer{}
+		socks5Addr := socks5.ParseAddr(addr)
+
+		n, err := WritePacket(buf, socks5Addr, []byte("payload"))
+		assert.Nil(t, err)
+		assert.Equal(t, 7, n)
+
+		// the family replaces the SOCKS address type
+		assert.NotEqual(t, socks5Addr[0], buf.Bytes()[0])
+		assert.Equal(t, []byte(socks5Addr[1:]), buf.Bytes()[1:len(socks5Addr)])
+
+		payload := make([]byte, 64)
+		src, n, err := ReadPacket(buf, payload)
+		assert.Nil(t, err)
+		assert.Equal(t, addr, src.String())
+		assert.Equal(t, "payload", string(payload[:n]))
+	}
+}
+
+func TestUoT_PacketTruncated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	socks5Addr := socks5.ParseAddr("1.2.3.4:53")
+	WritePacket(buf, socks5Addr, []byte("payload"))
+	WritePacket(buf, socks5Addr, []byte("next"))
+
+	payload := make([]byte, 3)
+	_, n, err := ReadPacket(buf, payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "pay", string(payload[:n]))
+
+	// the rest of the packet is discarded
+	payload = make([]byte, 64)
+	_, n, err = ReadPacket(buf, payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "next", string(payload[:n]))
+}
+
+func TestUoT_PacketTooLarge(t *testing.T) {
+	_, err := WritePacket(&bytes.Buffer{}, socks5.ParseAddr("1.2.3.4:53"), make([]byte, maxLength+1))
+	assert.Equal(t, ErrPacketLength, err)
+}
+
+func TestUoT_Request(t *testing.T) {
+	host, err := RequestHost(Version)
+	assert.Nil(t, err)
+	assert.Equal(t, MagicAddress, host)
+
+	host, err = RequestHost(LegacyVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, LegacyMagicAddress, host)
+
+	_, err = RequestHost(3)
+	assert.Equal(t, ErrVersion, err)
+
+	buf := &bytes.Buffer{}
+	socks5Addr := socks5.ParseAddr("1.2.3.4:53")
+	assert.Nil(t, WriteRequest(buf, socks5Addr))
+	// isConnect is false and the destination keeps the SOCKS address type
+	assert.Equal(t, append([]byte{0}, socks5Addr...), buf.Bytes())
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// the vectors are built by hand after the sing-box uot protocol
+func TestUoT_RequestVector(t *testing.T) {
+	assert.Equal(t, "sp.v2.udp-over-tcp.arpa", MagicAddress)
+	assert.Equal(t, "sp.udp-over-tcp.arpa", LegacyMagicAddress)
+
+	for addr, vector := range map[string]string{
+		// isConnect, atyp ipv4, ip, port
+		"1.2.3.4:53": "00" + "01" + "01020304" + "0035",
+		// isConnect, atyp domain, length, domain, port
+		"example.com:443": "00" + "03" + "0b" + hex.EncodeToString([]byte("example.com")) + "01bb",
+	} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, WriteRequest(buf, socks5.ParseAddr(addr)))
+		assert.Equal(t, vector, hex.EncodeToString(buf.Bytes()), addr)
+	}
+}
+
+func TestUoT_PacketVector(t *testing.T) {
+	for addr, vector := range map[string]string{
+		// family, ip, port, length, payload
+		"1.2.3.4:53":        "00" + "01020304" + "0035" + "0002" + "6869",
+		"[2001:db8::1]:443": "01" + "20010db8000000000000000000000001" + "01bb" + "0002" + "6869",
+		// family, length, domain, port, length, payload
+		"example.com:8080": "02" + "0b" + hex.EncodeToString([]byte("example.com")) + "1f90" + "0002" + "6869",
+	} {
+		buf := &bytes.Buffer{}
+		_, err := WritePacket(buf, socks5.ParseAddr(addr), []byte("hi"))
+		assert.Nil(t, err)
+		assert.Equal(t, vector, hex.EncodeToString(buf.Bytes()), addr)
+
+		payload := make([]byte, 64)
+		src, n, err := ReadPacket(bytes.NewReader(mustDecodeHex(vector)), payload)
+		assert.Nil(t, err)
+		assert.Equal(t, addr, src.String())
+		assert.Equal(t, "hi", string(payload[:n]))
+	}
+
+	// the SOCKS address type isn't a family
+	_, _, err := ReadPacket(bytes.NewReader(mustDecodeHex("03"+"01020304"+"0035"+"0000")), make([]byte, 64))
+	assert.Equal(t, ErrAddressType, err)
+}
+
+func TestUoT_PacketConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// the stand-in server echoes the packets back from their destinations
+	go func() {
+		defer server.Close()
+
+		payload := make([]byte, 64)
+		for {
+			addr, n, err := ReadPacket(server, payload)
+			if err != nil {
+				return
+			}
+			if _, err := WritePacket(server, addr, payload[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	pc := NewPacketConn(client)
+	rAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 53}
+	_, err := pc.WriteTo([]byte("hello"), rAddr)
+	assert.Nil(t, err)
+
+	buf := make([]byte, 64)
+	n, addr, err := pc.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, rAddr.String(), addr.String())
+
+	// a domain source can't be written back
+	_, err = pc.WritePacket([]byte("hello"), socks5.ParseAddr("example.com:53"))
+	assert.Nil(t, err)
+	_, _, err = pc.ReadFrom(buf)
+	assert.Equal(t, ErrAddressType, err)
+}
@@ -0,0 +1,123 @@
+package vless
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/Dreamacro/clash/component/vmess"
+
+	"github.com/gofrs/uuid"
+)
+
+// Conn wrapper a net.Conn with vless protocol,
+// every Write and Read is a length-prefixed packet when dst is udp
+type Conn struct {
+	net.Conn
+	dst *vmess.DstAddr
+
+	received bool
+	remain   int
+}
+
+func (vc *Conn) Write(b []byte) (int, error) {
+	if !vc.dst.UDP {
+		return vc.Conn.Write(b)
+	}
+
+	if len(b) > 0xffff {
+		return 0, errors.New("packet too large")
+	}
+
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+	if _, err := vc.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (vc *Conn) Read(b []byte) (int, error) {
+	if !vc.received {
+		if err := vc.recvResponse(); err != nil {
+			return 0, err
+		}
+		vc.received = true
+	}
+
+	if !vc.dst.UDP {
+		return vc.Conn.Read(b)
+	}
+
+	// the rest of a packet larger than b is dropped, like a udp socket does
+	if vc.remain != 0 {
+		if _, err := io.CopyN(io.Discard, vc.Conn, int64(vc.remain)); err != nil {
+			return 0, err
+		}
+		vc.remain = 0
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(vc.Conn, length[:]); err != nil {
+		return 0, err
+	}
+
+	total := int(binary.BigEndian.Uint16(length[:]))
+	n := total
+	if n > len(b) {
+		n = len(b)
+	}
+	if _, err := io.ReadFull(vc.Conn, b[:n]); err != nil {
+		return 0, err
+	}
+	vc.remain = total - n
+	return n, nil
+}
+
+func (vc *Conn) sendRequest(id *uuid.UUID) error {
+	buf := &bytes.Buffer{}
+
+	// Ver UUID AddonsLen Cmd
+	buf.WriteByte(Version)
+	buf.Write(id.Bytes())
+	buf.WriteByte(0)
+	if vc.dst.UDP {
+		buf.WriteByte(CommandUDP)
+	} else {
+		buf.WriteByte(CommandTCP)
+	}
+
+	// Port AddrType Addr
+	binary.Write(buf, binary.BigEndian, uint16(vc.dst.Port))
+	buf.WriteByte(vc.dst.AddrType)
+	buf.Write(vc.dst.Addr)
+
+	_, err := vc.Conn.Write(buf.Bytes())
+	return err
+}
+
+func (vc *Conn) recvResponse() error {
+	var buf [2]byte
+	if _, err := io.ReadFull(vc.Conn, buf[:]); err != nil {
+		return err
+	}
+
+	if buf[0] != Version {
+		return errors.New("unexpected response version")
+	}
+
+	// skip the addons
+	_, err := io.CopyN(io.Discard, vc.Conn, int64(buf[1]))
+	return err
+}
+
+func newConn(conn net.Conn, id *uuid.UUID, dst *vmess.DstAddr) (*Conn, error) {
+	c := &Conn{Conn: conn, dst: dst}
+	if err := c.sendRequest(id); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,57 @@
+package vless
+
+import (
+	"net"
+
+	"github.com/Dreamacro/clash/component/vmess"
+
+	"github.com/gofrs/uuid"
+)
+
+// Version of vless
+const Version byte = 0
+
+// Command types
+const (
+	CommandTCP byte = 1
+	CommandUDP byte = 2
+)
+
+// Client is vless connection generator
+type Client struct {
+	uuid      *uuid.UUID
+	transport *vmess.Transport
+}
+
+// Config of vless
+type Config struct {
+	UUID string
+	vmess.TransportConfig
+}
+
+// New return a Conn with net.Conn and DstAddr
+func (c *Client) New(conn net.Conn, dst *vmess.DstAddr) (net.Conn, error) {
+	conn, err := c.transport.StreamConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, c.uuid, dst)
+}
+
+// NewClient return Client instance
+func NewClient(config Config) (*Client, error) {
+	uid, err := uuid.FromString(config.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := vmess.NewTransport(config.TransportConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		uuid:      &uid,
+		transport: transport,
+	}, nil
+}
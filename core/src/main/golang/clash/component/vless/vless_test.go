@@ -0,0 +1,118 @@
+package vless
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/vmess"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const id = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// serveVless is a vless stand-in server, it replies with an addon and echoes the payload back
+func serveVless(conn net.Conn, headers chan<- []byte) {
+	defer conn.Close()
+
+	// Ver UUID AddonsLen Cmd Port AddrType
+	header := make([]byte, 1+16+1+1+2+1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+
+	var addr []byte
+	switch header[len(header)-1] {
+	case vmess.AtypIPv4:
+		addr = make([]byte, net.IPv4len)
+	case vmess.AtypIPv6:
+		addr = make([]byte, net.IPv6len)
+	case vmess.AtypDomainName:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		header = append(header, length[0])
+		addr = make([]byte, length[0])
+	}
+	if _, err := io.ReadFull(conn, addr); err != nil {
+		return
+	}
+	headers <- append(header, addr...)
+
+	if _, err := conn.Write([]byte{Version, 2, 0xff, 0xff}); err != nil {
+		return
+	}
+	io.Copy(conn, conn)
+}
+
+func dial(t *testing.T, dst *vmess.DstAddr) (net.Conn, []byte) {
+	client, err := NewClient(Config{UUID: id})
+	assert.Nil(t, err)
+
+	left, right := net.Pipe()
+	headers := make(chan []byte, 1)
+	go serveVless(right, headers)
+
+	conn, err := client.New(left, dst)
+	assert.Nil(t, err)
+	return conn, <-headers
+}
+
+func TestVless_TCP(t *testing.T) {
+	conn, header := dial(t, &vmess.DstAddr{
+		AddrType: vmess.AtypDomainName,
+		Addr:     append([]byte{11}, "example.com"...),
+		Port:     443,
+	})
+	defer conn.Close()
+
+	expected := []byte{Version}
+	expected = append(expected, 0xb8, 0x31, 0x38, 0x1d, 0x63, 0x24, 0x4d, 0x53, 0xad, 0x4f, 0x8c, 0xda, 0x48, 0xb3, 0x08, 0x11)
+	expected = append(expected, 0, CommandTCP, 0x01, 0xbb, vmess.AtypDomainName, 11)
+	expected = append(expected, "example.com"...)
+	assert.Equal(t, expected, header)
+
+	go conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestVless_UDP(t *testing.T) {
+	conn, header := dial(t, &vmess.DstAddr{
+		UDP:      true,
+		AddrType: vmess.AtypIPv4,
+		Addr:     []byte{1, 1, 1, 1},
+		Port:     53,
+	})
+	defer conn.Close()
+
+	assert.Equal(t, CommandUDP, header[18])
+	assert.Equal(t, uint16(53), binary.BigEndian.Uint16(header[19:21]))
+	assert.Equal(t, []byte{vmess.AtypIPv4, 1, 1, 1, 1}, header[21:])
+
+	go func() {
+		conn.Write([]byte("hello"))
+		conn.Write(bytes.Repeat([]byte{'a'}, 100))
+		conn.Write([]byte("world"))
+	}()
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), buf[:n])
+
+	// a packet larger than the buffer is truncated like a udp socket
+	n, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, n)
+
+	n, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), buf[:n])
+}
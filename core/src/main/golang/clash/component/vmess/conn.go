@@ -6,6 +6,7 @@ import (
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"hash/fnv"
@@ -34,6 +35,7 @@ type Conn struct {
 	respBodyKey []byte
 	respV       byte
 	security    byte
+	isAead      bool
 
 	received bool
 }
@@ -57,11 +59,12 @@ func (vc *Conn) Read(b []byte) (int, error) {
 func (vc *Conn) sendRequest() error {
 	timestamp := time.Now()
 
-	h := hmac.New(md5.New, vc.id.UUID.Bytes())
-	binary.Write(h, binary.BigEndian, uint64(timestamp.Unix()))
-	_, err := vc.Conn.Write(h.Sum(nil))
-	if err != nil {
-		return err
+	if !vc.isAead {
+		h := hmac.New(md5.New, vc.id.UUID.Bytes())
+		binary.Write(h, binary.BigEndian, uint64(timestamp.Unix()))
+		if _, err := vc.Conn.Write(h.Sum(nil)); err != nil {
+			return err
+		}
 	}
 
 	buf := &bytes.Buffer{}
@@ -99,6 +102,11 @@ func (vc *Conn) sendRequest() error {
 	fnv1a.Write(buf.Bytes())
 	buf.Write(fnv1a.Sum(nil))
 
+	if vc.isAead {
+		_, err := vc.Conn.Write(sealVMessAEADHeader(vc.id.CmdKey, buf.Bytes(), timestamp))
+		return err
+	}
+
 	block, err := aes.NewCipher(vc.id.CmdKey)
 	if err != nil {
 		return err
@@ -111,18 +119,30 @@ func (vc *Conn) sendRequest() error {
 }
 
 func (vc *Conn) recvResponse() error {
-	block, err := aes.NewCipher(vc.respBodyKey[:])
-	if err != nil {
-		return err
-	}
-
-	stream := cipher.NewCFBDecrypter(block, vc.respBodyIV[:])
-	buf := make([]byte, 4)
-	_, err = io.ReadFull(vc.Conn, buf)
-	if err != nil {
-		return err
+	var buf []byte
+	if vc.isAead {
+		header, err := openAEADResponseHeader(vc.Conn, vc.respBodyKey, vc.respBodyIV)
+		if err != nil {
+			return err
+		}
+		if len(header) < 4 {
+			return errors.New("unexpected response header")
+		}
+		buf = header
+	} else {
+		block, err := aes.NewCipher(vc.respBodyKey[:])
+		if err != nil {
+			return err
+		}
+
+		stream := cipher.NewCFBDecrypter(block, vc.respBodyIV[:])
+		buf = make([]byte, 4)
+		_, err = io.ReadFull(vc.Conn, buf)
+		if err != nil {
+			return err
+		}
+		stream.XORKeyStream(buf, buf)
 	}
-	stream.XORKeyStream(buf, buf)
 
 	if buf[0] != vc.respV {
 		return errors.New("unexpected response header")
@@ -147,7 +167,7 @@ func hashTimestamp(t time.Time) []byte {
 }
 
 // newConn return a Conn instance
-func newConn(conn net.Conn, id *ID, dst *DstAddr, security Security) (*Conn, error) {
+func newConn(conn net.Conn, id *ID, dst *DstAddr, security Security, isAead bool) (*Conn, error) {
 	randBytes := make([]byte, 33)
 	rand.Read(randBytes)
 	reqBodyIV := make([]byte, 16)
@@ -156,8 +176,16 @@ func newConn(conn net.Conn, id *ID, dst *DstAddr, security Security) (*Conn, err
 	copy(reqBodyKey[:], randBytes[16:32])
 	respV := randBytes[32]
 
-	respBodyKey := md5.Sum(reqBodyKey[:])
-	respBodyIV := md5.Sum(reqBodyIV[:])
+	var respBodyKey, respBodyIV [16]byte
+	if isAead {
+		key := sha256.Sum256(reqBodyKey)
+		iv := sha256.Sum256(reqBodyIV)
+		copy(respBodyKey[:], key[:16])
+		copy(respBodyIV[:], iv[:16])
+	} else {
+		respBodyKey = md5.Sum(reqBodyKey)
+		respBodyIV = md5.Sum(reqBodyIV)
+	}
 
 	var writer io.Writer
 	var reader io.Reader
@@ -202,6 +230,7 @@ func newConn(conn net.Conn, id *ID, dst *DstAddr, security Security) (*Conn, err
 		reader:      reader,
 		writer:      writer,
 		security:    security,
+		isAead:      isAead,
 	}
 	if err := c.sendRequest(); err != nil {
 		return nil, err
@@ -0,0 +1,114 @@
+package vmess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// GrpcConfig of the grpc (gun) transport
+type GrpcConfig struct {
+	Host        string
+	ServiceName string
+}
+
+var defaultGrpcHeader = http.Header{
+	"Content-Type": []string{"application/grpc"},
+	"User-Agent":   []string{"grpc-go/1.36.0"},
+	"Te":           []string{"trailers"},
+}
+
+// gunConn is a stream of `Hunk` messages of the `Tun` bidirectional streaming rpc,
+// every message is a grpc frame wrapping a protobuf with the payload as field 1
+type gunConn struct {
+	*h2Conn
+	reader *bufio.Reader
+	remain int
+
+	wMux sync.Mutex
+}
+
+func (gc *gunConn) Read(b []byte) (int, error) {
+	if gc.remain == 0 {
+		length, err := gc.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		gc.remain = length
+	}
+
+	if len(b) > gc.remain {
+		b = b[:gc.remain]
+	}
+	n, err := gc.reader.Read(b)
+	gc.remain -= n
+	return n, err
+}
+
+// readHeader read the grpc frame header and the protobuf tag, return the payload length
+func (gc *gunConn) readHeader() (int, error) {
+	// compressed flag and message length
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(gc.reader, header); err != nil {
+		return 0, err
+	}
+	if header[0] != 0 {
+		return 0, errors.New("compressed grpc message is unsupported")
+	}
+
+	// protobuf field 1, wire type 2
+	tag, err := gc.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if tag != 0x0A {
+		return 0, errors.New("unexpected protobuf tag")
+	}
+
+	length, err := binary.ReadUvarint(gc.reader)
+	if err != nil {
+		return 0, err
+	}
+	if length == 0 {
+		return gc.readHeader()
+	}
+	return int(length), nil
+}
+
+func (gc *gunConn) Write(b []byte) (int, error) {
+	varint := make([]byte, binary.MaxVarintLen64)
+	varintLen := binary.PutUvarint(varint, uint64(len(b)))
+
+	buf := make([]byte, 5+1+varintLen+len(b))
+	binary.BigEndian.PutUint32(buf[1:5], uint32(1+varintLen+len(b)))
+	buf[5] = 0x0A
+	copy(buf[6:], varint[:varintLen])
+	copy(buf[6+varintLen:], b)
+
+	gc.wMux.Lock()
+	defer gc.wMux.Unlock()
+	if _, err := gc.h2Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// StreamGunConn wrap conn with the grpc `Tun` rpc of ServiceName
+func StreamGunConn(conn net.Conn, cfg *GrpcConfig) (net.Conn, error) {
+	uri := &url.URL{
+		Scheme: "https",
+		Host:   cfg.Host,
+		Path:   "/" + cfg.ServiceName + "/Tun",
+	}
+
+	hc, err := newH2Conn(conn, http.MethodPost, uri, defaultGrpcHeader.Clone())
+	if err != nil {
+		return nil, err
+	}
+	return &gunConn{h2Conn: hc, reader: bufio.NewReader(hc)}, nil
+}
@@ -0,0 +1,115 @@
+package vmess
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http2"
+)
+
+// H2Config of the http/2 transport
+type H2Config struct {
+	Hosts []string
+	Path  string
+}
+
+// h2Conn is a stream over the body of a http/2 request and its response,
+// the request is sent as soon as the conn is created
+type h2Conn struct {
+	net.Conn
+	cc     *http2.ClientConn
+	writer *io.PipeWriter
+	res    chan error
+	body   io.ReadCloser
+
+	received bool
+	err      error
+}
+
+func (hc *h2Conn) Read(b []byte) (int, error) {
+	if !hc.received {
+		hc.err = <-hc.res
+		hc.received = true
+	}
+	if hc.err != nil {
+		return 0, hc.err
+	}
+	return hc.body.Read(b)
+}
+
+func (hc *h2Conn) Write(b []byte) (int, error) {
+	return hc.writer.Write(b)
+}
+
+func (hc *h2Conn) Close() error {
+	hc.writer.Close()
+	// closing the client conn close the underlying conn as well
+	return hc.cc.Close()
+}
+
+func newH2Conn(conn net.Conn, method string, uri *url.URL, header http.Header) (*h2Conn, error) {
+	transport := &http2.Transport{}
+	cc, err := transport.NewClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	req := &http.Request{
+		Method:     method,
+		URL:        uri,
+		Host:       uri.Host,
+		Header:     header,
+		Body:       reader,
+		Proto:      "HTTP/2",
+		ProtoMajor: 2,
+		ProtoMinor: 0,
+	}
+
+	hc := &h2Conn{
+		Conn:   conn,
+		cc:     cc,
+		writer: writer,
+		res:    make(chan error, 1),
+	}
+
+	go func() {
+		res, err := cc.RoundTrip(req)
+		if err != nil {
+			reader.CloseWithError(err)
+			hc.res <- err
+			return
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			reader.CloseWithError(errors.New(res.Status))
+			hc.res <- errors.New("unexpected response status: " + res.Status)
+			return
+		}
+		hc.body = res.Body
+		hc.res <- nil
+	}()
+
+	return hc, nil
+}
+
+// StreamH2Conn wrap conn with a http/2 PUT request to one of the hosts at path,
+// conn should be a tls conn negotiated with h2 or a cleartext conn
+func StreamH2Conn(conn net.Conn, cfg *H2Config) (net.Conn, error) {
+	host := "www.example.com"
+	if len(cfg.Hosts) != 0 {
+		host = cfg.Hosts[rand.Intn(len(cfg.Hosts))]
+	}
+
+	uri := &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   cfg.Path,
+	}
+
+	return newH2Conn(conn, http.MethodPut, uri, http.Header{})
+}
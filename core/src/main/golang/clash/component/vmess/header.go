@@ -0,0 +1,129 @@
+package vmess
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// KDF salts of the AEAD header
+const (
+	kdfSaltConstAuthIDEncryptionKey             = "AES Auth ID Encryption"
+	kdfSaltConstAEADRespHeaderLenKey            = "AEAD Resp Header Len Key"
+	kdfSaltConstAEADRespHeaderLenIV             = "AEAD Resp Header Len IV"
+	kdfSaltConstAEADRespHeaderPayloadKey        = "AEAD Resp Header Key"
+	kdfSaltConstAEADRespHeaderPayloadIV         = "AEAD Resp Header IV"
+	kdfSaltConstVMessAEADKDF                    = "VMess AEAD KDF"
+	kdfSaltConstVMessHeaderPayloadAEADKey       = "VMess Header AEAD Key"
+	kdfSaltConstVMessHeaderPayloadAEADIV        = "VMess Header AEAD Nonce"
+	kdfSaltConstVMessHeaderPayloadLengthAEADKey = "VMess Header AEAD Key_Length"
+	kdfSaltConstVMessHeaderPayloadLengthAEADIV  = "VMess Header AEAD Nonce_Length"
+)
+
+// hmacCreator build the nested hmac, every level use its parent as the hash function
+type hmacCreator struct {
+	parent *hmacCreator
+	value  []byte
+}
+
+func (h *hmacCreator) Create() hash.Hash {
+	if h.parent == nil {
+		return hmac.New(sha256.New, h.value)
+	}
+	return hmac.New(h.parent.Create, h.value)
+}
+
+func kdf(key []byte, path ...string) []byte {
+	creator := &hmacCreator{value: []byte(kdfSaltConstVMessAEADKDF)}
+	for _, v := range path {
+		creator = &hmacCreator{value: []byte(v), parent: creator}
+	}
+	h := creator.Create()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// createAuthID encrypt timestamp, random and their crc32 with the key derived from cmdKey
+func createAuthID(cmdKey []byte, timestamp int64) [16]byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, timestamp)
+	random := make([]byte, 4)
+	rand.Read(random)
+	buf.Write(random)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	block, _ := aes.NewCipher(kdf(cmdKey, kdfSaltConstAuthIDEncryptionKey)[:16])
+	var authID [16]byte
+	block.Encrypt(authID[:], buf.Bytes())
+	return authID
+}
+
+// sealVMessAEADHeader return AuthID, encrypted length, connection nonce and encrypted header
+func sealVMessAEADHeader(cmdKey []byte, header []byte, t time.Time) []byte {
+	authID := createAuthID(cmdKey, t.Unix())
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(header)))
+
+	lengthKey := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadLengthAEADKey, string(authID[:]), string(nonce))[:16]
+	lengthIV := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadLengthAEADIV, string(authID[:]), string(nonce))[:12]
+	encryptedLength := newGCM(lengthKey).Seal(nil, lengthIV, length, authID[:])
+
+	payloadKey := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadAEADKey, string(authID[:]), string(nonce))[:16]
+	payloadIV := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadAEADIV, string(authID[:]), string(nonce))[:12]
+	encryptedHeader := newGCM(payloadKey).Seal(nil, payloadIV, header, authID[:])
+
+	buf := &bytes.Buffer{}
+	buf.Write(authID[:])
+	buf.Write(encryptedLength)
+	buf.Write(nonce)
+	buf.Write(encryptedHeader)
+	return buf.Bytes()
+}
+
+// openAEADResponseHeader read the length-prefixed response header sealed with the response body key and iv
+func openAEADResponseHeader(r io.Reader, respBodyKey, respBodyIV []byte) ([]byte, error) {
+	lengthKey := kdf(respBodyKey, kdfSaltConstAEADRespHeaderLenKey)[:16]
+	lengthIV := kdf(respBodyIV, kdfSaltConstAEADRespHeaderLenIV)[:12]
+	lengthAEAD := newGCM(lengthKey)
+
+	buf := make([]byte, 2+lengthAEAD.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	length, err := lengthAEAD.Open(buf[:0], lengthIV, buf, nil)
+	if err != nil {
+		return nil, errors.New("invalid response header length")
+	}
+
+	payloadKey := kdf(respBodyKey, kdfSaltConstAEADRespHeaderPayloadKey)[:16]
+	payloadIV := kdf(respBodyIV, kdfSaltConstAEADRespHeaderPayloadIV)[:12]
+	payloadAEAD := newGCM(payloadKey)
+
+	buf = make([]byte, int(binary.BigEndian.Uint16(length))+payloadAEAD.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	header, err := payloadAEAD.Open(buf[:0], payloadIV, buf, nil)
+	if err != nil {
+		return nil, errors.New("invalid response header")
+	}
+	return header, nil
+}
@@ -0,0 +1,208 @@
+package vmess
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestKDF(t *testing.T) {
+	key := []byte("Demo Key for KDF Value Test")
+
+	assert.Equal(t, "5451591560e05bd6f1e3c32b90469d9c924859a1909594c88ce9f67a6cb47f14", hex.EncodeToString(kdf(key)))
+	assert.Equal(t, "2647ab714c0509e244b6f762daa09fa2d456d536cffe4f1a77609b43d127a256", hex.EncodeToString(kdf(key, "Demo Path for KDF Value Test")))
+	assert.Equal(t, "53e9d7e1bd7bd25022b71ead07d8a596efc8a845c7888652fd684b4903dc8892", hex.EncodeToString(kdf(key,
+		"Demo Path for KDF Value Test", "Demo Path for KDF Value Test2", "Demo Path for KDF Value Test3")))
+}
+
+func TestCmdKey(t *testing.T) {
+	uid := uuid.FromStringOrNil(testUUID)
+	assert.Equal(t, "b50d916ac0cec067981af8e5f38a758f", hex.EncodeToString(newID(&uid).CmdKey))
+}
+
+func TestCreateAuthID(t *testing.T) {
+	uid := uuid.FromStringOrNil(testUUID)
+	cmdKey := newID(&uid).CmdKey
+	now := time.Now().Unix()
+
+	authID := createAuthID(cmdKey, now)
+	decrypted := decryptAuthID(cmdKey, authID[:])
+	assert.Equal(t, now, int64(binary.BigEndian.Uint64(decrypted[:8])))
+	assert.Equal(t, crc32.ChecksumIEEE(decrypted[:12]), binary.BigEndian.Uint32(decrypted[12:]))
+
+	// the random part make every auth id different
+	another := createAuthID(cmdKey, now)
+	assert.NotEqual(t, authID, another)
+}
+
+func decryptAuthID(cmdKey []byte, authID []byte) []byte {
+	block, _ := aes.NewCipher(kdf(cmdKey, kdfSaltConstAuthIDEncryptionKey)[:16])
+	decrypted := make([]byte, 16)
+	block.Decrypt(decrypted, authID)
+	return decrypted
+}
+
+// openVMessAEADHeader is the server side of sealVMessAEADHeader
+func openVMessAEADHeader(cmdKey []byte, r io.Reader) ([]byte, error) {
+	buf := make([]byte, 16+18+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	authID, encryptedLength, nonce := buf[:16], buf[16:34], buf[34:]
+
+	lengthKey := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadLengthAEADKey, string(authID), string(nonce))[:16]
+	lengthIV := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadLengthAEADIV, string(authID), string(nonce))[:12]
+	length, err := newGCM(lengthKey).Open(nil, lengthIV, encryptedLength, authID)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadKey := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadAEADKey, string(authID), string(nonce))[:16]
+	payloadIV := kdf(cmdKey, kdfSaltConstVMessHeaderPayloadAEADIV, string(authID), string(nonce))[:12]
+	encryptedHeader := make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(r, encryptedHeader); err != nil {
+		return nil, err
+	}
+	return newGCM(payloadKey).Open(nil, payloadIV, encryptedHeader, authID)
+}
+
+func TestSealVMessAEADHeader(t *testing.T) {
+	uid := uuid.FromStringOrNil(testUUID)
+	cmdKey := newID(&uid).CmdKey
+	header := []byte("vmess request header")
+
+	sealed := sealVMessAEADHeader(cmdKey, header, time.Now())
+	assert.Len(t, sealed, 16+18+8+len(header)+16)
+
+	opened, err := openVMessAEADHeader(cmdKey, bytes.NewReader(sealed))
+	assert.Nil(t, err)
+	assert.Equal(t, header, opened)
+
+	// tampering the header is detected
+	sealed[len(sealed)-1] ^= 1
+	_, err = openVMessAEADHeader(cmdKey, bytes.NewReader(sealed))
+	assert.NotNil(t, err)
+}
+
+// serveVmess is a vmess stand-in server with aes-128-gcm body, it echoes the payload back
+func serveVmess(conn net.Conn, id *ID, isAead bool, dst chan<- []byte) {
+	defer conn.Close()
+
+	var reader io.Reader
+	if isAead {
+		header, err := openVMessAEADHeader(id.CmdKey, conn)
+		if err != nil {
+			return
+		}
+		reader = bytes.NewReader(header)
+	} else {
+		auth := make([]byte, 16)
+		if _, err := io.ReadFull(conn, auth); err != nil {
+			return
+		}
+
+		// the test client and server share the same clock, but the second may change
+		timestamp := time.Now()
+		for _, ts := range []time.Time{timestamp, timestamp.Add(-time.Second)} {
+			h := hmac.New(md5.New, id.UUID.Bytes())
+			binary.Write(h, binary.BigEndian, uint64(ts.Unix()))
+			if hmac.Equal(auth, h.Sum(nil)) {
+				timestamp = ts
+			}
+		}
+
+		block, _ := aes.NewCipher(id.CmdKey)
+		stream := cipher.NewCFBDecrypter(block, hashTimestamp(timestamp))
+		reader = &cipher.StreamReader{S: stream, R: conn}
+	}
+
+	// Ver IV Key V Opt P|Sec Reserve Cmd Port AddrType Addr(ipv4)
+	header := make([]byte, 1+16+16+1+1+1+1+1+2+1+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	padding := int(header[35] >> 4)
+	if _, err := io.ReadFull(reader, make([]byte, padding+4)); err != nil {
+		return
+	}
+	dst <- header[38:]
+
+	reqBodyIV, reqBodyKey, respV := header[1:17], header[17:33], header[33]
+	var respBodyKey, respBodyIV []byte
+	if isAead {
+		key, iv := sha256.Sum256(reqBodyKey), sha256.Sum256(reqBodyIV)
+		respBodyKey, respBodyIV = key[:16], iv[:16]
+	} else {
+		key, iv := md5.Sum(reqBodyKey), md5.Sum(reqBodyIV)
+		respBodyKey, respBodyIV = key[:], iv[:]
+	}
+
+	if isAead {
+		resp := []byte{respV, 0, 0, 0}
+		length := []byte{0, byte(len(resp))}
+		lengthKey := kdf(respBodyKey, kdfSaltConstAEADRespHeaderLenKey)[:16]
+		lengthIV := kdf(respBodyIV, kdfSaltConstAEADRespHeaderLenIV)[:12]
+		conn.Write(newGCM(lengthKey).Seal(nil, lengthIV, length, nil))
+
+		payloadKey := kdf(respBodyKey, kdfSaltConstAEADRespHeaderPayloadKey)[:16]
+		payloadIV := kdf(respBodyIV, kdfSaltConstAEADRespHeaderPayloadIV)[:12]
+		conn.Write(newGCM(payloadKey).Seal(nil, payloadIV, resp, nil))
+	} else {
+		block, _ := aes.NewCipher(respBodyKey)
+		resp := []byte{respV, 0, 0, 0}
+		cipher.NewCFBEncrypter(block, respBodyIV).XORKeyStream(resp, resp)
+		conn.Write(resp)
+	}
+
+	bodyReader := newAEADReader(conn, newGCM(reqBodyKey), reqBodyIV)
+	bodyWriter := newAEADWriter(conn, newGCM(respBodyKey), respBodyIV)
+	io.Copy(bodyWriter, bodyReader)
+}
+
+func testVmessConn(t *testing.T, alterID uint16) {
+	client, err := NewClient(Config{UUID: testUUID, AlterID: alterID, Security: "aes-128-gcm"})
+	assert.Nil(t, err)
+	assert.Equal(t, alterID == 0, client.isAead)
+
+	left, right := net.Pipe()
+	dst := make(chan []byte, 1)
+	go serveVmess(right, client.user[len(client.user)-1], client.isAead, dst)
+
+	// the stand-in only knows the primary id
+	client.user = client.user[len(client.user)-1:]
+
+	conn, err := client.New(left, &DstAddr{AddrType: AtypIPv4, Addr: []byte{1, 1, 1, 1}, Port: 80})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, []byte{0, 80, AtypIPv4, 1, 1, 1, 1}, <-dst)
+
+	go conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+}
+
+func TestConn_AEAD(t *testing.T) {
+	testVmessConn(t, 0)
+}
+
+func TestConn_Legacy(t *testing.T) {
+	testVmessConn(t, 4)
+}
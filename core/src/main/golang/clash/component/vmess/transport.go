@@ -0,0 +1,112 @@
+package vmess
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// TransportConfig of the stream under vmess and vless
+type TransportConfig struct {
+	TLS              bool
+	HostName         string
+	Port             string
+	NetWork          string
+	WebSocketPath    string
+	WebSocketHeaders map[string]string
+	HTTP2Hosts       []string
+	HTTP2Path        string
+	GrpcServiceName  string
+	// TLSConfig is the tls of the stream when TLS is set, the server name and alpn are filled in when empty
+	TLSConfig *tls.Config
+}
+
+// Transport wrap the connection to the server with tls, websocket, http/2 or grpc
+type Transport struct {
+	tls        bool
+	wsConfig   *WebsocketConfig
+	h2Config   *H2Config
+	grpcConfig *GrpcConfig
+	tlsConfig  *tls.Config
+}
+
+// NewTransport check the network of config and build the transport
+func NewTransport(config TransportConfig) (*Transport, error) {
+	switch config.NetWork {
+	case "", "ws", "h2", "grpc":
+	default:
+		return nil, fmt.Errorf("Unknown network type: %s", config.NetWork)
+	}
+
+	header := http.Header{}
+	for k, v := range config.WebSocketHeaders {
+		header.Add(k, v)
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLS {
+		tlsConfig = &tls.Config{}
+		if config.TLSConfig != nil {
+			tlsConfig = config.TLSConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = config.HostName
+			if host := header.Get("Host"); host != "" {
+				tlsConfig.ServerName = host
+			}
+		}
+		if len(tlsConfig.NextProtos) == 0 && (config.NetWork == "h2" || config.NetWork == "grpc") {
+			tlsConfig.NextProtos = []string{"h2"}
+		}
+	}
+
+	t := &Transport{tls: config.TLS, tlsConfig: tlsConfig}
+	switch config.NetWork {
+	case "ws":
+		t.wsConfig = &WebsocketConfig{
+			Host:      net.JoinHostPort(config.HostName, config.Port),
+			Path:      config.WebSocketPath,
+			Headers:   header,
+			TLS:       config.TLS,
+			TLSConfig: tlsConfig,
+		}
+	case "h2":
+		hosts := config.HTTP2Hosts
+		if len(hosts) == 0 {
+			hosts = []string{config.HostName}
+		}
+		t.h2Config = &H2Config{
+			Hosts: hosts,
+			Path:  config.HTTP2Path,
+		}
+	case "grpc":
+		t.grpcConfig = &GrpcConfig{
+			Host:        config.HostName,
+			ServiceName: config.GrpcServiceName,
+		}
+	}
+
+	return t, nil
+}
+
+// StreamConn wrap conn with the transport
+func (t *Transport) StreamConn(conn net.Conn) (net.Conn, error) {
+	switch {
+	case t.wsConfig != nil:
+		return NewWebsocketConn(conn, t.wsConfig)
+	case t.h2Config != nil:
+		if t.tls {
+			conn = tls.Client(conn, t.tlsConfig)
+		}
+		return StreamH2Conn(conn, t.h2Config)
+	case t.grpcConfig != nil:
+		if t.tls {
+			conn = tls.Client(conn, t.tlsConfig)
+		}
+		return StreamGunConn(conn, t.grpcConfig)
+	case t.tls:
+		return tls.Client(conn, t.tlsConfig), nil
+	}
+	return conn, nil
+}
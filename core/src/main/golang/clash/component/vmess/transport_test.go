@@ -0,0 +1,135 @@
+package vmess
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+type request struct {
+	method string
+	path   string
+	header http.Header
+}
+
+// serveH2 serve a cleartext http/2 stand-in server on conn, it echoes the request body back
+func serveH2(conn net.Conn, requests chan<- *request) {
+	server := &http2.Server{}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- &request{method: r.Method, path: r.URL.Path, header: r.Header}
+
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+
+			buf := make([]byte, 1024)
+			for {
+				n, err := r.Body.Read(buf)
+				if n > 0 {
+					w.Write(buf[:n])
+					w.(http.Flusher).Flush()
+				}
+				if err != nil {
+					return
+				}
+			}
+		}),
+	})
+}
+
+func echo(t *testing.T, conn net.Conn, payload []byte) {
+	go conn.Write(payload)
+
+	buf := make([]byte, len(payload))
+	_, err := io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestStreamH2Conn(t *testing.T) {
+	left, right := net.Pipe()
+	requests := make(chan *request, 1)
+	go serveH2(right, requests)
+
+	conn, err := StreamH2Conn(left, &H2Config{Hosts: []string{"example.com"}, Path: "/path"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	req := <-requests
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/path", req.path)
+
+	echo(t, conn, []byte("ping"))
+	echo(t, conn, bytes.Repeat([]byte{'a'}, 4096))
+}
+
+func TestStreamGunConn(t *testing.T) {
+	left, right := net.Pipe()
+	requests := make(chan *request, 1)
+	go serveH2(right, requests)
+
+	conn, err := StreamGunConn(left, &GrpcConfig{Host: "example.com", ServiceName: "example"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	req := <-requests
+	assert.Equal(t, http.MethodPost, req.method)
+	assert.Equal(t, "/example/Tun", req.path)
+	assert.Equal(t, "application/grpc", req.header.Get("Content-Type"))
+
+	// the stand-in echoes the frames, so the client reads back what it wrote
+	echo(t, conn, []byte("ping"))
+	echo(t, conn, bytes.Repeat([]byte{'a'}, 4096))
+}
+
+func TestGunConn_Frame(t *testing.T) {
+	reader, writer := io.Pipe()
+	gc := &gunConn{h2Conn: &h2Conn{writer: writer}}
+	go func() {
+		gc.Write([]byte("hello"))
+		writer.Close()
+	}()
+	buf := &bytes.Buffer{}
+	io.Copy(buf, reader)
+
+	// grpc frame header, protobuf tag and varint length, payload
+	assert.Equal(t, append([]byte{0, 0, 0, 0, 7, 0x0A, 5}, "hello"...), buf.Bytes())
+}
+
+func TestNewTransport(t *testing.T) {
+	_, err := NewTransport(TransportConfig{NetWork: "quic"})
+	assert.NotNil(t, err)
+
+	transport, err := NewTransport(TransportConfig{
+		TLS:              true,
+		HostName:         "example.com",
+		Port:             "443",
+		NetWork:          "ws",
+		WebSocketHeaders: map[string]string{"Host": "cdn.example.com"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:443", transport.wsConfig.Host)
+	assert.Equal(t, "cdn.example.com", transport.tlsConfig.ServerName)
+
+	transport, err = NewTransport(TransportConfig{TLS: true, HostName: "example.com", NetWork: "grpc"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"h2"}, transport.tlsConfig.NextProtos)
+	assert.Equal(t, "example.com", transport.grpcConfig.Host)
+
+	// h2 over cleartext
+	left, right := net.Pipe()
+	requests := make(chan *request, 1)
+	go serveH2(right, requests)
+
+	transport, err = NewTransport(TransportConfig{HostName: "example.com", NetWork: "h2", HTTP2Path: "/path"})
+	assert.Nil(t, err)
+	conn, err := transport.StreamConn(left)
+	assert.Nil(t, err)
+	echo(t, conn, []byte("ping"))
+	assert.Equal(t, "/path", (<-requests).path)
+}
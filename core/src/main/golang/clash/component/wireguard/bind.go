@@ -0,0 +1,174 @@
+package wireguard
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// bind is a conn.Bind talking to a single server through a packet conn of dialer
+type bind struct {
+	server   string
+	reserved [3]byte
+	dialer   C.Dialer
+
+	mux  sync.Mutex
+	pc   net.PacketConn
+	addr *net.UDPAddr
+}
+
+func newBind(server string, reserved [3]byte, dialer C.Dialer) *bind {
+	return &bind{server: server, reserved: reserved, dialer: dialer}
+}
+
+func (b *bind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.pc != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+
+	pc, err := b.dialer.ListenPacket(b.server)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.pc = pc
+	// resolve the server again, it may have moved since the last time
+	b.addr = nil
+
+	_, p, _ := net.SplitHostPort(pc.LocalAddr().String())
+	actualPort, _ := strconv.ParseUint(p, 10, 16)
+	return []conn.ReceiveFunc{b.receive(pc)}, uint16(actualPort), nil
+}
+
+func (b *bind) receive(pc net.PacketConn) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		n, addr, err := pc.ReadFrom(packets[0])
+		if err != nil {
+			return 0, err
+		}
+
+		// the reserved bytes are a hint for the server, the device expects them to be zero
+		if n > 3 {
+			packets[0][1], packets[0][2], packets[0][3] = 0, 0, 0
+		}
+		sizes[0] = n
+		eps[0] = &endpoint{address: b.server, ip: addrIP(addr)}
+		return 1, nil
+	}
+}
+
+func (b *bind) Close() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.pc == nil {
+		return nil
+	}
+	err := b.pc.Close()
+	b.pc = nil
+	return err
+}
+
+func (b *bind) SetMark(mark uint32) error {
+	return nil
+}
+
+func (b *bind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	pc, addr, err := b.remote()
+	if err != nil {
+		return err
+	}
+
+	for _, buf := range bufs {
+		if len(buf) > 3 {
+			copy(buf[1:4], b.reserved[:])
+		}
+		if _, err := pc.WriteTo(buf, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remote return the opened socket and the resolved address of the server
+func (b *bind) remote() (net.PacketConn, *net.UDPAddr, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.pc == nil {
+		return nil, nil, net.ErrClosed
+	}
+
+	if b.addr == nil {
+		host, port, err := net.SplitHostPort(b.server)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ip, err := resolver.ResolveIP(host)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		p, _ := strconv.Atoi(port)
+		b.addr = &net.UDPAddr{IP: ip, Port: p}
+	}
+	return b.pc, b.addr, nil
+}
+
+// ParseEndpoint accepts the server only, packets are always sent to it
+func (b *bind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _ := netip.ParseAddr(host)
+	return &endpoint{address: s, ip: ip}, nil
+}
+
+func (b *bind) BatchSize() int {
+	return 1
+}
+
+type endpoint struct {
+	address string
+	ip      netip.Addr
+}
+
+func (e *endpoint) ClearSrc() {}
+
+func (e *endpoint) SrcToString() string {
+	return ""
+}
+
+func (e *endpoint) DstToString() string {
+	return e.address
+}
+
+func (e *endpoint) DstToBytes() []byte {
+	return []byte(e.address)
+}
+
+func (e *endpoint) DstIP() netip.Addr {
+	return e.ip
+}
+
+func (e *endpoint) SrcIP() netip.Addr {
+	return netip.Addr{}
+}
+
+func addrIP(addr net.Addr) netip.Addr {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		ip, _ := netip.AddrFromSlice(udpAddr.IP)
+		return ip.Unmap()
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,150 @@
+package wireguard
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const nicID = 1
+
+// netTun is a tun.Device backed by a userspace netstack,
+// the packets written by the stack are read by the wireguard device and vice versa
+type netTun struct {
+	ep     *channel.Endpoint
+	stack  *stack.Stack
+	mtu    int
+	events chan tun.Event
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newNetTun(ips []net.IP, mtu int) (*netTun, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+
+	ep := channel.New(1024, uint32(mtu), "")
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		return nil, errorf("create nic", err)
+	}
+
+	for _, ip := range ips {
+		protocolAddress := tcpip.ProtocolAddress{Protocol: protocolNumber(ip), AddressWithPrefix: address(ip).WithPrefix()}
+		if err := s.AddProtocolAddress(nicID, protocolAddress, stack.AddressProperties{}); err != nil {
+			return nil, errorf("add address", err)
+		}
+	}
+
+	// every packet goes through the tunnel, the peer decides what to do with it
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}, {Destination: header.IPv6EmptySubnet, NIC: nicID}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &netTun{
+		ep:     ep,
+		stack:  s,
+		mtu:    mtu,
+		events: make(chan tun.Event, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	t.events <- tun.EventUp
+	return t, nil
+}
+
+func (t *netTun) File() *os.File {
+	return nil
+}
+
+func (t *netTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	pkt := t.ep.ReadContext(t.ctx)
+	if pkt.IsNil() {
+		return 0, os.ErrClosed
+	}
+	defer pkt.DecRef()
+
+	n := 0
+	for _, slice := range pkt.AsSlices() {
+		n += copy(bufs[0][offset+n:], slice)
+	}
+	sizes[0] = n
+	return 1, nil
+}
+
+func (t *netTun) Write(bufs [][]byte, offset int) (int, error) {
+	for _, buf := range bufs {
+		packet := buf[offset:]
+		if len(packet) == 0 {
+			continue
+		}
+
+		var proto tcpip.NetworkProtocolNumber
+		switch packet[0] >> 4 {
+		case 4:
+			proto = ipv4.ProtocolNumber
+		case 6:
+			proto = ipv6.ProtocolNumber
+		default:
+			continue
+		}
+
+		// the packet is copied, the buffer is reused by the device
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)})
+		t.ep.InjectInbound(proto, pkt)
+		pkt.DecRef()
+	}
+	return len(bufs), nil
+}
+
+func (t *netTun) MTU() (int, error) {
+	return t.mtu, nil
+}
+
+func (t *netTun) Name() (string, error) {
+	return "wireguard", nil
+}
+
+func (t *netTun) Events() <-chan tun.Event {
+	return t.events
+}
+
+func (t *netTun) Close() error {
+	select {
+	case <-t.ctx.Done():
+	default:
+		t.cancel()
+		close(t.events)
+		t.stack.Close()
+	}
+	return nil
+}
+
+func (t *netTun) BatchSize() int {
+	return 1
+}
+
+func protocolNumber(ip net.IP) tcpip.NetworkProtocolNumber {
+	if ip.To4() != nil {
+		return ipv4.ProtocolNumber
+	}
+	return ipv6.ProtocolNumber
+}
+
+func address(ip net.IP) tcpip.Address {
+	if ip4 := ip.To4(); ip4 != nil {
+		return tcpip.AddrFromSlice(ip4)
+	}
+	return tcpip.AddrFromSlice(ip.To16())
+}
@@ -0,0 +1,168 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"golang.zx2c4.com/wireguard/device"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+)
+
+const (
+	// DefaultMTU leaves room for the wireguard and the outer ip headers
+	DefaultMTU = 1408
+)
+
+var (
+	defaultAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+)
+
+// Option of wireguard
+type Option struct {
+	// Server is the address of the peer, host:port
+	Server string
+	// IP and IPv6 are the addresses of the local end of the tunnel
+	IP   net.IP
+	IPv6 net.IP
+	// PrivateKey, PublicKey and PreSharedKey are base64 encoded
+	PrivateKey   string
+	PublicKey    string
+	PreSharedKey string
+	AllowedIPs   []string
+	MTU          int
+	// Reserved is written to the reserved bytes of every outgoing message
+	Reserved [3]byte
+	// Dialer reaches the server, through the local network or the previous hops of a relay
+	Dialer C.Dialer
+}
+
+// Tunnel is a userspace wireguard peer with its own netstack
+type Tunnel struct {
+	device *device.Device
+	tun    *netTun
+}
+
+// New start the wireguard device, the handshake is made on the first packet
+func New(option Option) (*Tunnel, error) {
+	if option.IP == nil && option.IPv6 == nil {
+		return nil, errors.New("missing ip of the tunnel")
+	}
+	if option.MTU == 0 {
+		option.MTU = DefaultMTU
+	}
+	if len(option.AllowedIPs) == 0 {
+		option.AllowedIPs = defaultAllowedIPs
+	}
+
+	config, err := uapiConfig(option)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, ip := range []net.IP{option.IP, option.IPv6} {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	t, err := newNetTun(ips, option.MTU)
+	if err != nil {
+		return nil, err
+	}
+
+	dev := device.NewDevice(t, newBind(option.Server, option.Reserved, option.Dialer), device.NewLogger(device.LogLevelSilent, ""))
+	if err := dev.IpcSet(config); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("wireguard config error: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	return &Tunnel{device: dev, tun: t}, nil
+}
+
+// DialContext dial a tcp connection to ip:port through the tunnel
+func (t *Tunnel) DialContext(ctx context.Context, ip net.IP, port int) (net.Conn, error) {
+	addr := tcpip.FullAddress{NIC: nicID, Addr: address(ip), Port: uint16(port)}
+	return gonet.DialContextTCP(ctx, t.tun.stack, addr, protocolNumber(ip))
+}
+
+// ListenPacket return a unconnected udp socket in the tunnel, it can only reach the ips of the same family as ip
+func (t *Tunnel) ListenPacket(ip net.IP) (net.PacketConn, error) {
+	pc, err := gonet.DialUDP(t.tun.stack, nil, nil, protocolNumber(ip))
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{pc}, nil
+}
+
+// Close stop the wireguard device and its netstack
+func (t *Tunnel) Close() {
+	t.device.Close()
+}
+
+// packetConn normalizes the ipv4 addresses for the netstack
+type packetConn struct {
+	*gonet.UDPConn
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		if ip4 := udpAddr.IP.To4(); ip4 != nil {
+			addr = &net.UDPAddr{IP: ip4, Port: udpAddr.Port}
+		}
+	}
+	return pc.UDPConn.WriteTo(b, addr)
+}
+
+// uapiConfig translate option to the configuration protocol of wireguard
+func uapiConfig(option Option) (string, error) {
+	var sb strings.Builder
+
+	keys := []struct {
+		name  string
+		value string
+	}{
+		{"private_key", option.PrivateKey},
+		{"public_key", option.PublicKey},
+		{"preshared_key", option.PreSharedKey},
+	}
+	for _, key := range keys {
+		if key.value == "" {
+			if key.name == "preshared_key" {
+				continue
+			}
+			return "", fmt.Errorf("missing %s", key.name)
+		}
+
+		k, err := base64.StdEncoding.DecodeString(key.value)
+		if err != nil || len(k) != device.NoisePublicKeySize {
+			return "", fmt.Errorf("invalid %s", key.name)
+		}
+		fmt.Fprintf(&sb, "%s=%s\n", key.name, hex.EncodeToString(k))
+	}
+
+	fmt.Fprintf(&sb, "endpoint=%s\n", option.Server)
+	for _, allowedIP := range option.AllowedIPs {
+		if _, _, err := net.ParseCIDR(allowedIP); err != nil {
+			return "", fmt.Errorf("invalid allowed ip %s", allowedIP)
+		}
+		fmt.Fprintf(&sb, "allowed_ip=%s\n", allowedIP)
+	}
+	return sb.String(), nil
+}
+
+func errorf(op string, err tcpip.Error) error {
+	return fmt.Errorf("%s: %s", op, err)
+}
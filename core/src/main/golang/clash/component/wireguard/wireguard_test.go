@@ -0,0 +1,177 @@
+package wireguard
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+func newKey(t *testing.T) (private, public []byte) {
+	private = make([]byte, curve25519.ScalarSize)
+	rand.Read(private)
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	assert.Nil(t, err)
+	return
+}
+
+// serverPeer is the other end of the tunnel, it listens on a normal udp socket
+func serverPeer(t *testing.T, ip net.IP, private, peerPublic []byte) (*netTun, int) {
+	tun, err := newNetTun([]net.IP{ip}, DefaultMTU)
+	assert.Nil(t, err)
+
+	dev := device.NewDevice(tun, conn.NewStdNetBind(), device.NewLogger(device.LogLevelSilent, ""))
+	config := "private_key=" + hex.EncodeToString(private) + "\nlisten_port=0\n" +
+		"public_key=" + hex.EncodeToString(peerPublic) + "\nallowed_ip=0.0.0.0/0\n"
+	assert.Nil(t, dev.IpcSet(config))
+	assert.Nil(t, dev.Up())
+	t.Cleanup(dev.Close)
+
+	status, err := dev.IpcGet()
+	assert.Nil(t, err)
+	for _, line := range strings.Split(status, "\n") {
+		if strings.HasPrefix(line, "listen_port=") {
+			port, _ := strconv.Atoi(strings.TrimPrefix(line, "listen_port="))
+			return tun, port
+		}
+	}
+	t.Fatal("missing listen_port")
+	return nil, 0
+}
+
+// netDialer reaches the server directly
+type netDialer struct{}
+
+func (netDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+}
+
+func (netDialer) ListenPacket(address string) (net.PacketConn, error) {
+	return net.ListenPacket("udp", "")
+}
+
+func TestTunnel(t *testing.T) {
+	clientPrivate, clientPublic := newKey(t)
+	serverPrivate, serverPublic := newKey(t)
+
+	serverIP := net.IPv4(10, 0, 0, 1).To4()
+	server, port := serverPeer(t, serverIP, serverPrivate, clientPublic)
+
+	l, err := gonet.ListenTCP(server.stack, tcpip.FullAddress{NIC: nicID, Addr: address(serverIP), Port: 80}, ipv4.ProtocolNumber)
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	echo, err := gonet.DialUDP(server.stack, &tcpip.FullAddress{NIC: nicID, Addr: address(serverIP), Port: 53}, nil, ipv4.ProtocolNumber)
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		n, addr, err := echo.ReadFrom(buf)
+		if err == nil {
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	tunnel, err := New(Option{
+		Server:     net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		IP:         net.IPv4(10, 0, 0, 2),
+		PrivateKey: base64.StdEncoding.EncodeToString(clientPrivate),
+		PublicKey:  base64.StdEncoding.EncodeToString(serverPublic),
+		Dialer:     netDialer{},
+	})
+	assert.Nil(t, err)
+	defer tunnel.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := tunnel.DialContext(ctx, serverIP, 80)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+
+	pc, err := tunnel.ListenPacket(serverIP)
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	_, err = pc.WriteTo([]byte("pong"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53})
+	assert.Nil(t, err)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := pc.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pong"), buf[:n])
+	assert.Equal(t, "10.0.0.1:53", addr.String())
+}
+
+func TestBind_Reserved(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	b := newBind(server.LocalAddr().String(), [3]byte{1, 2, 3}, netDialer{})
+	fns, _, err := b.Open(0)
+	assert.Nil(t, err)
+	defer b.Close()
+
+	assert.Nil(t, b.Send([][]byte{{4, 0, 0, 0, 5}}, nil))
+	buf := make([]byte, 16)
+	n, client, err := server.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4, 1, 2, 3, 5}, buf[:n])
+
+	server.WriteTo([]byte{4, 1, 2, 3, 5}, client)
+	packets, sizes, eps := [][]byte{make([]byte, 16)}, make([]int, 1), make([]conn.Endpoint, 1)
+	count, err := fns[0](packets, sizes, eps)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []byte{4, 0, 0, 0, 5}, packets[0][:sizes[0]])
+	assert.Equal(t, server.LocalAddr().String(), eps[0].DstToString())
+}
+
+func TestUapiConfig(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	config, err := uapiConfig(Option{
+		Server:     "example.com:51820",
+		PrivateKey: key,
+		PublicKey:  key,
+		AllowedIPs: defaultAllowedIPs,
+	})
+	assert.Nil(t, err)
+	zero := strings.Repeat("0", 64)
+	assert.Equal(t, "private_key="+zero+"\npublic_key="+zero+"\nendpoint=example.com:51820\nallowed_ip=0.0.0.0/0\nallowed_ip=::/0\n", config)
+
+	_, err = uapiConfig(Option{PrivateKey: key})
+	assert.NotNil(t, err)
+
+	_, err = uapiConfig(Option{PrivateKey: key, PublicKey: "short"})
+	assert.NotNil(t, err)
+
+	_, err = uapiConfig(Option{PrivateKey: key, PublicKey: key, AllowedIPs: []string{"10.0.0.1"}})
+	assert.NotNil(t, err)
+}
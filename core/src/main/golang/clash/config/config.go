@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"github.com/Dreamacro/clash/component/auth"
 	trie "github.com/Dreamacro/clash/component/domain-trie"
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/resolver"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/log"
@@ -25,18 +27,19 @@ import (
 
 // General config
 type General struct {
-	Port               int          `json:"port"`
-	SocksPort          int          `json:"socks-port"`
-	RedirPort          int          `json:"redir-port"`
-	Tun                Tun          `json:"tun"`
-	Authentication     []string     `json:"authentication"`
-	AllowLan           bool         `json:"allow-lan"`
-	BindAddress        string       `json:"bind-address"`
-	Mode               T.TunnelMode `json:"mode"`
-	LogLevel           log.LogLevel `json:"log-level"`
-	ExternalController string       `json:"-"`
-	ExternalUI         string       `json:"-"`
-	Secret             string       `json:"-"`
+	Port               int                `json:"port"`
+	SocksPort          int                `json:"socks-port"`
+	RedirPort          int                `json:"redir-port"`
+	Tun                Tun                `json:"tun"`
+	Authentication     []string           `json:"authentication"`
+	AllowLan           bool               `json:"allow-lan"`
+	BindAddress        string             `json:"bind-address"`
+	Mode               T.TunnelMode       `json:"mode"`
+	LogLevel           log.LogLevel       `json:"log-level"`
+	IPVersion          resolver.IPVersion `json:"ip-version"`
+	ExternalController string             `json:"-"`
+	ExternalUI         string             `json:"-"`
+	Secret             string             `json:"-"`
 }
 
 // DNS config
@@ -47,9 +50,13 @@ type DNS struct {
 	Fallback          []dns.NameServer `yaml:"fallback"`
 	FallbackFilter    FallbackFilter   `yaml:"fallback-filter"`
 	Listen            string           `yaml:"listen"`
+	TLSListen         string           `yaml:"tls-listen"`
+	HTTPSListen       string           `yaml:"https-listen"`
 	EnhancedMode      dns.EnhancedMode `yaml:"enhanced-mode"`
 	DefaultNameserver []dns.NameServer `yaml:"default-nameserver"`
 	FakeIPRange       *fakeip.Pool
+	ClientSubnet      *dns.ClientSubnet `yaml:"ecs"`
+	Certificate       *tls.Certificate
 }
 
 // FallbackFilter config
@@ -60,9 +67,18 @@ type FallbackFilter struct {
 
 // Tun config
 type Tun struct {
-	Enable    bool   `yaml:"enable" json:"enable"`
-	DeviceURL string `yaml:"device-url" json:"device-url"`
-	DNSListen string `yaml:"dns-listen" json:"dns-listen"`
+	Enable    bool     `yaml:"enable" json:"enable"`
+	DeviceURL string   `yaml:"device-url" json:"device-url"`
+	DNSListen string   `yaml:"dns-listen" json:"dns-listen"`
+	DNSHijack []string `yaml:"dns-hijack" json:"dns-hijack"`
+}
+
+// Hijack return dns-hijack with dns-listen, the legacy form of hijacking both udp and tcp on one address
+func (t Tun) Hijack() []string {
+	if t.DNSListen == "" {
+		return t.DNSHijack
+	}
+	return append([]string{"udp://" + t.DNSListen, "tcp://" + t.DNSListen}, t.DNSHijack...)
 }
 
 // Experimental config
@@ -91,10 +107,15 @@ type RawDNS struct {
 	Fallback          []string          `yaml:"fallback"`
 	FallbackFilter    RawFallbackFilter `yaml:"fallback-filter"`
 	Listen            string            `yaml:"listen"`
+	TLSListen         string            `yaml:"tls-listen"`
+	HTTPSListen       string            `yaml:"https-listen"`
+	Certificate       string            `yaml:"certificate"`
+	PrivateKey        string            `yaml:"private-key"`
 	EnhancedMode      dns.EnhancedMode  `yaml:"enhanced-mode"`
 	FakeIPRange       string            `yaml:"fake-ip-range"`
 	FakeIPFilter      []string          `yaml:"fake-ip-filter"`
 	DefaultNameserver []string          `yaml:"default-nameserver"`
+	ClientSubnet      string            `yaml:"ecs"`
 }
 
 type RawFallbackFilter struct {
@@ -103,22 +124,23 @@ type RawFallbackFilter struct {
 }
 
 type RawConfig struct {
-	Port               int          `yaml:"port"`
-	SocksPort          int          `yaml:"socks-port"`
-	RedirPort          int          `yaml:"redir-port"`
-	Authentication     []string     `yaml:"authentication"`
-	AllowLan           bool         `yaml:"allow-lan"`
-	BindAddress        string       `yaml:"bind-address"`
-	Mode               T.TunnelMode `yaml:"mode"`
-	LogLevel           log.LogLevel `yaml:"log-level"`
-	ExternalController string       `yaml:"external-controller"`
-	ExternalUI         string       `yaml:"external-ui"`
-	Secret             string       `yaml:"secret"`
+	Port               int                `yaml:"port"`
+	SocksPort          int                `yaml:"socks-port"`
+	RedirPort          int                `yaml:"redir-port"`
+	Authentication     []string           `yaml:"authentication"`
+	AllowLan           bool               `yaml:"allow-lan"`
+	BindAddress        string             `yaml:"bind-address"`
+	Mode               T.TunnelMode       `yaml:"mode"`
+	LogLevel           log.LogLevel       `yaml:"log-level"`
+	IPVersion          resolver.IPVersion `yaml:"ip-version"`
+	ExternalController string             `yaml:"external-controller"`
+	ExternalUI         string             `yaml:"external-ui"`
+	Secret             string             `yaml:"secret"`
 
 	ProxyProvider map[string]map[string]interface{} `yaml:"proxy-provider"`
 	Hosts         map[string]string                 `yaml:"hosts"`
 	DNS           RawDNS                            `yaml:"dns"`
-    Tun           Tun                               `yaml:"tun"`
+	Tun           Tun                               `yaml:"tun"`
 	Experimental  Experimental                      `yaml:"experimental"`
 	Proxy         []map[string]interface{}          `yaml:"Proxy"`
 	ProxyGroup    []map[string]interface{}          `yaml:"Proxy Group"`
@@ -143,6 +165,7 @@ func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
 		Mode:           T.Rule,
 		Authentication: []string{},
 		LogLevel:       log.INFO,
+		IPVersion:      resolver.DualStack,
 		Hosts:          map[string]string{},
 		Rule:           []string{},
 		Proxy:          []map[string]interface{}{},
@@ -176,7 +199,7 @@ func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
 	return rawCfg, nil
 }
 
-func ParseRawConfig(rawCfg *RawConfig, baseDir string) (*Config, error) {
+func ParseRawConfig(rawCfg *RawConfig, baseDir string) (_ *Config, err error) {
 	config := &Config{}
 
 	config.Experimental = &rawCfg.Experimental
@@ -194,6 +217,13 @@ func ParseRawConfig(rawCfg *RawConfig, baseDir string) (*Config, error) {
 	config.Proxies = proxies
 	config.Providers = providers
 
+	// stop the proxies and providers when the rest of config is invalid
+	defer func() {
+		if err != nil {
+			destroyProxies(proxies, providers)
+		}
+	}()
+
 	rules, err := parseRules(rawCfg, proxies)
 	if err != nil {
 		return nil, err
@@ -229,6 +259,19 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 	secret := cfg.Secret
 	mode := cfg.Mode
 	logLevel := cfg.LogLevel
+	ipVersion := cfg.IPVersion
+
+	if err := ipVersion.Validate(); err != nil {
+		return nil, err
+	}
+	if ipVersion == "" {
+		ipVersion = resolver.DualStack
+	}
+
+	// the dns disabling AAAA leaves nothing to resolve
+	if ipVersion == resolver.IPv6Only && cfg.DNS.Enable && !cfg.DNS.IPv6 {
+		return nil, errors.New("ip-version ipv6-only requires dns.ipv6 when dns is enabled")
+	}
 
 	if externalUI != "" {
 		externalUI = C.Path.Resolve(externalUI)
@@ -247,6 +290,7 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 		BindAddress:        bindAddress,
 		Mode:               mode,
 		LogLevel:           logLevel,
+		IPVersion:          ipVersion,
 		ExternalController: externalController,
 		ExternalUI:         externalUI,
 		Secret:             secret,
@@ -262,18 +306,22 @@ func parseProxies(cfg *RawConfig, baseDir string) (proxies map[string]C.Proxy, p
 	groupsConfig := cfg.ProxyGroup
 	providersConfig := cfg.ProxyProvider
 
+	// the results are cleared by the returns on error, keep them for the cleanup
+	createdProxies, createdProviders := proxies, providersMap
 	defer func() {
-		// Destroy already created provider when err != nil
+		// Destroy already created provider and proxies when err != nil
 		if err != nil {
-			for _, provider := range providersMap {
-				provider.Destroy()
-			}
+			destroyProxies(createdProxies, createdProviders)
 		}
 	}()
 
 	proxies["DIRECT"] = outbound.NewProxy(outbound.NewDirect())
 	proxies["REJECT"] = outbound.NewProxy(outbound.NewReject())
-	proxyList = append(proxyList, "DIRECT", "REJECT")
+	proxies["REJECT-DROP"] = outbound.NewProxy(outbound.NewRejectDrop())
+	proxies["REJECT-RESET"] = outbound.NewProxy(outbound.NewRejectReset())
+	proxies["REJECT-PAGE"] = outbound.NewProxy(outbound.NewRejectPage())
+	proxies["REJECT-TINYGIF"] = outbound.NewProxy(outbound.NewRejectTinyGIF())
+	proxyList = append(proxyList, "DIRECT", "REJECT", "REJECT-DROP", "REJECT-RESET", "REJECT-PAGE", "REJECT-TINYGIF")
 
 	// parse proxy
 	for idx, mapping := range proxiesConfig {
@@ -283,6 +331,7 @@ func parseProxies(cfg *RawConfig, baseDir string) (proxies map[string]C.Proxy, p
 		}
 
 		if _, exist := proxies[proxy.Name()]; exist {
+			proxy.Destroy()
 			return nil, nil, fmt.Errorf("Proxy %s is the duplicate name", proxy.Name())
 		}
 		proxies[proxy.Name()] = proxy
@@ -364,6 +413,15 @@ func parseProxies(cfg *RawConfig, baseDir string) (proxies map[string]C.Proxy, p
 	return proxies, providersMap, nil
 }
 
+func destroyProxies(proxies map[string]C.Proxy, providers map[string]provider.ProxyProvider) {
+	for _, provider := range providers {
+		provider.Destroy()
+	}
+	for _, proxy := range proxies {
+		proxy.Destroy()
+	}
+}
+
 func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, error) {
 	rules := []C.Rule{}
 
@@ -503,6 +561,9 @@ func parseNameServer(servers []string) ([]dns.NameServer, error) {
 			clearURL := url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
 			addr = clearURL.String()
 			dnsNetType = "https" // DNS over HTTPS
+		case "quic":
+			addr, err = hostWithDefaultPort(u.Host, "853")
+			dnsNetType = "quic" // DNS over QUIC
 		default:
 			return nil, fmt.Errorf("DNS NameServer[%d] unsupport scheme: %s", idx, u.Scheme)
 		}
@@ -511,11 +572,19 @@ func parseNameServer(servers []string) ([]dns.NameServer, error) {
 			return nil, fmt.Errorf("DNS NameServer[%d] format error: %s", idx, err.Error())
 		}
 
+		var subnet *dns.ClientSubnet
+		if ecs := u.Query().Get("ecs"); ecs != "" {
+			if subnet, err = dns.ParseClientSubnet(ecs); err != nil {
+				return nil, fmt.Errorf("DNS NameServer[%d] format error: %s", idx, err.Error())
+			}
+		}
+
 		nameservers = append(
 			nameservers,
 			dns.NameServer{
-				Net:  dnsNetType,
-				Addr: addr,
+				Net:          dnsNetType,
+				Addr:         addr,
+				ClientSubnet: subnet,
 			},
 		)
 	}
@@ -544,6 +613,8 @@ func parseDNS(cfg RawDNS) (*DNS, error) {
 	dnsCfg := &DNS{
 		Enable:       cfg.Enable,
 		Listen:       cfg.Listen,
+		TLSListen:    cfg.TLSListen,
+		HTTPSListen:  cfg.HTTPSListen,
 		IPv6:         cfg.IPv6,
 		EnhancedMode: cfg.EnhancedMode,
 		FallbackFilter: FallbackFilter{
@@ -596,6 +667,24 @@ func parseDNS(cfg RawDNS) (*DNS, error) {
 		dnsCfg.FakeIPRange = pool
 	}
 
+	if cfg.ClientSubnet != "" {
+		if dnsCfg.ClientSubnet, err = dns.ParseClientSubnet(cfg.ClientSubnet); err != nil {
+			return nil, fmt.Errorf("DNS ecs format error: %s", err.Error())
+		}
+	}
+
+	if cfg.TLSListen != "" || cfg.HTTPSListen != "" {
+		if cfg.Certificate == "" || cfg.PrivateKey == "" {
+			return nil, errors.New("DNS tls-listen and https-listen require certificate and private-key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(C.Path.Resolve(cfg.Certificate), C.Path.Resolve(cfg.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("DNS certificate load error: %s", err.Error())
+		}
+		dnsCfg.Certificate = &cert
+	}
+
 	dnsCfg.FallbackFilter.GeoIP = cfg.FallbackFilter.GeoIP
 	if fallbackip, err := parseFallbackIPCIDR(cfg.FallbackFilter.IPCIDR); err == nil {
 		dnsCfg.FallbackFilter.IPCIDR = fallbackip
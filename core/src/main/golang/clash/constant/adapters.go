@@ -21,6 +21,11 @@ const (
 	URLTest
 	Vmess
 	LoadBalance
+	Trojan
+	Vless
+	Relay
+	WireGuard
+	Ssh
 )
 
 type ServerAdapter interface {
@@ -28,6 +33,11 @@ type ServerAdapter interface {
 	Metadata() *Metadata
 }
 
+// InboundResetter is a Conn asking the tunnel to reset the inbound connection instead of relaying to it
+type InboundResetter interface {
+	ResetInbound()
+}
+
 type Connection interface {
 	Chains() Chain
 	AppendToChains(adapter ProxyAdapter)
@@ -57,13 +67,32 @@ type PacketConn interface {
 	WriteWithMetadata(p []byte, metadata *Metadata) (n int, err error)
 }
 
+// Dialer reaches the server of a proxy, through the local network or the previous hops of a relay
+type Dialer interface {
+	DialContext(ctx context.Context, address string) (net.Conn, error)
+	// ListenPacket return a packet conn which relays the packets to address
+	ListenPacket(address string) (net.PacketConn, error)
+}
+
 type ProxyAdapter interface {
 	Name() string
 	Type() AdapterType
+	// Addr is the address of the server, empty for the proxies without a server
+	Addr() string
+	// StreamConn wraps the protocol of the proxy around c, the connection to its server
+	StreamConn(c net.Conn, metadata *Metadata) (net.Conn, error)
 	DialContext(ctx context.Context, metadata *Metadata) (Conn, error)
 	DialUDP(metadata *Metadata) (PacketConn, error)
+	// DialUDPWithDialer is DialUDP reaching the server with d
+	DialUDPWithDialer(d Dialer, metadata *Metadata) (PacketConn, error)
 	SupportUDP() bool
 	MarshalJSON() ([]byte, error)
+	// Unwrap return the proxy which a group picks for metadata, nil for a proxy
+	Unwrap(metadata *Metadata) Proxy
+	// Destroy release the resources held by the proxy, like the processes of plugins
+	Destroy()
+	// Dialer reaches the server of the proxy through the local network, bound as the proxy is configured
+	Dialer() Dialer
 }
 
 type DelayHistory struct {
@@ -76,6 +105,8 @@ type Proxy interface {
 	Alive() bool
 	DelayHistory() []DelayHistory
 	Dial(metadata *Metadata) (Conn, error)
+	// DialContextWithDialer is DialContext reaching the server with d, the hops of a relay are dialed with it
+	DialContextWithDialer(ctx context.Context, d Dialer, metadata *Metadata) (Conn, error)
 	LastDelay() uint16
 	URLTest(ctx context.Context, url string) (uint16, error)
 }
@@ -109,6 +140,16 @@ func (at AdapterType) String() string {
 		return "Vmess"
 	case LoadBalance:
 		return "LoadBalance"
+	case Trojan:
+		return "Trojan"
+	case Vless:
+		return "Vless"
+	case Relay:
+		return "Relay"
+	case WireGuard:
+		return "WireGuard"
+	case Ssh:
+		return "Ssh"
 	default:
 		return "Unknown"
 	}
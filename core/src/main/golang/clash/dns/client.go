@@ -16,6 +16,18 @@ type client struct {
 	r    *Resolver
 	port string
 	host string
+	ecs  *ClientSubnet
+}
+
+func (c *client) Address() string {
+	scheme := "udp"
+	switch c.Client.Net {
+	case "tcp":
+		scheme = "tcp"
+	case "tcp-tls":
+		scheme = "tls"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(c.host, c.port))
 }
 
 func (c *client) Exchange(m *D.Msg) (msg *D.Msg, err error) {
@@ -34,16 +46,15 @@ func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err
 		}
 	}
 
-	d := dialer.Dialer()
-	if dialer.DialHook != nil {
-		network := "udp"
-		if strings.HasPrefix(c.Client.Net, "tcp") {
-			network = "tcp"
-		}
-		dialer.DialHook(d, network, ip)
+	network := "udp"
+	if strings.HasPrefix(c.Client.Net, "tcp") {
+		network = "tcp"
 	}
+	d := dialer.Dialer()
+	dialer.BindDialer(d, network, ip)
 
 	c.Client.Dialer = d
+	m = c.ecs.apply(m)
 
 	// miekg/dns ExchangeContext doesn't respond to context cancel.
 	// this is a workaround
@@ -21,6 +21,11 @@ const (
 type dohClient struct {
 	url       string
 	transport *http.Transport
+	ecs       *ClientSubnet
+}
+
+func (dc *dohClient) Address() string {
+	return dc.url
 }
 
 func (dc *dohClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
@@ -28,7 +33,7 @@ func (dc *dohClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
 }
 
 func (dc *dohClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
-	req, err := dc.newRequest(m)
+	req, err := dc.newRequest(dc.ecs.apply(m))
 	if err != nil {
 		return nil, err
 	}
@@ -71,9 +76,10 @@ func (dc *dohClient) doRequest(req *http.Request) (msg *D.Msg, err error) {
 	return msg, err
 }
 
-func newDoHClient(url string, r *Resolver) *dohClient {
+func newDoHClient(url string, r *Resolver, ecs *ClientSubnet) *dohClient {
 	return &dohClient{
 		url: url,
+		ecs: ecs,
 		transport: &http.Transport{
 			TLSClientConfig: &tls.Config{ClientSessionCache: globalSessionCache},
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	D "github.com/miekg/dns"
+)
+
+const (
+	// dohPath is the well-known path of DoH, see RFC 8484 section 4.1.1
+	dohPath = "/dns-query"
+
+	// dohMaxMsgSize limit the request body, a dns message never exceed it
+	dohMaxMsgSize = 65535
+)
+
+// dohHandler serve DoH GET and POST request with the handler of server
+type dohHandler struct {
+	server *Server
+}
+
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("content-type") != dotMimeType {
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, dohMaxMsgSize))
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil || len(buf) == 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	msg := &D.Msg{}
+	if err := msg.Unpack(buf); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	writer := &dohResponseWriter{request: r}
+	h.server.ServeDNS(writer, msg)
+	if writer.msg == nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("content-type", dotMimeType)
+	w.Write(writer.msg)
+}
+
+// dohResponseWriter keep the reply of handler for the http response
+type dohResponseWriter struct {
+	request *http.Request
+	msg     []byte
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	if addr, ok := w.request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", w.request.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+func (w *dohResponseWriter) WriteMsg(msg *D.Msg) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	w.msg = buf
+	return nil
+}
+
+func (w *dohResponseWriter) Write(buf []byte) (int, error) {
+	w.msg = append([]byte{}, buf...)
+	return len(buf), nil
+}
+
+func (w *dohResponseWriter) Close() error {
+	return nil
+}
+
+func (w *dohResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *dohResponseWriter) TsigTimersOnly(bool) {
+}
+
+// Hijack has nothing to take over, the http server owns the connection
+func (w *dohResponseWriter) Hijack() {
+}
@@ -0,0 +1,210 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// doqNoError is the application error code of a graceful close, see RFC 9250 section 4.3
+	doqNoError quic.ApplicationErrorCode = 0x0
+
+	doqIdleTimeout = 30 * time.Second
+)
+
+// doqSessionCache keep the session tickets of the DoQ servers apart from the tls ones,
+// quic resumes 0-RTT only with the tickets it issued
+var doqSessionCache = tls.NewLRUClientSessionCache(64)
+
+type doqClient struct {
+	r    *Resolver
+	port string
+	host string
+	ecs  *ClientSubnet
+
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	mux  sync.Mutex
+	conn quic.Connection
+	pc   net.PacketConn
+}
+
+func (dc *doqClient) Address() string {
+	return "quic://" + net.JoinHostPort(dc.host, dc.port)
+}
+
+func (dc *doqClient) Exchange(m *D.Msg) (msg *D.Msg, err error) {
+	return dc.ExchangeContext(context.Background(), m)
+}
+
+func (dc *doqClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
+	conn, err := dc.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the message id must be 0 over quic, see RFC 9250 section 4.2.1
+	query := dc.ecs.apply(m).Copy()
+	query.Id = 0
+
+	msg, err = dc.exchange(ctx, conn, query)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// the server refused the early data, send again after the full handshake
+		conn = dc.nextConn(conn)
+		msg, err = dc.exchange(ctx, conn, query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Id = m.Id
+	return msg, nil
+}
+
+func (dc *doqClient) exchange(ctx context.Context, conn quic.Connection, m *D.Msg) (msg *D.Msg, err error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the stream of a rejected 0-RTT shares its id with the next one, leave it alone
+		if errors.Is(err, quic.Err0RTTRejected) {
+			return
+		}
+		if err != nil {
+			stream.CancelWrite(quic.StreamErrorCode(doqNoError))
+		}
+		stream.CancelRead(quic.StreamErrorCode(doqNoError))
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	packet := make([]byte, 2+len(buf))
+	binary.BigEndian.PutUint16(packet, uint16(len(buf)))
+	copy(packet[2:], buf)
+	if _, err := stream.Write(packet); err != nil {
+		return nil, err
+	}
+
+	// the client must send the STREAM FIN after the query
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return nil, err
+	}
+
+	buf = make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, err
+	}
+
+	msg = &D.Msg{}
+	err = msg.Unpack(buf)
+	return msg, err
+}
+
+// getConn return the cached quic connection, or dial a new one
+func (dc *doqClient) getConn(ctx context.Context) (quic.Connection, error) {
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+
+	if dc.conn != nil {
+		select {
+		case <-dc.conn.Context().Done():
+			dc.pc.Close()
+			dc.conn = nil
+		default:
+			return dc.conn, nil
+		}
+	}
+
+	var ip net.IP
+	if dc.r == nil {
+		// a default ip dns
+		ip = net.ParseIP(dc.host)
+	} else {
+		var err error
+		if ip, err = dc.r.ResolveIP(dc.host); err != nil {
+			return nil, fmt.Errorf("use default dns resolve failed: %w", err)
+		}
+	}
+
+	port, err := strconv.Atoi(dc.port)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := dialer.ListenPacket("udp", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// DialEarly send the query with 0-RTT when a session ticket was cached
+	conn, err := quic.DialEarly(ctx, pc, &net.UDPAddr{IP: ip, Port: port}, dc.tlsConfig, dc.quicConfig)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("%s connect error: %w", net.JoinHostPort(dc.host, dc.port), err)
+	}
+
+	dc.conn = conn
+	dc.pc = pc
+	return conn, nil
+}
+
+// nextConn replace the connection whose 0-RTT was rejected with the one after handshake
+func (dc *doqClient) nextConn(conn quic.Connection) quic.Connection {
+	next := conn.(quic.EarlyConnection).NextConnection()
+
+	dc.mux.Lock()
+	defer dc.mux.Unlock()
+
+	if dc.conn == conn {
+		dc.conn = next
+	}
+	return next
+}
+
+func newDoQClient(addr string, r *Resolver, ecs *ClientSubnet) *doqClient {
+	host, port, _ := net.SplitHostPort(addr)
+	return &doqClient{
+		r:    r,
+		port: port,
+		host: host,
+		ecs:  ecs,
+		tlsConfig: &tls.Config{
+			ServerName:         host,
+			ClientSessionCache: doqSessionCache,
+			// alpn identifier, see RFC 9250 section 4.1.1
+			NextProtos: []string{"doq"},
+		},
+		// no keep alive, an idle connection expires and the next query dials again
+		quicConfig: &quic.Config{
+			MaxIdleTimeout: doqIdleTimeout,
+		},
+	}
+}
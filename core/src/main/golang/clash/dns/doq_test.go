@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/tlstest"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// received is a query seen by the stand-in server
+type received struct {
+	id       uint16
+	used0RTT bool
+}
+
+// serveDoQ answer every A query with 1.2.3.4 and record the received queries
+func serveDoQ(ln *quic.EarlyListener, queries chan<- received) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer stream.Close()
+
+					buf, err := io.ReadAll(stream)
+					if err != nil || len(buf) < 2 {
+						return
+					}
+
+					query := &D.Msg{}
+					if err := query.Unpack(buf[2:]); err != nil {
+						return
+					}
+					queries <- received{id: query.Id, used0RTT: conn.ConnectionState().Used0RTT}
+
+					msg := &D.Msg{}
+					msg.SetReply(query)
+					msg.Answer = append(msg.Answer, &D.A{
+						Hdr: D.RR_Header{Name: query.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+						A:   net.IPv4(1, 2, 3, 4),
+					})
+
+					resp, _ := msg.Pack()
+					packet := make([]byte, 2+len(resp))
+					binary.BigEndian.PutUint16(packet, uint16(len(resp)))
+					copy(packet[2:], resp)
+					stream.Write(packet)
+				}()
+			}
+		}()
+	}
+}
+
+func TestDoQClient_Exchange(t *testing.T) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+
+	ln, err := quic.ListenAddrEarly("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, &quic.Config{Allow0RTT: true})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	queries := make(chan received, 2)
+	go serveDoQ(ln, queries)
+
+	port := ln.Addr().(*net.UDPAddr).Port
+	client := newDoQClient(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil, nil)
+	client.tlsConfig.InsecureSkipVerify = true
+
+	for i := 0; i < 2; i++ {
+		query := &D.Msg{}
+		query.SetQuestion("example.com.", D.TypeA)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		msg, err := client.ExchangeContext(ctx, query)
+		cancel()
+		assert.Nil(t, err)
+		assert.Equal(t, query.Id, msg.Id)
+		assert.Len(t, msg.Answer, 1)
+		assert.Equal(t, "1.2.3.4", msg.Answer[0].(*D.A).A.String())
+		assert.Equal(t, uint16(0), (<-queries).id)
+	}
+}
+
+func TestDoQClient_0RTT(t *testing.T) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+
+	ln, err := quic.ListenAddrEarly("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, &quic.Config{Allow0RTT: true})
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	queries := make(chan received, 2)
+	go serveDoQ(ln, queries)
+
+	port := ln.Addr().(*net.UDPAddr).Port
+	client := newDoQClient(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil, nil)
+	client.tlsConfig.InsecureSkipVerify = true
+	client.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	exchange := func() {
+		query := &D.Msg{}
+		query.SetQuestion("example.com.", D.TypeA)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		msg, err := client.ExchangeContext(ctx, query)
+		assert.Nil(t, err)
+		assert.Len(t, msg.Answer, 1)
+	}
+
+	exchange()
+	assert.False(t, (<-queries).used0RTT)
+
+	// the session ticket arrives after the handshake, wait for it before the connection is dropped
+	time.Sleep(100 * time.Millisecond)
+	client.conn.CloseWithError(doqNoError, "")
+
+	// the next connection resumes with the cached ticket and send the query as early data
+	exchange()
+	assert.True(t, (<-queries).used0RTT)
+}
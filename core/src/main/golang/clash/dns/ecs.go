@@ -0,0 +1,250 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+
+	D "github.com/miekg/dns"
+)
+
+const (
+	// default source prefix length of a client subnet, see RFC 7871 section 11.1
+	ecsIPv4PrefixLength = 24
+	ecsIPv6PrefixLength = 56
+
+	// myip.opendns.com answers the public address of the querier, the family of the
+	// address is the one the query is sent over
+	ecsDetectDomain  = "myip.opendns.com."
+	ecsDetectServer  = "208.67.222.222:53"
+	ecsDetectServer6 = "[2620:119:35::35]:53"
+
+	// the public address changes with the network, so detect it again after a while
+	ecsDetectInterval = 5 * time.Minute
+)
+
+var (
+	ecsDetector = &subnetDetector{detect: detectClientSubnet}
+
+	errECSFormat = errors.New("invalid client subnet, should be `auto` or ip/cidr")
+)
+
+// ClientSubnet is the EDNS Client Subnet option attached to outgoing queries
+type ClientSubnet struct {
+	Auto  bool
+	IPNet *net.IPNet
+}
+
+// ParseClientSubnet parse `auto`, a cidr or a single ip into ClientSubnet
+func ParseClientSubnet(s string) (*ClientSubnet, error) {
+	s = strings.TrimSpace(s)
+	if s == "auto" {
+		return &ClientSubnet{Auto: true}, nil
+	}
+
+	if _, ipnet, err := net.ParseCIDR(s); err == nil {
+		return &ClientSubnet{IPNet: ipnet}, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errECSFormat
+	}
+
+	return &ClientSubnet{IPNet: defaultSubnet(ip)}, nil
+}
+
+// Subnet return the configured subnet, or the detected one when Auto is set
+func (cs *ClientSubnet) Subnet() *net.IPNet {
+	if cs == nil {
+		return nil
+	}
+
+	if cs.Auto {
+		return ecsDetector.subnet()
+	}
+
+	return cs.IPNet
+}
+
+func (cs *ClientSubnet) String() string {
+	if cs == nil {
+		return ""
+	}
+
+	if cs.Auto {
+		return "auto"
+	}
+
+	return cs.IPNet.String()
+}
+
+func defaultSubnet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ecsIPv4PrefixLength, 8*net.IPv4len)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+
+	mask := net.CIDRMask(ecsIPv6PrefixLength, 8*net.IPv6len)
+	return &net.IPNet{IP: ip.To16().Mask(mask), Mask: mask}
+}
+
+// subnetDetector keeps the detected subnets, the detection runs in the background so that
+// the queries never wait for it
+type subnetDetector struct {
+	detect func(qtype uint16) (*net.IPNet, error)
+
+	ipv4    atomic.Value
+	ipv6    atomic.Value
+	last    time.Time
+	running bool
+	mux     sync.Mutex
+}
+
+// subnet return the detected subnet, ipv4 is preferred, nil until the first detection finishes
+func (d *subnetDetector) subnet() *net.IPNet {
+	d.refresh()
+
+	if ipnet, _ := d.ipv4.Load().(*net.IPNet); ipnet != nil {
+		return ipnet
+	}
+	ipnet, _ := d.ipv6.Load().(*net.IPNet)
+	return ipnet
+}
+
+// refresh start a detection in the background if the last one is older than ecsDetectInterval
+func (d *subnetDetector) refresh() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.running || (!d.last.IsZero() && time.Since(d.last) < ecsDetectInterval) {
+		return
+	}
+	d.running = true
+
+	go func() {
+		// a failed family is dropped, the network may have lost it
+		ipv4, _ := d.detect(D.TypeA)
+		ipv6, _ := d.detect(D.TypeAAAA)
+		d.ipv4.Store(ipv4)
+		d.ipv6.Store(ipv6)
+
+		d.mux.Lock()
+		d.running = false
+		d.last = time.Now()
+		d.mux.Unlock()
+	}()
+}
+
+// detectClientSubnet ask opendns for the public address of the family of qtype
+func detectClientSubnet(qtype uint16) (*net.IPNet, error) {
+	server := ecsDetectServer
+	if qtype == D.TypeAAAA {
+		server = ecsDetectServer6
+	}
+
+	query := &D.Msg{}
+	query.SetQuestion(ecsDetectDomain, qtype)
+
+	client := &D.Client{Timeout: 5 * time.Second, Dialer: dialer.Dialer()}
+	msg, _, err := client.Exchange(query, server)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, answer := range msg.Answer {
+		switch a := answer.(type) {
+		case *D.A:
+			return defaultSubnet(a.A), nil
+		case *D.AAAA:
+			return defaultSubnet(a.AAAA), nil
+		}
+	}
+
+	return nil, fmt.Errorf("detect client subnet failed: no answer from %s", server)
+}
+
+// clientSubnetOf return the subnet carried by msg, or nil
+func clientSubnetOf(msg *D.Msg) *net.IPNet {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		subnet, ok := o.(*D.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		bits := 8 * net.IPv4len
+		if subnet.Family == 2 {
+			bits = 8 * net.IPv6len
+		}
+		mask := net.CIDRMask(int(subnet.SourceNetmask), bits)
+		return &net.IPNet{IP: subnet.Address.Mask(mask), Mask: mask}
+	}
+
+	return nil
+}
+
+// withClientSubnet return a copy of msg carrying the subnet, msg is left untouched
+func withClientSubnet(msg *D.Msg, ipnet *net.IPNet) *D.Msg {
+	msg = msg.Copy()
+
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(D.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+
+	ones, _ := ipnet.Mask.Size()
+	subnet := &D.EDNS0_SUBNET{
+		Code:          D.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+		SourceScope:   0,
+	}
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		subnet.Family = 1
+		subnet.Address = ip4
+	} else {
+		subnet.Family = 2
+		subnet.Address = ipnet.IP.To16()
+	}
+
+	opt.Option = append(opt.Option, subnet)
+	return msg
+}
+
+// stripClientSubnet remove the subnet option from msg in place
+func stripClientSubnet(msg *D.Msg) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if _, ok := o.(*D.EDNS0_SUBNET); ok {
+			continue
+		}
+		options = append(options, o)
+	}
+	opt.Option = options
+}
+
+// apply attach the subnet to a copy of msg unless msg already carries one
+func (cs *ClientSubnet) apply(msg *D.Msg) *D.Msg {
+	subnet := cs.Subnet()
+	if subnet == nil || clientSubnetOf(msg) != nil {
+		return msg
+	}
+
+	return withClientSubnet(msg, subnet)
+}
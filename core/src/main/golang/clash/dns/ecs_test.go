@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCIDR(s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipnet
+}
+
+func TestParseClientSubnet(t *testing.T) {
+	cs, err := ParseClientSubnet("auto")
+	assert.Nil(t, err)
+	assert.True(t, cs.Auto)
+
+	cs, err = ParseClientSubnet("1.2.3.4")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.0/24", cs.String())
+
+	cs, err = ParseClientSubnet("2001:db8:1:2:3::1")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8:1::/56", cs.String())
+
+	cs, err = ParseClientSubnet("10.0.0.1/16")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/16", cs.String())
+
+	_, err = ParseClientSubnet("example.com")
+	assert.Equal(t, errECSFormat, err)
+}
+
+func TestWithClientSubnet(t *testing.T) {
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+
+	msg := withClientSubnet(m, mustCIDR("1.2.3.0/24"))
+	assert.Nil(t, m.IsEdns0())
+	subnet := msg.IsEdns0().Option[0].(*D.EDNS0_SUBNET)
+	assert.Equal(t, uint16(1), subnet.Family)
+	assert.Equal(t, uint8(24), subnet.SourceNetmask)
+	assert.Equal(t, "1.2.3.0/24", clientSubnetOf(msg).String())
+
+	msg = withClientSubnet(m, mustCIDR("2001:db8::/56"))
+	subnet = msg.IsEdns0().Option[0].(*D.EDNS0_SUBNET)
+	assert.Equal(t, uint16(2), subnet.Family)
+	assert.Equal(t, "2001:db8::/56", clientSubnetOf(msg).String())
+
+	stripClientSubnet(msg)
+	assert.Nil(t, clientSubnetOf(msg))
+	assert.NotNil(t, msg.IsEdns0())
+}
+
+func TestClientSubnet_Apply(t *testing.T) {
+	cs := &ClientSubnet{IPNet: mustCIDR("1.2.3.0/24")}
+
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+	assert.Equal(t, "1.2.3.0/24", clientSubnetOf(cs.apply(m)).String())
+
+	// the subnet of the client is kept
+	m = withClientSubnet(m, mustCIDR("5.6.7.0/24"))
+	assert.Equal(t, "5.6.7.0/24", clientSubnetOf(cs.apply(m)).String())
+
+	var none *ClientSubnet
+	assert.Nil(t, clientSubnetOf(none.apply(&D.Msg{})))
+}
+
+func TestSubnetDetector(t *testing.T) {
+	release := make(chan struct{})
+	d := &subnetDetector{detect: func(qtype uint16) (*net.IPNet, error) {
+		<-release
+		if qtype == D.TypeA {
+			return mustCIDR("1.2.3.0/24"), nil
+		}
+		return mustCIDR("2001:db8::/56"), nil
+	}}
+
+	// the detection never blocks the caller
+	start := time.Now()
+	assert.Nil(t, d.subnet())
+	assert.True(t, time.Since(start) < time.Second)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		subnet := d.subnet()
+		return subnet != nil && subnet.String() == "1.2.3.0/24"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "2001:db8::/56", d.ipv6.Load().(*net.IPNet).String())
+}
+
+func TestResolver_CacheKey(t *testing.T) {
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+
+	plain := (&Resolver{}).cacheKey(m)
+
+	r := &Resolver{subnets: []*ClientSubnet{{IPNet: mustCIDR("1.2.3.0/24")}}}
+	fixed := r.cacheKey(m)
+	assert.NotEqual(t, plain, fixed)
+	assert.Contains(t, fixed, "1.2.3.0/24")
+
+	other := &Resolver{subnets: []*ClientSubnet{{IPNet: mustCIDR("5.6.7.0/24")}}}
+	assert.NotEqual(t, fixed, other.cacheKey(m))
+
+	// the subnet of the client wins over the nameservers
+	withSubnet := withClientSubnet(m, mustCIDR("5.6.7.0/24"))
+	assert.Equal(t, other.cacheKey(m), r.cacheKey(withSubnet))
+}
+
+// echoSubnetClient answer as stubClient and echo the subnet of the question
+type echoSubnetClient struct {
+	stubClient
+}
+
+func (ec *echoSubnetClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	msg, _ := ec.stubClient.ExchangeContext(ctx, m)
+	if subnet := clientSubnetOf(m); subnet != nil {
+		msg = withClientSubnet(msg, subnet)
+	}
+	return msg, nil
+}
+
+func TestResolver_StripCachedSubnet(t *testing.T) {
+	r := newStubResolver()
+	r.main = []dnsClient{&echoSubnetClient{}}
+
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+	m = withClientSubnet(m, mustCIDR("1.2.3.0/24"))
+
+	// the caller gets the subnet of the reply, the cache doesn't keep it
+	msg, err := r.exchange(m, &Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.0/24", clientSubnetOf(msg).String())
+
+	cached, _ := r.cache.GetWithExpire(r.cacheKey(m))
+	assert.NotNil(t, cached)
+	assert.Nil(t, clientSubnetOf(cached.(*D.Msg)))
+}
@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	D "github.com/miekg/dns"
+)
+
+// LookupResult is the reply of Lookup
+type LookupResult struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Rcode      string   `json:"rcode"`
+	Answer     []string `json:"answer"`
+	Authority  []string `json:"authority"`
+	Additional []string `json:"additional"`
+	Upstream   string   `json:"upstream"`
+	TTL        uint32   `json:"ttl"`
+	Cache      bool     `json:"cache"`
+	FakeIP     bool     `json:"fakeip"`
+}
+
+// Lookup answer name with qtype through the handler of the dns server, as if client asked it
+func (r *Resolver) Lookup(name string, qtype uint16, client string) (*LookupResult, error) {
+	m := &D.Msg{}
+	m.SetQuestion(D.Fqdn(name), qtype)
+
+	w := &lookupWriter{client: lookupAddr(client)}
+	NewHandler(r)(w, m)
+
+	if w.query == nil || w.msg == nil {
+		return nil, errors.New("no reply from the dns handler")
+	}
+	if w.query.Error != "" {
+		return nil, errors.New(w.query.Error)
+	}
+
+	var ttl uint32
+	if w.query.FakeIP {
+		ttl = 1
+	} else if _, expireTime := r.cache.GetWithExpire(r.cacheKey(m)); !expireTime.IsZero() {
+		ttl = uint32(time.Until(expireTime).Seconds())
+	}
+
+	return newLookupResult(w.msg, w.query, ttl), nil
+}
+
+// newQueryFrom start the record of q asked through w, a lookupWriter keeps it for the result
+func newQueryFrom(w D.ResponseWriter, q D.Question) *Query {
+	query := newQuery(q, w.RemoteAddr().String(), time.Now())
+	if lw, ok := w.(*lookupWriter); ok {
+		lw.query = query
+	}
+	return query
+}
+
+// lookupAddr is the client address of Lookup as it was given
+type lookupAddr string
+
+func (a lookupAddr) Network() string { return "tcp" }
+func (a lookupAddr) String() string  { return string(a) }
+
+// lookupWriter keep the reply of the handler and the record of the query for Lookup
+type lookupWriter struct {
+	client lookupAddr
+	msg    *D.Msg
+	query  *Query
+}
+
+func (w *lookupWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (w *lookupWriter) RemoteAddr() net.Addr {
+	return w.client
+}
+
+func (w *lookupWriter) WriteMsg(msg *D.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func (w *lookupWriter) Write(buf []byte) (int, error) {
+	msg := &D.Msg{}
+	if err := msg.Unpack(buf); err != nil {
+		return 0, err
+	}
+	w.msg = msg
+	return len(buf), nil
+}
+
+func (w *lookupWriter) Close() error {
+	return nil
+}
+
+func (w *lookupWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *lookupWriter) TsigTimersOnly(bool) {
+}
+
+func (w *lookupWriter) Hijack() {
+}
+
+func newLookupResult(msg *D.Msg, query *Query, ttl uint32) *LookupResult {
+	return &LookupResult{
+		Name:       query.Domain,
+		Type:       query.Type,
+		Rcode:      D.RcodeToString[msg.Rcode],
+		Answer:     rrToString(msg.Answer),
+		Authority:  rrToString(msg.Ns),
+		Additional: rrToString(msg.Extra),
+		Upstream:   query.Upstream,
+		TTL:        ttl,
+		Cache:      query.Cache,
+		FakeIP:     query.FakeIP,
+	}
+}
+
+func rrToString(rrs []D.RR) []string {
+	ret := []string{}
+	for _, rr := range rrs {
+		ret = append(ret, rr.String())
+	}
+	return ret
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	trie "github.com/Dreamacro/clash/component/domain-trie"
+	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/resolver"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// failClient fail every exchange
+type failClient struct{}
+
+func (fc *failClient) Address() string {
+	return "fail"
+}
+
+func (fc *failClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return fc.ExchangeContext(context.Background(), m)
+}
+
+func (fc *failClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	return nil, errors.New("refused")
+}
+
+func TestResolver_Lookup(t *testing.T) {
+	r := newStubResolver()
+
+	result, err := r.Lookup("example.com", D.TypeA, "127.0.0.1:1053")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", result.Name)
+	assert.Equal(t, "A", result.Type)
+	assert.Equal(t, "NOERROR", result.Rcode)
+	assert.Len(t, result.Answer, 1)
+	assert.Equal(t, "stub", result.Upstream)
+	assert.False(t, result.Cache)
+
+	// the second lookup is answered from the cache
+	result, err = r.Lookup("example.com", D.TypeA, "127.0.0.1:1053")
+	assert.Nil(t, err)
+	assert.True(t, result.Cache)
+	assert.NotZero(t, result.TTL)
+}
+
+func TestResolver_LookupFakeIP(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("198.18.0.0/16")
+	pool, err := fakeip.New(ipnet, 10, nil)
+	assert.Nil(t, err)
+
+	r := newStubResolver()
+	r.fakeip = true
+	r.pool = pool
+
+	result, err := r.Lookup("example.com", D.TypeA, "")
+	assert.Nil(t, err)
+	assert.True(t, result.FakeIP)
+	assert.Equal(t, uint32(1), result.TTL)
+	assert.Len(t, result.Answer, 1)
+
+	// the same empty reply as the dns server, not a failure
+	result, err = r.Lookup("example.com", D.TypeAAAA, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "NOERROR", result.Rcode)
+	assert.Empty(t, result.Answer)
+}
+
+func TestResolver_LookupFakeIPFilter(t *testing.T) {
+	host := trie.New()
+	host.Insert("example.com", true)
+	_, ipnet, _ := net.ParseCIDR("198.18.0.0/16")
+	pool, err := fakeip.New(ipnet, 10, host)
+	assert.Nil(t, err)
+
+	r := newStubResolver()
+	r.fakeip = true
+	r.pool = pool
+
+	// the filtered host is only recorded by the upstream query
+	result, err := r.Lookup("example.com", D.TypeA, "")
+	assert.Nil(t, err)
+	assert.False(t, result.FakeIP)
+	assert.Equal(t, "stub", result.Upstream)
+}
+
+func TestResolver_LookupIPVersion(t *testing.T) {
+	r := newStubResolver()
+	r.ipv6 = true
+	resolver.SetDefaultIPVersion(resolver.IPv4Only)
+	defer func() { resolver.SetDefaultIPVersion(resolver.DualStack) }()
+
+	result, err := r.Lookup("example.com", D.TypeAAAA, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "NOERROR", result.Rcode)
+	assert.Empty(t, result.Answer)
+	assert.Empty(t, result.Upstream)
+}
+
+func TestResolver_LookupFailed(t *testing.T) {
+	r := newStubResolver()
+	r.main = []dnsClient{&failClient{}}
+
+	_, err := r.Lookup("example.com", D.TypeA, "")
+	assert.NotNil(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/log"
 
 	D "github.com/miekg/dns"
@@ -12,46 +13,88 @@ import (
 type handler func(w D.ResponseWriter, r *D.Msg)
 type middleware func(next handler) handler
 
+// fakeIPReply answer the A query r with a fake ip, nil if the host skip fake-ip
+func fakeIPReply(fakePool *fakeip.Pool, r *D.Msg) *D.Msg {
+	q := r.Question[0]
+	host := strings.TrimRight(q.Name, ".")
+	if fakePool.LookupHost(host) {
+		return nil
+	}
+
+	rr := &D.A{}
+	rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: dnsDefaultTTL}
+	ip := fakePool.Lookup(host)
+	rr.A = ip
+	msg := r.Copy()
+	msg.Answer = []D.RR{rr}
+
+	setMsgTTL(msg, 1)
+	msg.SetRcode(r, msg.Rcode)
+	msg.Authoritative = true
+	return msg
+}
+
 func withFakeIP(fakePool *fakeip.Pool) middleware {
 	return func(next handler) handler {
 		return func(w D.ResponseWriter, r *D.Msg) {
 			q := r.Question[0]
 
 			if q.Qtype == D.TypeAAAA {
-				D.HandleFailed(w, r)
+				// the fake ips are ipv4, the clients fall back to them
+				query := newQueryFrom(w, q)
+				msg := emptyReply(r)
+				recordQuery(query, msg, nil)
+
+				w.WriteMsg(msg)
 				return
 			} else if q.Qtype != D.TypeA {
 				next(w, r)
 				return
 			}
 
-			host := strings.TrimRight(q.Name, ".")
-			if fakePool.LookupHost(host) {
+			msg := fakeIPReply(fakePool, r)
+			if msg == nil {
 				next(w, r)
 				return
 			}
 
-			rr := &D.A{}
-			rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: dnsDefaultTTL}
-			ip := fakePool.Lookup(host)
-			rr.A = ip
-			msg := r.Copy()
-			msg.Answer = []D.RR{rr}
+			// the query is recorded by next when it isn't answered here
+			query := newQueryFrom(w, q)
+			query.FakeIP = true
+			recordQuery(query, msg, nil)
 
-			setMsgTTL(msg, 1)
-			msg.SetRcode(r, msg.Rcode)
-			msg.Authoritative = true
 			w.WriteMsg(msg)
 			return
 		}
 	}
 }
 
+// withIPVersion answer the queries of the family disabled by the global ip-version without any record
+func withIPVersion() middleware {
+	return func(next handler) handler {
+		return func(w D.ResponseWriter, r *D.Msg) {
+			q := r.Question[0]
+
+			version := resolver.DefaultIPVersion()
+			if !(q.Qtype == D.TypeAAAA && version == resolver.IPv4Only) && !(q.Qtype == D.TypeA && version == resolver.IPv6Only) {
+				next(w, r)
+				return
+			}
+
+			query := newQueryFrom(w, q)
+			msg := emptyReply(r)
+			recordQuery(query, msg, nil)
+
+			w.WriteMsg(msg)
+		}
+	}
+}
+
 func withResolver(resolver *Resolver) handler {
 	return func(w D.ResponseWriter, r *D.Msg) {
-		msg, err := resolver.Exchange(r)
+		q := r.Question[0]
+		msg, err := resolver.exchangeRecord(r, newQueryFrom(w, q))
 		if err != nil {
-			q := r.Question[0]
 			log.Debugln("[DNS Server] Exchange %s failed: %v", q.String(), err)
 			D.HandleFailed(w, r)
 			return
@@ -80,6 +123,7 @@ func NewHandler(resolver *Resolver) handler {
 	if resolver.FakeIPEnabled() {
 		middlewares = append(middlewares, withFakeIP(resolver.pool))
 	}
+	middlewares = append(middlewares, withIPVersion())
 
 	return compose(middlewares, withResolver(resolver))
 }
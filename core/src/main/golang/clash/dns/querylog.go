@@ -0,0 +1,141 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/observable"
+
+	D "github.com/miekg/dns"
+)
+
+// queryLogSize is the number of queries kept in memory
+const queryLogSize = 1024
+
+var (
+	queryCh     = make(chan interface{})
+	querySource = observable.NewObservable(queryCh)
+	queryLog    = newQueryLog(queryLogSize)
+)
+
+// Query is a record of one dns query answered by clash
+type Query struct {
+	Time     time.Time `json:"time"`
+	Domain   string    `json:"domain"`
+	Type     string    `json:"type"`
+	Upstream string    `json:"upstream"`
+	Latency  int64     `json:"latency"`
+	Answer   []string  `json:"answer"`
+	Cache    bool      `json:"cache"`
+	FakeIP   bool      `json:"fakeip"`
+	Client   string    `json:"client"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Statistics count the queries since clash started
+type Statistics struct {
+	Total  int64 `json:"total"`
+	Cache  int64 `json:"cache"`
+	FakeIP int64 `json:"fakeip"`
+	Failed int64 `json:"failed"`
+}
+
+type queryRing struct {
+	mux     sync.Mutex
+	queries []*Query
+	next    int
+	full    bool
+	stats   Statistics
+}
+
+func newQueryLog(size int) *queryRing {
+	return &queryRing{queries: make([]*Query, size)}
+}
+
+func (qr *queryRing) put(q *Query) {
+	qr.mux.Lock()
+	defer qr.mux.Unlock()
+
+	qr.queries[qr.next] = q
+	qr.next = (qr.next + 1) % len(qr.queries)
+	if qr.next == 0 {
+		qr.full = true
+	}
+
+	qr.stats.Total++
+	if q.Cache {
+		qr.stats.Cache++
+	}
+	if q.FakeIP {
+		qr.stats.FakeIP++
+	}
+	if q.Error != "" {
+		qr.stats.Failed++
+	}
+}
+
+func (qr *queryRing) snapshot() ([]*Query, Statistics) {
+	qr.mux.Lock()
+	defer qr.mux.Unlock()
+
+	if !qr.full {
+		return append([]*Query{}, qr.queries[:qr.next]...), qr.stats
+	}
+
+	queries := make([]*Query, 0, len(qr.queries))
+	queries = append(queries, qr.queries[qr.next:]...)
+	queries = append(queries, qr.queries[:qr.next]...)
+	return queries, qr.stats
+}
+
+// Queries return the recent queries, the oldest first
+func Queries() []*Query {
+	queries, _ := queryLog.snapshot()
+	return queries
+}
+
+// QueryStatistics return the statistics of all queries
+func QueryStatistics() Statistics {
+	_, stats := queryLog.snapshot()
+	return stats
+}
+
+// SubscribeQuery receive every *Query recorded from now on
+func SubscribeQuery() observable.Subscription {
+	sub, _ := querySource.Subscribe()
+	return sub
+}
+
+func UnSubscribeQuery(sub observable.Subscription) {
+	querySource.UnSubscribe(sub)
+}
+
+func newQuery(q D.Question, client string, start time.Time) *Query {
+	return &Query{
+		Time:   start,
+		Domain: strings.TrimRight(q.Name, "."),
+		Type:   D.Type(q.Qtype).String(),
+		Client: client,
+		Answer: []string{},
+	}
+}
+
+// recordQuery finish query with the reply and put it into the log
+func recordQuery(query *Query, msg *D.Msg, err error) {
+	query.Latency = time.Since(query.Time).Milliseconds()
+	if err != nil {
+		query.Error = err.Error()
+	}
+
+	if msg != nil {
+		for _, rr := range msg.Answer {
+			// the rdata of rr, e.g. 1.1.1.1 for A
+			answer := strings.TrimPrefix(rr.String(), rr.Header().String())
+			query.Answer = append(query.Answer, answer)
+		}
+	}
+
+	queryLog.put(query)
+	queryCh <- query
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRing_Snapshot(t *testing.T) {
+	qr := newQueryLog(2)
+	qr.put(&Query{Domain: "a.com", Cache: true})
+	qr.put(&Query{Domain: "b.com", FakeIP: true})
+	qr.put(&Query{Domain: "c.com", Error: "failed"})
+
+	queries, stats := qr.snapshot()
+	assert.Len(t, queries, 2)
+	assert.Equal(t, "b.com", queries[0].Domain)
+	assert.Equal(t, "c.com", queries[1].Domain)
+	assert.Equal(t, Statistics{Total: 3, Cache: 1, FakeIP: 1, Failed: 1}, stats)
+}
+
+func TestResolver_QueryLog(t *testing.T) {
+	sub := SubscribeQuery()
+	defer UnSubscribeQuery(sub)
+
+	r := newStubResolver()
+	m := &D.Msg{}
+	m.SetQuestion("example.com.", D.TypeA)
+
+	for _, cache := range []bool{false, true} {
+		_, err := r.exchangeFrom(m, "127.0.0.1:2053")
+		assert.Nil(t, err)
+
+		// the queries of the former tests could be published after the subscription
+		var query *Query
+		timeout := time.After(time.Second)
+		for query == nil || query.Client != "127.0.0.1:2053" {
+			select {
+			case elm := <-sub:
+				query = elm.(*Query)
+			case <-timeout:
+				assert.FailNow(t, "query not recorded")
+			}
+		}
+
+		assert.Equal(t, "example.com", query.Domain)
+		assert.Equal(t, "A", query.Type)
+		assert.Equal(t, []string{"1.2.3.4"}, query.Answer)
+		assert.Equal(t, cache, query.Cache)
+		if !cache {
+			assert.Equal(t, "stub", query.Upstream)
+		}
+	}
+}
+
+func TestRecordQuery_Error(t *testing.T) {
+	query := newQuery(D.Question{Name: "example.com.", Qtype: D.TypeAAAA}, "", time.Now())
+	recordQuery(query, nil, errors.New("All DNS requests failed"))
+
+	assert.Equal(t, "AAAA", query.Type)
+	assert.Equal(t, "All DNS requests failed", query.Error)
+	assert.Empty(t, query.Answer)
+}
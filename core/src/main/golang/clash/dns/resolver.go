@@ -25,11 +25,14 @@ var (
 type dnsClient interface {
 	Exchange(m *D.Msg) (msg *D.Msg, err error)
 	ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error)
+	// Address return the upstream in the form of nameserver config
+	Address() string
 }
 
 type result struct {
-	Msg   *D.Msg
-	Error error
+	Msg      *D.Msg
+	Upstream string
+	Error    error
 }
 
 type Resolver struct {
@@ -42,10 +45,15 @@ type Resolver struct {
 	fallbackFilters []fallbackFilter
 	group           singleflight.Group
 	cache           *cache.Cache
+	subnets         []*ClientSubnet
 }
 
 // ResolveIP request with TypeA and TypeAAAA, priority return TypeA
 func (r *Resolver) ResolveIP(host string) (ip net.IP, err error) {
+	if !r.ipv6 {
+		return r.resolveIP(host, D.TypeA)
+	}
+
 	ch := make(chan net.IP, 1)
 	go func() {
 		defer close(ch)
@@ -79,6 +87,16 @@ func (r *Resolver) ResolveIPv6(host string) (ip net.IP, err error) {
 	return r.resolveIP(host, D.TypeAAAA)
 }
 
+// ResolveAllIPv4 request with TypeA, return all the addresses of the answer
+func (r *Resolver) ResolveAllIPv4(host string) (ips []net.IP, err error) {
+	return r.resolveAllIP(host, D.TypeA)
+}
+
+// ResolveAllIPv6 request with TypeAAAA, return all the addresses of the answer
+func (r *Resolver) ResolveAllIPv6(host string) (ips []net.IP, err error) {
+	return r.resolveAllIP(host, D.TypeAAAA)
+}
+
 func (r *Resolver) shouldFallback(ip net.IP) bool {
 	for _, filter := range r.fallbackFilters {
 		if filter.Match(ip) {
@@ -90,15 +108,40 @@ func (r *Resolver) shouldFallback(ip net.IP) bool {
 
 // Exchange a batch of dns request, and it use cache
 func (r *Resolver) Exchange(m *D.Msg) (msg *D.Msg, err error) {
+	return r.exchangeFrom(m, "")
+}
+
+// exchangeFrom is Exchange with the query of client recorded, client is empty for clash itself
+func (r *Resolver) exchangeFrom(m *D.Msg, client string) (*D.Msg, error) {
 	if len(m.Question) == 0 {
 		return nil, errors.New("should have one question at least")
 	}
 
+	return r.exchangeRecord(m, newQuery(m.Question[0], client, time.Now()))
+}
+
+// exchangeRecord exchange m and put query into the log
+func (r *Resolver) exchangeRecord(m *D.Msg, query *Query) (msg *D.Msg, err error) {
+	defer func() {
+		recordQuery(query, msg, err)
+	}()
+
+	if m.Question[0].Qtype == D.TypeAAAA && !r.ipv6 {
+		return emptyReply(m), nil
+	}
+
+	return r.exchange(m, query)
+}
+
+// exchange fill the upstream and cache flag of query while exchanging m
+func (r *Resolver) exchange(m *D.Msg, query *Query) (msg *D.Msg, err error) {
 	q := m.Question[0]
-	cache, expireTime := r.cache.GetWithExpire(q.String())
+	key := r.cacheKey(m)
+	cache, expireTime := r.cache.GetWithExpire(key)
 	if cache != nil {
 		msg = cache.(*D.Msg).Copy()
 		setMsgTTL(msg, uint32(expireTime.Sub(time.Now()).Seconds()))
+		query.Cache = true
 		return
 	}
 	defer func() {
@@ -106,32 +149,54 @@ func (r *Resolver) Exchange(m *D.Msg) (msg *D.Msg, err error) {
 			return
 		}
 
-		putMsgToCache(r.cache, q.String(), msg)
+		// msg is shared by the callers of the group, only the cached copy is stripped
+		cached := msg.Copy()
+		stripClientSubnet(cached)
+		putMsgToCache(r.cache, key, cached)
 		if r.mapping {
-			ips := r.msgToIP(msg)
+			ips := r.msgToIP(cached)
 			for _, ip := range ips {
-				putMsgToCache(r.cache, ip.String(), msg)
+				putMsgToCache(r.cache, ip.String(), cached)
 			}
 		}
 	}()
 
-	ret, err, _ := r.group.Do(q.String(), func() (interface{}, error) {
+	ret, err, _ := r.group.Do(key, func() (interface{}, error) {
 		isIPReq := isIPRequest(q)
 		if isIPReq {
-			msg, err := r.fallbackExchange(m)
-			return msg, err
+			res := r.fallbackExchange(m)
+			return res, res.Error
 		}
 
-		return r.batchExchange(r.main, m)
+		res := r.batchExchange(r.main, m)
+		return res, res.Error
 	})
 
 	if err == nil {
-		msg = ret.(*D.Msg)
+		res := ret.(*result)
+		msg = res.Msg
+		query.Upstream = res.Upstream
 	}
 
 	return
 }
 
+// cacheKey distinguish the same question sent with different client subnets, the subnet carried
+// by m is sent as it is, otherwise the nameservers attach their own
+func (r *Resolver) cacheKey(m *D.Msg) string {
+	key := m.Question[0].String()
+	if subnet := clientSubnetOf(m); subnet != nil {
+		return key + subnet.String()
+	}
+
+	for _, cs := range r.subnets {
+		if subnet := cs.Subnet(); subnet != nil {
+			key += subnet.String()
+		}
+	}
+	return key
+}
+
 // IPToHost return fake-ip or redir-host mapping host
 func (r *Resolver) IPToHost(ip net.IP) (string, bool) {
 	if r.fakeip {
@@ -163,30 +228,31 @@ func (r *Resolver) IsFakeIP(ip net.IP) bool {
 	return false
 }
 
-func (r *Resolver) batchExchange(clients []dnsClient, m *D.Msg) (msg *D.Msg, err error) {
+func (r *Resolver) batchExchange(clients []dnsClient, m *D.Msg) *result {
 	fast, ctx := picker.WithTimeout(context.Background(), time.Second*5)
 	for _, client := range clients {
 		r := client
 		fast.Go(func() (interface{}, error) {
-			return r.ExchangeContext(ctx, m)
+			msg, err := r.ExchangeContext(ctx, m)
+			if err != nil {
+				return nil, err
+			}
+			return &result{Msg: msg, Upstream: r.Address()}, nil
 		})
 	}
 
 	elm := fast.Wait()
 	if elm == nil {
-		return nil, errors.New("All DNS requests failed")
+		return &result{Error: errors.New("All DNS requests failed")}
 	}
 
-	msg = elm.(*D.Msg)
-	return
+	return elm.(*result)
 }
 
-func (r *Resolver) fallbackExchange(m *D.Msg) (msg *D.Msg, err error) {
+func (r *Resolver) fallbackExchange(m *D.Msg) *result {
 	msgCh := r.asyncExchange(r.main, m)
 	if r.fallback == nil {
-		res := <-msgCh
-		msg, err = res.Msg, res.Error
-		return
+		return <-msgCh
 	}
 	fallbackMsg := r.asyncExchange(r.fallback, m)
 	res := <-msgCh
@@ -194,30 +260,41 @@ func (r *Resolver) fallbackExchange(m *D.Msg) (msg *D.Msg, err error) {
 		if ips := r.msgToIP(res.Msg); len(ips) != 0 {
 			if r.shouldFallback(ips[0]) {
 				go func() { <-fallbackMsg }()
-				msg = res.Msg
-				return msg, err
+				return res
 			}
 		}
 	}
 
-	res = <-fallbackMsg
-	msg, err = res.Msg, res.Error
-	return
+	return <-fallbackMsg
 }
 
 func (r *Resolver) resolveIP(host string, dnsType uint16) (ip net.IP, err error) {
-	ip = net.ParseIP(host)
+	ips, err := r.resolveAllIP(host, dnsType)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips[rand.Intn(len(ips))], nil
+}
+
+func (r *Resolver) resolveAllIP(host string, dnsType uint16) (ips []net.IP, err error) {
+	ip := net.ParseIP(host)
 	if ip != nil {
 		isIPv4 := ip.To4() != nil
 		if dnsType == D.TypeAAAA && !isIPv4 {
-			return ip, nil
+			return []net.IP{ip}, nil
 		} else if dnsType == D.TypeA && isIPv4 {
-			return ip, nil
+			return []net.IP{ip}, nil
 		} else {
 			return nil, resolver.ErrIPVersion
 		}
 	}
 
+	// the AAAA queries would be answered empty anyway, see exchangeRecord
+	if dnsType == D.TypeAAAA && !r.ipv6 {
+		return nil, resolver.ErrIPv6Disabled
+	}
+
 	query := &D.Msg{}
 	query.SetQuestion(D.Fqdn(host), dnsType)
 
@@ -226,14 +303,12 @@ func (r *Resolver) resolveIP(host string, dnsType uint16) (ip net.IP, err error)
 		return nil, err
 	}
 
-	ips := r.msgToIP(msg)
-	ipLength := len(ips)
-	if ipLength == 0 {
+	ips = r.msgToIP(msg)
+	if len(ips) == 0 {
 		return nil, resolver.ErrIPNotFound
 	}
 
-	ip = ips[rand.Intn(ipLength)]
-	return
+	return ips, nil
 }
 
 func (r *Resolver) msgToIP(msg *D.Msg) []net.IP {
@@ -254,15 +329,15 @@ func (r *Resolver) msgToIP(msg *D.Msg) []net.IP {
 func (r *Resolver) asyncExchange(client []dnsClient, msg *D.Msg) <-chan *result {
 	ch := make(chan *result)
 	go func() {
-		res, err := r.batchExchange(client, msg)
-		ch <- &result{Msg: res, Error: err}
+		ch <- r.batchExchange(client, msg)
 	}()
 	return ch
 }
 
 type NameServer struct {
-	Net  string
-	Addr string
+	Net          string
+	Addr         string
+	ClientSubnet *ClientSubnet
 }
 
 type FallbackFilter struct {
@@ -277,25 +352,42 @@ type Config struct {
 	EnhancedMode   EnhancedMode
 	FallbackFilter FallbackFilter
 	Pool           *fakeip.Pool
+	ClientSubnet   *ClientSubnet
 }
 
 func New(config Config) *Resolver {
 	defaultResolver := &Resolver{
+		ipv6:  config.IPv6,
 		main:  transform(config.Default, nil),
 		cache: cache.New(time.Second * 60),
 	}
 
+	main := withDefaultSubnet(config.Main, config.ClientSubnet)
+	fallback := withDefaultSubnet(config.Fallback, config.ClientSubnet)
+
 	r := &Resolver{
 		ipv6:    config.IPv6,
-		main:    transform(config.Main, defaultResolver),
+		main:    transform(main, defaultResolver),
 		cache:   cache.New(time.Second * 60),
 		mapping: config.EnhancedMode == MAPPING,
 		fakeip:  config.EnhancedMode == FAKEIP,
 		pool:    config.Pool,
 	}
 
-	if len(config.Fallback) != 0 {
-		r.fallback = transform(config.Fallback, defaultResolver)
+	if len(fallback) != 0 {
+		r.fallback = transform(fallback, defaultResolver)
+	}
+
+	seen := map[string]bool{}
+	for _, ns := range append(main, fallback...) {
+		if ns.ClientSubnet != nil && !seen[ns.ClientSubnet.String()] {
+			seen[ns.ClientSubnet.String()] = true
+			r.subnets = append(r.subnets, ns.ClientSubnet)
+		}
+		// start detecting early, the first queries go without a subnet until it is done
+		if ns.ClientSubnet != nil && ns.ClientSubnet.Auto {
+			ecsDetector.refresh()
+		}
 	}
 
 	fallbackFilters := []fallbackFilter{}
@@ -1,14 +1,27 @@
 package dns
 
 import (
+	"crypto/tls"
 	"net"
+	"net/http"
+	"sync"
 
 	D "github.com/miekg/dns"
 )
 
 var (
-	address string
-	server  = &Server{}
+	address      string
+	tlsAddress   string
+	httpsAddress string
+
+	// every listener serves with the handler of server
+	server      = &Server{}
+	tcpServer   *D.Server
+	tlsServer   *D.Server
+	httpsServer *http.Server
+
+	certificate *tls.Certificate
+	certMux     sync.RWMutex
 
 	dnsDefaultTTL uint32 = 600
 )
@@ -31,6 +44,25 @@ func (s *Server) SetHandler(handler handler) {
 	s.handler = handler
 }
 
+func setCertificate(cert *tls.Certificate) {
+	certMux.Lock()
+	defer certMux.Unlock()
+	certificate = cert
+}
+
+// getCertificate let the tls listeners pick up a new certificate without restart
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMux.RLock()
+	defer certMux.RUnlock()
+	return certificate, nil
+}
+
+func portIsZero(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return port == "0" || port == "" || err != nil
+}
+
+// ReCreateServer serve dns on both udp and tcp of addr
 func ReCreateServer(addr string, resolver *Resolver) error {
 	if addr == address && resolver != nil {
 		handler := NewHandler(resolver)
@@ -40,11 +72,16 @@ func ReCreateServer(addr string, resolver *Resolver) error {
 
 	if server.Server != nil {
 		server.Shutdown()
+		server.Server = nil
 		address = ""
 	}
 
-	_, port, err := net.SplitHostPort(addr)
-	if port == "0" || port == "" || err != nil {
+	if tcpServer != nil {
+		tcpServer.Shutdown()
+		tcpServer = nil
+	}
+
+	if portIsZero(addr) {
 		return nil
 	}
 
@@ -58,13 +95,97 @@ func ReCreateServer(addr string, resolver *Resolver) error {
 		return err
 	}
 
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		p.Close()
+		return err
+	}
+
 	address = addr
-	handler := NewHandler(resolver)
-	server = &Server{handler: handler}
+	server.SetHandler(NewHandler(resolver))
 	server.Server = &D.Server{Addr: addr, PacketConn: p, Handler: server}
+	tcpServer = &D.Server{Addr: addr, Net: "tcp", Listener: l, Handler: server}
+
+	go server.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	return nil
+}
+
+// ReCreateTLSServer serve dns over tls on addr
+func ReCreateTLSServer(addr string, cert *tls.Certificate, resolver *Resolver) error {
+	if cert != nil {
+		setCertificate(cert)
+	}
+
+	if addr == tlsAddress && resolver != nil {
+		server.SetHandler(NewHandler(resolver))
+		return nil
+	}
+
+	if tlsServer != nil {
+		tlsServer.Shutdown()
+		tlsServer = nil
+		tlsAddress = ""
+	}
+
+	if portIsZero(addr) {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	tlsAddress = addr
+	server.SetHandler(NewHandler(resolver))
+	tlsServer = &D.Server{
+		Addr:     addr,
+		Net:      "tcp-tls",
+		Listener: tls.NewListener(l, &tls.Config{GetCertificate: getCertificate}),
+		Handler:  server,
+	}
+
+	go tlsServer.ActivateAndServe()
+	return nil
+}
+
+// ReCreateHTTPSServer serve dns over https on addr
+func ReCreateHTTPSServer(addr string, cert *tls.Certificate, resolver *Resolver) error {
+	if cert != nil {
+		setCertificate(cert)
+	}
+
+	if addr == httpsAddress && resolver != nil {
+		server.SetHandler(NewHandler(resolver))
+		return nil
+	}
+
+	if httpsServer != nil {
+		httpsServer.Close()
+		httpsServer = nil
+		httpsAddress = ""
+	}
+
+	if portIsZero(addr) {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(dohPath, &dohHandler{server: server})
+
+	httpsAddress = addr
+	server.SetHandler(NewHandler(resolver))
+	httpsServer = &http.Server{
+		Handler:   mux,
+		TLSConfig: &tls.Config{GetCertificate: getCertificate},
+	}
 
-	go func() {
-		server.ActivateAndServe()
-	}()
+	go httpsServer.ServeTLS(l, "", "")
 	return nil
 }
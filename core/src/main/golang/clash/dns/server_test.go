@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/tlstest"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubClient answer every question with 1.2.3.4
+type stubClient struct{}
+
+func (sc *stubClient) Address() string {
+	return "stub"
+}
+
+func (sc *stubClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return sc.ExchangeContext(context.Background(), m)
+}
+
+func (sc *stubClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	msg.Answer = append(msg.Answer, &D.A{
+		Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 60},
+		A:   net.IPv4(1, 2, 3, 4),
+	})
+	return msg, nil
+}
+
+func newStubResolver() *Resolver {
+	return &Resolver{
+		main:  []dnsClient{&stubClient{}},
+		cache: cache.New(time.Minute),
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func assertStubAnswer(t *testing.T, msg *D.Msg) {
+	assert.Len(t, msg.Answer, 1)
+	assert.Equal(t, "1.2.3.4", msg.Answer[0].(*D.A).A.String())
+}
+
+func TestServer_UDPAndTCP(t *testing.T) {
+	addr := freeAddr(t)
+	assert.Nil(t, ReCreateServer(addr, newStubResolver()))
+	defer ReCreateServer("", nil)
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+
+	for _, network := range []string{"udp", "tcp"} {
+		client := &D.Client{Net: network, Timeout: time.Second}
+		msg, _, err := client.Exchange(query, addr)
+		assert.Nil(t, err)
+		assertStubAnswer(t, msg)
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	cert, err := tlstest.GenerateCertificate()
+	assert.Nil(t, err)
+
+	addr := freeAddr(t)
+	assert.Nil(t, ReCreateTLSServer(addr, &cert, newStubResolver()))
+	defer ReCreateTLSServer("", nil, nil)
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+
+	client := &D.Client{Net: "tcp-tls", Timeout: time.Second, TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	msg, _, err := client.Exchange(query, addr)
+	assert.Nil(t, err)
+	assertStubAnswer(t, msg)
+}
+
+func TestServer_DoH(t *testing.T) {
+	s := &Server{}
+	s.SetHandler(NewHandler(newStubResolver()))
+	ts := httptest.NewServer(&dohHandler{server: s})
+	defer ts.Close()
+
+	query := &D.Msg{}
+	query.SetQuestion("example.com.", D.TypeA)
+	buf, _ := query.Pack()
+
+	get, err := http.Get(ts.URL + dohPath + "?dns=" + base64.RawURLEncoding.EncodeToString(buf))
+	assert.Nil(t, err)
+	post, err := http.Post(ts.URL+dohPath, dotMimeType, bytes.NewReader(buf))
+	assert.Nil(t, err)
+
+	for _, resp := range []*http.Response{get, post} {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, dotMimeType, resp.Header.Get("content-type"))
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		msg := &D.Msg{}
+		assert.Nil(t, msg.Unpack(body))
+		assert.Equal(t, query.Id, msg.Id)
+		assertStubAnswer(t, msg)
+	}
+
+	resp, err := http.Post(ts.URL+dohPath, "text/plain", bytes.NewReader(buf))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestServer_IPVersion(t *testing.T) {
+	addr := freeAddr(t)
+	r := newStubResolver()
+	r.ipv6 = true
+	assert.Nil(t, ReCreateServer(addr, r))
+	defer ReCreateServer("", nil)
+	defer func() { resolver.SetDefaultIPVersion(resolver.DualStack) }()
+
+	client := &D.Client{Net: "udp", Timeout: time.Second}
+	exchange := func(qtype uint16) *D.Msg {
+		query := &D.Msg{}
+		query.SetQuestion("example.com.", qtype)
+		msg, _, err := client.Exchange(query, addr)
+		assert.Nil(t, err)
+		assert.Equal(t, D.RcodeSuccess, msg.Rcode)
+		return msg
+	}
+
+	resolver.SetDefaultIPVersion(resolver.IPv4Only)
+	assert.Empty(t, exchange(D.TypeAAAA).Answer)
+	assertStubAnswer(t, exchange(D.TypeA))
+
+	resolver.SetDefaultIPVersion(resolver.IPv6Only)
+	assert.Empty(t, exchange(D.TypeA).Answer)
+
+	resolver.SetDefaultIPVersion(resolver.DualStack)
+	r.ipv6 = false
+	assert.Empty(t, exchange(D.TypeAAAA).Answer)
+}
+
+func TestResolver_IPv6Disabled(t *testing.T) {
+	r := newStubResolver()
+
+	_, err := r.ResolveAllIPv6("example.com")
+	assert.Equal(t, resolver.ErrIPv6Disabled, err)
+	_, err = r.ResolveIPv6("example.com")
+	assert.Equal(t, resolver.ErrIPv6Disabled, err)
+
+	// the query is sent to the upstream
+	r.ipv6 = true
+	_, err = r.ResolveAllIPv6("example.com")
+	assert.NotEqual(t, resolver.ErrIPv6Disabled, err)
+}
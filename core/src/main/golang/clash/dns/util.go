@@ -111,6 +111,13 @@ func setMsgTTL(msg *D.Msg, ttl uint32) {
 	}
 }
 
+// emptyReply answer m without any record, the client takes it as the host has no address of the type
+func emptyReply(m *D.Msg) *D.Msg {
+	msg := &D.Msg{}
+	msg.SetReply(m)
+	return msg
+}
+
 func isIPRequest(q D.Question) bool {
 	if q.Qclass == D.ClassINET && (q.Qtype == D.TypeA || q.Qtype == D.TypeAAAA) {
 		return true
@@ -118,11 +125,27 @@ func isIPRequest(q D.Question) bool {
 	return false
 }
 
+func withDefaultSubnet(servers []NameServer, subnet *ClientSubnet) []NameServer {
+	ret := make([]NameServer, 0, len(servers))
+	for _, s := range servers {
+		if s.ClientSubnet == nil {
+			s.ClientSubnet = subnet
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
 func transform(servers []NameServer, resolver *Resolver) []dnsClient {
 	ret := []dnsClient{}
 	for _, s := range servers {
 		if s.Net == "https" {
-			ret = append(ret, newDoHClient(s.Addr, resolver))
+			ret = append(ret, newDoHClient(s.Addr, resolver, s.ClientSubnet))
+			continue
+		}
+
+		if s.Net == "quic" {
+			ret = append(ret, newDoQClient(s.Addr, resolver, s.ClientSubnet))
 			continue
 		}
 
@@ -141,6 +164,7 @@ func transform(servers []NameServer, resolver *Resolver) []dnsClient {
 			port: port,
 			host: host,
 			r:    resolver,
+			ecs:  s.ClientSubnet,
 		})
 	}
 	return ret
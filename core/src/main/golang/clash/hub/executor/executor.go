@@ -78,6 +78,7 @@ func ParseWithBytes(buf []byte) (*config.Config, error) {
 // ApplyConfig dispatch configure to all parts
 func ApplyConfig(cfg *config.Config, force bool) {
 	updateUsers(cfg.Users)
+	updateIPVersion(cfg.General.IPVersion)
 	updateDNS(cfg.DNS)
 	if force {
 		updateGeneral(cfg.General)
@@ -105,6 +106,7 @@ func GetGeneral() *config.General {
 		BindAddress:    P.BindAddress(),
 		Mode:           tunnel.Mode(),
 		LogLevel:       log.Level(),
+		IPVersion:      resolver.DefaultIPVersion(),
 	}
 
 	return general
@@ -114,12 +116,10 @@ func updateExperimental(c *config.Config) {
 	cfg := c.Experimental
 
 	tunnel.UpdateExperimental(cfg.IgnoreResolveFail)
-	if cfg.Interface != "" && c.DNS.Enable {
-		dialer.DialHook = dialer.DialerWithInterface(cfg.Interface)
-		dialer.ListenPacketHook = dialer.ListenPacketWithInterface(cfg.Interface)
+	if cfg.Interface != "" {
+		dialer.SetDefaultOptions(dialer.WithInterface(cfg.Interface))
 	} else {
-		dialer.DialHook = nil
-		dialer.ListenPacketHook = nil
+		dialer.SetDefaultOptions()
 	}
 }
 
@@ -128,6 +128,8 @@ func updateDNS(c *config.DNS) {
 		resolver.DefaultResolver = nil
 		tunnel.SetResolver(nil)
 		dns.ReCreateServer("", nil)
+		dns.ReCreateTLSServer("", nil, nil)
+		dns.ReCreateHTTPSServer("", nil, nil)
 		return
 	}
 	r := dns.New(dns.Config{
@@ -140,17 +142,27 @@ func updateDNS(c *config.DNS) {
 			GeoIP:  c.FallbackFilter.GeoIP,
 			IPCIDR: c.FallbackFilter.IPCIDR,
 		},
-		Default: c.DefaultNameserver,
+		Default:      c.DefaultNameserver,
+		ClientSubnet: c.ClientSubnet,
 	})
 	resolver.DefaultResolver = r
 	tunnel.SetResolver(r)
 	if err := dns.ReCreateServer(c.Listen, r); err != nil {
 		log.Errorln("Start DNS server error: %s", err.Error())
-		return
+	} else if c.Listen != "" {
+		log.Infoln("DNS server listening at: %s", c.Listen)
 	}
 
-	if c.Listen != "" {
-		log.Infoln("DNS server listening at: %s", c.Listen)
+	if err := dns.ReCreateTLSServer(c.TLSListen, c.Certificate, r); err != nil {
+		log.Errorln("Start DNS over TLS server error: %s", err.Error())
+	} else if c.TLSListen != "" {
+		log.Infoln("DNS over TLS server listening at: %s", c.TLSListen)
+	}
+
+	if err := dns.ReCreateHTTPSServer(c.HTTPSListen, c.Certificate, r); err != nil {
+		log.Errorln("Start DNS over HTTPS server error: %s", err.Error())
+	} else if c.HTTPSListen != "" {
+		log.Infoln("DNS over HTTPS server listening at: %s", c.HTTPSListen)
 	}
 }
 
@@ -159,14 +171,22 @@ func updateHosts(tree *trie.Trie) {
 }
 
 func updateProxies(proxies map[string]C.Proxy, providers map[string]provider.ProxyProvider) {
+	oldProxies := tunnel.Proxies()
 	oldProviders := tunnel.Providers()
 
+	// swap first, the new connections mustn't pick the destroyed proxies
+	tunnel.UpdateProxies(proxies, providers)
+
 	// close providers goroutine
 	for _, provider := range oldProviders {
 		provider.Destroy()
 	}
 
-	tunnel.UpdateProxies(proxies, providers)
+	// release the resources of proxies, like the processes of plugins,
+	// after their in-flight connections are closed
+	for _, proxy := range oldProxies {
+		proxy.Destroy()
+	}
 }
 
 func updateRules(rules []C.Rule) {
@@ -201,6 +221,10 @@ func updateGeneral(general *config.General) {
 
 }
 
+func updateIPVersion(version resolver.IPVersion) {
+	resolver.SetDefaultIPVersion(version)
+}
+
 func updateUsers(users []auth.AuthUser) {
 	authenticator := auth.NewAuthenticator(users)
 	authStore.SetAuthenticator(authenticator)
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/dns"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+	D "github.com/miekg/dns"
+)
+
+func dnsRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/queries", getQueries)
+	r.Get("/query", queryDNS)
+	return r
+}
+
+func queryDNS(w http.ResponseWriter, r *http.Request) {
+	res, ok := resolver.DefaultResolver.(*dns.Resolver)
+	if !ok {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, newError("DNS is not enabled"))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	typeText := r.URL.Query().Get("type")
+	if typeText == "" {
+		typeText = "A"
+	}
+
+	qtype, exist := D.StringToType[strings.ToUpper(typeText)]
+	if name == "" || !exist {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	result, err := res.Lookup(name, qtype, r.RemoteAddr)
+	if err != nil {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	render.JSON(w, r, result)
+}
+
+func getQueries(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		render.JSON(w, r, render.M{
+			"queries":    dns.Queries(),
+			"statistics": dns.QueryStatistics(),
+		})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	sub := dns.SubscribeQuery()
+	defer dns.UnSubscribeQuery(sub)
+	buf := &bytes.Buffer{}
+	for elm := range sub {
+		buf.Reset()
+		if err := json.NewEncoder(buf).Encode(elm.(*dns.Query)); err != nil {
+			break
+		}
+
+		if err := conn.WriteMessage(websocket.TextMessage, buf.Bytes()); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package route
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/dns"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSRouter_Query(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("198.18.0.0/16")
+	pool, err := fakeip.New(ipnet, 10, nil)
+	assert.Nil(t, err)
+
+	// fake-ip answers without any upstream
+	resolver.DefaultResolver = dns.New(dns.Config{EnhancedMode: dns.FAKEIP, Pool: pool})
+	defer func() { resolver.DefaultResolver = nil }()
+
+	ts := httptest.NewServer(dnsRouter())
+	defer ts.Close()
+
+	query := func(params string) (int, *dns.LookupResult) {
+		resp, err := http.Get(ts.URL + "/query" + params)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+
+		result := &dns.LookupResult{}
+		json.NewDecoder(resp.Body).Decode(result)
+		return resp.StatusCode, result
+	}
+
+	code, result := query("?name=example.com")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "A", result.Type)
+	assert.True(t, result.FakeIP)
+	assert.Len(t, result.Answer, 1)
+
+	code, result = query("?name=example.com&type=aaaa")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "NOERROR", result.Rcode)
+	assert.Empty(t, result.Answer)
+
+	code, _ = query("")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = query("?name=example.com&type=BOGUS")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	resolver.DefaultResolver = nil
+	code, _ = query("?name=example.com")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -24,6 +24,9 @@ var (
 	httpListener     *http.HttpListener
 	redirListener    *redir.RedirListener
 	tunAdapter       tun.TunAdapter
+
+	// tunConfig is the applied tun config, dns-listen is merged into the hijack of tunAdapter
+	tunConfig config.Tun
 )
 
 type listener interface {
@@ -56,7 +59,8 @@ func Tun() config.Tun {
 	return config.Tun{
 		Enable:    true,
 		DeviceURL: tunAdapter.DeviceURL(),
-		DNSListen: tunAdapter.DNSListen(),
+		DNSListen: tunConfig.DNSListen,
+		DNSHijack: tunConfig.DNSHijack,
 	}
 }
 
@@ -164,10 +168,13 @@ func ReCreateRedir(port int) error {
 func ReCreateTun(conf config.Tun) error {
 	enable := conf.Enable
 	url := conf.DeviceURL
+	hijack := conf.Hijack()
+	tunConfig = conf
+
 	if tunAdapter != nil {
 		if enable && (url == "" || url == tunAdapter.DeviceURL()) {
 			// Though we don't need to recreate tun device, we should update tun DNSServer
-			return tunAdapter.ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), conf.DNSListen)
+			return tunAdapter.ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), hijack)
 		}
 		tunAdapter.Close()
 		tunAdapter = nil
@@ -181,7 +188,7 @@ func ReCreateTun(conf config.Tun) error {
 		return err
 	}
 	if resolver.DefaultResolver != nil {
-		return tunAdapter.ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), conf.DNSListen)
+		return tunAdapter.ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), hijack)
 	}
 	return nil
 }
@@ -1,6 +1,6 @@
 package dev
 
-import "github.com/google/netstack/tcpip/stack"
+import "gvisor.dev/gvisor/pkg/tcpip/stack"
 
 // TunDevice is cross-platform tun interface
 type TunDevice interface {
@@ -4,6 +4,7 @@ package dev
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
@@ -14,11 +15,11 @@ import (
 	"unsafe"
 
 	"github.com/Dreamacro/clash/log"
-	"github.com/google/netstack/tcpip"
-	"github.com/google/netstack/tcpip/buffer"
-	"github.com/google/netstack/tcpip/header"
-	"github.com/google/netstack/tcpip/link/channel"
-	"github.com/google/netstack/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"golang.org/x/sys/unix"
 )
 
@@ -103,9 +104,11 @@ func (t *tunLinux) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 				p = header.IPv6ProtocolNumber
 			}
 			if linkEP.IsAttached() {
-				linkEP.InjectInbound(p, tcpip.PacketBuffer{
-					Data: buffer.View(packet[:n]).ToVectorisedView(),
+				pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Payload: buffer.MakeWithData(packet[:n]),
 				})
+				linkEP.InjectInbound(p, pkt)
+				pkt.DecRef()
 			} else {
 				log.Debugln("Received packet from tun when %s is not attached to any dispatcher.", t.Name())
 			}
@@ -118,17 +121,21 @@ func (t *tunLinux) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 	// start write loop. read ip packet from ipstack and write it to tun
 	go func() {
 		t.wg.Add(1)
-	packetLoop:
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-t.stopW
+			cancel()
+		}()
+
 		for {
-			var packet channel.PacketInfo
-			select {
-			case packet = <-linkEP.C:
-			case <-t.stopW:
-				break packetLoop
+			pkt := linkEP.ReadContext(ctx)
+			if pkt.IsNil() {
+				break
 			}
-			header := packet.Pkt.Header.View()
-			data := packet.Pkt.Data.ToView()
-			_, err := t.Write(buffer.NewVectorisedView(len(header)+len(data), []buffer.View{header, data}).ToView())
+			view := pkt.ToView()
+			_, err := t.Write(view.AsSlice())
+			view.Release()
+			pkt.DecRef()
 			if err != nil {
 				log.Errorln("Can not read from tun: %v", err)
 				break
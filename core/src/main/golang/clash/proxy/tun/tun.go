@@ -6,7 +6,7 @@ import "github.com/Dreamacro/clash/dns"
 type TunAdapter interface {
 	Close()
 	DeviceURL() string
-	// Create creates dns server on tun device
-	ReCreateDNSServer(resolver *dns.Resolver, addr string) error
-	DNSListen() string
+	// ReCreateDNSServer answer dns request to the hijacked addresses on tun device
+	ReCreateDNSServer(resolver *dns.Resolver, hijack []string) error
+	DNSHijack() []string
 }
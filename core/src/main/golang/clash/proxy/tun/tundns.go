@@ -3,38 +3,37 @@ package tun
 import (
 	"fmt"
 	"net"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/log"
-	"github.com/google/netstack/tcpip"
-	"github.com/google/netstack/tcpip/adapters/gonet"
-	"github.com/google/netstack/tcpip/buffer"
-	"github.com/google/netstack/tcpip/header"
-	"github.com/google/netstack/tcpip/network/ipv4"
-	"github.com/google/netstack/tcpip/network/ipv6"
-	"github.com/google/netstack/tcpip/stack"
-	"github.com/google/netstack/tcpip/transport/udp"
 	D "github.com/miekg/dns"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/ports"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
 
 const (
 	defaultTimeout = 5
 )
 
-var (
-	ipv4Zero = tcpip.Address(net.IPv4zero.To4())
-	ipv6Zero = tcpip.Address(net.IPv6zero.To16())
-)
-
 // DNSServer is DNS Server listening on tun devcice
 type DNSServer struct {
 	*dns.Server
 	resolver *dns.Resolver
+	hijack   []string
 
-	stack         *stack.Stack
-	tcpListener   net.Listener
-	udpEndpoint   *dnsEndpoint
-	udpEndpointID *stack.TransportEndpointID
+	stack        *stack.Stack
+	tcpServers   []*D.Server
+	udpEndpoints []*dnsEndpoint
 	tcpip.NICID
 }
 
@@ -44,39 +43,39 @@ type dnsEndpoint struct {
 	stack    *stack.Stack
 	uniqueID uint64
 	server   *dns.Server
+	id       *stack.TransportEndpointID
 }
 
 // Keep track of the source of DNS request
 type dnsResponseWriter struct {
-	s  *stack.Stack
-	r  *stack.Route
-	id stack.TransportEndpointID
+	s     *stack.Stack
+	proto tcpip.NetworkProtocolNumber
+	id    stack.TransportEndpointID
 }
 
 func (e *dnsEndpoint) UniqueID() uint64 {
 	return e.uniqueID
 }
 
-func (e *dnsEndpoint) HandlePacket(r *stack.Route, id stack.TransportEndpointID, pkt tcpip.PacketBuffer) {
-	hdr := header.UDP(pkt.Data.First())
-	if int(hdr.Length()) > pkt.Data.Size() {
+func (e *dnsEndpoint) HandlePacket(id stack.TransportEndpointID, pkt stack.PacketBufferPtr) {
+	hdr := header.UDP(pkt.TransportHeader().Slice())
+	if int(hdr.Length()) > pkt.Data().Size()+header.UDPMinimumSize {
 		// Malformed packet.
 		e.stack.Stats().UDP.MalformedPacketsReceived.Increment()
 		return
 	}
-	pkt.Data.TrimFront(header.UDPMinimumSize)
 
 	// server DNS
 	var msg D.Msg
-	msg.Unpack(pkt.Data.ToView())
-	writer := dnsResponseWriter{s: e.stack, r: r, id: id}
+	msg.Unpack(pkt.Data().AsRange().ToSlice())
+	writer := dnsResponseWriter{s: e.stack, proto: pkt.NetworkProtocolNumber, id: id}
 	go e.server.ServeDNS(&writer, &msg)
 }
 
-func (e *dnsEndpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.ControlType, extra uint32, pkt tcpip.PacketBuffer) {
+func (e *dnsEndpoint) HandleError(transErr stack.TransportError, pkt stack.PacketBufferPtr) {
 }
 
-func (e *dnsEndpoint) Close() {
+func (e *dnsEndpoint) Abort() {
 }
 
 func (e *dnsEndpoint) Wait() {
@@ -84,11 +83,11 @@ func (e *dnsEndpoint) Wait() {
 }
 
 func (w *dnsResponseWriter) LocalAddr() net.Addr {
-	return &net.UDPAddr{IP: net.IP(w.id.LocalAddress), Port: int(w.id.LocalPort)}
+	return &net.UDPAddr{IP: net.IP(w.id.LocalAddress.AsSlice()), Port: int(w.id.LocalPort)}
 }
 
 func (w *dnsResponseWriter) RemoteAddr() net.Addr {
-	return &net.UDPAddr{IP: net.IP(w.id.RemoteAddress), Port: int(w.id.RemotePort)}
+	return &net.UDPAddr{IP: net.IP(w.id.RemoteAddress.AsSlice()), Port: int(w.id.RemotePort)}
 }
 
 func (w *dnsResponseWriter) WriteMsg(msg *D.Msg) error {
@@ -111,135 +110,196 @@ func (w *dnsResponseWriter) Hijack() {
 }
 
 func (w *dnsResponseWriter) Write(b []byte) (int, error) {
-	v := buffer.NewView(len(b))
-	copy(v, b)
-	data := v.ToVectorisedView()
-	return writeUDP(w.r, data, w.id.LocalPort, w.id.RemotePort)
+	return writeUDP(w.s, w.proto, w.id.LocalAddress, w.id.LocalPort, w.id.RemoteAddress, w.id.RemotePort, b)
 }
 
 func (w *dnsResponseWriter) Close() error {
 	return nil
 }
 
-// CreateDNSServer create a dns server on given netstack
-func CreateDNSServer(s *stack.Stack, resolver *dns.Resolver, ip net.IP, port int, nicID tcpip.NICID) (*DNSServer, error) {
+// dnsHijack is an address on the tun NIC answered by the clash resolver
+type dnsHijack struct {
+	network string
+	address tcpip.FullAddress
+	v4      bool
+}
 
-	var v4 bool
-	var err error
+// parseDNSHijack parse `[udp|tcp://]host:port`, host `any` match every destination
+func parseDNSHijack(addr string, nicID tcpip.NICID) (*dnsHijack, error) {
+	network := "udp"
+	if idx := strings.Index(addr, "://"); idx != -1 {
+		network = addr[:idx]
+		addr = addr[idx+3:]
+	}
 
-	address := tcpip.FullAddress{NIC: nicID, Port: uint16(port)}
-	if ip.To4() != nil {
-		v4 = true
-		address.Addr = tcpip.Address(ip.To4())
-		// netstack will only reassemble IP fragments when its' dest ip address is registered in NIC.endpoints
-		s.AddAddress(nicID, ipv4.ProtocolNumber, address.Addr)
-	} else {
-		v4 = false
-		address.Addr = tcpip.Address(ip.To16())
-		s.AddAddress(nicID, ipv6.ProtocolNumber, address.Addr)
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("DNS hijack %s unsupport network: %s", addr, network)
 	}
-	if address.Addr == ipv4Zero || address.Addr == ipv6Zero {
-		address.Addr = ""
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("DNS hijack %s format error: %v", addr, err)
 	}
 
-	handler := dns.NewHandler(resolver)
-	serverIn := &dns.Server{}
-	serverIn.SetHandler(handler)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("DNS hijack %s invalid port", addr)
+	}
 
-	// UDP DNS
-	id := &stack.TransportEndpointID{
-		LocalAddress:  address.Addr,
-		LocalPort:     uint16(port),
-		RemotePort:    0,
-		RemoteAddress: "",
+	hijack := &dnsHijack{
+		network: network,
+		address: tcpip.FullAddress{NIC: nicID, Port: uint16(port)},
 	}
 
-	// TransportEndpoint for DNS
-	endpoint := &dnsEndpoint{
-		stack:    s,
-		uniqueID: s.UniqueID(),
-		server:   serverIn,
+	if host == "any" {
+		return hijack, nil
 	}
 
-	if tcpiperr := s.RegisterTransportEndpoint(1,
-		[]tcpip.NetworkProtocolNumber{
-			ipv4.ProtocolNumber,
-			ipv6.ProtocolNumber,
-		},
-		udp.ProtocolNumber,
-		*id,
-		endpoint,
-		true,
-		nicID); err != nil {
-		log.Errorln("Unable to start UDP DNS on tun:  %v", tcpiperr.String())
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("DNS hijack %s should be an ip or any", addr)
 	}
 
-	// TCP DNS
-	var tcpListener net.Listener
-	if v4 {
-		tcpListener, err = gonet.NewListener(s, address, ipv4.ProtocolNumber)
+	if ip.To4() != nil {
+		hijack.v4 = true
+		hijack.address.Addr = tcpip.AddrFromSlice(ip.To4())
 	} else {
-		tcpListener, err = gonet.NewListener(s, address, ipv6.ProtocolNumber)
+		hijack.address.Addr = tcpip.AddrFromSlice(ip.To16())
 	}
-	if err != nil {
-		return nil, fmt.Errorf("Can not listen on tun: %v", err)
+	if hijack.address.Addr.Unspecified() {
+		hijack.address.Addr = tcpip.Address{}
 	}
 
+	return hijack, nil
+}
+
+// CreateDNSServer create a dns server on given netstack
+func CreateDNSServer(s *stack.Stack, resolver *dns.Resolver, hijack []string, nicID tcpip.NICID) (*DNSServer, error) {
+	handler := dns.NewHandler(resolver)
+	serverIn := &dns.Server{}
+	serverIn.SetHandler(handler)
+
 	server := &DNSServer{
-		Server:        serverIn,
-		resolver:      resolver,
-		stack:         s,
-		tcpListener:   tcpListener,
-		udpEndpoint:   endpoint,
-		udpEndpointID: id,
-		NICID:         nicID,
+		Server:   serverIn,
+		resolver: resolver,
+		hijack:   hijack,
+		stack:    s,
+		NICID:    nicID,
 	}
-	server.SetHandler(handler)
-	server.Server.Server = &D.Server{Listener: tcpListener, Handler: server}
 
-	go func() {
-		server.ActivateAndServe()
-	}()
+	for _, addr := range hijack {
+		h, err := parseDNSHijack(addr, nicID)
+		if err != nil {
+			server.Stop()
+			return nil, err
+		}
+
+		// netstack will only reassemble IP fragments when its' dest ip address is registered in NIC.endpoints
+		if h.address.Addr.Len() != 0 {
+			protocol := tcpip.ProtocolAddress{Protocol: ipv6.ProtocolNumber, AddressWithPrefix: h.address.Addr.WithPrefix()}
+			if h.v4 {
+				protocol.Protocol = ipv4.ProtocolNumber
+			}
+			s.AddProtocolAddress(nicID, protocol, stack.AddressProperties{})
+		}
+
+		if h.network == "udp" {
+			// UDP DNS
+			id := &stack.TransportEndpointID{
+				LocalAddress:  h.address.Addr,
+				LocalPort:     h.address.Port,
+				RemotePort:    0,
+				RemoteAddress: tcpip.Address{},
+			}
+
+			// TransportEndpoint for DNS
+			endpoint := &dnsEndpoint{
+				stack:    s,
+				uniqueID: s.UniqueID(),
+				server:   serverIn,
+				id:       id,
+			}
+
+			if tcpiperr := s.RegisterTransportEndpoint(
+				[]tcpip.NetworkProtocolNumber{
+					ipv4.ProtocolNumber,
+					ipv6.ProtocolNumber,
+				},
+				udp.ProtocolNumber,
+				*id,
+				endpoint,
+				ports.Flags{LoadBalanced: true},
+				nicID); tcpiperr != nil {
+				log.Errorln("Unable to start UDP DNS on tun %s: %v", addr, tcpiperr.String())
+				continue
+			}
+
+			server.udpEndpoints = append(server.udpEndpoints, endpoint)
+			continue
+		}
+
+		// TCP DNS, an ipv6 listener without address accept ipv4 as well
+		var tcpListener net.Listener
+		if h.v4 {
+			tcpListener, err = gonet.ListenTCP(s, h.address, ipv4.ProtocolNumber)
+		} else {
+			tcpListener, err = gonet.ListenTCP(s, h.address, ipv6.ProtocolNumber)
+		}
+		if err != nil {
+			server.Stop()
+			return nil, fmt.Errorf("Can not listen on tun %s: %v", addr, err)
+		}
+
+		tcpServer := &D.Server{Listener: tcpListener, Handler: server}
+		server.tcpServers = append(server.tcpServers, tcpServer)
+
+		go func() {
+			tcpServer.ActivateAndServe()
+		}()
+	}
 
-	return server, err
+	return server, nil
 }
 
 // Stop stop the DNS Server on tun
 func (s *DNSServer) Stop() {
-	// shutdown TCP DNS Server
-	s.Server.Shutdown()
-	// remove TCP endpoint from stack
-	if s.Listener != nil {
-		s.Listener.Close()
+	for _, tcpServer := range s.tcpServers {
+		// shutdown TCP DNS Server
+		tcpServer.Shutdown()
+		// remove TCP endpoint from stack
+		tcpServer.Listener.Close()
 	}
+
 	// remove udp endpoint from stack
-	s.stack.UnregisterTransportEndpoint(s.NICID,
-		[]tcpip.NetworkProtocolNumber{
-			ipv4.ProtocolNumber,
-			ipv6.ProtocolNumber,
-		},
-		udp.ProtocolNumber,
-		*s.udpEndpointID,
-		s.udpEndpoint,
-		s.NICID)
+	for _, endpoint := range s.udpEndpoints {
+		s.stack.UnregisterTransportEndpoint(
+			[]tcpip.NetworkProtocolNumber{
+				ipv4.ProtocolNumber,
+				ipv6.ProtocolNumber,
+			},
+			udp.ProtocolNumber,
+			*endpoint.id,
+			endpoint,
+			ports.Flags{LoadBalanced: true},
+			s.NICID)
+	}
 }
 
-// DNSListen return the listening address of DNS Server
-func (t *tunAdapter) DNSListen() string {
+// DNSHijack return the hijacked addresses of DNS Server
+func (t *tunAdapter) DNSHijack() []string {
 	if t.dnsserver != nil {
-		id := t.dnsserver.udpEndpointID
-		return fmt.Sprintf("%s:%d", id.LocalAddress.String(), id.LocalPort)
+		return t.dnsserver.hijack
 	}
-	return ""
+	return []string{}
 }
 
-// Stop stop the DNS Server on tun
-func (t *tunAdapter) ReCreateDNSServer(resolver *dns.Resolver, addr string) error {
-	if addr == "" && t.dnsserver == nil {
+// ReCreateDNSServer answer dns request to the hijacked addresses with resolver
+func (t *tunAdapter) ReCreateDNSServer(resolver *dns.Resolver, hijack []string) error {
+	if len(hijack) == 0 && t.dnsserver == nil {
 		return nil
 	}
 
-	if addr == t.DNSListen() && t.dnsserver != nil && t.dnsserver.resolver == resolver {
+	if t.dnsserver != nil && t.dnsserver.resolver == resolver && reflect.DeepEqual(t.dnsserver.hijack, hijack) {
 		return nil
 	}
 
@@ -249,9 +309,7 @@ func (t *tunAdapter) ReCreateDNSServer(resolver *dns.Resolver, addr string) erro
 		log.Debugln("Tun DNS server stoped")
 	}
 
-	var err error
-	_, port, err := net.SplitHostPort(addr)
-	if port == "0" || port == "" || err != nil {
+	if len(hijack) == 0 {
 		return nil
 	}
 
@@ -259,16 +317,11 @@ func (t *tunAdapter) ReCreateDNSServer(resolver *dns.Resolver, addr string) erro
 		return fmt.Errorf("Failed to create DNS server on tun: resolver not provided")
 	}
 
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return err
-	}
-
-	server, err := CreateDNSServer(t.ipstack, resolver, udpAddr.IP, udpAddr.Port, 1)
+	server, err := CreateDNSServer(t.ipstack, resolver, hijack, 1)
 	if err != nil {
 		return err
 	}
 	t.dnsserver = server
-	log.Infoln("Tun DNS server listening at: %s", addr)
+	log.Infoln("Tun DNS server hijacking: %s", strings.Join(hijack, ", "))
 	return nil
 }
@@ -0,0 +1,59 @@
+package tun
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDNSHijack(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		ip      string
+		port    uint16
+		v4      bool
+	}{
+		{addr: "udp://198.18.0.2:53", network: "udp", ip: "198.18.0.2", port: 53, v4: true},
+		{addr: "tcp://198.18.0.2:53", network: "tcp", ip: "198.18.0.2", port: 53, v4: true},
+		{addr: "198.18.0.2:5353", network: "udp", ip: "198.18.0.2", port: 5353, v4: true},
+		{addr: "tcp://[fd00::2]:53", network: "tcp", ip: "fd00::2", port: 53},
+		// any and the unspecified addresses match every destination
+		{addr: "any:53", network: "udp", port: 53},
+		{addr: "tcp://any:53", network: "tcp", port: 53},
+		{addr: "0.0.0.0:53", network: "udp", port: 53, v4: true},
+	}
+
+	for _, c := range cases {
+		h, err := parseDNSHijack(c.addr, 1)
+		if !assert.Nil(t, err, c.addr) {
+			continue
+		}
+		assert.Equal(t, c.network, h.network, c.addr)
+		assert.Equal(t, c.port, h.address.Port, c.addr)
+		assert.Equal(t, tcpip.NICID(1), h.address.NIC, c.addr)
+		assert.Equal(t, c.v4, h.v4, c.addr)
+		if c.ip == "" {
+			assert.Equal(t, 0, h.address.Addr.Len(), c.addr)
+		} else {
+			assert.Equal(t, c.ip, h.address.Addr.String(), c.addr)
+		}
+	}
+}
+
+func TestParseDNSHijack_Invalid(t *testing.T) {
+	for _, addr := range []string{
+		"",
+		"198.18.0.2",
+		"http://198.18.0.2:53",
+		"udp://198.18.0.2:0",
+		"udp://198.18.0.2:65536",
+		"udp://198.18.0.2:dns",
+		"udp://example.com:53",
+	} {
+		_, err := parseDNSHijack(addr, 1)
+		assert.NotNil(t, err, addr)
+	}
+}
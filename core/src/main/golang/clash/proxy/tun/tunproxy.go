@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net"
 	"net/url"
-	"strings"
 
 	adapters "github.com/Dreamacro/clash/adapters/inbound"
 	"github.com/Dreamacro/clash/component/socks5"
@@ -15,15 +14,15 @@ import (
 
 	"encoding/binary"
 
-	"github.com/google/netstack/tcpip"
-	"github.com/google/netstack/tcpip/adapters/gonet"
-	"github.com/google/netstack/tcpip/header"
-	"github.com/google/netstack/tcpip/network/ipv4"
-	"github.com/google/netstack/tcpip/network/ipv6"
-	"github.com/google/netstack/tcpip/stack"
-	"github.com/google/netstack/tcpip/transport/tcp"
-	"github.com/google/netstack/tcpip/transport/udp"
-	"github.com/google/netstack/waiter"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 // tunAdapter is the wraper of tun
@@ -50,8 +49,8 @@ func NewTunProxy(deviceURL string) (TunAdapter, error) {
 	}
 
 	ipstack := stack.New(stack.Options{
-		NetworkProtocols:   []stack.NetworkProtocol{ipv4.NewProtocol(), ipv6.NewProtocol()},
-		TransportProtocols: []stack.TransportProtocol{tcp.NewProtocol(), udp.NewProtocol()},
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
 	})
 
 	tl := &tunAdapter{
@@ -68,13 +67,13 @@ func NewTunProxy(deviceURL string) (TunAdapter, error) {
 		return nil, fmt.Errorf("Fail to create NIC in ipstack: %v", err)
 	}
 
-	// IPv4 0.0.0.0/0
-	subnet, _ := tcpip.NewSubnet(tcpip.Address(strings.Repeat("\x00", 4)), tcpip.AddressMask(strings.Repeat("\x00", 4)))
-	ipstack.AddAddressRange(1, ipv4.ProtocolNumber, subnet)
-
-	// IPv6 [::]/0
-	subnet, _ = tcpip.NewSubnet(tcpip.Address(strings.Repeat("\x00", 16)), tcpip.AddressMask(strings.Repeat("\x00", 16)))
-	ipstack.AddAddressRange(1, ipv6.ProtocolNumber, subnet)
+	// accept the packets to every address and answer from them
+	ipstack.SetPromiscuousMode(1, true)
+	ipstack.SetSpoofing(1, true)
+	ipstack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: 1},
+		{Destination: header.IPv6EmptySubnet, NIC: 1},
+	})
 
 	// TCP handler
 	// maximum number of half-open tcp connection set to 1024
@@ -83,14 +82,14 @@ func NewTunProxy(deviceURL string) (TunAdapter, error) {
 		var wq waiter.Queue
 		ep, err := r.CreateEndpoint(&wq)
 		if err != nil {
-			log.Warnln("Can't create TCP Endpoint in ipstack: %v", err)
+			log.Warnln("Can't create TCP Endpoint in ipstack: %s", err)
 			r.Complete(true)
 			return
 		}
 		r.Complete(false)
 
-		conn := gonet.NewConn(&wq, ep)
-		target := getAddr(ep.Info().(*tcp.EndpointInfo).ID)
+		conn := &tcpConn{TCPConn: gonet.NewTCPConn(&wq, ep), ep: ep}
+		target := getAddr(r.ID())
 		tun.Add(adapters.NewSocket(target, conn, C.TUN, C.TCP))
 
 	})
@@ -118,22 +117,21 @@ func (t *tunAdapter) DeviceURL() string {
 	return t.device.URL()
 }
 
-func (t *tunAdapter) udpHandlePacket(r *stack.Route, id stack.TransportEndpointID, pkt tcpip.PacketBuffer) bool {
-
-	hdr := header.UDP(pkt.Data.First())
-	if int(hdr.Length()) > pkt.Data.Size() {
+func (t *tunAdapter) udpHandlePacket(id stack.TransportEndpointID, pkt stack.PacketBufferPtr) bool {
+	hdr := header.UDP(pkt.TransportHeader().Slice())
+	if int(hdr.Length()) > pkt.Data().Size()+header.UDPMinimumSize {
 		// Malformed packet.
 		t.ipstack.Stats().UDP.MalformedPacketsReceived.Increment()
 		return true
 	}
-	pkt.Data.TrimFront(header.UDPMinimumSize)
 
 	target := getAddr(id)
 
 	packet := &fakeConn{
+		s:       t.ipstack,
 		id:      id,
-		r:       r,
-		payload: pkt.Data.ToView(),
+		proto:   pkt.NetworkProtocolNumber,
+		payload: pkt.Data().AsRange().ToSlice(),
 	}
 	tun.AddPacket(adapters.NewPacket(target, packet, C.SOCKS))
 
@@ -141,22 +139,20 @@ func (t *tunAdapter) udpHandlePacket(r *stack.Route, id stack.TransportEndpointI
 }
 
 func getAddr(id stack.TransportEndpointID) socks5.Addr {
-	ipv4 := id.LocalAddress.To4()
-
 	// get the big-endian binary represent of port
 	port := make([]byte, 2)
 	binary.BigEndian.PutUint16(port, id.LocalPort)
 
-	if ipv4 != "" {
+	if id.LocalAddress.Len() == net.IPv4len {
 		addr := make([]byte, 1+net.IPv4len+2)
 		addr[0] = socks5.AtypIPv4
-		copy(addr[1:1+net.IPv4len], []byte(ipv4))
+		copy(addr[1:1+net.IPv4len], id.LocalAddress.AsSlice())
 		addr[1+net.IPv4len], addr[1+net.IPv4len+1] = port[0], port[1]
 		return addr
 	} else {
 		addr := make([]byte, 1+net.IPv6len+2)
 		addr[0] = socks5.AtypIPv6
-		copy(addr[1:1+net.IPv6len], []byte(id.LocalAddress))
+		copy(addr[1:1+net.IPv6len], id.LocalAddress.AsSlice())
 		addr[1+net.IPv6len], addr[1+net.IPv6len+1] = port[0], port[1]
 		return addr
 	}
@@ -2,20 +2,37 @@ package tun
 
 import (
 	"fmt"
+	"math"
 	"net"
 
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/dns"
-	"github.com/google/netstack/tcpip"
-	"github.com/google/netstack/tcpip/buffer"
-	"github.com/google/netstack/tcpip/header"
-	"github.com/google/netstack/tcpip/stack"
-	"github.com/google/netstack/tcpip/transport/udp"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
 
+// tcpConn is a connection accepted by the stack
+type tcpConn struct {
+	*gonet.TCPConn
+	ep tcpip.Endpoint
+}
+
+// Reset close the connection with a RST, a zero linger makes the endpoint reset as the sockets do
+func (c *tcpConn) Reset() {
+	c.ep.SocketOptions().SetLinger(tcpip.LingerOption{Enabled: true, Timeout: 0})
+	c.TCPConn.Close()
+}
+
 type fakeConn struct {
+	s       *stack.Stack
 	id      stack.TransportEndpointID
-	r       *stack.Route
+	proto   tcpip.NetworkProtocolNumber
 	payload []byte
 	fakeip  *bool
 }
@@ -25,25 +42,21 @@ func (c *fakeConn) Data() []byte {
 }
 
 func (c *fakeConn) WriteBack(b []byte, addr net.Addr) (n int, err error) {
-	v := buffer.View(b)
-	data := v.ToVectorisedView()
 	// if addr is not provided, write back use original dst Addr as src Addr
 	if c.FakeIP() || addr == nil {
-		return writeUDP(c.r, data, uint16(c.id.LocalPort), c.id.RemotePort)
+		return writeUDP(c.s, c.proto, c.id.LocalAddress, c.id.LocalPort, c.id.RemoteAddress, c.id.RemotePort, b)
 	}
 
 	udpaddr, _ := addr.(*net.UDPAddr)
-	r := c.r.Clone()
-	if ipv4 := udpaddr.IP.To4(); ipv4 != nil {
-		r.LocalAddress = tcpip.Address(ipv4)
-	} else {
-		r.LocalAddress = tcpip.Address(udpaddr.IP)
+	local := tcpip.AddrFromSlice(udpaddr.IP.To16())
+	if ipv4 := udpaddr.IP.To4(); ipv4 != nil && c.proto == header.IPv4ProtocolNumber {
+		local = tcpip.AddrFromSlice(ipv4)
 	}
-	return writeUDP(&r, data, uint16(udpaddr.Port), c.id.RemotePort)
+	return writeUDP(c.s, c.proto, local, uint16(udpaddr.Port), c.id.RemoteAddress, c.id.RemotePort, b)
 }
 
 func (c *fakeConn) LocalAddr() net.Addr {
-	return &net.UDPAddr{IP: net.IP(c.id.RemoteAddress), Port: int(c.id.RemotePort)}
+	return &net.UDPAddr{IP: net.IP(c.id.RemoteAddress.AsSlice()), Port: int(c.id.RemotePort)}
 }
 
 func (c *fakeConn) Close() error {
@@ -55,46 +68,57 @@ func (c *fakeConn) FakeIP() bool {
 		return *c.fakeip
 	}
 	resolver := resolver.DefaultResolver.(*dns.Resolver)
-	fakeip := resolver.IsFakeIP(net.IP(c.id.LocalAddress.To4()))
+	fakeip := resolver.IsFakeIP(net.IP(c.id.LocalAddress.AsSlice()))
 	c.fakeip = &fakeip
 	return fakeip
 }
 
-func writeUDP(r *stack.Route, data buffer.VectorisedView, localPort, remotePort uint16) (int, error) {
-	const protocol = udp.ProtocolNumber
-	// Allocate a buffer for the UDP header.
-	hdr := buffer.NewPrependable(header.UDPMinimumSize + int(r.MaxHeaderLength()))
+// writeUDP send data from local:localPort to remote:remotePort, the stack spoofs any local address
+func writeUDP(s *stack.Stack, proto tcpip.NetworkProtocolNumber, local tcpip.Address, localPort uint16, remote tcpip.Address, remotePort uint16, data []byte) (int, error) {
+	r, tcpipErr := s.FindRoute(1, local, remote, proto, false)
+	if tcpipErr != nil {
+		return 0, fmt.Errorf("%s", tcpipErr)
+	}
+	defer r.Release()
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.UDPMinimumSize + int(r.MaxHeaderLength()),
+		Payload:            buffer.MakeWithData(data),
+	})
+	defer pkt.DecRef()
 
 	// Initialize the header.
-	udp := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+	hdr := header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize))
+	pkt.TransportProtocolNumber = udp.ProtocolNumber
 
-	length := uint16(hdr.UsedLength() + data.Size())
-	udp.Encode(&header.UDPFields{
+	length := uint16(pkt.Size())
+	hdr.Encode(&header.UDPFields{
 		SrcPort: localPort,
 		DstPort: remotePort,
 		Length:  length,
 	})
 
 	// Only calculate the checksum if offloading isn't supported.
-	if r.Capabilities()&stack.CapabilityTXChecksumOffload == 0 {
-		xsum := r.PseudoHeaderChecksum(protocol, length)
-		for _, v := range data.Views() {
-			xsum = header.Checksum(v, xsum)
+	if r.RequiresTXTransportChecksum() {
+		xsum := hdr.CalculateChecksum(checksum.Combine(
+			r.PseudoHeaderChecksum(udp.ProtocolNumber, length),
+			pkt.Data().Checksum(),
+		))
+		// zero means no checksum, it's sent as all ones
+		if xsum != math.MaxUint16 {
+			xsum = ^xsum
 		}
-		udp.SetChecksum(^udp.CalculateChecksum(xsum))
+		hdr.SetChecksum(xsum)
 	}
 
 	ttl := r.DefaultTTL()
 
-	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: protocol, TTL: ttl, TOS: 0 /* default */}, tcpip.PacketBuffer{
-		Header: hdr,
-		Data:   data,
-	}); err != nil {
+	if err := r.WritePacket(stack.NetworkHeaderParams{Protocol: udp.ProtocolNumber, TTL: ttl, TOS: 0 /* default */}, pkt); err != nil {
 		r.Stats().UDP.PacketSendErrors.Increment()
-		return 0, fmt.Errorf("%v", err)
+		return 0, fmt.Errorf("%s", err)
 	}
 
 	// Track count of packets sent.
 	r.Stats().UDP.PacketsSent.Increment()
-	return data.Size(), nil
+	return len(data), nil
 }
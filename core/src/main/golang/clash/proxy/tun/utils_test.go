@@ -0,0 +1,67 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+func TestTCPConn_Reset(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer s.Destroy()
+
+	assert.Nil(t, s.CreateNIC(1, loopback.New()))
+	s.AddProtocolAddress(1, tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddrFrom4([4]byte{10, 0, 0, 1}).WithPrefix(),
+	}, stack.AddressProperties{})
+	s.SetPromiscuousMode(1, true)
+	s.SetSpoofing(1, true)
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: 1}})
+
+	accepted := make(chan *tcpConn, 1)
+	fwd := tcp.NewForwarder(s, 0, 16, func(r *tcp.ForwarderRequest) {
+		var wq waiter.Queue
+		ep, err := r.CreateEndpoint(&wq)
+		if err != nil {
+			r.Complete(true)
+			return
+		}
+		r.Complete(false)
+
+		accepted <- &tcpConn{TCPConn: gonet.NewTCPConn(&wq, ep), ep: ep}
+	})
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, fwd.HandlePacket)
+
+	c, err := gonet.DialTCP(s, tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom4([4]byte{10, 0, 0, 2}), Port: 80}, ipv4.ProtocolNumber)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	// reset before the client has sent anything
+	select {
+	case conn := <-accepted:
+		conn.Reset()
+	case <-time.After(time.Second):
+		assert.FailNow(t, "not accepted")
+	}
+
+	start := time.Now()
+	c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Contains(t, err.Error(), (&tcpip.ErrConnectionReset{}).String())
+}
@@ -267,6 +267,13 @@ func handleTCPConn(localConn C.ServerAdapter) {
 		log.Warnln("dial %s error: %s", proxy.Name(), err.Error())
 		return
 	}
+
+	if _, ok := remoteConn.(C.InboundResetter); ok {
+		remoteConn.Close()
+		log.Infoln("[TCP] %s --> %v reset by %s", metadata.SourceAddress(), metadata.String(), remoteConn.Chains().String())
+		inbound.ResetConn(localConn)
+		return
+	}
 	remoteConn = newTCPTracker(remoteConn, DefaultManager, metadata, rule)
 	defer remoteConn.Close()
 
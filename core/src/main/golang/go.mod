@@ -1,15 +1,48 @@
 module github.com/kr328/cfa
 
-go 1.13
+go 1.21
 
 require (
 	github.com/Dreamacro/clash v0.0.0 // local
 	github.com/go-chi/render v1.0.1
-	github.com/google/go-cmp v0.3.1 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
+	github.com/miekg/dns v1.1.27
 	golang.org/x/mobile v0.0.0-20191210151939-1a1fef82734d // indirect
-	golang.org/x/sys v0.0.0-20191224085550-c709ea063b76
+	golang.org/x/sys v0.12.0
 )
 
-replace github.com/Dreamacro/clash => ./clash
+require (
+	github.com/Dreamacro/go-shadowsocks2 v0.1.5 // indirect
+	github.com/eapache/queue v1.1.0 // indirect
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/gofrs/uuid v3.2.0+incompatible // indirect
+	github.com/google/btree v1.0.1 // indirect
+	github.com/google/pprof v0.0.0-20210407192527-94a9f03dee38 // indirect
+	github.com/gorilla/websocket v1.4.1 // indirect
+	github.com/hashicorp/yamux v0.1.2 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
+	github.com/oschwald/geoip2-golang v1.4.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.6.0 // indirect
+	github.com/quic-go/quic-go v0.41.0 // indirect
+	github.com/sirupsen/logrus v1.8.1 // indirect
+	gitlab.com/yawning/chacha20.git v0.0.0-20190903091407-6d1cb28dc72c // indirect
+	go.uber.org/mock v0.3.0 // indirect
+	golang.org/x/crypto v0.13.0 // indirect
+	golang.org/x/exp v0.0.0-20230725093048-515e97ebf090 // indirect
+	golang.org/x/mod v0.12.0 // indirect
+	golang.org/x/net v0.15.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
+	golang.org/x/tools v0.13.0 // indirect
+	golang.zx2c4.com/wireguard v0.0.0-20230704135630-469159ecf7d1 // indirect
+	gopkg.in/eapache/channels.v1 v1.1.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gvisor.dev/gvisor v0.0.0-20230927004350-cbd86285d259 // indirect
+	lukechampine.com/blake3 v1.1.7 // indirect
+)
 
-replace github.com/google/netstack => github.com/comzyh/netstack v0.0.0-20191217044024-67c27819ada4
+replace github.com/Dreamacro/clash => ./clash
@@ -70,7 +70,7 @@ func LoadDefault() {
 
 	executor.ApplyConfig(defaultC, true)
 
-	tun.ResetDnsRedirect()
+	tun.ResetDnsRedirect(defaultC.General.Tun)
 }
 
 // LoadFromFile - load file
@@ -91,7 +91,7 @@ func LoadFromFile(path, baseDir string) error {
 
 	executor.ApplyConfig(cfg, true)
 
-	tun.ResetDnsRedirect()
+	tun.ResetDnsRedirect(cfg.General.Tun)
 
 	log.Infoln("Profile " + path + " loaded")
 
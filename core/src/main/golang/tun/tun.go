@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/config"
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/log"
 	"github.com/Dreamacro/clash/proxy/tun"
@@ -12,6 +13,7 @@ import (
 
 var tunInstance *tun.TunAdapter
 var dnsAddress string
+var dnsHijack []string
 var mutex sync.Mutex
 
 func StartTunDevice(fd, mtu int, dns string) error {
@@ -30,7 +32,7 @@ func StartTunDevice(fd, mtu int, dns string) error {
 	tunInstance = &t
 	dnsAddress = dns
 
-	ResetDnsRedirect()
+	resetDnsRedirect()
 
 	log.Infoln("Android tun started")
 
@@ -52,10 +54,30 @@ func StopTunDevice() {
 	log.Infoln("Android tun stopped")
 }
 
-func ResetDnsRedirect() {
+// ResetDnsRedirect hijack the dns of the profile, tun.dns-hijack and tun.dns-listen,
+// along with the dns address of the vpn
+func ResetDnsRedirect(conf config.Tun) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	dnsHijack = conf.Hijack()
+
+	resetDnsRedirect()
+}
+
+func resetDnsRedirect() {
 	if tunInstance == nil {
 		return
 	}
 
-	(*tunInstance).ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), dnsAddress)
+	var hijack []string
+	// an empty dns address means the vpn doesn't route dns into tun
+	if dnsAddress != "" {
+		hijack = append(hijack, "udp://"+dnsAddress, "tcp://"+dnsAddress)
+	}
+	hijack = append(hijack, dnsHijack...)
+
+	if err := (*tunInstance).ReCreateDNSServer(resolver.DefaultResolver.(*dns.Resolver), hijack); err != nil {
+		log.Errorln("Android tun dns hijack error: %s", err.Error())
+	}
 }
@@ -21,6 +21,11 @@ data class Proxy(
         HTTP,
         VMESS,
         LOAD_BALANCE,
+        TROJAN,
+        VLESS,
+        RELAY,
+        WIREGUARD,
+        SSH,
         UNKNOWN;
 
         override fun toString(): String {
@@ -37,6 +42,11 @@ data class Proxy(
                 HTTP -> TYPE_HTTP
                 VMESS -> TYPE_VMESS
                 LOAD_BALANCE -> TYPE_LOAD_BALANCE
+                TROJAN -> TYPE_TROJAN
+                VLESS -> TYPE_VLESS
+                RELAY -> TYPE_RELAY
+                WIREGUARD -> TYPE_WIREGUARD
+                SSH -> TYPE_SSH
                 UNKNOWN -> TYPE_UNKNOWN
             }
         }
@@ -56,6 +66,11 @@ data class Proxy(
                     TYPE_HTTP -> HTTP
                     TYPE_VMESS -> VMESS
                     TYPE_LOAD_BALANCE -> LOAD_BALANCE
+                    TYPE_TROJAN -> TROJAN
+                    TYPE_VLESS -> VLESS
+                    TYPE_RELAY -> RELAY
+                    TYPE_WIREGUARD -> WIREGUARD
+                    TYPE_SSH -> SSH
                     TYPE_UNKNOWN -> UNKNOWN
                     else -> UNKNOWN
                 }
@@ -76,6 +91,11 @@ data class Proxy(
         private const val TYPE_HTTP = "Http"
         private const val TYPE_VMESS = "Vmess"
         private const val TYPE_LOAD_BALANCE = "LoadBalance"
+        private const val TYPE_TROJAN = "Trojan"
+        private const val TYPE_VLESS = "Vless"
+        private const val TYPE_RELAY = "Relay"
+        private const val TYPE_WIREGUARD = "WireGuard"
+        private const val TYPE_SSH = "Ssh"
         private const val TYPE_UNKNOWN = "Unknown"
 
     }